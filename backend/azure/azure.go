@@ -0,0 +1,376 @@
+// Package azure implements a granular backend on Azure Blob Storage.
+//
+// It uses the Blob service REST API directly and has no dependencies.
+// Requests are authorized with a shared key (WithSharedKey) or a SAS token
+// (WithSAS):
+//
+//	b, err := azure.New("myaccount", "build-cache", azure.WithSharedKey(os.Getenv("AZURE_STORAGE_KEY")))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	cache, err := granular.Open("", granular.WithBackend(b))
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophersatwork/granular/backend"
+)
+
+// apiVersion is the Blob service REST API version requests are made with.
+const apiVersion = "2021-08-06"
+
+// Backend is a backend.Backend storing blobs as block blobs in a container.
+type Backend struct {
+	account   string
+	container string
+	prefix    string
+	endpoint  string
+	key       []byte     // decoded shared key, if set
+	sas       url.Values // SAS token parameters, if set
+	client    *http.Client
+	now       func() time.Time
+	optionErr error // Invalid option value, returned by New
+}
+
+var _ backend.Backend = (*Backend)(nil)
+
+// Option configures a Backend.
+type Option func(*Backend)
+
+// WithSharedKey authorizes requests with the storage account key
+// (base64-encoded, as shown in the Azure portal). New fails if the key is
+// not valid base64.
+func WithSharedKey(accountKey string) Option {
+	return func(b *Backend) {
+		key, err := base64.StdEncoding.DecodeString(accountKey)
+		if err != nil {
+			b.optionErr = fmt.Errorf("azure: invalid shared key: %w", err)
+			return
+		}
+		b.key = key
+	}
+}
+
+// WithSAS authorizes requests with a shared access signature token, with or
+// without the leading "?". The token needs read, write, delete, and list
+// permissions on the container. New fails if the token cannot be parsed.
+func WithSAS(token string) Option {
+	return func(b *Backend) {
+		sas, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+		if err != nil {
+			b.optionErr = fmt.Errorf("azure: invalid SAS token: %w", err)
+			return
+		}
+		b.sas = sas
+	}
+}
+
+// WithPrefix stores blobs under prefix, so several caches can share a
+// container. Use a distinct prefix per cache, usually ending in "/".
+func WithPrefix(prefix string) Option {
+	return func(b *Backend) {
+		b.prefix = prefix
+	}
+}
+
+// WithEndpoint sets the service endpoint, for sovereign clouds or the
+// Azurite emulator (for example "http://127.0.0.1:10000/devstoreaccount1").
+func WithEndpoint(endpoint string) Option {
+	return func(b *Backend) {
+		b.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// WithHTTPClient sets the client used for requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *Backend) {
+		b.client = client
+	}
+}
+
+// New returns a Backend for a container in a storage account. It returns an
+// error if an option was given an invalid value, such as a shared key that
+// is not base64.
+func New(account, container string, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		account:   account,
+		container: container,
+		endpoint:  "https://" + account + ".blob.core.windows.net",
+		client:    http.DefaultClient,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.optionErr != nil {
+		return nil, b.optionErr
+	}
+	return b, nil
+}
+
+func (b *Backend) blobURL(key string) string {
+	return b.endpoint + "/" + url.PathEscape(b.container) + "/" + escapePath(b.prefix+key)
+}
+
+// escapePath escapes a blob name, keeping slashes.
+func escapePath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Open implements backend.Backend.
+func (b *Backend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, b.blobURL(key), nil, nil, 0, key)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat implements backend.Backend.
+func (b *Backend) Stat(ctx context.Context, key string) (backend.Info, error) {
+	resp, err := b.do(ctx, http.MethodHead, b.blobURL(key), nil, nil, 0, key)
+	if err != nil {
+		return backend.Info{}, err
+	}
+	_ = resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return backend.Info{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// blockSize is the size of the blocks blobs of unknown size are uploaded in.
+// A block blob has at most 50,000 blocks, so such blobs are limited to
+// about 390 GiB. It is a variable so tests can upload several blocks.
+var blockSize = 8 << 20
+
+// Put implements backend.Backend. Blobs are streamed in a single request,
+// which the service accepts up to 5000 MiB. The service needs the length of
+// such a request up front, so blobs of unknown size larger than blockSize
+// are uploaded one block at a time instead, keeping one block in memory.
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size < 0 {
+		block := make([]byte, blockSize)
+		n, err := io.ReadFull(r, block)
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return b.putBlob(ctx, key, bytes.NewReader(block[:n]), int64(n))
+		case err != nil:
+			return fmt.Errorf("azure: put %s: %w", key, err)
+		}
+		return b.putBlocks(ctx, key, block, r)
+	}
+	return b.putBlob(ctx, key, r, size)
+}
+
+// putBlob stores a blob in a single Put Blob request.
+func (b *Backend) putBlob(ctx context.Context, key string, r io.Reader, size int64) error {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}, "Content-Type": {"application/octet-stream"}}
+	resp, err := b.do(ctx, http.MethodPut, b.blobURL(key), header, r, size, key)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// putBlocks stores a blob whose first block has been read into block and
+// whose rest is read from r, with a Put Block request per block and a Put
+// Block List request committing them.
+func (b *Backend) putBlocks(ctx context.Context, key string, block []byte, r io.Reader) error {
+	var list strings.Builder
+	list.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for i, n := 0, len(block); n > 0; i++ {
+		// Block IDs must all have the same length
+		id := base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%08d", i))
+		u := b.blobURL(key) + "?comp=block&blockid=" + url.QueryEscape(id)
+		resp, err := b.do(ctx, http.MethodPut, u, nil, bytes.NewReader(block[:n]), int64(n), key)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		list.WriteString("<Latest>" + id + "</Latest>")
+
+		var readErr error
+		n, readErr = io.ReadFull(r, block)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("azure: put %s: %w", key, readErr)
+		}
+	}
+	list.WriteString("</BlockList>")
+
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := b.do(ctx, http.MethodPut, b.blobURL(key)+"?comp=blocklist", header, strings.NewReader(list.String()), int64(list.Len()), key)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Delete implements backend.Backend.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, b.blobURL(key), nil, nil, 0, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// listResult is the subset of the List Blobs response used by the backend.
+type listResult struct {
+	Blobs      []listBlob `xml:"Blobs>Blob"`
+	NextMarker string     `xml:"NextMarker"`
+}
+
+type listBlob struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		ContentLength int64  `xml:"Content-Length"`
+		LastModified  string `xml:"Last-Modified"`
+	} `xml:"Properties"`
+}
+
+// List implements backend.Backend.
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[backend.Info, error] {
+	return func(yield func(backend.Info, error) bool) {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {b.prefix + prefix}}
+		for {
+			u := b.endpoint + "/" + url.PathEscape(b.container) + "?" + query.Encode()
+			resp, err := b.do(ctx, http.MethodGet, u, nil, nil, 0, prefix)
+			if err != nil {
+				yield(backend.Info{}, err)
+				return
+			}
+			var page listResult
+			err = xml.NewDecoder(resp.Body).Decode(&page)
+			_ = resp.Body.Close()
+			if err != nil {
+				yield(backend.Info{}, fmt.Errorf("azure: list %s: %w", prefix, err))
+				return
+			}
+			for _, blob := range page.Blobs {
+				modTime, _ := http.ParseTime(blob.Properties.LastModified)
+				info := backend.Info{Key: strings.TrimPrefix(blob.Name, b.prefix), Size: blob.Properties.ContentLength, ModTime: modTime}
+				if !yield(info, nil) {
+					return
+				}
+			}
+			if page.NextMarker == "" {
+				return
+			}
+			query.Set("marker", page.NextMarker)
+		}
+	}
+}
+
+// do sends an authorized request and returns the response if it succeeded.
+// 404 is reported as fs.ErrNotExist.
+func (b *Backend) do(ctx context.Context, method, u string, header http.Header, body io.Reader, size int64, key string) (*http.Response, error) {
+	if b.sas != nil {
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + b.sas.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Ms-Version", apiVersion)
+	req.Header.Set("X-Ms-Date", b.now().UTC().Format(http.TimeFormat))
+	if b.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+b.account+":"+b.sign(req))
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("azure: %w", err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("azure: %s: %w", key, fs.ErrNotExist)
+	}
+	return nil, fmt.Errorf("azure: %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// sign returns the Shared Key signature of req.
+func (b *Backend) sign(req *http.Request) string {
+	h := hmac.New(sha256.New, b.key)
+	h.Write([]byte(stringToSign(b.account, req)))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// stringToSign builds the Shared Key string to sign for req.
+func stringToSign(account string, req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date: x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	var msHeaders []string
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.Join(values, ","))
+		}
+	}
+	slices.Sort(msHeaders)
+	lines = append(lines, msHeaders...)
+
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	for _, name := range slices.Sorted(maps.Keys(query)) {
+		values := slices.Sorted(slices.Values(query[name]))
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return strings.Join(append(lines, resource), "\n")
+}
@@ -0,0 +1,208 @@
+package azure
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/backend/backendtest"
+)
+
+var testKey = base64.StdEncoding.EncodeToString([]byte("test account key"))
+
+// newBackend returns New's Backend, failing the test if New fails.
+func newBackend(t *testing.T, account, container string, opts ...Option) *Backend {
+	t.Helper()
+	b, err := New(account, container, opts...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return b
+}
+
+// fakeAzure serves the subset of the Blob service API used by Backend and
+// checks the Shared Key signature of every request. Listing returns two
+// blobs per page to exercise markers.
+func fakeAzure(t *testing.T, account, container string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	blobs := make(map[string][]byte)
+	blocks := make(map[string][]byte) // uncommitted, by blob name and block ID
+	verifier := newBackend(t, account, container, WithSharedKey(testKey))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "SharedKey "+account+":"+verifier.sign(r); got != want {
+			http.Error(w, "signature mismatch", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+
+		name, ok := strings.CutPrefix(r.URL.Path, "/"+container+"/")
+		if !ok {
+			if r.URL.Query().Get("comp") != "list" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			var names []string
+			for name := range blobs {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					names = append(names, name)
+				}
+			}
+			slices.Sort(names)
+			start := 0
+			fmt.Sscan(r.URL.Query().Get("marker"), &start)
+			end := min(start+2, len(names))
+			var result listResult
+			for _, name := range names[start:end] {
+				blob := listBlob{Name: name}
+				blob.Properties.ContentLength = int64(len(blobs[name]))
+				result.Blobs = append(result.Blobs, blob)
+			}
+			if end < len(names) {
+				result.NextMarker = fmt.Sprint(end)
+			}
+			_ = xml.NewEncoder(w).Encode(struct {
+				XMLName xml.Name `xml:"EnumerationResults"`
+				listResult
+			}{listResult: result})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			switch r.URL.Query().Get("comp") {
+			case "block":
+				blocks[name+"#"+r.URL.Query().Get("blockid")], _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+				return
+			case "blocklist":
+				var list struct {
+					Latest []string `xml:"Latest"`
+				}
+				if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+					http.Error(w, "InvalidXmlDocument", http.StatusBadRequest)
+					return
+				}
+				var data []byte
+				for _, id := range list.Latest {
+					block, ok := blocks[name+"#"+id]
+					if !ok {
+						http.Error(w, "InvalidBlockList", http.StatusBadRequest)
+						return
+					}
+					data = append(data, block...)
+				}
+				blobs[name] = data
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			if r.ContentLength < 0 {
+				http.Error(w, "MissingContentLengthHeader", http.StatusLengthRequired)
+				return
+			}
+			if r.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+				http.Error(w, "missing blob type", http.StatusBadRequest)
+				return
+			}
+			blobs[name], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, ok := blobs[name]
+		if !ok {
+			http.Error(w, "BlobNotFound", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			delete(blobs, name)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			if r.Method == http.MethodGet {
+				_, _ = w.Write(data)
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestBackend(t *testing.T) {
+	ts := fakeAzure(t, "account", "container")
+	backendtest.Run(t, newBackend(t, "account", "container", WithEndpoint(ts.URL), WithSharedKey(testKey), WithPrefix("cache/")))
+}
+
+func TestWrongKey(t *testing.T) {
+	ts := fakeAzure(t, "account", "container")
+	wrongKey := newBackend(t, "account", "container", WithEndpoint(ts.URL), WithSharedKey(base64.StdEncoding.EncodeToString([]byte("wrong"))))
+	if err := wrongKey.Put(t.Context(), "x", strings.NewReader(""), 0); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put with wrong key = %v, want 403", err)
+	}
+}
+
+func TestPutUnknownSize(t *testing.T) {
+	ts := fakeAzure(t, "account", "container")
+	b := newBackend(t, "account", "container", WithEndpoint(ts.URL), WithSharedKey(testKey))
+	defer func(size int) { blockSize = size }(blockSize)
+	blockSize = 4
+
+	for _, data := range []string{"", "abc", "abcd", "abcdefghij"} {
+		// Hide the reader's length, as NewFs does when streaming
+		if err := b.Put(t.Context(), "blob", struct{ io.Reader }{strings.NewReader(data)}, -1); err != nil {
+			t.Fatalf("Put(%q) failed: %v", data, err)
+		}
+		rc, err := b.Open(t.Context(), "blob")
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		got, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		if string(got) != data {
+			t.Errorf("stored %q, want %q", got, data)
+		}
+	}
+}
+
+func TestNewInvalidCredentials(t *testing.T) {
+	if _, err := New("account", "container", WithSharedKey("not base64!")); err == nil {
+		t.Error("New with a malformed shared key succeeded")
+	}
+	if _, err := New("account", "container", WithSAS("?sv=2021&sig=%zz")); err == nil {
+		t.Error("New with a malformed SAS token succeeded")
+	}
+	if _, err := New("account", "container", WithSAS("?sv=2021-08-06&sig=abc%3D")); err != nil {
+		t.Errorf("New with a valid SAS token failed: %v", err)
+	}
+}
+
+func TestCacheInContainer(t *testing.T) {
+	ts := fakeAzure(t, "account", "container")
+	b := newBackend(t, "account", "container", WithEndpoint(ts.URL), WithSharedKey(testKey))
+
+	cache, err := granular.Open("", granular.WithBackend(b))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := cache.Key().String("target", "app").Build()
+	if err := cache.Put(key).Bytes("out", []byte("built")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	result, err := cache.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if data, _ := result.BytesErr("out"); string(data) != "built" {
+		t.Errorf("cached data = %q", data)
+	}
+}
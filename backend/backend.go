@@ -0,0 +1,67 @@
+// Package backend stores granular caches in key/value blob stores.
+//
+// A Backend is a flat namespace of keys holding byte blobs, the common
+// denominator of object stores and key/value databases. NewFs presents a
+// Backend as an afero.Fs, which is how granular reaches storage, so any
+// Backend can hold a whole cache (granular.WithBackend) or just its
+// manifests (granular.WithManifestBackend) while objects stay on another
+// filesystem.
+//
+// Keys are slash-separated paths relative to the cache root. Directories are
+// recorded as empty marker keys ending in "/" so that empty directories exist,
+// as they do on a filesystem.
+package backend
+
+import (
+	"context"
+	"io"
+	"iter"
+	"time"
+)
+
+// Backend is a key/value blob store.
+//
+// Implementations must be safe for concurrent use. Missing keys are reported
+// with an error wrapping fs.ErrNotExist. Put is given a reader so that large
+// blobs can be streamed; NewFs streams files into it as they are written.
+type Backend interface {
+	// Open returns a reader for the blob stored under key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Stat returns information about the blob stored under key.
+	Stat(ctx context.Context, key string) (Info, error)
+
+	// Put stores the size bytes read from r under key, replacing any
+	// existing blob. A size of -1 means the length is not known and r is
+	// read until EOF. Implementations that can upload a stream should not
+	// buffer r in memory.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Delete removes the blob stored under key. Deleting a missing key is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List yields every key starting with prefix, in any order.
+	List(ctx context.Context, prefix string) iter.Seq2[Info, error]
+}
+
+// Info describes a stored blob. Backends that do not track modification
+// times leave ModTime zero.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// ReadBlob reads the blob of the given size from r, as passed to Put. A
+// size of -1 reads r until EOF. It is for backends that store values whole.
+func ReadBlob(r io.Reader, size int64) ([]byte, error) {
+	if size < 0 {
+		return io.ReadAll(r)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,109 @@
+// Package backendtest checks that a backend.Backend behaves as NewFs and
+// granular expect. Backend packages call Run from their tests, and keep only
+// driver-specific cases, such as authentication failures, local.
+package backendtest
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gophersatwork/granular/backend"
+)
+
+// Run stores, reads, lists, and deletes blobs in b, which must be empty.
+//
+// Keys ending in "/" that were not stored are ignored when listing, since
+// backends built on hierarchical stores list the directories of stored keys.
+func Run(t *testing.T, b backend.Backend) {
+	t.Helper()
+	ctx := t.Context()
+
+	if _, err := b.Open(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(missing) = %v, want ErrNotExist", err)
+	}
+	if _, err := b.Stat(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(missing) = %v, want ErrNotExist", err)
+	}
+
+	for _, key := range []string{"a/1", "a/2", "b/3", "c 4"} {
+		if err := b.Put(ctx, key, strings.NewReader("data:"+key), int64(len("data:"+key))); err != nil {
+			t.Fatalf("Put(%s) failed: %v", key, err)
+		}
+	}
+	if err := b.Put(ctx, "d/", strings.NewReader(""), 0); err != nil {
+		t.Fatalf("Put(d/) failed: %v", err)
+	}
+	// NewFs streams files of unknown size; hide the reader's length
+	if err := b.Put(ctx, "e/5", struct{ io.Reader }{strings.NewReader("data:e/5")}, -1); err != nil {
+		t.Fatalf("Put(e/5) of unknown size failed: %v", err)
+	}
+
+	read := func(key string) string {
+		t.Helper()
+		rc, err := b.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", key, err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", key, err)
+		}
+		return string(data)
+	}
+	for _, key := range []string{"c 4", "e/5"} {
+		if got := read(key); got != "data:"+key {
+			t.Errorf("Open(%s) read %q, want %q", key, got, "data:"+key)
+		}
+	}
+	for _, key := range []string{"b/3", "e/5"} {
+		if info, err := b.Stat(ctx, key); err != nil || info.Key != key || info.Size != 8 {
+			t.Errorf("Stat(%s) = %+v, %v; want size 8", key, info, err)
+		}
+	}
+
+	list := func(prefix string) []string {
+		t.Helper()
+		var keys []string
+		for info, err := range b.List(ctx, prefix) {
+			if err != nil {
+				t.Fatalf("List(%q) failed: %v", prefix, err)
+			}
+			if !strings.HasSuffix(info.Key, "/") || info.Key == "d/" {
+				keys = append(keys, info.Key)
+			}
+		}
+		slices.Sort(keys)
+		return keys
+	}
+	if got, want := list(""), []string{"a/1", "a/2", "b/3", "c 4", "d/", "e/5"}; !slices.Equal(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+	if got, want := list("a/"), []string{"a/1", "a/2"}; !slices.Equal(got, want) {
+		t.Errorf("List(a/) = %v, want %v", got, want)
+	}
+	if got := list("missing/"); len(got) != 0 {
+		t.Errorf("List(missing/) = %v, want empty", got)
+	}
+
+	if err := b.Put(ctx, "b/3", strings.NewReader("replaced"), 8); err != nil {
+		t.Fatalf("Put(b/3) over an existing blob failed: %v", err)
+	}
+	if got := read("b/3"); got != "replaced" {
+		t.Errorf("Open(b/3) after replacing read %q", got)
+	}
+
+	if err := b.Delete(ctx, "a/1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := b.Stat(ctx, "a/1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat after Delete = %v, want ErrNotExist", err)
+	}
+	if err := b.Delete(ctx, "a/1"); err != nil {
+		t.Errorf("Delete of missing blob = %v, want nil", err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package bolt implements a granular backend on a bbolt database file.
+//
+// It is meant for manifests (see granular.WithManifestBackend): a cache of
+// millions of entries keeps its manifests in one file instead of millions of
+// small ones, which spares inode quotas and makes listing them a sequential
+// read. Large objects belong on a filesystem.
+//
+// Only one process can have the database open at a time; others wait in
+// New (see WithTimeout). To share such a cache between processes, serve it
+// from one of them with the server package.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"time"
+
+	"github.com/gophersatwork/granular/backend"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// listPage is the number of keys List reads per read transaction. Keys are
+// yielded outside transactions, so the caller may write while listing.
+const listPage = 1000
+
+// defaultBucket is the bucket holding blobs when WithBucket is not used.
+const defaultBucket = "granular"
+
+// Backend is a backend.Backend storing blobs in a bbolt database. Each blob
+// is stored with its modification time. It is safe for concurrent use.
+type Backend struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+var _ backend.Backend = (*Backend)(nil)
+
+// Option configures a Backend.
+type Option func(*config)
+
+type config struct {
+	bucket  string
+	timeout time.Duration
+	noSync  bool
+}
+
+// WithBucket stores blobs in the named bucket, so several caches can share
+// a database file. Use a distinct bucket per cache.
+//
+// Example:
+//
+//	db, err := bolt.New("/var/cache/manifests.db", bolt.WithBucket("ci"))
+func WithBucket(name string) Option {
+	return func(c *config) {
+		c.bucket = name
+	}
+}
+
+// WithTimeout makes New wait up to timeout for another process to release
+// the database file. By default New waits indefinitely.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithNoSync skips fsync after each commit. Writes are faster, but a crash
+// of the machine can lose recent commits or corrupt the database; only use
+// it for caches that can be rebuilt.
+func WithNoSync() Option {
+	return func(c *config) {
+		c.noSync = true
+	}
+}
+
+// New opens the bbolt database at path, creating it if it does not exist.
+// Close the Backend to release the database file.
+func New(path string, opts ...Option) (*Backend, error) {
+	cfg := config{bucket: defaultBucket}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	db, err := bbolt.Open(path, 0o644, &bbolt.Options{Timeout: cfg.timeout, NoSync: cfg.noSync})
+	if err != nil {
+		return nil, fmt.Errorf("bolt: open %s: %w", path, err)
+	}
+	b := &Backend{db: db, bucket: []byte(cfg.bucket)}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(b.bucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("bolt: create bucket %s: %w", cfg.bucket, err)
+	}
+	return b, nil
+}
+
+// Close closes the database file.
+func (b *Backend) Close() error {
+	return b.db.Close()
+}
+
+// Values are the modification time in Unix nanoseconds, big-endian,
+// followed by the blob.
+const timeLen = 8
+
+func encode(data []byte, modTime time.Time) []byte {
+	value := make([]byte, timeLen+len(data))
+	binary.BigEndian.PutUint64(value, uint64(modTime.UnixNano()))
+	copy(value[timeLen:], data)
+	return value
+}
+
+func decodeInfo(key string, value []byte) backend.Info {
+	if len(value) < timeLen {
+		return backend.Info{Key: key}
+	}
+	return backend.Info{
+		Key:     key,
+		Size:    int64(len(value) - timeLen),
+		ModTime: time.Unix(0, int64(binary.BigEndian.Uint64(value))),
+	}
+}
+
+// get returns a copy of the value stored under key.
+func (b *Backend) get(op, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(b.bucket).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("%s %s: %w", op, key, fs.ErrNotExist)
+		}
+		value = bytes.Clone(v) // v is only valid during the transaction
+		return nil
+	})
+	return value, err
+}
+
+// Open implements backend.Backend.
+func (b *Backend) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	value, err := b.get("open", key)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < timeLen {
+		return nil, fmt.Errorf("bolt: open %s: truncated value", key)
+	}
+	return io.NopCloser(bytes.NewReader(value[timeLen:])), nil
+}
+
+// Stat implements backend.Backend.
+func (b *Backend) Stat(_ context.Context, key string) (backend.Info, error) {
+	value, err := b.get("stat", key)
+	if err != nil {
+		return backend.Info{}, err
+	}
+	return decodeInfo(key, value), nil
+}
+
+// Put implements backend.Backend. Values are stored whole, so r is read into
+// memory first.
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := backend.ReadBlob(r, size)
+	if err != nil {
+		return fmt.Errorf("bolt: put %s: %w", key, err)
+	}
+	value := encode(data, time.Now())
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(b.bucket).Put([]byte(key), value)
+	})
+}
+
+// Delete implements backend.Backend.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(b.bucket).Delete([]byte(key))
+	})
+}
+
+// List implements backend.Backend. Keys are yielded in sorted order, a page
+// at a time; keys written during the listing may or may not be listed.
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[backend.Info, error] {
+	return func(yield func(backend.Info, error) bool) {
+		seek := []byte(prefix)
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(backend.Info{}, err)
+				return
+			}
+			var page []backend.Info
+			err := b.db.View(func(tx *bbolt.Tx) error {
+				c := tx.Bucket(b.bucket).Cursor()
+				for k, v := c.Seek(seek); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+					if len(page) == listPage {
+						seek = bytes.Clone(k)
+						return nil
+					}
+					page = append(page, decodeInfo(string(k), v))
+				}
+				seek = nil
+				return nil
+			})
+			if err != nil {
+				yield(backend.Info{}, err)
+				return
+			}
+			for _, info := range page {
+				if !yield(info, nil) {
+					return
+				}
+			}
+			if seek == nil {
+				return
+			}
+		}
+	}
+}
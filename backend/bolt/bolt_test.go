@@ -0,0 +1,129 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/backend/backendtest"
+	"github.com/spf13/afero"
+)
+
+func TestBackend(t *testing.T) {
+	b, err := New(filepath.Join(t.TempDir(), "manifests.db"), WithBucket("test"), WithNoSync())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer b.Close()
+	backendtest.Run(t, b)
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifests.db")
+	b, err := New(path, WithBucket("test"), WithNoSync())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+	before := time.Now()
+	if err := b.Put(ctx, "ab/two.json", strings.NewReader("data"), 4); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	info, err := b.Stat(ctx, "ab/two.json")
+	if err != nil || info.ModTime.Before(before.Truncate(time.Second)) {
+		t.Errorf("Stat = %+v, %v; want the time of the Put", info, err)
+	}
+
+	// Blobs survive reopening, and buckets keep caches apart
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	b, err = New(path, WithBucket("test"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := b.Stat(ctx, "ab/two.json"); err != nil {
+		t.Errorf("Stat after reopen = %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	other, err := New(path, WithBucket("other"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Stat(ctx, "ab/two.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat in other bucket = %v, want ErrNotExist", err)
+	}
+}
+
+// TestListPages tests listing more keys than fit in one read transaction,
+// writing while the listing is in progress.
+func TestListPages(t *testing.T) {
+	b, err := New(filepath.Join(t.TempDir(), "manifests.db"), WithNoSync())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer b.Close()
+	ctx := context.Background()
+
+	const n = 2*listPage + 10
+	for i := range n {
+		if err := b.Put(ctx, fmt.Sprintf("m/%05d", i), strings.NewReader("x"), 1); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	count := 0
+	for info, err := range b.List(ctx, "m/") {
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if want := fmt.Sprintf("m/%05d", count); info.Key != want {
+			t.Fatalf("List yielded %s, want %s", info.Key, want)
+		}
+		if err := b.Put(ctx, "other/"+info.Key, strings.NewReader(""), 0); err != nil {
+			t.Fatalf("Put during List failed: %v", err)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("List yielded %d keys, want %d", count, n)
+	}
+}
+
+func TestManifestsInBolt(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "manifests.db"), WithNoSync())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer db.Close()
+
+	cache, err := granular.Open("/cache", granular.WithFs(afero.NewMemMapFs()), granular.WithManifestBackend(db))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := range 5 {
+		key := cache.Key().String("i", strconv.Itoa(i)).Build()
+		if err := cache.Put(key).Bytes("out", []byte("data")).Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+	}
+	entries, err := cache.Entries()
+	if err != nil || len(entries) != 5 {
+		t.Fatalf("Entries = %d, %v; want 5", len(entries), err)
+	}
+	if _, err := cache.Get(cache.Key().String("i", "3").Build()); err != nil {
+		t.Errorf("Get failed: %v", err)
+	}
+	if _, err := cache.Get(cache.Key().String("i", "9").Build()); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Get of missing key = %v, want ErrCacheMiss", err)
+	}
+}
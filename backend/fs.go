@@ -0,0 +1,741 @@
+package backend
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// NewFs returns an afero.Fs storing files in b.
+//
+// Files written from the start are streamed to the backend in a single Put
+// as they are written, which completes on Sync or Close. Files that are
+// read back, truncated, or written out of order while open are kept in a
+// temporary file instead and uploaded whole. Reads stream from the backend
+// until the file is seeked, which loads it into memory. Permissions
+// and times are not stored: Chmod, Chown, and Chtimes are no-ops. Renames
+// stream each blob to its new key and then delete the old one, so they are
+// not atomic.
+//
+// Example:
+//
+//	cache, err := granular.Open("", granular.WithFs(backend.NewFs(b)))
+func NewFs(b Backend) afero.Fs {
+	return &blobFs{b: b}
+}
+
+type blobFs struct {
+	b    Backend
+	dirs sync.Map // directory keys known to exist, to skip marker writes
+}
+
+// key maps a file name to a backend key: a clean, slash-separated path
+// without leading slash. The root directory is "".
+func key(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
+
+// dirPrefix returns the prefix of keys below the directory with key k, which
+// is also the key of its marker.
+func dirPrefix(k string) string {
+	if k == "" {
+		return ""
+	}
+	return k + "/"
+}
+
+func (bfs *blobFs) Name() string { return "BackendFs" }
+
+func (bfs *blobFs) Create(name string) (afero.File, error) {
+	return bfs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+func (bfs *blobFs) Mkdir(name string, _ os.FileMode) error {
+	k := key(name)
+	if k == "" {
+		return nil
+	}
+	if _, err := bfs.b.Stat(context.Background(), k); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	return bfs.mkdir(k)
+}
+
+func (bfs *blobFs) MkdirAll(name string, _ os.FileMode) error {
+	k := key(name)
+	for i := 0; i <= len(k); i++ {
+		if i == len(k) || k[i] == '/' {
+			if err := bfs.mkdir(k[:i]); err != nil {
+				return &os.PathError{Op: "mkdir", Path: name, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// mkdir writes the marker of the directory with key k.
+func (bfs *blobFs) mkdir(k string) error {
+	if k == "" {
+		return nil
+	}
+	if _, ok := bfs.dirs.Load(k); ok {
+		return nil
+	}
+	if err := bfs.b.Put(context.Background(), dirPrefix(k), bytes.NewReader(nil), 0); err != nil {
+		return err
+	}
+	bfs.dirs.Store(k, true)
+	return nil
+}
+
+// isDir reports whether the directory with key k exists.
+func (bfs *blobFs) isDir(k string) bool {
+	if k == "" {
+		return true
+	}
+	if _, ok := bfs.dirs.Load(k); ok {
+		return true
+	}
+	if _, err := bfs.b.Stat(context.Background(), dirPrefix(k)); err != nil {
+		return false
+	}
+	bfs.dirs.Store(k, true)
+	return true
+}
+
+// forgetDirs drops cached directories at or below the directory with key k.
+func (bfs *blobFs) forgetDirs(k string) {
+	bfs.dirs.Range(func(d, _ any) bool {
+		if dir := d.(string); dir == k || strings.HasPrefix(dir, dirPrefix(k)) {
+			bfs.dirs.Delete(dir)
+		}
+		return true
+	})
+}
+
+func (bfs *blobFs) Open(name string) (afero.File, error) {
+	return bfs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (bfs *blobFs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	ctx := context.Background()
+	k := key(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if k != "" {
+			rc, err := bfs.b.Open(ctx, k)
+			if err == nil {
+				return &readFile{fs: bfs, name: name, key: k, rc: rc}, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, &os.PathError{Op: "open", Path: name, Err: err}
+			}
+		}
+		if bfs.isDir(k) {
+			return &dirFile{fs: bfs, name: name, key: k}, nil
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if k == "" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	f := &writeFile{fs: bfs, name: name, key: k}
+	if flag&os.O_TRUNC == 0 || flag&os.O_EXCL != 0 || flag&os.O_CREATE == 0 {
+		info, err := bfs.b.Stat(ctx, k)
+		switch {
+		case err == nil:
+			if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+				return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+			}
+			if flag&os.O_TRUNC == 0 {
+				// Keep the stored content; it is only downloaded if
+				// the file is changed anywhere but at its end
+				f.size, f.stored, f.synced = info.Size, true, true
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		case flag&os.O_CREATE == 0:
+			return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	if flag&os.O_APPEND != 0 {
+		f.off = f.size
+	}
+	return f, nil
+}
+
+func (bfs *blobFs) Remove(name string) error {
+	ctx := context.Background()
+	k := key(name)
+	if _, err := bfs.b.Stat(ctx, k); err == nil && k != "" {
+		return bfs.b.Delete(ctx, k)
+	}
+	if k == "" || !bfs.isDir(k) {
+		return &os.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	for info, err := range bfs.b.List(ctx, dirPrefix(k)) {
+		if err != nil {
+			return &os.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if info.Key != dirPrefix(k) {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	bfs.forgetDirs(k)
+	return bfs.b.Delete(ctx, dirPrefix(k))
+}
+
+func (bfs *blobFs) RemoveAll(name string) error {
+	ctx := context.Background()
+	k := key(name)
+
+	var keys []string
+	for info, err := range bfs.b.List(ctx, dirPrefix(k)) {
+		if err != nil {
+			return &os.PathError{Op: "removeall", Path: name, Err: err}
+		}
+		keys = append(keys, info.Key)
+	}
+	if k != "" {
+		keys = append(keys, k)
+	}
+	bfs.forgetDirs(k)
+	slices.SortFunc(keys, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	for _, key := range keys {
+		if err := bfs.b.Delete(ctx, key); err != nil {
+			return &os.PathError{Op: "removeall", Path: name, Err: err}
+		}
+	}
+	return nil
+}
+
+func (bfs *blobFs) Rename(oldname, newname string) error {
+	ctx := context.Background()
+	oldKey, newKey := key(oldname), key(newname)
+	if oldKey == newKey {
+		return nil
+	}
+
+	moves := map[string]string{}
+	sizes := map[string]int64{}
+	if info, err := bfs.b.Stat(ctx, oldKey); err == nil && oldKey != "" {
+		moves[oldKey] = newKey
+		sizes[oldKey] = info.Size
+	} else if oldKey != "" && bfs.isDir(oldKey) {
+		for info, err := range bfs.b.List(ctx, dirPrefix(oldKey)) {
+			if err != nil {
+				return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+			}
+			moves[info.Key] = dirPrefix(newKey) + strings.TrimPrefix(info.Key, dirPrefix(oldKey))
+			sizes[info.Key] = info.Size
+		}
+		bfs.forgetDirs(oldKey)
+	} else {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+
+	// Copy everything before deleting anything, and delete deeper keys
+	// first: some backends delete a directory's contents with its marker.
+	froms := slices.SortedFunc(maps.Keys(moves), func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	for _, from := range froms {
+		if err := bfs.copy(ctx, from, moves[from], sizes[from]); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+	}
+	for _, from := range froms {
+		if err := bfs.b.Delete(ctx, from); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+	}
+	return nil
+}
+
+// copy streams the blob of the given size stored under from to to.
+func (bfs *blobFs) copy(ctx context.Context, from, to string, size int64) error {
+	rc, err := bfs.b.Open(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return bfs.b.Put(ctx, to, rc, size)
+}
+
+func (bfs *blobFs) Stat(name string) (os.FileInfo, error) {
+	k := key(name)
+	if k != "" {
+		info, err := bfs.b.Stat(context.Background(), k)
+		if err == nil {
+			return fileInfo(info), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+		}
+	}
+	if bfs.isDir(k) {
+		return dirInfo(path.Base("/" + k)), nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// LstatIfPossible implements afero.Lstater. Backends have no symlinks, so it
+// is Stat; granular's Export requires it.
+func (bfs *blobFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	info, err := bfs.Stat(name)
+	return info, false, err
+}
+
+func (bfs *blobFs) Chmod(string, os.FileMode) error            { return nil }
+func (bfs *blobFs) Chown(string, int, int) error               { return nil }
+func (bfs *blobFs) Chtimes(string, time.Time, time.Time) error { return nil }
+
+// blobInfo implements os.FileInfo for blobs and directories.
+type blobInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func fileInfo(info Info) *blobInfo {
+	return &blobInfo{name: path.Base(info.Key), size: info.Size, modTime: info.ModTime}
+}
+
+func dirInfo(name string) *blobInfo {
+	return &blobInfo{name: name, dir: true}
+}
+
+func (i *blobInfo) Name() string       { return i.name }
+func (i *blobInfo) Size() int64        { return i.size }
+func (i *blobInfo) ModTime() time.Time { return i.modTime }
+func (i *blobInfo) IsDir() bool        { return i.dir }
+func (i *blobInfo) Sys() any           { return nil }
+
+func (i *blobInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// readFile is a blob opened for reading. It streams from the backend until
+// random access is needed, then loads the blob into memory.
+type readFile struct {
+	fs   *blobFs
+	name string
+	key  string
+	rc   io.ReadCloser
+	off  int64
+	r    *bytes.Reader // non-nil once loaded
+}
+
+func (f *readFile) Name() string { return f.name }
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.r != nil {
+		return f.r.Read(p)
+	}
+	n, err := f.rc.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+// load reads the whole blob into memory, keeping the current offset.
+func (f *readFile) load() error {
+	if f.r != nil {
+		return nil
+	}
+	rc, err := f.fs.b.Open(context.Background(), f.key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return err
+	}
+	_ = f.rc.Close()
+	f.r = bytes.NewReader(data)
+	_, err = f.r.Seek(f.off, io.SeekStart)
+	return err
+}
+
+func (f *readFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	info, err := f.fs.b.Stat(context.Background(), f.key)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	return fileInfo(info), nil
+}
+
+func (f *readFile) Close() error {
+	if f.r != nil {
+		return nil
+	}
+	return f.rc.Close()
+}
+
+func (f *readFile) Sync() error { return nil }
+
+func (f *readFile) Write([]byte) (int, error)          { return 0, f.readOnly("write") }
+func (f *readFile) WriteAt([]byte, int64) (int, error) { return 0, f.readOnly("write") }
+func (f *readFile) WriteString(string) (int, error)    { return 0, f.readOnly("write") }
+func (f *readFile) Truncate(int64) error               { return f.readOnly("truncate") }
+func (f *readFile) Readdir(int) ([]os.FileInfo, error) { return nil, f.notDir() }
+func (f *readFile) Readdirnames(int) ([]string, error) { return nil, f.notDir() }
+
+func (f *readFile) readOnly(op string) error {
+	return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+}
+
+func (f *readFile) notDir() error {
+	return &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+// writeFile is a blob opened for writing. Writes at the end of a new or
+// truncated file are streamed to the backend in a single Put, started on
+// the first write and finished by Sync or Close. Anything else, such as
+// reading, writing elsewhere, or truncating, moves the content into a
+// temporary file first, which is uploaded whole by Sync and Close.
+type writeFile struct {
+	fs     *blobFs
+	name   string
+	key    string
+	off    int64
+	size   int64
+	stored bool // the backend holds the content up to size
+	synced bool // the backend holds the current content
+	closed bool
+
+	pw   *io.PipeWriter // streaming upload in progress, if non-nil
+	done chan error     // receives the result of the streaming upload
+	tmp  *os.File       // content, once it has left the stream
+}
+
+func (f *writeFile) Name() string { return f.name }
+
+func (f *writeFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *writeFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if err := f.spill("read"); err != nil {
+		return 0, err
+	}
+	return f.tmp.ReadAt(p, off)
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	n, err := f.WriteAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	f.synced = false
+	if f.tmp == nil && off == f.size && (f.pw != nil || f.size == 0) {
+		if f.pw == nil {
+			f.startUpload()
+		}
+		n, err := f.pw.Write(p)
+		f.size += int64(n)
+		if err != nil {
+			return n, &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+		return n, nil
+	}
+	if err := f.spill("write"); err != nil {
+		return 0, err
+	}
+	n, err := f.tmp.WriteAt(p, off)
+	f.size = max(f.size, off+int64(n))
+	return n, err
+}
+
+func (f *writeFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// startUpload starts streaming the file's content to the backend.
+func (f *writeFile) startUpload() {
+	pr, pw := io.Pipe()
+	f.pw, f.done = pw, make(chan error, 1)
+	go func() {
+		err := f.fs.b.Put(context.Background(), f.key, pr, -1)
+		// Fail writes still waiting for a reader that stopped early
+		_ = pr.CloseWithError(cmp.Or(err, io.ErrClosedPipe))
+		f.done <- err
+	}()
+}
+
+// finishUpload ends the streaming upload and waits for its result.
+func (f *writeFile) finishUpload() error {
+	if f.pw == nil {
+		return nil
+	}
+	_ = f.pw.Close()
+	err := <-f.done
+	f.pw, f.done = nil, nil
+	if err != nil {
+		return err
+	}
+	f.stored = true
+	return nil
+}
+
+// spill moves the content into a temporary file for random access. A
+// streaming upload is finished first, and stored content downloaded.
+func (f *writeFile) spill(op string) error {
+	if f.tmp != nil {
+		return nil
+	}
+	if err := f.finishUpload(); err != nil {
+		return &os.PathError{Op: op, Path: f.name, Err: err}
+	}
+	tmp, err := os.CreateTemp("", "granular-blob-*")
+	if err != nil {
+		return &os.PathError{Op: op, Path: f.name, Err: err}
+	}
+	if f.stored && f.size > 0 {
+		err = f.download(tmp)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return &os.PathError{Op: op, Path: f.name, Err: err}
+	}
+	f.tmp = tmp
+	return nil
+}
+
+// download copies the stored content into tmp.
+func (f *writeFile) download(tmp *os.File) error {
+	rc, err := f.fs.b.Open(context.Background(), f.key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.CopyN(tmp, rc, f.size)
+	return err
+}
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *writeFile) Truncate(size int64) error {
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EINVAL}
+	}
+	if size == f.size {
+		return nil
+	}
+	f.synced = false
+	if size == 0 && f.pw == nil {
+		if f.tmp != nil {
+			if err := f.tmp.Truncate(0); err != nil {
+				return err
+			}
+		}
+		f.size, f.stored = 0, false
+		return nil
+	}
+	if err := f.spill("truncate"); err != nil {
+		return err
+	}
+	if err := f.tmp.Truncate(size); err != nil {
+		return err
+	}
+	f.size = size
+	return nil
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	return &blobInfo{name: path.Base(f.key), size: f.size, modTime: time.Now()}, nil
+}
+
+// Sync uploads the content, unless the backend already holds it.
+func (f *writeFile) Sync() error {
+	if f.synced {
+		return nil
+	}
+	var err error
+	switch {
+	case f.pw != nil:
+		err = f.finishUpload()
+	case f.tmp != nil:
+		err = f.fs.b.Put(context.Background(), f.key, io.NewSectionReader(f.tmp, 0, f.size), f.size)
+	default:
+		err = f.fs.b.Put(context.Background(), f.key, bytes.NewReader(nil), 0)
+	}
+	if err != nil {
+		return &os.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	f.stored, f.synced = true, true
+	return nil
+}
+
+func (f *writeFile) Close() error {
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	err := f.Sync()
+	if f.pw != nil {
+		_ = f.finishUpload()
+	}
+	if f.tmp != nil {
+		_ = f.tmp.Close()
+		_ = os.Remove(f.tmp.Name())
+	}
+	return err
+}
+
+func (f *writeFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+func (f *writeFile) Readdirnames(int) ([]string, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+// dirFile is an open directory. Its entries are derived from the keys below
+// it when first read.
+type dirFile struct {
+	fs      *blobFs
+	name    string
+	key     string
+	entries []os.FileInfo
+	loaded  bool
+	pos     int
+}
+
+func (d *dirFile) Name() string { return d.name }
+
+func (d *dirFile) load() error {
+	if d.loaded {
+		return nil
+	}
+	prefix := dirPrefix(d.key)
+	seen := make(map[string]bool)
+	for info, err := range d.fs.b.List(context.Background(), prefix) {
+		if err != nil {
+			return &os.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		rest := strings.TrimPrefix(info.Key, prefix)
+		if rest == "" {
+			continue // the directory's own marker
+		}
+		name, _, nested := strings.Cut(rest, "/")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if nested {
+			d.entries = append(d.entries, dirInfo(name))
+		} else {
+			d.entries = append(d.entries, fileInfo(info))
+		}
+	}
+	slices.SortFunc(d.entries, func(a, b os.FileInfo) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	d.loaded = true
+	return nil
+}
+
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	rest := d.entries[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(count, len(rest))]
+	d.pos += len(rest)
+	return rest, nil
+}
+
+func (d *dirFile) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+func (d *dirFile) Stat() (os.FileInfo, error) {
+	return dirInfo(path.Base("/" + d.key)), nil
+}
+
+func (d *dirFile) Close() error { return nil }
+func (d *dirFile) Sync() error  { return nil }
+
+func (d *dirFile) Read([]byte) (int, error)           { return 0, d.isDir() }
+func (d *dirFile) ReadAt([]byte, int64) (int, error)  { return 0, d.isDir() }
+func (d *dirFile) Seek(int64, int) (int64, error)     { return 0, d.isDir() }
+func (d *dirFile) Write([]byte) (int, error)          { return 0, d.isDir() }
+func (d *dirFile) WriteAt([]byte, int64) (int, error) { return 0, d.isDir() }
+func (d *dirFile) WriteString(string) (int, error)    { return 0, d.isDir() }
+func (d *dirFile) Truncate(int64) error               { return d.isDir() }
+
+func (d *dirFile) isDir() error {
+	return &os.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
@@ -0,0 +1,247 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestFs(t *testing.T) {
+	bfs := NewFs(NewMemory())
+
+	if _, err := bfs.Open("/missing.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(missing) = %v, want ErrNotExist", err)
+	}
+	if err := bfs.MkdirAll("/manifests/ab", 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if info, err := bfs.Stat("/manifests/ab"); err != nil || !info.IsDir() {
+		t.Fatalf("Stat(dir) = %v, %v; want a directory", info, err)
+	}
+	if entries, err := afero.ReadDir(bfs, "/manifests/ab"); err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir(empty dir) = %v, %v", entries, err)
+	}
+
+	if err := afero.WriteFile(bfs, "/manifests/ab/abc.json.tmp", []byte("manifest"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := bfs.Rename("/manifests/ab/abc.json.tmp", "/manifests/ab/abc.json"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	data, err := afero.ReadFile(bfs, "/manifests/ab/abc.json")
+	if err != nil || string(data) != "manifest" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	if _, err := bfs.Stat("/manifests/ab/abc.json.tmp"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(renamed) = %v, want ErrNotExist", err)
+	}
+
+	// Seeking loads the blob, keeping the position
+	f, err := bfs.Open("/manifests/ab/abc.json")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	head := make([]byte, 3)
+	_, _ = io.ReadFull(f, head)
+	if pos, err := f.Seek(0, io.SeekCurrent); err != nil || pos != 3 {
+		t.Errorf("Seek after read = %d, %v; want 3", pos, err)
+	}
+	rest, _ := io.ReadAll(f)
+	if string(head)+string(rest) != "manifest" {
+		t.Errorf("read %q + %q", head, rest)
+	}
+	_ = f.Close()
+
+	if err := afero.WriteFile(bfs, "/manifests/cd/def.json", []byte("x"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	names, err := afero.ReadDir(bfs, "/manifests")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var got []string
+	for _, info := range names {
+		got = append(got, info.Name())
+		if !info.IsDir() {
+			t.Errorf("%s: expected a directory", info.Name())
+		}
+	}
+	if !slices.Equal(got, []string{"ab", "cd"}) {
+		t.Errorf("ReadDir(/manifests) = %v, want [ab cd]", got)
+	}
+
+	if err := bfs.Remove("/manifests/ab"); err == nil {
+		t.Error("Expected Remove of a non-empty directory to fail")
+	}
+	if err := bfs.RemoveAll("/manifests/ab"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if _, err := bfs.Stat("/manifests/ab/abc.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat after RemoveAll = %v, want ErrNotExist", err)
+	}
+	if _, err := bfs.OpenFile("/manifests/cd/def.json", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("OpenFile(O_EXCL) on existing file = %v, want ErrExist", err)
+	}
+}
+
+// countingBackend counts Puts and discards the blobs written with them.
+type countingBackend struct {
+	Backend
+	puts    atomic.Int64
+	written atomic.Int64
+}
+
+func (b *countingBackend) Put(_ context.Context, _ string, r io.Reader, _ int64) error {
+	b.puts.Add(1)
+	n, err := io.Copy(io.Discard, r)
+	b.written.Add(n)
+	return err
+}
+
+// zeroReader yields n zero bytes without allocating.
+type zeroReader struct{ n int64 }
+
+func (r *zeroReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), r.n)]
+	clear(p)
+	r.n -= int64(len(p))
+	return len(p), nil
+}
+
+func TestFsStreamsWrites(t *testing.T) {
+	const size = 64 << 20
+	b := &countingBackend{Backend: NewMemory()}
+	bfs := NewFs(b)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	f, err := bfs.OpenFile("/objects/big.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := io.Copy(f, &zeroReader{n: size}); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if puts := b.puts.Load(); puts != 1 {
+		t.Errorf("Write, Sync, and Close made %d Puts, want 1", puts)
+	}
+	if written := b.written.Load(); written != size {
+		t.Errorf("Put read %d bytes, want %d", written, size)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Errorf("Writing %d bytes allocated %d bytes, want the file streamed", size, allocated)
+	}
+}
+
+func TestFsRandomWrites(t *testing.T) {
+	b := &countingBackend{Backend: NewMemory()}
+	bfs := NewFs(b.Backend)
+	if err := afero.WriteFile(bfs, "/f", []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// Resuming a partial file keeps what is stored up to the offset
+	f, err := bfs.OpenFile("/f", os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if err := f.Truncate(6); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if _, err := f.Write([]byte("there")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("H"), 0); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	head := make([]byte, 5)
+	if _, err := f.ReadAt(head, 0); err != nil || string(head) != "Hello" {
+		t.Errorf("ReadAt = %q, %v; want Hello", head, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if data, err := afero.ReadFile(bfs, "/f"); err != nil || string(data) != "Hello there" {
+		t.Errorf("ReadFile = %q, %v; want %q", data, err, "Hello there")
+	}
+
+	// Opening a file without changing it stores nothing
+	f, err = NewFs(b).OpenFile("/f", os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if puts := b.puts.Load(); puts != 0 {
+		t.Errorf("Closing an unchanged file made %d Puts, want 0", puts)
+	}
+}
+
+func TestMount(t *testing.T) {
+	base := afero.NewMemMapFs()
+	mem := NewMemory()
+	mfs := Mount(base, "/cache/manifests", NewFs(mem))
+
+	if err := mfs.MkdirAll("/cache/manifests/ab", 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := afero.WriteFile(mfs, "/cache/manifests/ab/abc.json", []byte("m"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := afero.WriteFile(mfs, "/cache/objects/ab/abc/data.out.dat", []byte("o"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	if exists, _ := afero.Exists(base, "/cache/manifests/ab/abc.json"); exists {
+		t.Error("Expected manifest to bypass the base filesystem")
+	}
+	if exists, _ := afero.Exists(base, "/cache/objects/ab/abc/data.out.dat"); !exists {
+		t.Error("Expected object on the base filesystem")
+	}
+
+	// Walks cross into the mount
+	var walked []string
+	_ = afero.Walk(mfs, "/cache", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			walked = append(walked, path)
+		}
+		return err
+	})
+	if want := []string{"/cache/manifests/ab/abc.json", "/cache/objects/ab/abc/data.out.dat"}; !slices.Equal(walked, want) {
+		t.Errorf("Walk = %v, want %v", walked, want)
+	}
+
+	if err := mfs.Rename("/cache/manifests/ab/abc.json", "/cache/abc.json"); err == nil {
+		t.Error("Expected rename out of the mount to fail")
+	}
+	if err := mfs.RemoveAll("/cache"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	for info := range mem.List(t.Context(), "") {
+		t.Errorf("Expected mount to be emptied, found %s", info.Key)
+	}
+}
@@ -0,0 +1,202 @@
+// Package gcs implements a granular backend on Google Cloud Storage.
+//
+// It uses the Cloud Storage JSON API directly and has no dependencies.
+// Authentication is left to the http.Client passed with WithHTTPClient,
+// typically one from golang.org/x/oauth2/google:
+//
+//	client, _ := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_write")
+//	b := gcs.New("my-bucket", gcs.WithHTTPClient(client), gcs.WithPrefix("build-cache/"))
+//	cache, err := granular.Open("", granular.WithBackend(b))
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophersatwork/granular/backend"
+)
+
+// DefaultEndpoint is the Cloud Storage API endpoint.
+const DefaultEndpoint = "https://storage.googleapis.com"
+
+// Backend is a backend.Backend storing blobs as objects in a bucket.
+type Backend struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	client   *http.Client
+}
+
+var _ backend.Backend = (*Backend)(nil)
+
+// Option configures a Backend.
+type Option func(*Backend)
+
+// WithHTTPClient sets the client used for requests. It must authenticate
+// them; the default client sends anonymous requests, which only work for
+// public buckets and emulators.
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *Backend) {
+		b.client = client
+	}
+}
+
+// WithPrefix stores objects under prefix, so several caches can share a
+// bucket. Use a distinct prefix per cache, usually ending in "/".
+func WithPrefix(prefix string) Option {
+	return func(b *Backend) {
+		b.prefix = prefix
+	}
+}
+
+// WithEndpoint sets the API endpoint, for emulators such as fake-gcs-server.
+func WithEndpoint(endpoint string) Option {
+	return func(b *Backend) {
+		b.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// New returns a Backend for the given bucket.
+func New(bucket string, opts ...Option) *Backend {
+	b := &Backend{bucket: bucket, endpoint: DefaultEndpoint, client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// object is the subset of the object resource used by the backend.
+type object struct {
+	Name    string    `json:"name"`
+	Size    string    `json:"size"` // int64 encoded as a string
+	Updated time.Time `json:"updated"`
+}
+
+func (b *Backend) info(o object) backend.Info {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return backend.Info{Key: strings.TrimPrefix(o.Name, b.prefix), Size: size, ModTime: o.Updated}
+}
+
+func (b *Backend) objectURL(key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", b.endpoint, url.PathEscape(b.bucket), url.PathEscape(b.prefix+key))
+}
+
+// Open implements backend.Backend.
+func (b *Backend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, b.objectURL(key)+"?alt=media", nil, 0, key)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat implements backend.Backend.
+func (b *Backend) Stat(ctx context.Context, key string) (backend.Info, error) {
+	resp, err := b.do(ctx, http.MethodGet, b.objectURL(key), nil, 0, key)
+	if err != nil {
+		return backend.Info{}, err
+	}
+	defer resp.Body.Close()
+	var o object
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		return backend.Info{}, fmt.Errorf("gcs: stat %s: %w", key, err)
+	}
+	return b.info(o), nil
+}
+
+// Put implements backend.Backend. The blob is streamed in a single media
+// upload, with chunked transfer encoding if its size is not known.
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		b.endpoint, url.PathEscape(b.bucket), url.QueryEscape(b.prefix+key))
+	resp, err := b.do(ctx, http.MethodPost, u, r, size, key)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Delete implements backend.Backend.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, b.objectURL(key), nil, 0, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List implements backend.Backend.
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[backend.Info, error] {
+	return func(yield func(backend.Info, error) bool) {
+		query := url.Values{"prefix": {b.prefix + prefix}, "fields": {"items(name,size,updated),nextPageToken"}}
+		for {
+			u := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", b.endpoint, url.PathEscape(b.bucket), query.Encode())
+			resp, err := b.do(ctx, http.MethodGet, u, nil, 0, prefix)
+			if err != nil {
+				yield(backend.Info{}, err)
+				return
+			}
+			var page struct {
+				Items         []object `json:"items"`
+				NextPageToken string   `json:"nextPageToken"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&page)
+			_ = resp.Body.Close()
+			if err != nil {
+				yield(backend.Info{}, fmt.Errorf("gcs: list %s: %w", prefix, err))
+				return
+			}
+			for _, o := range page.Items {
+				if !yield(b.info(o), nil) {
+					return
+				}
+			}
+			if page.NextPageToken == "" {
+				return
+			}
+			query.Set("pageToken", page.NextPageToken)
+		}
+	}
+}
+
+// do sends a request and returns the response if it succeeded. 404 is
+// reported as fs.ErrNotExist.
+func (b *Backend) do(ctx context.Context, method, u string, body io.Reader, size int64, key string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: %w", err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("gcs: %s: %w", key, fs.ErrNotExist)
+	}
+	return nil, fmt.Errorf("gcs: %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
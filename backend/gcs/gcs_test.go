@@ -0,0 +1,109 @@
+package gcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/backend/backendtest"
+)
+
+// fakeGCS serves the subset of the Cloud Storage JSON API used by Backend.
+// Listing returns two objects per page to exercise page tokens.
+func fakeGCS(t *testing.T, bucket string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /upload/storage/v1/b/"+bucket+"/o", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		objects[r.URL.Query().Get("name")] = data
+		mu.Unlock()
+		fmt.Fprint(w, "{}")
+	})
+	mux.HandleFunc("/storage/v1/b/"+bucket+"/o/{object...}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		name := r.PathValue("object")
+		data, ok := objects[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Query().Get("alt") == "media":
+			_, _ = w.Write(data)
+		default:
+			_ = json.NewEncoder(w).Encode(map[string]string{"name": name, "size": fmt.Sprint(len(data))})
+		}
+	})
+	mux.HandleFunc("GET /storage/v1/b/"+bucket+"/o", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var names []string
+		for name := range objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		start := 0
+		fmt.Sscan(r.URL.Query().Get("pageToken"), &start)
+		end := min(start+2, len(names))
+		page := map[string]any{"items": []map[string]string{}}
+		for _, name := range names[start:end] {
+			page["items"] = append(page["items"].([]map[string]string), map[string]string{"name": name, "size": fmt.Sprint(len(objects[name]))})
+		}
+		if end < len(names) {
+			page["nextPageToken"] = fmt.Sprint(end)
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestBackend(t *testing.T) {
+	ts := fakeGCS(t, "bucket")
+	backendtest.Run(t, New("bucket", WithEndpoint(ts.URL), WithHTTPClient(ts.Client()), WithPrefix("cache/")))
+}
+
+func TestCacheInBucket(t *testing.T) {
+	ts := fakeGCS(t, "bucket")
+	b := New("bucket", WithEndpoint(ts.URL), WithHTTPClient(ts.Client()))
+
+	cache, err := granular.Open("", granular.WithBackend(b))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := cache.Key().String("target", "app").Build()
+	if err := cache.Put(key).Bytes("out", []byte("built")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	reopened, err := granular.Open("", granular.WithBackend(b))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	result, err := reopened.Get(reopened.Key().String("target", "app").Build())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if data, _ := result.BytesErr("out"); string(data) != "built" {
+		t.Errorf("cached data = %q", data)
+	}
+}
@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Backend holding blobs in memory. It is useful in tests and as
+// a reference for implementing a Backend.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty in-memory Backend.
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string]memoryBlob)}
+}
+
+// Open implements Backend.
+func (m *Memory) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[key]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", key, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(blob.data)), nil
+}
+
+// Stat implements Backend.
+func (m *Memory) Stat(_ context.Context, key string) (Info, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[key]
+	if !ok {
+		return Info{}, fmt.Errorf("stat %s: %w", key, fs.ErrNotExist)
+	}
+	return Info{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime}, nil
+}
+
+// Put implements Backend.
+func (m *Memory) Put(_ context.Context, key string, r io.Reader, size int64) error {
+	data, err := ReadBlob(r, size)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = memoryBlob{data: data, modTime: time.Now()}
+	return nil
+}
+
+// Delete implements Backend.
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+// List implements Backend.
+func (m *Memory) List(_ context.Context, prefix string) iter.Seq2[Info, error] {
+	return func(yield func(Info, error) bool) {
+		m.mu.RLock()
+		var infos []Info
+		for _, key := range slices.Sorted(maps.Keys(m.blobs)) {
+			if strings.HasPrefix(key, prefix) {
+				blob := m.blobs[key]
+				infos = append(infos, Info{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime})
+			}
+		}
+		m.mu.RUnlock()
+
+		for _, info := range infos {
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package backend_test
+
+import (
+	"testing"
+
+	"github.com/gophersatwork/granular/backend"
+	"github.com/gophersatwork/granular/backend/backendtest"
+)
+
+func TestMemory(t *testing.T) {
+	backendtest.Run(t, backend.NewMemory())
+}
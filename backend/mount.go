@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Mount returns an afero.Fs that serves the directory dir and everything
+// below it from mounted, and all other paths from base. Paths are passed to
+// mounted relative to dir, so dir itself is the root of mounted.
+//
+// Creating dir also creates it in base, so walks of base descend into the
+// mount. Renames between base and the mount fail.
+//
+// Example:
+//
+//	// Manifests in Redis, objects on NFS
+//	fs := backend.Mount(afero.NewOsFs(), "/mnt/nfs/cache/manifests", backend.NewFs(rdb))
+func Mount(base afero.Fs, dir string, mounted afero.Fs) afero.Fs {
+	return &mountFs{base: base, dir: filepath.Clean(dir), mounted: mounted}
+}
+
+type mountFs struct {
+	base    afero.Fs
+	dir     string
+	mounted afero.Fs
+}
+
+// route returns the filesystem serving name and the name to pass to it.
+func (m *mountFs) route(name string) (afero.Fs, string, bool) {
+	clean := filepath.Clean(name)
+	if clean == m.dir {
+		return m.mounted, string(filepath.Separator), true
+	}
+	if rel, ok := strings.CutPrefix(clean, m.dir+string(filepath.Separator)); ok {
+		return m.mounted, string(filepath.Separator) + rel, true
+	}
+	return m.base, name, false
+}
+
+// under reports whether the mount is below the directory name.
+func (m *mountFs) under(name string) bool {
+	ancestor := filepath.Clean(name)
+	if !strings.HasSuffix(ancestor, string(filepath.Separator)) {
+		ancestor += string(filepath.Separator)
+	}
+	return ancestor == "."+string(filepath.Separator) && !filepath.IsAbs(m.dir) || strings.HasPrefix(m.dir, ancestor)
+}
+
+func (m *mountFs) Name() string { return "MountFs" }
+
+func (m *mountFs) Create(name string) (afero.File, error) {
+	fs, name, _ := m.route(name)
+	return fs.Create(name)
+}
+
+func (m *mountFs) Mkdir(name string, perm os.FileMode) error {
+	fs, rel, mounted := m.route(name)
+	if mounted {
+		if err := m.base.MkdirAll(m.dir, perm); err != nil {
+			return err
+		}
+	}
+	return fs.Mkdir(rel, perm)
+}
+
+func (m *mountFs) MkdirAll(name string, perm os.FileMode) error {
+	fs, rel, mounted := m.route(name)
+	if mounted {
+		if err := m.base.MkdirAll(m.dir, perm); err != nil {
+			return err
+		}
+	}
+	return fs.MkdirAll(rel, perm)
+}
+
+func (m *mountFs) Open(name string) (afero.File, error) {
+	fs, name, _ := m.route(name)
+	return fs.Open(name)
+}
+
+func (m *mountFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fs, name, _ := m.route(name)
+	return fs.OpenFile(name, flag, perm)
+}
+
+func (m *mountFs) Remove(name string) error {
+	fs, name, _ := m.route(name)
+	return fs.Remove(name)
+}
+
+func (m *mountFs) RemoveAll(name string) error {
+	fs, rel, mounted := m.route(name)
+	if !mounted && m.under(name) {
+		// Removing an ancestor of the mount empties the mount too
+		if err := m.mounted.RemoveAll(string(filepath.Separator)); err != nil {
+			return err
+		}
+	}
+	return fs.RemoveAll(rel)
+}
+
+func (m *mountFs) Rename(oldname, newname string) error {
+	oldFs, oldRel, oldMounted := m.route(oldname)
+	_, newRel, newMounted := m.route(newname)
+	if oldMounted != newMounted {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return oldFs.Rename(oldRel, newRel)
+}
+
+func (m *mountFs) Stat(name string) (os.FileInfo, error) {
+	fs, name, _ := m.route(name)
+	return fs.Stat(name)
+}
+
+// LstatIfPossible implements afero.Lstater, which granular's Export requires.
+func (m *mountFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fs, name, _ := m.route(name)
+	if lstater, ok := fs.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+	info, err := fs.Stat(name)
+	return info, false, err
+}
+
+func (m *mountFs) Chmod(name string, mode os.FileMode) error {
+	fs, name, _ := m.route(name)
+	return fs.Chmod(name, mode)
+}
+
+func (m *mountFs) Chown(name string, uid, gid int) error {
+	fs, name, _ := m.route(name)
+	return fs.Chown(name, uid, gid)
+}
+
+func (m *mountFs) Chtimes(name string, atime, mtime time.Time) error {
+	fs, name, _ := m.route(name)
+	return fs.Chtimes(name, atime, mtime)
+}
@@ -0,0 +1,373 @@
+// Package redis implements a granular backend on Redis or Valkey.
+//
+// It is meant for manifests, which are small and read on every lookup (see
+// granular.WithManifestBackend); large objects belong on a filesystem or an
+// object store. Blobs are stored as string values under a configurable key
+// prefix. The client speaks RESP2 over plain TCP and has no dependencies.
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophersatwork/granular/backend"
+)
+
+// scanCount is the number of keys requested per SCAN call.
+const scanCount = 1000
+
+// Backend is a backend.Backend storing blobs in Redis. It keeps a small pool
+// of connections and is safe for concurrent use.
+type Backend struct {
+	addr        string
+	password    string
+	db          int
+	prefix      string
+	dialTimeout time.Duration
+	pool        chan *conn
+
+	mu     sync.Mutex // guards closed and sends to pool
+	closed bool       // set by Close; returned connections are closed, not pooled
+}
+
+var _ backend.Backend = (*Backend)(nil)
+
+// Option configures a Backend.
+type Option func(*Backend)
+
+// WithPassword authenticates connections with AUTH.
+func WithPassword(password string) Option {
+	return func(b *Backend) {
+		b.password = password
+	}
+}
+
+// WithDB selects the logical database used by connections.
+func WithDB(db int) Option {
+	return func(b *Backend) {
+		b.db = db
+	}
+}
+
+// WithPrefix prepends prefix to every key, so several caches can share a
+// Redis instance. Use a distinct prefix per cache.
+//
+// Example:
+//
+//	rdb := redis.New("localhost:6379", redis.WithPrefix("granular:ci:"))
+func WithPrefix(prefix string) Option {
+	return func(b *Backend) {
+		b.prefix = prefix
+	}
+}
+
+// WithPoolSize sets the number of idle connections kept open (default 8).
+func WithPoolSize(n int) Option {
+	return func(b *Backend) {
+		b.pool = make(chan *conn, max(n, 1))
+	}
+}
+
+// New returns a Backend for the Redis server at addr ("host:port").
+// Connections are opened on demand.
+func New(addr string, opts ...Option) *Backend {
+	b := &Backend{
+		addr:        addr,
+		dialTimeout: 5 * time.Second,
+		pool:        make(chan *conn, 8),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Close closes idle connections. Connections in use are closed when they
+// are returned, and so are those opened by calls made after Close.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	var errs []error
+	for {
+		select {
+		case c := <-b.pool:
+			errs = append(errs, c.Close())
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// Open implements backend.Backend.
+func (b *Backend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	replies, err := b.do(ctx, []string{"GET", b.prefix + key})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := replies[0].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", key, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Stat implements backend.Backend. Redis does not record modification
+// times, so ModTime is zero.
+func (b *Backend) Stat(ctx context.Context, key string) (backend.Info, error) {
+	replies, err := b.do(ctx, []string{"EXISTS", b.prefix + key}, []string{"STRLEN", b.prefix + key})
+	if err != nil {
+		return backend.Info{}, err
+	}
+	if replies[0] != int64(1) {
+		return backend.Info{}, fmt.Errorf("stat %s: %w", key, fs.ErrNotExist)
+	}
+	size, _ := replies[1].(int64)
+	return backend.Info{Key: key, Size: size}, nil
+}
+
+// Put implements backend.Backend. Values are sent whole, so r is read into
+// memory first.
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := backend.ReadBlob(r, size)
+	if err != nil {
+		return fmt.Errorf("redis: put %s: %w", key, err)
+	}
+	_, err = b.do(ctx, []string{"SET", b.prefix + key, string(data)})
+	return err
+}
+
+// Delete implements backend.Backend.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.do(ctx, []string{"DEL", b.prefix + key})
+	return err
+}
+
+// List implements backend.Backend using SCAN, so it does not block the
+// server. Keys written during the scan may or may not be listed.
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[backend.Info, error] {
+	return func(yield func(backend.Info, error) bool) {
+		pattern := escapeGlob(b.prefix+prefix) + "*"
+		cursor := "0"
+		for {
+			replies, err := b.do(ctx, []string{"SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(scanCount)})
+			if err != nil {
+				yield(backend.Info{}, err)
+				return
+			}
+			page, ok := replies[0].([]any)
+			if !ok || len(page) != 2 {
+				yield(backend.Info{}, fmt.Errorf("redis: unexpected SCAN reply %v", replies[0]))
+				return
+			}
+			next, _ := page[0].([]byte)
+			keys, _ := page[1].([]any)
+
+			// Sizes of the whole page in one round trip
+			cmds := make([][]string, len(keys))
+			for i, k := range keys {
+				key, _ := k.([]byte)
+				cmds[i] = []string{"STRLEN", string(key)}
+			}
+			sizes, err := b.do(ctx, cmds...)
+			if err != nil {
+				yield(backend.Info{}, err)
+				return
+			}
+			for i, k := range keys {
+				key, _ := k.([]byte)
+				size, _ := sizes[i].(int64)
+				if !yield(backend.Info{Key: strings.TrimPrefix(string(key), b.prefix), Size: size}, nil) {
+					return
+				}
+			}
+
+			cursor = string(next)
+			if cursor == "0" || cursor == "" {
+				return
+			}
+		}
+	}
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as a pattern.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// do sends cmds in one pipeline and returns their replies. A Redis error
+// reply to any command is returned as an error.
+func (b *Backend) do(ctx context.Context, cmds ...[]string) ([]any, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	c, err := b.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := c.pipeline(ctx, cmds)
+	if err != nil {
+		if _, ok := errors.AsType[redisError](err); !ok {
+			_ = c.Close() // the connection state is unknown
+			return nil, err
+		}
+	}
+	b.put(c)
+	return replies, err
+}
+
+// get returns an idle connection or dials a new one.
+func (b *Backend) get(ctx context.Context) (*conn, error) {
+	select {
+	case c := <-b.pool:
+		return c, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: b.dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var setup [][]string
+	if b.password != "" {
+		setup = append(setup, []string{"AUTH", b.password})
+	}
+	if b.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(b.db)})
+	}
+	if len(setup) > 0 {
+		if _, err := c.pipeline(ctx, setup); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full.
+func (b *Backend) put(c *conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		_ = c.Close()
+		return
+	}
+	select {
+	case b.pool <- c:
+	default:
+		_ = c.Close()
+	}
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// conn is a connection speaking RESP2.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// pipeline writes every command, then reads one reply per command. The
+// first error reply is returned after all replies have been read, so the
+// connection stays usable.
+func (c *conn) pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	replies := make([]any, len(cmds))
+	var replyErr error
+	for i := range cmds {
+		reply, err := c.readReply()
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		if e, ok := reply.(redisError); ok && replyErr == nil {
+			replyErr = e
+		}
+		replies[i] = reply
+	}
+	return replies, replyErr
+}
+
+// readReply reads one RESP2 value: a string, redisError, int64, []byte, nil
+// (null bulk string or array), or []any.
+func (c *conn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", kind)
+	}
+}
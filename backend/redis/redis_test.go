@@ -0,0 +1,240 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/backend/backendtest"
+	"github.com/spf13/afero"
+)
+
+// fakeRedis is a minimal in-process Redis server implementing the commands
+// the backend uses. SCAN returns two keys per page to exercise cursors.
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]string
+	auth string
+}
+
+func startFakeRedis(t *testing.T, password string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	srv := &fakeRedis{data: make(map[string]string), auth: password}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (s *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	authed := s.auth == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			fmt.Fprint(w, "-NOAUTH Authentication required.\r\n")
+		} else {
+			authed = s.exec(w, cmd, args[1:], authed)
+		}
+		if r.Buffered() == 0 {
+			_ = w.Flush()
+		}
+	}
+}
+
+func (s *fakeRedis) exec(w io.Writer, cmd string, args []string, authed bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bulk := func(v string) { fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v) }
+	switch cmd {
+	case "AUTH":
+		if args[0] != s.auth {
+			fmt.Fprint(w, "-WRONGPASS invalid password\r\n")
+			return authed
+		}
+		fmt.Fprint(w, "+OK\r\n")
+		return true
+	case "SELECT":
+		fmt.Fprint(w, "+OK\r\n")
+	case "GET":
+		if v, ok := s.data[args[0]]; ok {
+			bulk(v)
+		} else {
+			fmt.Fprint(w, "$-1\r\n")
+		}
+	case "SET":
+		s.data[args[0]] = args[1]
+		fmt.Fprint(w, "+OK\r\n")
+	case "DEL":
+		_, ok := s.data[args[0]]
+		delete(s.data, args[0])
+		fmt.Fprintf(w, ":%d\r\n", map[bool]int{true: 1}[ok])
+	case "EXISTS":
+		_, ok := s.data[args[0]]
+		fmt.Fprintf(w, ":%d\r\n", map[bool]int{true: 1}[ok])
+	case "STRLEN":
+		fmt.Fprintf(w, ":%d\r\n", len(s.data[args[0]]))
+	case "SCAN":
+		cursor, _ := strconv.Atoi(args[0])
+		prefix := strings.TrimSuffix(args[2], "*")
+		prefix = strings.NewReplacer(`\*`, "*", `\?`, "?", `\[`, "[", `\]`, "]", `\\`, `\`).Replace(prefix)
+		var keys []string
+		for k := range s.data {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		page := keys[min(cursor, len(keys)):min(cursor+2, len(keys))]
+		next := cursor + 2
+		if next >= len(keys) {
+			next = 0
+		}
+		fmt.Fprintf(w, "*2\r\n")
+		bulk(strconv.Itoa(next))
+		fmt.Fprintf(w, "*%d\r\n", len(page))
+		for _, k := range page {
+			bulk(k)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", cmd)
+	}
+	return authed
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestBackend(t *testing.T) {
+	addr := startFakeRedis(t, "secret")
+	b := New(addr, WithPassword("secret"), WithDB(1), WithPrefix("test:"))
+	defer b.Close()
+	backendtest.Run(t, b)
+}
+
+func TestListGlobCharacters(t *testing.T) {
+	addr := startFakeRedis(t, "")
+	b := New(addr)
+	defer b.Close()
+	ctx := context.Background()
+	for _, key := range []string{"x*y", "xy"} {
+		if err := b.Put(ctx, key, strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("Put(%s) failed: %v", key, err)
+		}
+	}
+	var star []string
+	for info := range b.List(ctx, "x*") {
+		star = append(star, info.Key)
+	}
+	if !slices.Equal(star, []string{"x*y"}) {
+		t.Errorf("List(x*) = %v, want glob characters matched literally", star)
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	addr := startFakeRedis(t, "secret")
+	// Error replies leave the connection usable
+	bad := New(addr, WithPassword("wrong"))
+	defer bad.Close()
+	ctx := context.Background()
+	if err := bad.Put(ctx, "k", strings.NewReader(""), 0); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Put with wrong password = %v, want WRONGPASS", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := New(startFakeRedis(t, ""))
+	ctx := context.Background()
+	inUse, err := b.get(ctx)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if err := b.Put(ctx, "k", strings.NewReader("data"), 4); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Connections returned after Close are closed, not pooled
+	b.put(inUse)
+	if err := b.Put(ctx, "k", strings.NewReader("data"), 4); err != nil {
+		t.Fatalf("Put after Close failed: %v", err)
+	}
+	if n := len(b.pool); n != 0 {
+		t.Errorf("pool holds %d connections after Close, want 0", n)
+	}
+	if _, err := inUse.Write([]byte("PING\r\n")); err == nil {
+		t.Error("Expected the connection in use during Close to be closed")
+	}
+}
+
+func TestManifestsInRedis(t *testing.T) {
+	rdb := New(startFakeRedis(t, ""), WithPrefix("cache:"))
+	defer rdb.Close()
+
+	cache, err := granular.Open("/cache", granular.WithFs(afero.NewMemMapFs()), granular.WithManifestBackend(rdb))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := range 5 {
+		key := cache.Key().String("i", strconv.Itoa(i)).Build()
+		if err := cache.Put(key).Bytes("out", []byte("data")).Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+	}
+	entries, err := cache.Entries()
+	if err != nil || len(entries) != 5 {
+		t.Fatalf("Entries = %d, %v; want 5", len(entries), err)
+	}
+	if _, err := cache.Get(cache.Key().String("i", "3").Build()); err != nil {
+		t.Errorf("Get failed: %v", err)
+	}
+	if _, err := cache.Get(cache.Key().String("i", "9").Build()); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Get of missing key = %v, want ErrCacheMiss", err)
+	}
+}
@@ -0,0 +1,322 @@
+// Package webdav implements a granular backend on a WebDAV server.
+//
+// It suits small teams whose only shared storage is a file server: Apache
+// mod_dav, nginx with the dav module, rclone serve webdav, Nextcloud, and
+// most NAS appliances speak WebDAV. Blobs are stored as files below a base
+// URL and directory markers as collections. The client has no dependencies.
+//
+//	b := webdav.New("https://files.internal/dav/build-cache/", webdav.WithBasicAuth("ci", token))
+//	cache, err := granular.Open("", granular.WithBackend(b))
+//
+// For a shared box reachable only over SSH, use afero's sftpfs package with
+// granular.WithFs instead; it stores the cache on the remote filesystem
+// directly.
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gophersatwork/granular/backend"
+)
+
+// Backend is a backend.Backend storing blobs on a WebDAV server.
+type Backend struct {
+	base     *url.URL
+	baseErr  error
+	user     string
+	password string
+	client   *http.Client
+}
+
+var _ backend.Backend = (*Backend)(nil)
+
+// Option configures a Backend.
+type Option func(*Backend)
+
+// WithBasicAuth authenticates requests with HTTP basic authentication.
+func WithBasicAuth(user, password string) Option {
+	return func(b *Backend) {
+		b.user, b.password = user, password
+	}
+}
+
+// WithHTTPClient sets the client used for requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *Backend) {
+		b.client = client
+	}
+}
+
+// New returns a Backend storing blobs below baseURL, which must be an
+// existing collection. An invalid URL makes every request fail.
+func New(baseURL string, opts ...Option) *Backend {
+	b := &Backend{client: http.DefaultClient}
+	b.base, b.baseErr = url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// url returns the URL of key.
+func (b *Backend) url(key string) string {
+	u := *b.base
+	u.Path = b.base.Path + key
+	u.RawPath = ""
+	return u.String()
+}
+
+// Open implements backend.Backend. Servers redirect requests for a
+// collection to its URL with a trailing slash; such a key is reported as
+// missing, as in Stat.
+func (b *Backend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(key, "/") && strings.HasSuffix(resp.Request.URL.Path, "/") {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("webdav: %s: %w", key, fs.ErrNotExist)
+	}
+	return resp.Body, nil
+}
+
+// Stat implements backend.Backend. Collections only exist as keys ending
+// in "/", so a file key naming a collection is reported as missing.
+func (b *Backend) Stat(ctx context.Context, key string) (backend.Info, error) {
+	infos, err := b.propfind(ctx, key, "0")
+	if err != nil {
+		return backend.Info{}, err
+	}
+	if len(infos) != 1 || infos[0].Key != key {
+		return backend.Info{}, fmt.Errorf("webdav: %s: %w", key, fs.ErrNotExist)
+	}
+	return infos[0], nil
+}
+
+// Put implements backend.Backend. Keys ending in "/" are created as
+// collections; missing parent collections are created as needed. Retrying
+// after creating them rewinds r, so readers that cannot seek get their
+// parent collection created up front instead. Blobs of unknown size are
+// sent with chunked transfer encoding.
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if strings.HasSuffix(key, "/") {
+		return b.mkcol(ctx, key)
+	}
+	seeker, ok := r.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		if err := b.mkcol(ctx, path.Dir(key)+"/"); err != nil {
+			return err
+		}
+	}
+	resp, err := b.do(ctx, http.MethodPut, key, nil, r, size)
+	if ok && isStatus(err, http.StatusConflict) {
+		// RFC 4918: PUT answers 409 when the parent collection is missing
+		if err := b.mkcol(ctx, path.Dir(key)+"/"); err != nil {
+			return err
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		resp, err = b.do(ctx, http.MethodPut, key, nil, r, size)
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// mkcol creates the collection with the given key and its missing parents.
+func (b *Backend) mkcol(ctx context.Context, key string) error {
+	if key == "/" || key == "./" {
+		return nil
+	}
+	resp, err := b.do(ctx, "MKCOL", key, nil, nil, 0)
+	if isStatus(err, http.StatusConflict) {
+		if err := b.mkcol(ctx, path.Dir(strings.TrimSuffix(key, "/"))+"/"); err != nil {
+			return err
+		}
+		resp, err = b.do(ctx, "MKCOL", key, nil, nil, 0)
+	}
+	if isStatus(err, http.StatusMethodNotAllowed) {
+		return nil // already exists
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Delete implements backend.Backend. Deleting a collection marker deletes
+// the collection and everything in it.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// multistatus is the subset of a PROPFIND response used by the backend.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ContentLength int64     `xml:"DAV: getcontentlength"`
+				LastModified  string    `xml:"DAV: getlastmodified"`
+				Collection    *struct{} `xml:"DAV: resourcetype>collection"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+// List implements backend.Backend. Collections are walked one level at a
+// time (PROPFIND with Depth 1), since many servers refuse infinite depth.
+// Collections are listed as keys ending in "/".
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[backend.Info, error] {
+	return func(yield func(backend.Info, error) bool) {
+		dir := prefix[:strings.LastIndex(prefix, "/")+1]
+		pending := []string{dir}
+		for len(pending) > 0 {
+			dir, pending = pending[0], pending[1:]
+			children, err := b.propfind(ctx, dir, "1")
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				yield(backend.Info{}, err)
+				return
+			}
+			for _, info := range children {
+				if info.Key == dir {
+					continue // the collection itself
+				}
+				if strings.HasSuffix(info.Key, "/") && (strings.HasPrefix(info.Key, prefix) || strings.HasPrefix(prefix, info.Key)) {
+					pending = append(pending, info.Key)
+				}
+				if strings.HasPrefix(info.Key, prefix) && !yield(info, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// propfind returns the properties of key and, with depth "1", of its direct
+// children.
+func (b *Backend) propfind(ctx context.Context, key, depth string) ([]backend.Info, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml"}}
+	resp, err := b.do(ctx, "PROPFIND", key, header, strings.NewReader(propfindBody), int64(len(propfindBody)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: propfind %s: %w", key, err)
+	}
+
+	var infos []backend.Info
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		name, ok := strings.CutPrefix(href.Path, b.base.Path)
+		if !ok {
+			continue
+		}
+		info := backend.Info{Key: name}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			info.Size = ps.Prop.ContentLength
+			info.ModTime, _ = http.ParseTime(ps.Prop.LastModified)
+			if ps.Prop.Collection != nil && name != "" && !strings.HasSuffix(name, "/") {
+				info.Key += "/"
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// statusError is an unexpected HTTP status.
+type statusError struct {
+	method, key string
+	code        int
+	msg         string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webdav: %s %s: %d %s: %s", e.method, e.key, e.code, http.StatusText(e.code), e.msg)
+}
+
+func isStatus(err error, code int) bool {
+	e, ok := errors.AsType[*statusError](err)
+	return ok && e.code == code
+}
+
+// do sends a request for key and returns the response if it succeeded. 404
+// is reported as fs.ErrNotExist.
+func (b *Backend) do(ctx context.Context, method, key string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	if b.baseErr != nil {
+		return nil, fmt.Errorf("webdav: invalid base URL: %w", b.baseErr)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if b.user != "" || b.password != "" {
+		req.SetBasicAuth(b.user, b.password)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: %w", err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("webdav: %s: %w", key, fs.ErrNotExist)
+	}
+	return nil, &statusError{method: method, key: key, code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+}
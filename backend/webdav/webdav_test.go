@@ -0,0 +1,230 @@
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/backend/backendtest"
+)
+
+// fakeDAV serves the subset of WebDAV used by Backend below /dav/, with the
+// strict semantics of RFC 4918: PUT and MKCOL fail with 409 when the parent
+// collection is missing, MKCOL fails with 405 when the resource exists, and
+// GET on a collection redirects to its URL with a trailing slash.
+func fakeDAV(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	collections := map[string]bool{"/dav": true}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "ci" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		name := strings.TrimSuffix(r.URL.Path, "/")
+		_, isFile := files[name]
+		exists := isFile || collections[name]
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if !exists {
+				http.NotFound(w, r)
+				return
+			}
+			if collections[name] && !strings.HasSuffix(r.URL.Path, "/") {
+				http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+				return
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(len(files[name])))
+			_, _ = w.Write(files[name])
+		case http.MethodPut:
+			if !collections[path.Dir(name)] {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			files[name], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case "MKCOL":
+			switch {
+			case exists:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			case !collections[path.Dir(name)]:
+				w.WriteHeader(http.StatusConflict)
+			default:
+				collections[name] = true
+				w.WriteHeader(http.StatusCreated)
+			}
+		case http.MethodDelete:
+			if !exists {
+				http.NotFound(w, r)
+				return
+			}
+			for other := range files {
+				if other == name || strings.HasPrefix(other, name+"/") {
+					delete(files, other)
+				}
+			}
+			for other := range collections {
+				if other == name || strings.HasPrefix(other, name+"/") {
+					delete(collections, other)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "PROPFIND":
+			depth := r.Header.Get("Depth")
+			if depth != "0" && depth != "1" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if !exists {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:">`)
+			for other := range collections {
+				if other == name || depth == "1" && path.Dir(other) == name {
+					writeResponse(w, other+"/", `<D:resourcetype><D:collection/></D:resourcetype>`)
+				}
+			}
+			for other, data := range files {
+				if other == name || depth == "1" && path.Dir(other) == name {
+					writeResponse(w, other, fmt.Sprintf(`<D:resourcetype/><D:getcontentlength>%d</D:getcontentlength>`+
+						`<D:getlastmodified>Mon, 12 Jan 2026 10:00:00 GMT</D:getlastmodified>`, len(data)))
+				}
+			}
+			fmt.Fprint(w, `</D:multistatus>`)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func writeResponse(w io.Writer, name, props string) {
+	href := (&url.URL{Path: name}).EscapedPath()
+	fmt.Fprintf(w, `<D:response><D:href>%s</D:href><D:propstat><D:prop>%s</D:prop>`+
+		`<D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`, href, props)
+}
+
+func TestBackend(t *testing.T) {
+	ts := fakeDAV(t)
+	backendtest.Run(t, New(ts.URL+"/dav", WithHTTPClient(ts.Client()), WithBasicAuth("ci", "secret")))
+}
+
+func TestCollections(t *testing.T) {
+	ts := fakeDAV(t)
+	b := New(ts.URL+"/dav", WithHTTPClient(ts.Client()), WithBasicAuth("ci", "secret"))
+	ctx := t.Context()
+
+	for _, key := range []string{"a/1", "a/b/2", "d/"} {
+		if err := b.Put(ctx, key, strings.NewReader("data:"+key), int64(len("data:"+key))); err != nil {
+			t.Fatalf("Put(%s) failed: %v", key, err)
+		}
+	}
+	if err := b.Put(ctx, "d/", strings.NewReader(""), 0); err != nil {
+		t.Errorf("Put of existing collection = %v, want nil", err)
+	}
+	// A reader that cannot seek gets its parent collections created first
+	if err := b.Put(ctx, "e/f/5", io.MultiReader(strings.NewReader("data:e/f/5")), 10); err != nil {
+		t.Fatalf("Put(e/f/5) from a stream failed: %v", err)
+	}
+	if info, err := b.Stat(ctx, "e/f/5"); err != nil || info.Size != 10 {
+		t.Errorf("Stat(e/f/5) = %+v, %v", info, err)
+	}
+	if err := b.Delete(ctx, "e/"); err != nil {
+		t.Fatalf("Delete(e/) failed: %v", err)
+	}
+
+	if _, err := b.Open(ctx, "a/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(collection) = %v, want ErrNotExist", err)
+	}
+	if _, err := b.Stat(ctx, "a/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(collection) = %v, want ErrNotExist", err)
+	}
+	if info, err := b.Stat(ctx, "a/b/"); err != nil || info.Key != "a/b/" {
+		t.Errorf("Stat(a/b/) = %+v, %v", info, err)
+	}
+
+	list := func(prefix string) []string {
+		var keys []string
+		for info, err := range b.List(ctx, prefix) {
+			if err != nil {
+				t.Fatalf("List(%q) failed: %v", prefix, err)
+			}
+			keys = append(keys, info.Key)
+		}
+		slices.Sort(keys)
+		return keys
+	}
+	if got, want := list(""), []string{"a/", "a/1", "a/b/", "a/b/2", "d/"}; !slices.Equal(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+	if got, want := list("a/b"), []string{"a/b/", "a/b/2"}; !slices.Equal(got, want) {
+		t.Errorf("List(a/b) = %v, want %v", got, want)
+	}
+
+	// Deleting a collection deletes its contents
+	if err := b.Delete(ctx, "a/"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if got, want := list(""), []string{"d/"}; !slices.Equal(got, want) {
+		t.Errorf("List after Delete = %v, want %v", got, want)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	ts := fakeDAV(t)
+	bad := New(ts.URL+"/dav", WithHTTPClient(ts.Client()))
+	if err := bad.Put(t.Context(), "x", strings.NewReader(""), 0); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Put without credentials = %v, want 401", err)
+	}
+}
+
+func TestCacheOnWebDAV(t *testing.T) {
+	ts := fakeDAV(t)
+	b := New(ts.URL+"/dav/", WithHTTPClient(ts.Client()), WithBasicAuth("ci", "secret"))
+
+	cache, err := granular.Open("", granular.WithBackend(b))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := cache.Key().String("target", "app").Build()
+	if err := cache.Put(key).Bytes("out", []byte("built")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	reopened, err := granular.Open("", granular.WithBackend(b))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	result, err := reopened.Get(reopened.Key().String("target", "app").Build())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if data, _ := result.BytesErr("out"); string(data) != "built" {
+		t.Errorf("cached data = %q", data)
+	}
+	stats, err := reopened.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Entries != 1 {
+		t.Errorf("Stats.Entries = %d, want 1", stats.Entries)
+	}
+}
@@ -0,0 +1,68 @@
+package granular
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/gophersatwork/granular/backend"
+	"github.com/spf13/afero"
+)
+
+// TestWithManifestBackend tests that manifests go to the backend, objects to
+// the filesystem, and that the cache works end to end in that layout.
+func TestWithManifestBackend(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	manifests := backend.NewMemory()
+	cache, err := Open("/cache", WithFs(fs), WithManifestBackend(manifests))
+	assertNoError(t, err, "Open")
+
+	key := cache.Key().String("target", "app").Build()
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("built")).Commit(), "Commit")
+
+	manifestKey := key.Hash()[:2] + "/" + key.Hash() + ".json"
+	if _, err := manifests.Stat(context.Background(), manifestKey); err != nil {
+		t.Errorf("Expected manifest %s in backend: %v", manifestKey, err)
+	}
+	if entries, _ := afero.ReadDir(fs, "/cache/manifests"); len(entries) != 0 {
+		t.Errorf("Expected no manifests on the filesystem, found %d", len(entries))
+	}
+
+	result, err := cache.Get(key)
+	assertNoError(t, err, "Get")
+	data, err := result.BytesErr("out")
+	assertNoError(t, err, "BytesErr")
+	assertEqual(t, string(data), "built", "cached data")
+
+	stats, err := cache.Stats()
+	assertNoError(t, err, "Stats")
+	if stats.Entries != 1 {
+		t.Errorf("Stats.Entries = %d, want 1", stats.Entries)
+	}
+	report, err := cache.Verify()
+	assertNoError(t, err, "Verify")
+	if !report.OK() {
+		t.Errorf("Verify found problems: %+v", report)
+	}
+
+	// Export walks into the mounted manifests
+	var archive bytes.Buffer
+	assertNoError(t, cache.Export(&archive), "Export")
+	other, err := Open("/cache", WithFs(afero.NewMemMapFs()))
+	assertNoError(t, err, "Open importer")
+	assertNoError(t, other.Import(&archive), "Import")
+	if !other.Has(other.Key().String("target", "app").Build()) {
+		t.Error("Expected exported entry in the importing cache")
+	}
+
+	assertNoError(t, cache.Clear(), "Clear")
+	if cache.Has(key) {
+		t.Error("Expected entry to be gone after Clear")
+	}
+	for info, err := range manifests.List(context.Background(), "") {
+		assertNoError(t, err, "List")
+		if info.Size > 0 {
+			t.Errorf("Expected no manifests in backend after Clear, found %s", info.Key)
+		}
+	}
+}
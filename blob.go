@@ -0,0 +1,126 @@
+package granular
+
+import (
+	"bytes"
+	"cmp"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gophersatwork/granular/hashing"
+	"github.com/spf13/afero"
+)
+
+// blobsDir returns the directory holding the data blobs shared between
+// entries by WithDedup.
+func (c *Cache) blobsDir() string {
+	return filepath.Join(c.root, "blobs")
+}
+
+// blobPath returns the path of the shared blob with the given digest,
+// sharded like objects. The base name is a valid data object name, so the
+// blob can be exported and imported like an entry's own objects. The digest
+// covers the uncompressed content, so the name also records the compression
+// the blob is stored with: a cache reopened with another compression must
+// not reuse it.
+func (c *Cache) blobPath(digest string) string {
+	return filepath.Join(c.blobsDir(), digest[:hashPrefixLen], "data."+digest+"."+blobCompression(c.compression)+".dat")
+}
+
+// blobCompression returns the name of ct in blob names.
+func blobCompression(ct CompressionType) string {
+	return cmp.Or(string(ct), "none")
+}
+
+// isBlobPath reports whether path has the layout of a shared blob, as
+// returned by blobPath. Blobs stored before their names recorded the
+// compression, without it, are recognized too, so GC keeps them while old
+// manifests reference them.
+func isBlobPath(path string) bool {
+	name := filepath.Base(path)
+	digest, ok := strings.CutPrefix(name, "data.")
+	if !ok {
+		return false
+	}
+	digest, ok = strings.CutSuffix(digest, ".dat")
+	if !ok {
+		return false
+	}
+	digest, _, _ = strings.Cut(digest, ".")
+	if validateKeyHash(digest) != nil {
+		return false
+	}
+	shard := filepath.Dir(path)
+	return filepath.Base(shard) == digest[:hashPrefixLen] && filepath.Base(filepath.Dir(shard)) == "blobs"
+}
+
+// dataDigest returns the digest naming the shared blob of data added with
+// Bytes or BytesNoCopy, hashing its uncompressed content.
+func (c *Cache) dataDigest(data []byte) (string, error) {
+	return c.readerDigest(bytes.NewReader(data))
+}
+
+// fileDigest is like dataDigest for data spilled to the file at path.
+func (c *Cache) fileDigest(path string) (string, error) {
+	f, err := c.fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	return c.readerDigest(f)
+}
+
+func (c *Cache) readerDigest(r io.Reader) (string, error) {
+	h := c.newHash()
+	if err := c.hasher().Reader(h, r); err != nil {
+		return "", err
+	}
+	return hashing.Sum(h), nil
+}
+
+// storeBlob moves the staged data object at staged to the shared blob at
+// blob, unless the blob is already stored. The caller must hold the read
+// lock, so GC cannot remove the blob before the manifest references it.
+func (c *Cache) storeBlob(staged, blob string) error {
+	exists, err := afero.Exists(c.fs, blob)
+	if err != nil || exists {
+		return err
+	}
+	if err := c.mkdirAll(filepath.Dir(blob)); err != nil {
+		return err
+	}
+	if err := c.fs.Rename(staged, blob); err != nil {
+		return err
+	}
+	if c.durable {
+		syncDir(c.fs, filepath.Dir(blob))
+	}
+	return nil
+}
+
+// removeUnreferencedBlobs removes the shared blobs whose paths are not in
+// referenced, returning how many were removed and their total size. The
+// caller must hold the write lock, so no commit can reference a blob while
+// it is removed.
+func (c *Cache) removeUnreferencedBlobs(referenced map[string]bool) (int, int64, error) {
+	if exists, err := afero.DirExists(c.fs, c.blobsDir()); err != nil || !exists {
+		return 0, 0, err
+	}
+	var removed int
+	var reclaimed int64
+	err := afero.Walk(c.fs, c.blobsDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || referenced[path] {
+			return nil
+		}
+		if err := c.fs.Remove(path); err == nil {
+			removed++
+			reclaimed += info.Size()
+		}
+		return nil
+	})
+	return removed, reclaimed, err
+}
@@ -2,16 +2,24 @@ package granular
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
 	"hash"
 	"iter"
+	"log/slog"
+	"maps"
+	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
+	"github.com/gophersatwork/granular/backend"
+	"github.com/gophersatwork/granular/internal/iobuf"
 	"github.com/spf13/afero"
 )
 
@@ -30,21 +38,56 @@ const defaultMaxDataSize = 1 << 30
 //  1. c.mu        — global RWMutex (RLock for Get, Has, Delete, Put-write; Lock for Clear, Prune, GC, Put-eviction, Import)
 //  2. c.keyLocks  — per-key sharded Mutex for individual entry operations (Get, Put, Delete, Has)
 //
-// Never acquire c.mu while holding a keyLock.
+// Never acquire c.mu while holding a keyLock. c.uploadLocks serializes
+// writes to staged uploads and is never held together with the other locks.
 type Cache struct {
 	root             string
 	hashFunc         HashFunc
 	hashAlgoName     string // Name of the hash algorithm for manifest compatibility
 	nowFunc          NowFunc
-	mu               sync.RWMutex // Global lock for operations needing consistency (Clear, Stats, Prune, Entries)
-	pendingSize      atomic.Int64 // Sum of in-flight Commit sizes, used by eviction to avoid TOCTOU overflows
-	keyLocks         *keyLocks    // Per-key locking for concurrent access to different keys
+	mu               *sync.RWMutex // Global lock for operations needing consistency (Clear, Stats, Prune, Entries); shared by namespace views
+	pendingSize      *atomic.Int64 // Sum of in-flight Commit sizes, used by eviction to avoid TOCTOU overflows
+	keyLocks         *keyLocks     // Per-key locking for concurrent access to different keys
+	uploadLocks      *keyLocks     // Per-key locking for objects staged by StageObject and ResumeObject
 	fs               afero.Fs
-	accumulateErrors bool            // If true, accumulate all validation errors; if false, fail-fast
-	maxSize          int64           // Maximum cache size in bytes; 0 means no limit
-	maxDataSize      int64           // Maximum size for a single decompressed data read; 0 uses defaultMaxDataSize
-	compression      CompressionType // Compression algorithm for stored data
-	metrics          *MetricsHooks   // Optional metrics hooks for observability
+	accumulateErrors bool                // If true, accumulate all validation errors; if false, fail-fast
+	maxSize          int64               // Maximum cache size in bytes; 0 means no limit
+	maxDataSize      int64               // Maximum size for a single decompressed data read; 0 uses defaultMaxDataSize
+	compression      CompressionType     // Compression algorithm for stored data
+	metrics          *MetricsHooks       // Optional metrics hooks for observability
+	strictWalks      bool                // If true, corrupted manifests abort walks instead of being skipped
+	slow             SlowThresholds      // Soft time limits reported through MetricsHooks.OnSlow
+	recordLatency    bool                // Record operation latencies in Metrics (WithLatencyHistograms)
+	verifyOnGet      bool                // If true, Get re-hashes outputs and compares with the stored OutputHash
+	selfHeal         bool                // If true, Get reports corrupted entries as misses instead of errors
+	durable          bool                // If true, fsync writes and journal in-flight commits
+	maintenance      *maintenance        // Background maintenance configured by WithAutoPrune; nil if disabled
+	counters         *counters           // In-process activity counters reported by Metrics
+	namespace        string              // Namespace of this view; empty for the whole cache
+	index            *index              // Entry index enabled by WithIndex; nil if disabled
+	accessDebounce   time.Duration       // Minimum age of AccessedAt before Get rewrites it
+	logger           *slog.Logger        // Optional structured logger; nil disables logging
+	manifestBackend  backend.Backend     // If set, manifests are stored here instead of fs
+	codec            Codec               // Encoding of PutValue, GetValue, and Func results
+	watchInterval    time.Duration       // How often Watch polls; 0 uses defaultWatchInterval
+	bufferSize       int                 // Size of I/O buffers for hashing and copies; 0 uses iobuf.Size
+	onProgress       func(ProgressEvent) // Optional callback for hashing and copy progress
+	foldCase         bool                // If true, paths are hashed lowercased and names must differ by more than case
+	basePath         string              // Absolute directory input paths are hashed relative to; empty hashes paths as given
+	skipRepeats      bool                // If true, a file already hashed by an earlier input of the key is not read again
+	salt             string              // Mixed into every key hash; changing it invalidates all entries
+	refresher        *refresher          // Soft-TTL refresh callback set by WithRefresher; nil if disabled
+	lockTimeout      time.Duration       // How long LockKey waits for another holder; 0 uses defaultLockTimeout
+	maxInputFiles    int                 // Maximum files in a single Glob or Dir input; 0 means no limit
+	maxInputBytes    int64               // Maximum total size of a single Glob or Dir input; 0 means no limit
+	maxInlineSize    int64               // Maximum size of the values stored in one manifest; 0 uses DefaultMaxInlineSize, negative means no limit
+	optionErr        error               // Invalid option value, returned by Open
+	mustExist        bool                // Set by OpenExisting: refuse to create a cache
+	readOnly         bool                // Set by Warm: write nothing, not even metadata, to the cache
+	dirMode          os.FileMode         // Exact mode of created directories; zero for 0o755 less the umask
+	fileMode         os.FileMode         // Exact mode of created files; zero for 0o644 less the umask
+	dedup            bool                // If true, byte data is stored once per content in the shared blob area
+	history          int                 // Previous results kept per key; 0 keeps none
 }
 
 // HashFunc defines a function that creates a new hash.Hash instance.
@@ -65,25 +108,96 @@ func Open(root string, options ...Option) (*Cache, error) {
 		nowFunc:      time.Now,
 		hashFunc:     defaultHashFunc,
 		hashAlgoName: DefaultHashAlgoName,
+		mu:           new(sync.RWMutex),
+		pendingSize:  new(atomic.Int64),
 		keyLocks:     newKeyLocks(),
+		uploadLocks:  newKeyLocks(),
+		counters:     new(counters),
+		verifyOnGet:  true,
+		codec:        JSONCodec,
 	}
 
 	// Apply options
 	for _, option := range options {
 		option(cache)
 	}
+	if cache.optionErr != nil {
+		return nil, cache.optionErr
+	}
+	if _, ok := cache.fs.(*afero.OsFs); ok {
+		cache.root = longPath(cache.root)
+	}
+	if cache.basePath != "" {
+		base, err := filepath.Abs(cache.basePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve base path: %w", err)
+		}
+		cache.basePath = base
+	}
+	if cache.manifestBackend != nil {
+		cache.fs = backend.Mount(cache.fs, cache.manifestDir(), backend.NewFs(cache.manifestBackend))
+	}
+	if cache.readOnly {
+		cache.fs = afero.NewReadOnlyFs(cache.fs)
+	}
+
+	if cache.mustExist {
+		ok, err := cache.isCache()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotCache, cache.root)
+		}
+	}
 
 	// Create cache directories
-	if err := cache.fs.MkdirAll(cache.manifestDir(), 0o755); err != nil {
-		return nil, fmt.Errorf("failed to create manifests directory: %w", err)
+	if !cache.readOnly {
+		if err := cache.mkdirAll(cache.manifestDir()); err != nil {
+			return nil, fmt.Errorf("failed to create manifests directory: %w", err)
+		}
+		if err := cache.mkdirAll(cache.objectsDir()); err != nil {
+			return nil, fmt.Errorf("failed to create objects directory: %w", err)
+		}
+	}
+	if err := cache.checkMeta(); err != nil {
+		return nil, err
+	}
+
+	// Recover commits interrupted by a crash
+	if cache.durable {
+		if err := cache.replayJournal(); err != nil {
+			return nil, fmt.Errorf("failed to replay write journal: %w", err)
+		}
 	}
-	if err := cache.fs.MkdirAll(cache.objectsDir(), 0o755); err != nil {
-		return nil, fmt.Errorf("failed to create objects directory: %w", err)
+
+	if cache.index != nil {
+		if err := cache.loadIndex(); err != nil {
+			return nil, fmt.Errorf("failed to load index: %w", err)
+		}
 	}
 
+	cache.startMaintenance()
+
 	return cache, nil
 }
 
+// OpenExisting opens the cache at root like Open, but fails with ErrNotCache
+// instead of creating one if root does not hold a granular cache. Use it
+// when root comes from configuration or the command line, where a typo
+// would otherwise turn an unrelated directory into a cache that Clear later
+// empties.
+//
+// Example:
+//
+//	cache, err := granular.OpenExisting(os.Getenv("BUILD_CACHE"))
+//	if errors.Is(err, granular.ErrNotCache) {
+//		log.Fatalf("BUILD_CACHE does not point at a cache: %v", err)
+//	}
+func OpenExisting(root string, options ...Option) (*Cache, error) {
+	return Open(root, append(slices.Clip(options), func(c *Cache) { c.mustExist = true })...)
+}
+
 // OpenTemp creates a temporary in-memory cache for testing.
 func OpenTemp() *Cache {
 	cache, err := Open("", WithFs(afero.NewMemMapFs()))
@@ -115,6 +229,13 @@ func (c *Cache) Get(key Key) (*Result, error) {
 		return nil, newValidationError(key.errors)
 	}
 
+	// Only read the clock when someone is listening
+	timed := c.timedGets()
+	var start time.Time
+	if timed {
+		start = c.now()
+	}
+
 	// Compute key hash BEFORE locking (pure computation, no lock needed)
 	keyHash, err := key.computeHash()
 	if err != nil {
@@ -126,6 +247,143 @@ func (c *Cache) Get(key Key) (*Result, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	result, err := c.lookup(keyHash, start, timed)
+	if err == nil {
+		c.refreshIfStale(key, result)
+	}
+	return result, err
+}
+
+// GetWithFallback retrieves the cached result for key, or on a miss, for the
+// first of the fallback keys that hits, like the restore keys of CI caches.
+// A fallback result is a previous entry for similar inputs, such as the
+// main-branch build of a feature branch, for callers to build on
+// incrementally; compare result.KeyHash() with key.Hash() to tell an exact
+// hit from a fallback, and store the updated outputs under key.
+//
+// It returns ErrCacheMiss if neither key nor any fallback hits. Errors other
+// than misses are returned as Get returns them, without trying further keys.
+//
+// Example:
+//
+//	key := cache.Key().String("branch", branch).Glob("src/**").Build()
+//	mainKey := cache.Key().String("branch", "main").Build()
+//	result, err := cache.GetWithFallback(key, mainKey)
+//	if err == nil && result.KeyHash() != key.Hash() {
+//		restore(result) // start from main's artifacts, then rebuild what changed
+//	}
+func (c *Cache) GetWithFallback(key Key, fallbacks ...Key) (*Result, error) {
+	for _, k := range append([]Key{key}, fallbacks...) {
+		result, err := c.Get(k)
+		if !errors.Is(err, ErrCacheMiss) {
+			return result, err
+		}
+	}
+	return nil, ErrCacheMiss
+}
+
+// getMultiWorkers bounds the lookups GetMulti runs concurrently.
+const getMultiWorkers = 16
+
+// GetMulti looks up many keys at once. It returns one result and one error
+// per key, in the order of keys, with the same meaning as Get's.
+//
+// Keys are hashed concurrently before any lock is taken, then looked up
+// concurrently under a single acquisition of the cache lock, so probing
+// hundreds of keys costs far less than as many Get calls, especially on
+// remote filesystems and backends where each lookup is a round trip.
+//
+// Example:
+//
+//	results, errs := cache.GetMulti(keys)
+//	for i, result := range results {
+//		if errors.Is(errs[i], granular.ErrCacheMiss) {
+//			rebuild(stages[i])
+//			continue
+//		}
+//		...
+//	}
+func (c *Cache) GetMulti(keys []Key) ([]*Result, []error) {
+	results := make([]*Result, len(keys))
+	errs := make([]error, len(keys))
+	hashes := make([]string, len(keys))
+	starts := make([]time.Time, len(keys))
+	timed := c.timedGets()
+
+	forEachConcurrently(len(keys), func(i int) {
+		if len(keys[i].errors) > 0 {
+			errs[i] = newValidationError(keys[i].errors)
+			return
+		}
+		if timed {
+			starts[i] = c.now()
+		}
+		keyHash, err := keys[i].computeHash()
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to compute key hash: %w", err)
+			return
+		}
+		hashes[i] = keyHash
+	})
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	forEachConcurrently(len(keys), func(i int) {
+		if hashes[i] != "" {
+			results[i], errs[i] = c.lookup(hashes[i], starts[i], timed)
+		}
+	})
+	for i, result := range results {
+		if result != nil {
+			c.refreshIfStale(keys[i], result)
+		}
+	}
+	return results, errs
+}
+
+// forEachConcurrently calls fn for 0..n-1 on up to getMultiWorkers
+// goroutines and waits for all calls to return.
+func forEachConcurrently(n int, fn func(i int)) {
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(n, getMultiWorkers) {
+		wg.Go(func() {
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				fn(i)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// timedGets reports whether lookups need to read the clock, which only
+// happens when someone is listening.
+func (c *Cache) timedGets() bool {
+	return c.slow.Get > 0 || (c.metrics != nil && c.metrics.OnGet != nil) || c.logging() || c.recordLatency
+}
+
+// lookup implements Get once the key hash is known. The caller holds c.mu
+// for reading; start is when the Get began, if timed.
+func (c *Cache) lookup(keyHash string, start time.Time, timed bool) (result *Result, err error) {
+	var entrySize int64
+	if timed {
+		defer func() {
+			elapsed := c.now().Sub(start)
+			c.reportIfSlow(SlowOpGet, keyHash, elapsed)
+			if c.recordLatency {
+				c.counters.getLatency.observe(elapsed)
+			}
+			c.metrics.get(keyHash, result != nil, entrySize, elapsed)
+			switch {
+			case result != nil:
+				c.log(slog.LevelDebug, "cache hit", keyHashAttr(keyHash), bytesAttr(entrySize), durationAttr(elapsed))
+			case errors.Is(err, ErrCacheMiss):
+				c.log(slog.LevelDebug, "cache miss", keyHashAttr(keyHash), durationAttr(elapsed))
+			}
+		}()
+	}
+
 	// Use per-key lock for concurrent access to different keys
 	c.keyLocks.lockKey(keyHash)
 	defer c.keyLocks.unlockKey(keyHash)
@@ -137,20 +395,18 @@ func (c *Cache) Get(key Key) (*Result, error) {
 	}
 	exists, err := afero.Exists(c.fs, manifestPath)
 	if err != nil {
-		c.metrics.error("get", err)
+		c.recordError("get", err)
 		return nil, fmt.Errorf("failed to check manifest: %w", err)
 	}
 	if !exists {
-		c.metrics.miss(keyHash)
+		c.recordMiss(keyHash)
 		return nil, ErrCacheMiss
 	}
 
 	// Load manifest — treat parse failures as corruption and auto-clean
 	m, err := c.loadManifest(keyHash)
 	if err != nil {
-		_ = c.deleteByKeyHash(keyHash)
-		c.metrics.error("get", ErrCacheCorrupted)
-		return nil, ErrCacheCorrupted
+		return nil, c.evictCorrupted(keyHash)
 	}
 
 	// Validate hash algorithm compatibility
@@ -167,26 +423,44 @@ func (c *Cache) Get(key Key) (*Result, error) {
 	// Reading data with the wrong decompressor would fail or produce garbage.
 	// Treat as a cache miss and auto-evict so callers checking for ErrCacheMiss
 	// can recompute the entry with the current compression setting.
-	if m.Compression != c.compression {
+	if CompressionType(m.Compression) != c.compression {
 		_ = c.deleteByKeyHash(keyHash)
-		c.metrics.miss(keyHash)
+		c.recordMiss(keyHash)
 		return nil, ErrCacheMiss
 	}
 
 	// Verify output hash to detect corruption
-	if err := c.verifyOutputHash(m); err != nil {
-		// Delete corrupted entry
-		_ = c.deleteByKeyHash(keyHash)
-		c.metrics.error("get", ErrCacheCorrupted)
-		return nil, ErrCacheCorrupted
+	if c.verifyOnGet {
+		if err := c.verifyOutputHash(m); err != nil {
+			return nil, c.evictCorrupted(keyHash)
+		}
 	}
 
-	// Update access time — best effort, does not affect cache hit validity
-	m.AccessedAt = c.now()
-	if err := c.saveManifest(m); err != nil {
-		c.metrics.error("get:update_access", err)
+	// Update access time — best effort, does not affect cache hit validity.
+	// Skipped while the recorded time is within the debounce window to
+	// avoid rewriting the manifest on every hit.
+	if now := c.now(); now.Sub(m.AccessedAt) >= c.accessDebounce {
+		m.AccessedAt = now
+		if err := c.saveManifest(m); err != nil {
+			c.recordError("get:update_access", err)
+		}
 	}
 
+	result = c.newResult(keyHash, m)
+
+	// Report cache hit with entry size
+	objectDir, err := c.objectPath(keyHash)
+	if err != nil {
+		return nil, err
+	}
+	entrySize, _ = c.dirSize(objectDir)
+	c.recordHit(keyHash, entrySize)
+
+	return result, nil
+}
+
+// newResult returns the Result for the entry of keyHash described by m.
+func (c *Cache) newResult(keyHash string, m *manifest) *Result {
 	// Build result with lazy-loading for data
 	// m.OutputData stores paths to .dat files, which are loaded on demand
 	result := &Result{
@@ -196,9 +470,14 @@ func (c *Cache) Get(key Key) (*Result, error) {
 		dataPaths:   m.OutputData, // Paths to .dat files for lazy loading
 		dataCache:   nil,          // Initialized on first data access
 		metadata:    m.OutputMeta,
-		compression: m.Compression,
+		tags:        m.Tags,
+		description: m.Description,
+		inputs:      m.InputDescs,
+		extras:      m.ExtraData,
+		compression: CompressionType(m.Compression),
 		createdAt:   m.CreatedAt,
 		accessedAt:  m.AccessedAt,
+		refreshAt:   m.RefreshAt,
 	}
 
 	// Initialize maps if nil
@@ -211,16 +490,10 @@ func (c *Cache) Get(key Key) (*Result, error) {
 	if result.metadata == nil {
 		result.metadata = make(map[string]string)
 	}
-
-	// Report cache hit with entry size
-	objectDir, err := c.objectPath(keyHash)
-	if err != nil {
-		return nil, err
+	if result.tags == nil {
+		result.tags = make(map[string]string)
 	}
-	entrySize, _ := c.dirSize(objectDir)
-	c.metrics.hit(keyHash, entrySize)
-
-	return result, nil
+	return result
 }
 
 // Put creates a WriteBuilder for storing a cache entry.
@@ -239,6 +512,7 @@ func (c *Cache) Put(key Key) *WriteBuilder {
 		metadata:         nil,
 		errors:           errors,
 		accumulateErrors: c.accumulateErrors,
+		conflict:         ConflictOverwrite,
 	}
 }
 
@@ -260,7 +534,11 @@ func (c *Cache) Has(key Key) bool {
 	if err != nil {
 		return false
 	}
+	return c.hasManifest(keyHash)
+}
 
+// hasManifest is Has for a key hash.
+func (c *Cache) hasManifest(keyHash string) bool {
 	// Hold global read lock to prevent Clear/GC/Import from removing
 	// directories while we check. Multiple Has calls proceed concurrently (RLock).
 	c.mu.RLock()
@@ -306,23 +584,63 @@ func (c *Cache) Delete(key Key) error {
 	}
 	entrySize, _ := c.dirSize(objectDir)
 
-	if err := c.deleteByKeyHash(keyHash); err != nil {
-		c.metrics.error("delete", err)
+	if err := c.removeWithHistory(keyHash); err != nil {
+		c.recordError("delete", err)
 		return err
 	}
 
-	c.metrics.evict(keyHash, entrySize, EvictReasonManual)
+	c.recordEvict(keyHash, entrySize, EvictReasonManual)
 	return nil
 }
 
+// evictCorrupted removes a corrupted entry found by Get and returns the error
+// Get should report: ErrCacheCorrupted, or ErrCacheMiss with WithSelfHeal.
+// Caller must hold the key lock.
+func (c *Cache) evictCorrupted(keyHash string) error {
+	_ = c.deleteByKeyHash(keyHash)
+	c.recordError("get", ErrCacheCorrupted)
+	if c.selfHeal {
+		c.recordMiss(keyHash)
+		return ErrCacheMiss
+	}
+	return ErrCacheCorrupted
+}
+
 // deleteByKeyHash removes a cache entry by key hash.
 // Caller must hold the key lock.
 func (c *Cache) deleteByKeyHash(keyHash string) error {
 	return c.removeByHash(keyHash)
 }
 
-// Clear removes all entries from the cache.
+// removeWithHistory removes a cache entry by key hash, along with the
+// previous results WithHistory kept for it. Entries found corrupted are
+// removed without their history, so Rollback can still restore one until
+// GC runs.
+// Caller must hold the key lock.
+func (c *Cache) removeWithHistory(keyHash string) error {
+	if err := c.removeByHash(keyHash); err != nil {
+		return err
+	}
+	dir, err := c.historyPath(keyHash)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove history: %w", err)
+	}
+	return nil
+}
+
+// Clear removes all entries from the cache, and the previous results kept by
+// WithHistory.
+// On a namespace view, only the entries of that namespace and their history
+// are removed.
 func (c *Cache) Clear() error {
+	if c.namespace != "" {
+		_, err := c.deleteWhere(func(Entry) bool { return true }, EvictReasonClear)
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -337,33 +655,52 @@ func (c *Cache) Clear() error {
 	// Orphaned objects (objects without manifests) are recoverable via GC,
 	// but orphaned manifests (manifests without objects) cause corrupted reads.
 	if err := c.fs.RemoveAll(c.objectsDir()); err != nil {
-		c.metrics.error("clear", err)
+		c.recordError("clear", err)
 		return fmt.Errorf("failed to remove objects: %w", err)
 	}
 	if err := c.fs.RemoveAll(c.manifestDir()); err != nil {
-		c.metrics.error("clear", err)
+		c.recordError("clear", err)
 		return fmt.Errorf("failed to remove manifests: %w", err)
 	}
+	if err := c.fs.RemoveAll(c.blobsDir()); err != nil {
+		c.recordError("clear", err)
+		return fmt.Errorf("failed to remove blobs: %w", err)
+	}
+	if err := c.fs.RemoveAll(c.historyDir()); err != nil {
+		c.recordError("clear", err)
+		return fmt.Errorf("failed to remove history: %w", err)
+	}
 
 	// Recreate directories
-	if err := c.fs.MkdirAll(c.manifestDir(), 0o755); err != nil {
+	if err := c.mkdirAll(c.manifestDir()); err != nil {
 		return fmt.Errorf("failed to recreate manifests directory: %w", err)
 	}
-	if err := c.fs.MkdirAll(c.objectsDir(), 0o755); err != nil {
+	if err := c.mkdirAll(c.objectsDir()); err != nil {
 		return fmt.Errorf("failed to recreate objects directory: %w", err)
 	}
+	c.indexReset()
 
 	// Report evictions
 	for _, entry := range entriesToEvict {
 		c.metrics.evict(entry.KeyHash, entry.Size, EvictReasonClear)
 	}
+	c.log(slog.LevelInfo, "cache cleared")
 
 	return nil
 }
 
 // Close closes the cache and releases any resources.
-// Currently a no-op, but provided for future extensibility.
+// It stops background maintenance started by WithAutoPrune, waiting for a
+// pass in progress to finish, waits for refreshes scheduled through
+// WithRefresher, and saves the index enabled by WithIndex.
 func (c *Cache) Close() error {
+	c.stopMaintenance()
+	c.waitRefreshes()
+	if c.index != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.saveIndex()
+	}
 	return nil
 }
 
@@ -372,6 +709,25 @@ func (c *Cache) manifestDir() string {
 	return filepath.Join(c.root, "manifests")
 }
 
+// longPath returns root in the extended-length form (\\?\C:\...) on
+// Windows, so cache paths deeper than MAX_PATH (260 characters) can be
+// opened. Such paths must be absolute, so root is made absolute first. On
+// other systems, or if root cannot be made absolute, root is returned
+// unchanged.
+func longPath(root string) string {
+	if runtime.GOOS != "windows" || strings.HasPrefix(root, `\\?\`) {
+		return root
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return root
+	}
+	if unc, ok := strings.CutPrefix(abs, `\\`); ok {
+		return `\\?\UNC\` + unc
+	}
+	return `\\?\` + abs
+}
+
 // objectsDir returns the path to the objects directory.
 func (c *Cache) objectsDir() string {
 	return filepath.Join(c.root, "objects")
@@ -410,6 +766,11 @@ func (c *Cache) now() time.Time {
 	return c.nowFunc()
 }
 
+// buffers returns the pool of I/O buffers sized for this cache.
+func (c *Cache) buffers() *iobuf.Pool {
+	return iobuf.For(c.bufferSize)
+}
+
 // defaultHashFunc returns the default hash function (xxHash64).
 func defaultHashFunc() hash.Hash {
 	return xxhash.New()
@@ -432,7 +793,8 @@ func (c *Cache) evictIfNeeded(requiredSpace int64) error {
 	// Get all entries with their sizes
 	var walkErr error
 	var corruptedKeys []string
-	entries := slices.Collect(c.entriesUnlocked(&walkErr, &corruptedKeys))
+	// The size limit applies to the whole cache, not just this namespace
+	entries := slices.Collect(c.entriesIn("", &walkErr, &corruptedKeys))
 	if walkErr != nil {
 		return fmt.Errorf("failed to get cache entries for eviction: %w", walkErr)
 	}
@@ -454,14 +816,7 @@ func (c *Cache) evictIfNeeded(requiredSpace int64) error {
 		return nil // Enough space
 	}
 
-	// Sort by AccessedAt ascending (oldest/least recently accessed first).
-	// Use KeyHash as tiebreaker for deterministic eviction when timestamps are equal.
-	slices.SortFunc(entries, func(a, b Entry) int {
-		return cmp.Or(
-			cmp.Compare(a.AccessedAt.UnixNano(), b.AccessedAt.UnixNano()),
-			cmp.Compare(a.KeyHash, b.KeyHash),
-		)
-	})
+	sortLRU(entries)
 
 	// Evict until we have enough space.
 	// Acquire per-key lock for each entry to prevent races with concurrent Get().
@@ -471,38 +826,72 @@ func (c *Cache) evictIfNeeded(requiredSpace int64) error {
 			break
 		}
 		c.keyLocks.lockKey(entry.KeyHash)
-		if err := c.removeByHash(entry.KeyHash); err != nil {
+		if err := c.removeWithHistory(entry.KeyHash); err != nil {
 			c.keyLocks.unlockKey(entry.KeyHash)
 			return fmt.Errorf("failed to evict entry %s: %w", entry.KeyHash, err)
 		}
 		c.keyLocks.unlockKey(entry.KeyHash)
-		c.metrics.evict(entry.KeyHash, entry.Size, EvictReasonLRU)
+		c.recordEvict(entry.KeyHash, entry.Size, EvictReasonLRU)
 		currentSize -= entry.Size
 	}
 
 	return nil
 }
 
-// entriesUnlocked returns an iterator over all cache entries without acquiring locks.
-// Walk errors are captured in walkErr. Caller must hold at least a read lock on c.mu.
-// Corrupted keyHashes are appended to corrupted if non-nil (see manifests()).
+// sortLRU sorts entries by AccessedAt ascending (oldest/least recently accessed first).
+// Uses KeyHash as tiebreaker for deterministic eviction when timestamps are equal.
+func sortLRU(entries []Entry) {
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Or(
+			cmp.Compare(a.AccessedAt.UnixNano(), b.AccessedAt.UnixNano()),
+			cmp.Compare(a.KeyHash, b.KeyHash),
+		)
+	})
+}
+
+// entriesUnlocked returns an iterator over the entries in c's namespace without
+// acquiring locks. Walk errors are captured in walkErr. Caller must hold at
+// least a read lock on c.mu. Corrupted keyHashes are appended to corrupted if
+// non-nil (see manifests()).
 func (c *Cache) entriesUnlocked(walkErr *error, corrupted *[]string) iter.Seq[Entry] {
+	return c.entriesIn(c.namespace, walkErr, corrupted)
+}
+
+// entriesIn is like entriesUnlocked but yields the entries of namespace ns
+// and its children. An empty ns yields every entry in the cache.
+func (c *Cache) entriesIn(ns string, walkErr *error, corrupted *[]string) iter.Seq[Entry] {
 	return func(yield func(Entry) bool) {
 		for keyHash, m := range c.manifests(walkErr, corrupted) {
-			entry := Entry{
-				KeyHash:    keyHash,
-				CreatedAt:  m.CreatedAt,
-				AccessedAt: m.AccessedAt,
-				Size:       c.manifestEntrySize(m),
-				FileCount:  len(m.OutputFiles) + len(m.OutputData),
+			if !inNamespace(ns, m.Namespace) {
+				continue
 			}
-			if !yield(entry) {
+			if !yield(c.newEntry(keyHash, m)) {
 				return
 			}
 		}
 	}
 }
 
+// newEntry builds the Entry describing manifest m.
+func (c *Cache) newEntry(keyHash string, m *manifest) Entry {
+	files, data, manifest, history := c.entrySizes(keyHash, m)
+	return Entry{
+		KeyHash:      keyHash,
+		CreatedAt:    m.CreatedAt,
+		AccessedAt:   m.AccessedAt,
+		Size:         files + data + history,
+		FileCount:    len(m.OutputFiles) + len(m.OutputData),
+		FileSize:     files,
+		DataSize:     data,
+		ManifestSize: manifest,
+		HistorySize:  history,
+		Tags:         maps.Clone(m.Tags),
+		Meta:         maps.Clone(m.OutputMeta),
+		Namespace:    m.Namespace,
+		Description:  m.Description,
+	}
+}
+
 // MaxSize returns the maximum cache size in bytes.
 // Returns 0 if no size limit is set.
 func (c *Cache) MaxSize() int64 {
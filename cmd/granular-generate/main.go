@@ -0,0 +1,244 @@
+// Command granular-generate runs go generate directives through a granular
+// cache, restoring their outputs instead of rerunning them when nothing they
+// depend on has changed.
+//
+// Usage:
+//
+//	granular-generate [-root dir] [-run regexp] [-v] [packages]
+//
+// Packages are given as to go generate and default to the current
+// directory. Each //go:generate directive is cached on its own, keyed on the
+// source file holding it, the directive, the go command, the generator
+// binary it names, and any extra inputs. Its outputs are declared in a
+// comment directly above it, together with extra inputs such as the
+// generator's own sources when it is run with go run:
+//
+//	//granular:inputs ../api/*.proto
+//	//granular:outputs api.pb.go api_grpc.pb.go
+//	//go:generate protoc --go_out=. --go-grpc_out=. -I ../api ../api/service.proto
+//
+// Patterns are relative to the directory of the source file and support **.
+// Directives without declared outputs cannot be restored, so they always
+// run, as they would under go generate.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/toolcache"
+)
+
+// Prefixes of the comment lines granular-generate reads.
+const (
+	generatePrefix = "//go:generate "
+	outputsPrefix  = "//granular:outputs "
+	inputsPrefix   = "//granular:inputs "
+)
+
+// directive is one //go:generate line and what is declared about it.
+type directive struct {
+	file    string   // source file holding the directive
+	line    int      // 1-based line number
+	text    string   // full line, as matched by go generate -run
+	inputs  []string // extra input patterns, relative to the file's directory
+	outputs []string // output patterns, relative to the file's directory
+}
+
+// command returns the generator command the directive runs.
+func (d directive) command() string {
+	fields := strings.Fields(strings.TrimPrefix(d.text, generatePrefix))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the process exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("granular-generate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	root := flags.String("root", ".cache", "cache directory")
+	runPattern := flags.String("run", "", "run only directives matching `regexp`, as go generate -run")
+	verbose := flags.Bool("v", false, "print each directive and whether it was cached")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: granular-generate [-root dir] [-run regexp] [-v] [packages]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	var selected *regexp.Regexp
+	if *runPattern != "" {
+		var err error
+		if selected, err = regexp.Compile(*runPattern); err != nil {
+			fmt.Fprintf(stderr, "granular-generate: invalid -run pattern: %v\n", err)
+			return 2
+		}
+	}
+
+	cache, err := granular.Open(*root)
+	if err != nil {
+		fmt.Fprintf(stderr, "granular-generate: %v\n", err)
+		return 1
+	}
+	files, err := goFiles(ctx, flags.Args(), stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "granular-generate: %v\n", err)
+		return 1
+	}
+	for _, file := range files {
+		directives, err := parseFile(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "granular-generate: %v\n", err)
+			return 1
+		}
+		for _, d := range directives {
+			if selected != nil && !selected.MatchString(d.text) {
+				continue
+			}
+			status, err := generate(ctx, cache, d, stdout, stderr)
+			if *verbose {
+				fmt.Fprintf(stderr, "%s:%d: %s (%s)\n", d.file, d.line, strings.TrimPrefix(d.text, generatePrefix), status)
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "%s:%d: %v\n", d.file, d.line, err)
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// generate runs one directive, through the cache if it declares outputs,
+// and reports whether it was cached, ran, or ran uncached.
+func generate(ctx context.Context, cache *granular.Cache, d directive, stdout, stderr io.Writer) (string, error) {
+	dir := filepath.Dir(d.file)
+	args := []string{"generate", "-run", "^" + regexp.QuoteMeta(d.text) + "$", filepath.Base(d.file)}
+	if len(d.outputs) == 0 {
+		cmd := exec.CommandContext(ctx, "go", args...)
+		cmd.Dir, cmd.Stdout, cmd.Stderr = dir, stdout, stderr
+		return "uncached", cmd.Run()
+	}
+
+	// Patterns are resolved against the relative package directory, so
+	// that keys do not depend on where the checkout lives
+	inputs := append([]string{filepath.Base(d.file)}, d.inputs...)
+	if cmd := d.command(); cmd != "go" {
+		if bin, err := exec.LookPath(cmd); err == nil {
+			if bin, err = filepath.Abs(bin); err == nil {
+				inputs = append(inputs, bin)
+			}
+		}
+	}
+	res, err := toolcache.Run(ctx, cache, toolcache.Spec{
+		Cmd:         "go",
+		Args:        args,
+		Dir:         dir,
+		Inputs:      inputs,
+		OutputGlobs: d.outputs,
+		Stdout:      stdout,
+		Stderr:      stderr,
+	})
+	if err != nil {
+		return "failed", err
+	}
+	status := "ran"
+	if res.Cached {
+		status = "cached"
+	}
+	if res.ExitCode != 0 {
+		return status, fmt.Errorf("go generate exited with code %d", res.ExitCode)
+	}
+	return status, nil
+}
+
+// goFiles returns the Go source files of the packages, relative to the
+// current directory, in the order go generate visits them.
+func goFiles(ctx context.Context, packages []string, stderr io.Writer) ([]string, error) {
+	if len(packages) == 0 {
+		packages = []string{"."}
+	}
+	cmd := exec.CommandContext(ctx, "go", append([]string{"list", "-json=Dir,GoFiles,CgoFiles,TestGoFiles,XTestGoFiles", "--"}, packages...)...)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg struct {
+			Dir                                          string
+			GoFiles, CgoFiles, TestGoFiles, XTestGoFiles []string
+		}
+		if err := dec.Decode(&pkg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("go list: %w", err)
+		}
+		dir, err := filepath.Rel(wd, pkg.Dir)
+		if err != nil {
+			dir = pkg.Dir
+		}
+		for _, names := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles} {
+			for _, name := range names {
+				files = append(files, filepath.Join(dir, name))
+			}
+		}
+	}
+	return files, nil
+}
+
+// parseFile returns the //go:generate directives of a source file, with the
+// inputs and outputs declared in the comment lines directly above each.
+func parseFile(path string) ([]directive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var directives []directive
+	var inputs, outputs []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		switch {
+		case strings.HasPrefix(line, inputsPrefix):
+			inputs = append(inputs, strings.Fields(strings.TrimPrefix(line, inputsPrefix))...)
+		case strings.HasPrefix(line, outputsPrefix):
+			outputs = append(outputs, strings.Fields(strings.TrimPrefix(line, outputsPrefix))...)
+		case strings.HasPrefix(line, generatePrefix):
+			directives = append(directives, directive{file: path, line: n, text: line, inputs: inputs, outputs: outputs})
+			inputs, outputs = nil, nil
+		default:
+			inputs, outputs = nil, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return directives, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The test binary doubles as the generator: with GENERATE_HELPER set, it
+// uppercases its first argument into its second and logs the run to
+// GENERATE_LOG.
+func TestMain(m *testing.M) {
+	if os.Getenv("GENERATE_HELPER") == "" {
+		os.Exit(m.Run())
+	}
+	f, err := os.OpenFile(os.Getenv("GENERATE_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		fmt.Fprintln(f, "run")
+		f.Close()
+	}
+	in, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := os.WriteFile(os.Args[2], bytes.ToUpper(in), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "runs.log")
+	t.Setenv("GENERATE_HELPER", "1")
+	t.Setenv("GENERATE_LOG", log)
+	generator, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/gen\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(dir, "api", "input.txt"), "hello")
+	writeFile(t, filepath.Join(dir, "api", "api.go"), fmt.Sprintf(`package api
+
+//granular:inputs input.txt
+//granular:outputs gen/out.txt
+//go:generate %s input.txt gen/out.txt
+`, generator))
+	if err := os.Mkdir(filepath.Join(dir, "api", "gen"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	generate := func(want string) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if code := run(context.Background(), []string{"-root", "cache", "-v", "./..."}, &stdout, &stderr); code != 0 {
+			t.Fatalf("granular-generate exited with %d: %s", code, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), "("+want+")") {
+			t.Errorf("directive was not %s:\n%s", want, stderr.String())
+		}
+	}
+	runs := func() int { return strings.Count(readFile(t, log), "run") }
+
+	generate("ran")
+	if got := readFile(t, "api/gen/out.txt"); got != "HELLO" {
+		t.Fatalf("generated %q, want HELLO", got)
+	}
+
+	// A hit restores the outputs without running the generator
+	if err := os.Remove("api/gen/out.txt"); err != nil {
+		t.Fatal(err)
+	}
+	generate("cached")
+	if got := readFile(t, "api/gen/out.txt"); got != "HELLO" || runs() != 1 {
+		t.Errorf("restored %q after %d runs, want HELLO after 1", got, runs())
+	}
+
+	// Changing a declared input runs it again
+	writeFile(t, "api/input.txt", "bye")
+	generate("ran")
+	if got := readFile(t, "api/gen/out.txt"); got != "BYE" || runs() != 2 {
+		t.Errorf("generated %q after %d runs, want BYE after 2", got, runs())
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gen.go")
+	writeFile(t, path, `package gen
+
+//granular:inputs a.proto
+//granular:inputs b.proto
+//granular:outputs a.pb.go
+//go:generate protoc a.proto b.proto
+
+//granular:outputs stale.go
+
+//go:generate stringer -type=Kind
+`)
+	directives, err := parseFile(path)
+	if err != nil {
+		t.Fatalf("parseFile failed: %v", err)
+	}
+	if len(directives) != 2 {
+		t.Fatalf("parsed %d directives, want 2", len(directives))
+	}
+	d := directives[0]
+	if d.line != 6 || d.command() != "protoc" || strings.Join(d.inputs, ",") != "a.proto,b.proto" || strings.Join(d.outputs, ",") != "a.pb.go" {
+		t.Errorf("first directive = %+v", d)
+	}
+	// Declarations only apply to the directive directly below them
+	if d := directives[1]; d.command() != "stringer" || d.outputs != nil {
+		t.Errorf("second directive = %+v", d)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gophersatwork/granular"
+)
+
+func runStats(e *env, args []string) error {
+	if err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+	cache, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	stats, err := cache.Stats()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(e.stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Root:\t%s\n", e.root)
+	fmt.Fprintf(tw, "Entries:\t%d\n", stats.Entries)
+	fmt.Fprintf(tw, "Total size:\t%s (files %s, data %s, manifests %s)\n", formatBytes(stats.TotalSize),
+		formatBytes(stats.FileSize), formatBytes(stats.DataSize), formatBytes(stats.ManifestSize))
+	if stats.Entries > 0 {
+		fmt.Fprintf(tw, "Oldest entry:\t%s ago\n", stats.OldestEntry.Round(time.Second))
+		fmt.Fprintf(tw, "Newest entry:\t%s ago\n", stats.NewestEntry.Round(time.Second))
+	}
+	if stats.SkippedManifests > 0 {
+		fmt.Fprintf(tw, "Unreadable manifests:\t%d (run 'granular verify')\n", stats.SkippedManifests)
+	}
+	return tw.Flush()
+}
+
+func runList(e *env, args []string) error {
+	asJSON := e.flags.Bool("json", false, "print entries as JSON")
+	sortBy := e.flags.String("sort", "hash", "sort entries by hash, created, accessed, or size (largest first)")
+	limit := e.flags.Int("limit", 0, "list at most `n` entries")
+	if err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+	orders := map[string]granular.QueryOrder{
+		"hash":     granular.OrderByKeyHash,
+		"created":  granular.OrderByCreated,
+		"accessed": granular.OrderByAccessed,
+		"size":     granular.OrderBySize,
+	}
+	order, ok := orders[*sortBy]
+	if !ok {
+		return fmt.Errorf("unknown sort order %q", *sortBy)
+	}
+	cache, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	entries, err := cache.Entries(granular.SortEntries(order), granular.LimitEntries(*limit))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(e, entries)
+	}
+
+	tw := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HASH\tSIZE\tFILES\tCREATED\tACCESSED\tDESCRIPTION")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", entry.KeyHash, formatBytes(entry.Size), entry.FileCount,
+			formatTime(entry.CreatedAt), formatTime(entry.AccessedAt), entry.Description)
+	}
+	return tw.Flush()
+}
+
+func runShow(e *env, args []string) error {
+	asJSON := e.flags.Bool("json", false, "print the entry as JSON")
+	if err := e.parse(args, 1, 1); err != nil {
+		return err
+	}
+	cache, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	info, err := cache.Describe(e.flags.Arg(0))
+	if errors.Is(err, granular.ErrCacheMiss) {
+		return fmt.Errorf("no entry %s", e.flags.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(e, info)
+	}
+
+	tw := tabwriter.NewWriter(e.stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Hash:\t%s (%s)\n", info.KeyHash, info.HashAlgo)
+	if info.Description != "" {
+		fmt.Fprintf(tw, "Description:\t%s\n", info.Description)
+	}
+	if info.Namespace != "" {
+		fmt.Fprintf(tw, "Namespace:\t%s\n", info.Namespace)
+	}
+	fmt.Fprintf(tw, "Size:\t%s\n", formatBytes(info.Size))
+	fmt.Fprintf(tw, "Created:\t%s\n", formatTime(info.CreatedAt))
+	fmt.Fprintf(tw, "Accessed:\t%s\n", formatTime(info.AccessedAt))
+	if info.Compression != granular.CompressionNone {
+		fmt.Fprintf(tw, "Compression:\t%s\n", info.Compression)
+	}
+	writeList(tw, "Inputs", info.Inputs)
+	writeMap(tw, "Extras", info.Extras)
+	writeList(tw, "Files", info.Files)
+	writeList(tw, "Data", info.Data)
+	writeMap(tw, "Metadata", info.Meta)
+	writeMap(tw, "Tags", info.Tags)
+	return tw.Flush()
+}
+
+func runRemove(e *env, args []string) error {
+	if err := e.parse(args, 1, -1); err != nil {
+		return err
+	}
+	cache, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	want := make(map[string]bool)
+	for _, hash := range e.flags.Args() {
+		want[hash] = true
+	}
+	removed, err := cache.DeleteWhere(func(entry granular.Entry) bool {
+		if want[entry.KeyHash] {
+			delete(want, entry.KeyHash)
+			return true
+		}
+		return false
+	})
+	fmt.Fprintf(e.stdout, "Removed %d entries\n", removed)
+	if err != nil {
+		return err
+	}
+	if len(want) > 0 {
+		missing := make([]string, 0, len(want))
+		for hash := range want {
+			missing = append(missing, hash)
+		}
+		return fmt.Errorf("no such entries: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func runPrune(e *env, args []string) error {
+	olderThan := e.flags.Duration("older-than", 0, "remove entries created longer ago than this")
+	unused := e.flags.Duration("unused", 0, "remove entries not accessed for this long")
+	maxSize := e.flags.String("max-size", "", "evict least recently used entries until the cache fits (e.g. 512M, 10G)")
+	dryRun := e.flags.Bool("dry-run", false, "list the entries that would be removed without removing them")
+	if err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+	var maxBytes int64 = -1
+	if *maxSize != "" {
+		n, err := parseBytes(*maxSize)
+		if err != nil {
+			return fmt.Errorf("invalid -max-size: %w", err)
+		}
+		maxBytes = n
+	}
+	if *olderThan <= 0 && *unused <= 0 && maxBytes < 0 {
+		e.flags.Usage()
+		return errUsage
+	}
+
+	cache, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	if *dryRun {
+		return prunePlan(e, cache, *olderThan, *unused, maxBytes)
+	}
+
+	if *olderThan > 0 {
+		n, err := cache.Prune(*olderThan)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Removed %d entries older than %s\n", n, *olderThan)
+	}
+	if *unused > 0 {
+		n, err := cache.PruneUnused(*unused)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Removed %d entries unused for %s\n", n, *unused)
+	}
+	if maxBytes >= 0 {
+		n, freed, err := cache.PruneToSize(maxBytes)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Removed %d entries (%s) to fit in %s\n", n, formatBytes(freed), formatBytes(maxBytes))
+	}
+	return nil
+}
+
+// prunePlan prints the entries each enabled prune step would remove.
+func prunePlan(e *env, cache *granular.Cache, olderThan, unused time.Duration, maxBytes int64) error {
+	report := func(reason string, entries []granular.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Would remove %d entries %s\n", len(entries), reason)
+		for _, entry := range entries {
+			fmt.Fprintf(e.stdout, "  %s  %s\n", entry.KeyHash, formatBytes(entry.Size))
+		}
+		return nil
+	}
+	if olderThan > 0 {
+		entries, err := cache.PrunePlan(olderThan)
+		if err := report("older than "+olderThan.String(), entries, err); err != nil {
+			return err
+		}
+	}
+	if unused > 0 {
+		entries, err := cache.PruneUnusedPlan(unused)
+		if err := report("unused for "+unused.String(), entries, err); err != nil {
+			return err
+		}
+	}
+	if maxBytes >= 0 {
+		entries, err := cache.PruneToSizePlan(maxBytes)
+		if err := report("to fit in "+formatBytes(maxBytes), entries, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runVerify(e *env, args []string) error {
+	repair := e.flags.Bool("repair", false, "remove damaged entries and orphaned objects")
+	if err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+	cache, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	verify := cache.Verify
+	if *repair {
+		verify = cache.Repair
+	}
+	report, err := verify()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.stdout, "Checked %d entries\n", report.Checked)
+	for _, problem := range []struct {
+		name   string
+		hashes []string
+	}{
+		{"Unreadable manifests", report.Unreadable},
+		{"Dangling manifests", report.Dangling},
+		{"Damaged entries", report.Damaged},
+		{"Orphaned objects", report.Orphans},
+	} {
+		if len(problem.hashes) == 0 {
+			continue
+		}
+		fmt.Fprintf(e.stdout, "%s: %d\n", problem.name, len(problem.hashes))
+		for _, hash := range problem.hashes {
+			fmt.Fprintf(e.stdout, "  %s\n", hash)
+		}
+	}
+
+	switch {
+	case report.OK():
+		fmt.Fprintln(e.stdout, "OK")
+	case report.Repaired:
+		fmt.Fprintln(e.stdout, "Repaired")
+	default:
+		return errors.New("cache has problems; run with -repair to remove them")
+	}
+	return nil
+}
+
+func runClear(e *env, args []string) error {
+	if err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+	cache, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	stats, err := cache.Stats()
+	if err != nil {
+		return err
+	}
+	if err := cache.Clear(); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Removed %d entries (%s)\n", stats.Entries, formatBytes(stats.TotalSize))
+	return nil
+}
+
+func writeJSON(e *env, v any) error {
+	enc := json.NewEncoder(e.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeList(tw *tabwriter.Writer, label string, items []string) {
+	for i, item := range items {
+		if i == 0 {
+			fmt.Fprintf(tw, "%s:\t%s\n", label, item)
+		} else {
+			fmt.Fprintf(tw, "\t%s\n", item)
+		}
+	}
+}
+
+func writeMap(tw *tabwriter.Writer, label string, m map[string]string) {
+	items := make([]string, 0, len(m))
+	for k, v := range m {
+		items = append(items, k+"="+v)
+	}
+	slices.Sort(items)
+	writeList(tw, label, items)
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format(time.DateTime)
+}
+
+// byteUnits are the suffixes accepted by parseBytes and produced by formatBytes.
+var byteUnits = []string{"B", "K", "M", "G", "T"}
+
+// formatBytes formats n with a binary unit suffix, e.g. "1.5M".
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n)
+	unit := 0
+	for v >= 1024 && unit < len(byteUnits)-1 {
+		v /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64) + byteUnits[unit]
+}
+
+// parseBytes parses a size such as "1048576", "512M", or "10G" (binary units).
+func parseBytes(s string) (int64, error) {
+	upper := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	for i := len(byteUnits) - 1; i > 0; i-- {
+		if rest, ok := strings.CutSuffix(upper, byteUnits[i]); ok {
+			upper = rest
+			multiplier = 1 << (10 * i)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
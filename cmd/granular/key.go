@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophersatwork/granular"
+	"github.com/spf13/afero"
+)
+
+// keyInput is one key input flag, applied to the builder in command-line order.
+type keyInput func(kb *granular.KeyBuilder)
+
+func runKey(e *env, args []string) error {
+	// Inputs are collected in the order they appear: the key hash depends on it.
+	var inputs []keyInput
+	var dirExcludes *[]string // excludes of the most recent -dir
+
+	e.flags.Func("file", "hash a file's content (repeatable)", func(path string) error {
+		inputs = append(inputs, func(kb *granular.KeyBuilder) { kb.File(path) })
+		return nil
+	})
+	e.flags.Func("glob", "hash all files matching a pattern, ** supported (repeatable)", func(pattern string) error {
+		inputs = append(inputs, func(kb *granular.KeyBuilder) { kb.Glob(pattern) })
+		return nil
+	})
+	e.flags.Func("dir", "hash all files in a directory (repeatable)", func(path string) error {
+		excludes := new([]string)
+		dirExcludes = excludes
+		inputs = append(inputs, func(kb *granular.KeyBuilder) { kb.Dir(path, *excludes...) })
+		return nil
+	})
+	e.flags.Func("exclude", "exclude basenames matching a pattern from the preceding -dir (repeatable)", func(pattern string) error {
+		if dirExcludes == nil {
+			return fmt.Errorf("-exclude must follow -dir")
+		}
+		*dirExcludes = append(*dirExcludes, pattern)
+		return nil
+	})
+	e.flags.Func("string", "add a key=value component (repeatable)", func(kv string) error {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", kv)
+		}
+		inputs = append(inputs, func(kb *granular.KeyBuilder) { kb.String(k, v) })
+		return nil
+	})
+	e.flags.Func("env", "add the value of an environment variable (repeatable)", func(name string) error {
+		inputs = append(inputs, func(kb *granular.KeyBuilder) { kb.Env(name) })
+		return nil
+	})
+	e.flags.Func("version", "add a version component", func(v string) error {
+		inputs = append(inputs, func(kb *granular.KeyBuilder) { kb.Version(v) })
+		return nil
+	})
+	hashAlgo := e.flags.String("hash", granular.DefaultHashAlgoName, "hash algorithm: xxhash64, sha256, or blake3")
+	trace := e.flags.Bool("trace", false, "print every input, the files it read, and the digest so far, for diffing between machines")
+	if err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		e.flags.Usage()
+		return errUsage
+	}
+
+	options := []granular.Option{
+		// Read inputs from disk, but keep the cache directories Open creates in memory
+		granular.WithFs(afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(afero.NewOsFs()), afero.NewMemMapFs())),
+		granular.WithAccumulateErrors(),
+	}
+	options = append(options, granular.WithHashAlgo(granular.HashAlgo(*hashAlgo)))
+	cache, err := granular.Open("", options...)
+	if err != nil {
+		return err
+	}
+
+	kb := cache.Key()
+	for _, input := range inputs {
+		input(kb)
+	}
+	if *trace {
+		t, err := kb.Build().Trace()
+		if err != nil {
+			return err
+		}
+		_, err = t.WriteTo(e.stdout)
+		return err
+	}
+	hash, err := kb.Build().HashErr()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(e.stdout, hash)
+	return nil
+}
@@ -0,0 +1,168 @@
+// Command granular inspects and maintains granular cache directories.
+//
+// Usage:
+//
+//	granular <command> [flags] [args]
+//
+// Commands:
+//
+//	stats           Print entry count, total size, and entry ages
+//	ls              List entries
+//	show <hash>     Print everything recorded about an entry
+//	rm <hash>...    Remove entries by key hash
+//	prune           Remove old, unused, or excess entries
+//	verify          Audit the cache and optionally repair it
+//	clear           Remove all entries
+//	key             Print the key hash for a set of inputs
+//	serve           Share the cache over HTTP
+//
+// Every command accepts -root to select the cache directory (default ".cache").
+// The key command ignores it: it hashes inputs without touching any cache.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gophersatwork/granular"
+)
+
+// command is a granular subcommand.
+type command struct {
+	name  string
+	args  string // argument synopsis shown in usage
+	short string
+	run   func(env *env, args []string) error
+}
+
+// env carries what a command needs: its flags, the opened cache, and output.
+type env struct {
+	flags  *flag.FlagSet
+	root   string
+	stdout io.Writer
+}
+
+// errUsage reports a usage error; the command's usage has already been printed.
+var errUsage = errors.New("usage error")
+
+var commands = []*command{
+	{name: "stats", short: "Print entry count, total size, and entry ages", run: runStats},
+	{name: "ls", short: "List entries", run: runList},
+	{name: "show", args: "<hash>", short: "Print everything recorded about an entry", run: runShow},
+	{name: "rm", args: "<hash>...", short: "Remove entries by key hash", run: runRemove},
+	{name: "prune", short: "Remove old, unused, or excess entries", run: runPrune},
+	{name: "verify", short: "Audit the cache and optionally repair it", run: runVerify},
+	{name: "clear", short: "Remove all entries", run: runClear},
+	{name: "key", short: "Print the key hash for a set of inputs", run: runKey},
+	{name: "serve", short: "Share the cache over HTTP", run: runServe},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the process exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		return 2
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		e := &env{flags: flag.NewFlagSet(cmd.name, flag.ContinueOnError), stdout: stdout}
+		e.flags.SetOutput(stderr)
+		e.flags.StringVar(&e.root, "root", ".cache", "cache directory")
+		e.flags.Usage = func() {
+			synopsis := strings.TrimSpace("granular " + cmd.name + " [flags] " + cmd.args)
+			fmt.Fprintf(stderr, "usage: %s\n\n%s.\n\nFlags:\n", synopsis, cmd.short)
+			e.flags.PrintDefaults()
+		}
+
+		err := cmd.run(e, args[1:])
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+			return 2
+		default:
+			fmt.Fprintf(stderr, "granular %s: %v\n", cmd.name, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stderr, "granular: unknown command %q\n\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: granular <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name+" "+cmd.args, cmd.short)
+	}
+	fmt.Fprintf(w, "\nRun 'granular <command> -h' for command flags.\n")
+}
+
+// parse parses the command's flags and checks the number of positional
+// arguments is within [min, max] (max < 0 means unlimited).
+func (e *env) parse(args []string, min, max int) error {
+	if err := e.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage // the flag package has printed the error and usage
+	}
+	if n := e.flags.NArg(); n < min || (max >= 0 && n > max) {
+		e.flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// open opens the cache directory selected by -root. Unlike granular.Open it
+// refuses to create a cache that does not exist yet.
+func (e *env) open() (*granular.Cache, error) {
+	options, err := e.options()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := granular.OpenExisting(e.root, options...)
+	if errors.Is(err, granular.ErrNotCache) {
+		return nil, fmt.Errorf("no cache at %s", e.root)
+	}
+	return cache, err
+}
+
+// options returns the options the cache at -root was created with, as
+// recorded in its granular.json, so caches using another hash algorithm
+// than the default open without ErrHashAlgoMismatch. A cache without the
+// file gets the defaults.
+func (e *env) options() ([]granular.Option, error) {
+	data, err := os.ReadFile(filepath.Join(e.root, "granular.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		HashAlgo string `json:"hashAlgo"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse cache metadata: %w", err)
+	}
+	if meta.HashAlgo == "" {
+		return nil, nil
+	}
+	return []granular.Option{granular.WithHashAlgo(granular.HashAlgo(meta.HashAlgo))}, nil
+}
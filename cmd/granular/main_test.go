@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/server"
+)
+
+// setupCache creates an on-disk cache with n entries and returns its root
+// and the key hashes.
+func setupCache(t *testing.T, n int) (string, []string) {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "cache")
+	cache, err := granular.Open(root)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	var hashes []string
+	for i := range n {
+		key := cache.Key().String("i", string(rune('a'+i))).Build()
+		if err := cache.Put(key).Bytes("out", []byte("data")).Meta("tool", "test").Describe("test entry").Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		hashes = append(hashes, key.Hash())
+	}
+	return root, hashes
+}
+
+// runCLI runs the CLI and returns its exit code, stdout, and stderr.
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestStatsAndList(t *testing.T) {
+	root, hashes := setupCache(t, 2)
+
+	code, out, errOut := runCLI("stats", "-root", root)
+	if code != 0 || !strings.Contains(out, "Entries:") || !strings.Contains(out, "2") || !strings.Contains(out, "manifests") {
+		t.Fatalf("stats: code=%d out=%q err=%q", code, out, errOut)
+	}
+
+	code, out, _ = runCLI("ls", "-root", root)
+	if code != 0 {
+		t.Fatalf("ls failed with code %d", code)
+	}
+	if !strings.Contains(out, "DESCRIPTION") || !strings.Contains(out, "test entry") {
+		t.Errorf("ls output missing descriptions:\n%s", out)
+	}
+	for _, hash := range hashes {
+		if !strings.Contains(out, hash) {
+			t.Errorf("ls output missing %s:\n%s", hash, out)
+		}
+	}
+
+	code, out, _ = runCLI("ls", "-root", root, "-sort", "created", "-limit", "1")
+	if code != 0 {
+		t.Fatalf("ls -sort created failed with code %d", code)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 {
+		t.Errorf("ls -limit 1 printed %d lines, want a header and 1 entry:\n%s", len(lines), out)
+	}
+	if code, _, errOut := runCLI("ls", "-root", root, "-sort", "bogus"); code != 1 || !strings.Contains(errOut, "bogus") {
+		t.Errorf("ls -sort bogus: code=%d err=%q", code, errOut)
+	}
+}
+
+func TestShowAndRemove(t *testing.T) {
+	root, hashes := setupCache(t, 2)
+
+	code, out, _ := runCLI("show", "-root", root, hashes[0])
+	if code != 0 || !strings.Contains(out, "tool=test") || !strings.Contains(out, "test entry") || !strings.Contains(out, "out") {
+		t.Fatalf("show: code=%d out=%q", code, out)
+	}
+
+	if code, _, errOut := runCLI("rm", "-root", root, hashes[0], "0000000000000000"); code != 1 || !strings.Contains(errOut, "0000000000000000") {
+		t.Fatalf("rm with a missing hash: code=%d err=%q", code, errOut)
+	}
+	if code, _, _ := runCLI("show", "-root", root, hashes[0]); code != 1 {
+		t.Error("Expected removed entry to be gone")
+	}
+	if code, _, _ := runCLI("show", "-root", root, hashes[1]); code != 0 {
+		t.Error("Expected other entry to remain")
+	}
+}
+
+func TestPruneVerifyClear(t *testing.T) {
+	root, hashes := setupCache(t, 3)
+
+	code, out, _ := runCLI("prune", "-root", root, "-max-size", "0", "-dry-run")
+	if code != 0 || !strings.Contains(out, "Would remove 3 entries") {
+		t.Fatalf("prune -dry-run: code=%d out=%q", code, out)
+	}
+	if code, _, _ := runCLI("show", "-root", root, hashes[0]); code != 0 {
+		t.Fatal("dry run removed an entry")
+	}
+
+	if code, out, _ := runCLI("verify", "-root", root); code != 0 || !strings.Contains(out, "OK") {
+		t.Fatalf("verify: code=%d out=%q", code, out)
+	}
+
+	if code, out, _ := runCLI("clear", "-root", root); code != 0 || !strings.Contains(out, "Removed 3 entries") {
+		t.Fatalf("clear: code=%d out=%q", code, out)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"bogus"},
+		{"show"},
+		{"prune"},
+	} {
+		if code, _, _ := runCLI(args...); code != 2 {
+			t.Errorf("run(%q) = %d, want 2", args, code)
+		}
+	}
+
+	if code, _, errOut := runCLI("stats", "-root", filepath.Join(t.TempDir(), "missing")); code != 1 || !strings.Contains(errOut, "no cache") {
+		t.Errorf("stats on missing cache: code=%d err=%q", code, errOut)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]int64{
+		"0":     0,
+		"1024":  1024,
+		"512K":  512 << 10,
+		"10G":   10 << 30,
+		"1.5GB": -1,
+		"1mb":   1 << 20,
+		"-1":    -1,
+	} {
+		got, err := parseBytes(in)
+		if want < 0 {
+			if err == nil {
+				t.Errorf("parseBytes(%q) = %d, want error", in, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("parseBytes(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte("package main"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "skip.tmp"), []byte("tmp"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GRANULAR_TEST_ENV", "on")
+
+	cache, err := granular.Open(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	want := cache.Key().File(file).Dir(dir, "*.tmp").String("goos", "linux").Env("GRANULAR_TEST_ENV").Build().Hash()
+
+	code, out, errOut := runCLI("key", "-file", file, "-dir", dir, "-exclude", "*.tmp", "-string", "goos=linux", "-env", "GRANULAR_TEST_ENV")
+	if code != 0 || strings.TrimSpace(out) != want {
+		t.Fatalf("key: code=%d out=%q err=%q, want %s", code, out, errOut, want)
+	}
+
+	// -trace lists each input with the files it read
+	code, out, errOut = runCLI("key", "-trace", "-file", file, "-string", "goos=linux")
+	if code != 0 || !strings.Contains(out, "input file:") || !strings.Contains(out, "  file "+file+" 12 bytes ") || !strings.Contains(out, "extra goos=linux") {
+		t.Errorf("key -trace: code=%d out=%q err=%q", code, out, errOut)
+	}
+
+	// File inputs are hashed in command-line order
+	_, forward, _ := runCLI("key", "-file", file, "-dir", dir)
+	_, reversed, _ := runCLI("key", "-dir", dir, "-file", file)
+	if forward == reversed {
+		t.Error("Expected reordered inputs to produce a different hash")
+	}
+
+	blake, err := granular.Open(filepath.Join(t.TempDir(), "cache"), granular.WithHashAlgo(granular.Blake3))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, out, _ := runCLI("key", "-hash", "blake3", "-file", file); strings.TrimSpace(out) != blake.Key().File(file).Hash() {
+		t.Errorf("key -hash blake3 = %q, want %s", out, blake.Key().File(file).Hash())
+	}
+	if code, _, errOut := runCLI("key", "-hash", "md5", "-file", file); code != 1 || !strings.Contains(errOut, "md5") {
+		t.Errorf("key -hash md5: code=%d err=%q", code, errOut)
+	}
+
+	if code, _, errOut := runCLI("key", "-file", filepath.Join(dir, "missing.go")); code != 1 || !strings.Contains(errOut, "missing.go") {
+		t.Errorf("key with a missing file: code=%d err=%q", code, errOut)
+	}
+	for _, args := range [][]string{{"key"}, {"key", "-string", "novalue"}, {"key", "-exclude", "*.tmp"}} {
+		if code, _, _ := runCLI(args...); code != 2 {
+			t.Errorf("%v: code = %d, want 2", args, code)
+		}
+	}
+}
+
+func TestNonDefaultHash(t *testing.T) {
+	for _, algo := range []granular.HashAlgo{granular.SHA256, granular.Blake3} {
+		root := filepath.Join(t.TempDir(), "cache")
+		cache, err := granular.Open(root, granular.WithHashAlgo(algo))
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		key := cache.Key().String("algo", string(algo)).Build()
+		if err := cache.Put(key).Bytes("out", []byte("data")).Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		_ = cache.Close()
+
+		for _, args := range [][]string{{"stats"}, {"ls"}, {"show", "-root", root, key.Hash()}, {"verify"}, {"rm", "-root", root, key.Hash()}} {
+			if len(args) == 1 {
+				args = append(args, "-root", root)
+			}
+			if code, out, errOut := runCLI(args...); code != 0 {
+				t.Errorf("%v on a %s cache: code=%d out=%q err=%q", args, algo, code, out, errOut)
+			}
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	root, hashes := setupCache(t, 1)
+	cache, err := granular.Open(root)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, ln, newHandler(cache, true)) }()
+
+	base := "http://" + ln.Addr().String()
+	client := server.NewClient(base, nil)
+	if has, err := client.Has(ctx, hashes[0]); err != nil || !has {
+		t.Errorf("Has = %v, %v", has, err)
+	}
+	// The empty blob is always in the Bazel CAS
+	resp, err := http.Get(base + "/cas/e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Bazel CAS request = %v, %v", resp, err)
+	}
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("serve returned %v after shutdown", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/server"
+	"github.com/gophersatwork/granular/server/bazel"
+)
+
+// shutdownTimeout bounds how long serve waits for in-flight requests on exit.
+const shutdownTimeout = 10 * time.Second
+
+func runServe(e *env, args []string) error {
+	addr := e.flags.String("addr", ":8080", "address to listen on")
+	withBazel := e.flags.Bool("bazel", false, "also serve the Bazel remote caching protocol at /ac/ and /cas/")
+	if err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+
+	// Unlike the other commands, serve creates the cache if it doesn't exist
+	options, err := e.options()
+	if err != nil {
+		return err
+	}
+	cache, err := granular.Open(e.root, options...)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Serving %s on %s\n", e.root, ln.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serve(ctx, ln, newHandler(cache, *withBazel))
+}
+
+// newHandler returns the handler for serve: the granular protocol under
+// /v1/ and, if withBazel is set, the Bazel protocol under /ac/ and /cas/.
+func newHandler(cache *granular.Cache, withBazel bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", server.New(cache))
+	if withBazel {
+		h := bazel.New(cache)
+		mux.Handle("/ac/", h)
+		mux.Handle("/cas/", h)
+	}
+	return mux
+}
+
+// serve serves handler on ln until ctx is done, then shuts down gracefully.
+func serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
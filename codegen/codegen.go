@@ -0,0 +1,183 @@
+// Package codegen caches the output of protoc-style code generators in a
+// granular cache.
+//
+// Code generators read schema files, load plugins, and write trees of
+// generated sources. Run keys a generator on exactly those, and on a hit
+// restores the generated trees without running it:
+//
+//	res, err := codegen.Run(ctx, cache, codegen.Spec{
+//		Tool:    "protoc",
+//		Args:    []string{"-I", "proto", "--go_out=gen", "--go-grpc_out=gen", "proto/api/v1/service.proto"},
+//		Schemas: []string{"proto/**/*.proto"},
+//		OutDirs: []string{"gen"},
+//	})
+//
+// For protoc, the plugins named by --NAME_out and --plugin flags are found
+// in PATH and keyed automatically, so upgrading protoc-gen-go regenerates
+// the code. Other tools list their plugins in Spec.Plugins.
+//
+// Output directories are emptied before each run and restored in full, so
+// files generated from schemas that have since been deleted do not linger.
+// They must only hold generated files. Like toolcache, paths are read
+// through the operating system; the cache should use the default OS
+// filesystem.
+package codegen
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/toolcache"
+)
+
+// Spec describes a code generator run.
+type Spec struct {
+	// Tool is the generator to run: a path, or a name looked up in PATH.
+	// Its binary is part of the key.
+	Tool string
+
+	// Args are the generator's arguments. They are part of the key.
+	Args []string
+
+	// Schemas are glob patterns (supporting **) of the schema files the
+	// generator reads. Their contents are part of the key.
+	Schemas []string
+
+	// Plugins are the plugins the generator loads: paths, or names looked
+	// up in PATH. Their binaries are part of the key. If Plugins is nil and
+	// Tool is protoc, they are taken from the arguments (see ProtocPlugins),
+	// and relative plugin paths there are relative to Dir.
+	Plugins []string
+
+	// OutDirs are the directories the generator writes. They are emptied
+	// before each run, and every file in them afterwards is cached and
+	// restored on a hit.
+	OutDirs []string
+
+	// Dir is the working directory of the generator; empty means the
+	// current directory. It is not part of the key. As in toolcache, the
+	// paths in Schemas, Plugins, and OutDirs are relative to the current
+	// directory, not to Dir.
+	Dir string
+
+	// Env holds extra environment variables in "KEY=value" form. They are
+	// part of the key.
+	Env []string
+
+	// Stdout and Stderr, if set, receive the generator's output as it
+	// runs, or the cached output on a hit.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// protocBuiltins are the generators compiled into protoc, which need no
+// plugin.
+var protocBuiltins = []string{"cpp", "csharp", "java", "kotlin", "objc", "php", "pyi", "python", "ruby", "rust", "upb", "upbdefs", "upb_minitable"}
+
+// ProtocPlugins returns the plugins a protoc command line loads: for every
+// --NAME_out flag whose generator is not built into protoc, the path given
+// for protoc-gen-NAME by a --plugin flag, or else protoc-gen-NAME itself,
+// which protoc looks up in PATH.
+//
+// Example:
+//
+//	codegen.ProtocPlugins([]string{"--go_out=gen", "--python_out=py", "api.proto"})
+//	// []string{"protoc-gen-go"}
+func ProtocPlugins(args []string) []string {
+	paths := make(map[string]string) // plugin name -> path given by --plugin
+	var names []string
+	for i, arg := range args {
+		var plugin string
+		switch {
+		case strings.HasPrefix(arg, "--plugin="):
+			plugin = strings.TrimPrefix(arg, "--plugin=")
+		case arg == "--plugin" && i+1 < len(args):
+			plugin = args[i+1]
+		case strings.HasPrefix(arg, "--"):
+			flag, _, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+			if name, ok := strings.CutSuffix(flag, "_out"); ok && !slices.Contains(protocBuiltins, name) {
+				names = append(names, "protoc-gen-"+name)
+			}
+			continue
+		default:
+			continue
+		}
+		// --plugin=protoc-gen-NAME=path, or a path whose base name is the
+		// plugin name
+		name, path, ok := strings.Cut(plugin, "=")
+		if !ok {
+			name, path = strings.TrimSuffix(filepath.Base(plugin), ".exe"), plugin
+		}
+		paths[name] = path
+	}
+
+	var plugins []string
+	for _, name := range names {
+		plugins = append(plugins, cmp.Or(paths[name], name))
+	}
+	slices.Sort(plugins)
+	return slices.Compact(plugins)
+}
+
+// Run runs the generator described by spec, or restores its output from the
+// cache if the tool, plugins, arguments, extra environment, and schema files
+// are unchanged. A non-zero exit code is reported in the result, not as an
+// error, as by toolcache.Run.
+func Run(ctx context.Context, cache *granular.Cache, spec Spec) (*toolcache.Result, error) {
+	plugins := spec.Plugins
+	if plugins == nil && strings.TrimSuffix(filepath.Base(spec.Tool), ".exe") == "protoc" {
+		for _, plugin := range ProtocPlugins(spec.Args) {
+			plugins = append(plugins, inDir(spec.Dir, plugin))
+		}
+	}
+	inputs := slices.Clone(spec.Schemas)
+	for _, plugin := range plugins {
+		path, err := exec.LookPath(plugin)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", plugin, err)
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, path)
+	}
+
+	var outputs []string
+	for _, dir := range spec.OutDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmt.Errorf("failed to empty output directory: %w", err)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		outputs = append(outputs, filepath.Join(dir, "**"))
+	}
+
+	return toolcache.Run(ctx, cache, toolcache.Spec{
+		Cmd:         spec.Tool,
+		Args:        spec.Args,
+		Dir:         spec.Dir,
+		Env:         spec.Env,
+		Inputs:      inputs,
+		OutputGlobs: outputs,
+		Stdout:      spec.Stdout,
+		Stderr:      spec.Stderr,
+	})
+}
+
+// inDir returns a path relative to dir as seen from the current directory.
+// Bare names, which are looked up in PATH, are returned unchanged.
+func inDir(dir, path string) string {
+	if dir == "" || filepath.IsAbs(path) || !strings.ContainsAny(path, `/`+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
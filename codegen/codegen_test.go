@@ -0,0 +1,173 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+)
+
+// The test binary doubles as the generator: with CODEGEN_HELPER set, it
+// writes the uppercased contents of each schema named after its first
+// argument into that directory, and logs the run to CODEGEN_LOG.
+func TestMain(m *testing.M) {
+	if os.Getenv("CODEGEN_HELPER") == "" {
+		os.Exit(m.Run())
+	}
+	f, err := os.OpenFile(os.Getenv("CODEGEN_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		fmt.Fprintln(f, "run")
+		f.Close()
+	}
+	out := os.Args[1]
+	for _, schema := range os.Args[2:] {
+		in, err := os.ReadFile(schema)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		gen := filepath.Join(out, "v1", filepath.Base(schema)+".gen")
+		if err := os.MkdirAll(filepath.Dir(gen), 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := os.WriteFile(gen, bytes.ToUpper(in), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTree returns the files under dir, relative to it.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "runs.log")
+	t.Setenv("CODEGEN_HELPER", "1")
+	t.Setenv("CODEGEN_LOG", log)
+	tool, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := granular.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Chdir(dir)
+
+	writeFile(t, "schema/a.schema", "alpha", 0o644)
+	writeFile(t, "schema/b.schema", "beta", 0o644)
+	writeFile(t, "bin/gen-plugin", "v1", 0o755)
+	spec := Spec{
+		Tool:    tool,
+		Args:    []string{"gen", "schema/a.schema", "schema/b.schema"},
+		Schemas: []string{"schema/*.schema"},
+		Plugins: []string{"bin/gen-plugin"},
+		OutDirs: []string{"gen"},
+	}
+	generate := func(spec Spec, cached bool) map[string]string {
+		t.Helper()
+		res, err := Run(t.Context(), cache, spec)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if res.ExitCode != 0 {
+			t.Fatalf("generator exited with %d: %s", res.ExitCode, res.Stderr)
+		}
+		if res.Cached != cached {
+			t.Errorf("Cached = %v, want %v", res.Cached, cached)
+		}
+		return readTree(t, "gen")
+	}
+	runs := func() int {
+		data, _ := os.ReadFile(log)
+		return strings.Count(string(data), "run")
+	}
+
+	want := map[string]string{"v1/a.schema.gen": "ALPHA", "v1/b.schema.gen": "BETA"}
+	if got := generate(spec, false); !maps.Equal(got, want) {
+		t.Fatalf("generated %v, want %v", got, want)
+	}
+
+	// A hit restores the tree, and files left in it are removed
+	writeFile(t, "gen/v1/stale.gen", "stale", 0o644)
+	if got := generate(spec, true); !maps.Equal(got, want) || runs() != 1 {
+		t.Errorf("restored %v after %d runs, want %v after 1", got, runs(), want)
+	}
+
+	// A new plugin binary runs the generator again
+	writeFile(t, "bin/gen-plugin", "v2", 0o755)
+	generate(spec, false)
+	if runs() != 2 {
+		t.Errorf("generator ran %d times after a plugin change, want 2", runs())
+	}
+
+	// Output of a deleted schema does not linger
+	if err := os.Remove("schema/b.schema"); err != nil {
+		t.Fatal(err)
+	}
+	spec.Args = spec.Args[:2]
+	want = map[string]string{"v1/a.schema.gen": "ALPHA"}
+	if got := generate(spec, false); !maps.Equal(got, want) {
+		t.Errorf("generated %v, want %v", got, want)
+	}
+
+	spec.Plugins = []string{"bin/missing"}
+	if _, err := Run(t.Context(), cache, spec); err == nil {
+		t.Error("Run with a missing plugin succeeded")
+	}
+}
+
+func TestProtocPlugins(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--go_out=gen", "--go-grpc_out=gen", "api.proto"}, []string{"protoc-gen-go", "protoc-gen-go-grpc"}},
+		{[]string{"--python_out", "py", "--cpp_out=cc", "api.proto"}, nil},
+		{[]string{"--plugin=protoc-gen-ts=node_modules/.bin/ts", "--ts_out=web"}, []string{"node_modules/.bin/ts"}},
+		{[]string{"--plugin", "bin/protoc-gen-lint", "--lint_out=.", "--go_out=gen", "--go_out=gen2"}, []string{"bin/protoc-gen-lint", "protoc-gen-go"}},
+		{[]string{"--plugin=protoc-gen-unused=bin/unused", "--include_imports"}, nil},
+	}
+	for _, tt := range tests {
+		if got := ProtocPlugins(tt.args); !slices.Equal(got, tt.want) {
+			t.Errorf("ProtocPlugins(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
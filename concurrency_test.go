@@ -6,6 +6,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -513,9 +514,132 @@ func TestConcurrentCommitsRespectMaxSize(t *testing.T) {
 	}
 
 	// Allow one entry of slack since eviction is estimated pre-compression.
+	// MaxSize bounds the objects; manifests are not counted against it.
 	limit := maxSize + int64(entrySize)
-	if stats.TotalSize > limit {
+	if size := stats.FileSize + stats.DataSize; size > limit {
 		t.Fatalf("Cache size %d exceeds maxSize %d + one entry slack %d (limit %d)",
-			stats.TotalSize, maxSize, entrySize, limit)
+			size, maxSize, entrySize, limit)
+	}
+}
+
+// TestConcurrentCommitIfAbsent tests that racing CommitIfAbsent calls store
+// the entry exactly once
+func TestConcurrentCommitIfAbsent(t *testing.T) {
+	t.Parallel()
+	cache, _ := setupConcurrentCache(t)
+	defer cache.Close()
+
+	key := cache.Key().File("file1.txt").Build()
+
+	const numWriters = 8
+	var stored atomic.Int32
+	var wg sync.WaitGroup
+	for i := range numWriters {
+		wg.Go(func() {
+			ok, err := cache.Put(key).
+				Bytes("output", []byte(fmt.Sprintf("writer-%d", i))).
+				CommitIfAbsent()
+			if err != nil {
+				t.Errorf("CommitIfAbsent failed: %v", err)
+			}
+			if ok {
+				stored.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := stored.Load(); n != 1 {
+		t.Errorf("Expected exactly 1 stored entry, got %d", n)
+	}
+}
+
+// blockingOpenFs blocks opening one file until release is closed.
+type blockingOpenFs struct {
+	afero.Fs
+	path    string
+	opened  chan struct{}
+	release chan struct{}
+}
+
+func (f *blockingOpenFs) Open(name string) (afero.File, error) {
+	if name == f.path {
+		close(f.opened)
+		<-f.release
+	}
+	return f.Fs.Open(name)
+}
+
+// TestHashingOutsideLock tests that a Get hashing slow inputs does not hold
+// the cache lock, so operations needing the exclusive lock are not blocked.
+func TestHashingOutsideLock(t *testing.T) {
+	fs := &blockingOpenFs{Fs: afero.NewMemMapFs(), path: "slow.txt", opened: make(chan struct{}), release: make(chan struct{})}
+	cache, err := Open(".cache", WithFs(fs))
+	assertNoError(t, err, "Open")
+	defer cache.Close()
+	createTestFile(t, fs.Fs, "slow.txt", []byte("large input"))
+
+	key := cache.Key().File("slow.txt").Build()
+	done := make(chan error)
+	go func() {
+		_, err := cache.Get(key)
+		done <- err
+	}()
+	<-fs.opened
+
+	cleared := make(chan error)
+	go func() { cleared <- cache.Clear() }()
+	select {
+	case err := <-cleared:
+		assertNoError(t, err, "Clear")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Clear blocked while Get was hashing its inputs")
+	}
+
+	close(fs.release)
+	if err := <-done; !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Get = %v, want ErrCacheMiss", err)
+	}
+}
+
+// TestCommitCopiesOutsideLock tests that a Commit copying slow outputs does
+// not hold the cache lock, so operations needing the exclusive lock are not
+// blocked, and that the entry is complete once the copy finishes.
+func TestCommitCopiesOutsideLock(t *testing.T) {
+	fs := &blockingOpenFs{Fs: afero.NewMemMapFs(), path: "big.bin", opened: make(chan struct{}), release: make(chan struct{})}
+	cache, err := Open(".cache", WithFs(fs))
+	assertNoError(t, err, "Open")
+	defer cache.Close()
+	createTestFile(t, fs.Fs, "big.bin", []byte("large output"))
+
+	key := cache.Key().String("stage", "link").Build()
+	done := make(chan error)
+	go func() { done <- cache.Put(key).File("binary", "big.bin").Commit() }()
+	<-fs.opened
+
+	gc := make(chan error)
+	go func() {
+		_, _, err := cache.GC()
+		gc <- err
+	}()
+	select {
+	case err := <-gc:
+		assertNoError(t, err, "GC")
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC blocked while Commit was copying its outputs")
+	}
+	if cache.Has(key) {
+		t.Error("entry visible before its outputs were copied")
+	}
+
+	close(fs.release)
+	assertNoError(t, <-done, "Commit")
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	data, err := afero.ReadFile(fs, result.File("binary"))
+	assertNoError(t, err, "ReadFile")
+	assertEqual(t, string(data), "large output", "cached output")
+	if entries, _ := afero.ReadDir(fs, ".cache/tmp"); len(entries) != 0 {
+		t.Errorf("staging left behind: %d entries in tmp", len(entries))
 	}
 }
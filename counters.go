@@ -0,0 +1,95 @@
+package granular
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is a snapshot of the cache's in-process activity counters.
+// Counters start at zero when the cache is opened and are not persisted.
+type Metrics struct {
+	Hits        int64 // Get calls that returned an entry
+	Misses      int64 // Get calls that found no usable entry
+	Puts        int64 // Successful commits
+	Evictions   int64 // Entries removed by Delete, DeleteWhere, pruning, or size-based eviction
+	BytesServed int64 // Total size of entries returned by hits
+
+	// Latency distributions, recorded only with WithLatencyHistograms.
+	HashLatency    Histogram // Computing key hashes, including reading input files
+	GetLatency     Histogram // Get calls, hit or miss, including key hashing
+	CommitLatency  Histogram // Commit calls, successful or not
+	RestoreLatency Histogram // Result.CopyFile calls restoring cached files
+}
+
+// HitRate returns the fraction of lookups that were hits, in [0, 1].
+// It returns 0 if there have been no lookups.
+func (m Metrics) HitRate() float64 {
+	total := m.Hits + m.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(m.Hits) / float64(total)
+}
+
+// counters holds the live values behind Metrics.
+type counters struct {
+	hits        atomic.Int64
+	misses      atomic.Int64
+	puts        atomic.Int64
+	evictions   atomic.Int64
+	bytesServed atomic.Int64
+
+	hashLatency    latencyHistogram
+	getLatency     latencyHistogram
+	commitLatency  latencyHistogram
+	restoreLatency latencyHistogram
+}
+
+// Metrics returns a snapshot of the cache's activity counters.
+// Entries removed by Clear are not counted as evictions.
+func (c *Cache) Metrics() Metrics {
+	return Metrics{
+		Hits:        c.counters.hits.Load(),
+		Misses:      c.counters.misses.Load(),
+		Puts:        c.counters.puts.Load(),
+		Evictions:   c.counters.evictions.Load(),
+		BytesServed: c.counters.bytesServed.Load(),
+
+		HashLatency:    c.counters.hashLatency.snapshot(),
+		GetLatency:     c.counters.getLatency.snapshot(),
+		CommitLatency:  c.counters.commitLatency.snapshot(),
+		RestoreLatency: c.counters.restoreLatency.snapshot(),
+	}
+}
+
+// The record helpers update the counters, log the event, and then call the
+// matching hook.
+
+func (c *Cache) recordHit(keyHash string, size int64) {
+	c.counters.hits.Add(1)
+	c.counters.bytesServed.Add(size)
+	c.metrics.hit(keyHash, size)
+}
+
+func (c *Cache) recordMiss(keyHash string) {
+	c.counters.misses.Add(1)
+	c.metrics.miss(keyHash)
+}
+
+func (c *Cache) recordPut(keyHash string, size int64, duration time.Duration) {
+	c.counters.puts.Add(1)
+	c.log(slog.LevelDebug, "cache put", keyHashAttr(keyHash), bytesAttr(size), durationAttr(duration))
+	c.metrics.put(keyHash, size, duration)
+}
+
+func (c *Cache) recordEvict(keyHash string, size int64, reason EvictReason) {
+	c.counters.evictions.Add(1)
+	c.log(slog.LevelDebug, "cache evict", keyHashAttr(keyHash), bytesAttr(size), slog.String("reason", string(reason)))
+	c.metrics.evict(keyHash, size, reason)
+}
+
+func (c *Cache) recordError(op string, err error) {
+	c.log(slog.LevelWarn, "cache error", slog.String("op", op), slog.Any("error", err))
+	c.metrics.error(op, err)
+}
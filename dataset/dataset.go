@@ -0,0 +1,323 @@
+// Package dataset caches partitioned data in a granular cache.
+//
+// A Dataset computes the same outputs for many partitions, such as the days
+// of a daily table. Each partition is keyed independently, so adding a day
+// or changing the inputs of one day recomputes only that day:
+//
+//	ds, err := dataset.New(cache, dataset.Spec{
+//		Name: "events",
+//		Inputs: func(kb *granular.KeyBuilder, partition string) {
+//			kb.Glob("raw/" + partition + "/*.json").Version("v2")
+//		},
+//		Run: func(ctx context.Context, partition string, out *granular.WriteBuilder) error {
+//			rows, err := aggregate(ctx, "raw/"+partition)
+//			if err != nil {
+//				return err
+//			}
+//			out.Bytes("rows", rows)
+//			return nil
+//		},
+//	})
+//	partitions := dataset.Dates(from, to) // date=2024-01-01, date=2024-01-02, ...
+//	stale, err := ds.Stale(partitions...)
+//	report, err := ds.Run(ctx, partitions...)
+//
+// Partitions are plain strings; the Hive-style name=value form keeps them
+// readable in reports and metadata, but any non-empty string works.
+//
+// Partitions run concurrently, so Run functions must not share
+// unsynchronized state; use WithWorkers(1) to run one partition at a time.
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gophersatwork/granular"
+)
+
+// Spec describes how to compute one partition of a Dataset.
+type Spec struct {
+	// Name identifies the dataset. It is part of every partition's key, so
+	// datasets sharing a cache do not see each other's partitions.
+	Name string
+
+	// Inputs adds the inputs of a partition to its key: the files it reads,
+	// configuration, and a version to bump when Run changes. The partition
+	// itself is always part of the key. It may be nil.
+	Inputs func(kb *granular.KeyBuilder, partition string)
+
+	// Run computes the outputs of a partition and adds them to out. Run is
+	// only called on a cache miss; if it returns an error, nothing is cached.
+	Run func(ctx context.Context, partition string, out *granular.WriteBuilder) error
+}
+
+// Status is the outcome of a partition in a run.
+type Status int
+
+const (
+	// Skipped partitions were not reached, because the run was canceled.
+	Skipped Status = iota
+	// Cached partitions were found in the cache and did not run.
+	Cached
+	// Ran partitions missed the cache, ran, and were stored.
+	Ran
+	// Failed partitions could not be looked up, run, or stored.
+	Failed
+)
+
+func (s Status) String() string {
+	switch s {
+	case Cached:
+		return "cached"
+	case Ran:
+		return "ran"
+	case Failed:
+		return "failed"
+	default:
+		return "skipped"
+	}
+}
+
+// PartitionReport describes what happened to one partition in a run.
+type PartitionReport struct {
+	Partition string
+	KeyHash   string // empty if the key could not be computed
+	Status    Status
+	Duration  time.Duration    // time spent looking up, running, and storing the partition
+	Err       error            // why the partition failed, if it did
+	Result    *granular.Result // the partition's outputs, if Cached or Ran
+}
+
+// Report describes a run of a dataset.
+type Report struct {
+	// Partitions lists the partitions of the run in the order they were
+	// given.
+	Partitions []PartitionReport
+}
+
+// Partition returns the report of the given partition, or nil if it was
+// not part of the run.
+func (r *Report) Partition(partition string) *PartitionReport {
+	for i := range r.Partitions {
+		if r.Partitions[i].Partition == partition {
+			return &r.Partitions[i]
+		}
+	}
+	return nil
+}
+
+// Dataset is a validated Spec bound to a cache.
+type Dataset struct {
+	cache   *granular.Cache
+	spec    Spec
+	workers int
+}
+
+// Option configures a Dataset.
+type Option func(*Dataset)
+
+// WithWorkers sets how many partitions may run at the same time. The
+// default is runtime.GOMAXPROCS(0); 1 runs partitions one after the other.
+// Values below 1 are treated as 1.
+//
+// Example:
+//
+//	ds, err := dataset.New(cache, spec, dataset.WithWorkers(4))
+func WithWorkers(n int) Option {
+	return func(d *Dataset) {
+		d.workers = max(n, 1)
+	}
+}
+
+// New returns a dataset computing partitions as described by spec. It fails
+// if spec has no name or no Run function.
+func New(cache *granular.Cache, spec Spec, opts ...Option) (*Dataset, error) {
+	if spec.Name == "" {
+		return nil, errors.New("dataset: empty name")
+	}
+	if spec.Run == nil {
+		return nil, fmt.Errorf("dataset: %q has no Run function", spec.Name)
+	}
+	d := &Dataset{
+		cache:   cache,
+		spec:    spec,
+		workers: runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d, nil
+}
+
+// Key returns the cache key of a partition.
+func (d *Dataset) Key(partition string) granular.Key {
+	kb := d.cache.Key().
+		String("dataset.name", d.spec.Name).
+		String("dataset.partition", partition)
+	if d.spec.Inputs != nil {
+		d.spec.Inputs(kb, partition)
+	}
+	return kb.Build()
+}
+
+// Get returns the cached outputs of a partition. It returns
+// granular.ErrCacheMiss if the partition is stale.
+func (d *Dataset) Get(partition string) (*granular.Result, error) {
+	if err := validatePartition(partition); err != nil {
+		return nil, err
+	}
+	return d.cache.Get(d.Key(partition))
+}
+
+// Stale returns the partitions that are not in the cache under their
+// current key, in the order they were given: the partitions a Run would
+// compute. Like granular.Cache.Has, it only checks that entries exist.
+//
+// It fails if a partition is invalid or its key cannot be computed, for
+// example because an input file is missing.
+func (d *Dataset) Stale(partitions ...string) ([]string, error) {
+	if err := validatePartitions(partitions); err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, p := range partitions {
+		key := d.Key(p)
+		if _, err := key.HashErr(); err != nil {
+			return nil, fmt.Errorf("partition %s: %w", p, err)
+		}
+		if !d.cache.Has(key) {
+			stale = append(stale, p)
+		}
+	}
+	return stale, nil
+}
+
+// Run brings the given partitions up to date. Each partition is looked up
+// in the cache under its own key and computed only on a miss, up to the
+// limit set by WithWorkers at a time.
+//
+// Partitions do not depend on each other, so a failed partition does not
+// stop the others. When ctx is canceled, partitions not yet started are
+// skipped. The returned error joins the errors of all failed partitions;
+// the report is returned either way and tells which partitions were
+// cached, ran, failed, or were skipped.
+func (d *Dataset) Run(ctx context.Context, partitions ...string) (*Report, error) {
+	if err := validatePartitions(partitions); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Partitions: make([]PartitionReport, len(partitions))}
+	for i, p := range partitions {
+		report.Partitions[i].Partition = p
+	}
+
+	// Each goroutine only writes the report of its own partition
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.workers)
+	var ctxErr error
+	for i := range report.Partitions {
+		sem <- struct{}{}
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			d.runPartition(ctx, &report.Partitions[i])
+		})
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, pr := range report.Partitions {
+		if pr.Status == Failed {
+			errs = append(errs, fmt.Errorf("partition %s: %w", pr.Partition, pr.Err))
+		}
+	}
+	if ctxErr != nil {
+		errs = append(errs, ctxErr)
+	}
+	return report, errors.Join(errs...)
+}
+
+// runPartition looks up a partition and runs it on a miss, filling in pr.
+func (d *Dataset) runPartition(ctx context.Context, pr *PartitionReport) {
+	start := time.Now()
+	defer func() { pr.Duration = time.Since(start) }()
+	fail := func(err error) {
+		pr.Status, pr.Err = Failed, err
+	}
+
+	key := d.Key(pr.Partition)
+	keyHash, err := key.HashErr()
+	if err != nil {
+		fail(err)
+		return
+	}
+	pr.KeyHash = keyHash
+
+	result, err := d.cache.Get(key)
+	if err == nil {
+		pr.Status, pr.Result = Cached, result
+		return
+	}
+	if !errors.Is(err, granular.ErrCacheMiss) {
+		fail(err)
+		return
+	}
+
+	out := d.cache.Put(key).
+		Meta("dataset.name", d.spec.Name).
+		Meta("dataset.partition", pr.Partition)
+	if err := d.spec.Run(ctx, pr.Partition, out); err != nil {
+		fail(err)
+		return
+	}
+	if err := out.Commit(); err != nil {
+		fail(fmt.Errorf("failed to store outputs: %w", err))
+		return
+	}
+	if pr.Result, err = d.cache.Get(key); err != nil {
+		fail(fmt.Errorf("failed to read stored outputs: %w", err))
+		return
+	}
+	pr.Status = Ran
+}
+
+// Dates returns the daily partitions date=YYYY-MM-DD from from to to,
+// both included. It returns nil if to is before from.
+func Dates(from, to time.Time) []string {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	var partitions []string
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		partitions = append(partitions, "date="+day.Format(time.DateOnly))
+	}
+	return partitions
+}
+
+// validatePartitions checks that partitions are valid and not repeated.
+func validatePartitions(partitions []string) error {
+	seen := make(map[string]bool, len(partitions))
+	for _, p := range partitions {
+		if err := validatePartition(p); err != nil {
+			return err
+		}
+		if seen[p] {
+			return fmt.Errorf("dataset: duplicate partition %q", p)
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+func validatePartition(partition string) error {
+	if partition == "" {
+		return errors.New("dataset: empty partition")
+	}
+	return nil
+}
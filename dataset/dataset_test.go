@@ -0,0 +1,195 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophersatwork/granular"
+)
+
+// events builds a dataset whose partitions output their partition and the
+// version of that partition in versions. Every run is recorded in runs.
+func events(t *testing.T, cache *granular.Cache, versions map[string]string, runs *[]string, opts ...Option) *Dataset {
+	t.Helper()
+	var mu sync.Mutex
+	ds, err := New(cache, Spec{
+		Name: "events",
+		Inputs: func(kb *granular.KeyBuilder, partition string) {
+			mu.Lock()
+			defer mu.Unlock()
+			kb.Version(versions[partition])
+		},
+		Run: func(_ context.Context, partition string, out *granular.WriteBuilder) error {
+			mu.Lock()
+			*runs = append(*runs, partition)
+			mu.Unlock()
+			out.Bytes("rows", []byte("rows of "+partition))
+			return nil
+		},
+	}, opts...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return ds
+}
+
+func statuses(r *Report) string {
+	var parts []string
+	for _, p := range r.Partitions {
+		parts = append(parts, p.Partition+"="+p.Status.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestRun(t *testing.T) {
+	cache := granular.OpenTemp()
+	versions := map[string]string{}
+	var runs []string
+	ds := events(t, cache, versions, &runs)
+	partitions := Dates(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+
+	check := func(want string, wantRuns ...string) *Report {
+		t.Helper()
+		runs = nil
+		report, err := ds.Run(t.Context(), partitions...)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if got := statuses(report); got != want {
+			t.Errorf("statuses = %s, want %s", got, want)
+		}
+		slices.Sort(runs)
+		if strings.Join(runs, ",") != strings.Join(wantRuns, ",") {
+			t.Errorf("ran %v, want %v", runs, wantRuns)
+		}
+		return report
+	}
+
+	report := check("date=2024-01-01=ran date=2024-01-02=ran date=2024-01-03=ran",
+		"date=2024-01-01", "date=2024-01-02", "date=2024-01-03")
+	if got := string(report.Partition("date=2024-01-02").Result.Bytes("rows")); got != "rows of date=2024-01-02" {
+		t.Errorf("rows = %q", got)
+	}
+	if got := report.Partition("date=2024-01-02").Result.Meta("dataset.partition"); got != "date=2024-01-02" {
+		t.Errorf("partition metadata = %q", got)
+	}
+	if report.Partition("date=2024-01-04") != nil {
+		t.Error("Partition returned a report for a partition outside the run")
+	}
+	check("date=2024-01-01=cached date=2024-01-02=cached date=2024-01-03=cached")
+
+	// Changing the inputs of one partition reruns only that partition
+	versions["date=2024-01-02"] = "v2"
+	check("date=2024-01-01=cached date=2024-01-02=ran date=2024-01-03=cached", "date=2024-01-02")
+
+	if _, err := ds.Run(t.Context(), "date=2024-01-01", "date=2024-01-01"); err == nil {
+		t.Error("Run with duplicate partitions succeeded")
+	}
+	if _, err := ds.Run(t.Context(), ""); err == nil {
+		t.Error("Run with an empty partition succeeded")
+	}
+}
+
+func TestStale(t *testing.T) {
+	cache := granular.OpenTemp()
+	versions := map[string]string{}
+	var runs []string
+	ds := events(t, cache, versions, &runs)
+
+	if _, err := ds.Run(t.Context(), "date=2024-01-01", "date=2024-01-02"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	versions["date=2024-01-01"] = "v2"
+
+	stale, err := ds.Stale("date=2024-01-01", "date=2024-01-02", "date=2024-01-03")
+	if err != nil {
+		t.Fatalf("Stale failed: %v", err)
+	}
+	if want := []string{"date=2024-01-01", "date=2024-01-03"}; !slices.Equal(stale, want) {
+		t.Errorf("Stale = %v, want %v", stale, want)
+	}
+
+	if _, err := ds.Get("date=2024-01-02"); err != nil {
+		t.Errorf("Get of a fresh partition failed: %v", err)
+	}
+	if _, err := ds.Get("date=2024-01-01"); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Get of a stale partition = %v, want ErrCacheMiss", err)
+	}
+
+	// Datasets sharing a cache do not share partitions
+	other, err := New(cache, Spec{Name: "other", Run: func(context.Context, string, *granular.WriteBuilder) error { return nil }})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if stale, _ := other.Stale("date=2024-01-02"); len(stale) != 1 {
+		t.Errorf("other dataset Stale = %v", stale)
+	}
+}
+
+func TestRun_Failure(t *testing.T) {
+	cache := granular.OpenTemp()
+	boom := errors.New("boom")
+	ds, err := New(cache, Spec{
+		Name: "flaky",
+		Run: func(_ context.Context, partition string, out *granular.WriteBuilder) error {
+			if partition == "b" {
+				return boom
+			}
+			out.Bytes("out", []byte(partition))
+			return nil
+		},
+	}, WithWorkers(1))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// A failed partition does not stop the others and is not cached
+	report, err := ds.Run(t.Context(), "a", "b", "c")
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), "partition b") {
+		t.Errorf("Run error = %v", err)
+	}
+	if got := statuses(report); got != "a=ran b=failed c=ran" {
+		t.Errorf("statuses = %s", got)
+	}
+	if stale, _ := ds.Stale("a", "b", "c"); !slices.Equal(stale, []string{"b"}) {
+		t.Errorf("Stale = %v", stale)
+	}
+
+	// Partitions not started before cancellation are skipped
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	report, err = ds.Run(ctx, "d")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled Run error = %v", err)
+	}
+	if got := statuses(report); got != "d=skipped" {
+		t.Errorf("statuses = %s", got)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	run := func(context.Context, string, *granular.WriteBuilder) error { return nil }
+	if _, err := New(granular.OpenTemp(), Spec{Run: run}); err == nil {
+		t.Error("New without a name succeeded")
+	}
+	if _, err := New(granular.OpenTemp(), Spec{Name: "x"}); err == nil {
+		t.Error("New without a Run function succeeded")
+	}
+}
+
+func TestDates(t *testing.T) {
+	from := time.Date(2024, 2, 28, 15, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC)
+	want := []string{"date=2024-02-28", "date=2024-02-29", "date=2024-03-01"}
+	if got := Dates(from, to); !slices.Equal(got, want) {
+		t.Errorf("Dates = %v, want %v", got, want)
+	}
+	if got := Dates(to, from); got != nil {
+		t.Errorf("Dates of an empty range = %v", got)
+	}
+}
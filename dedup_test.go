@@ -0,0 +1,154 @@
+package granular
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/gophersatwork/granular/internal/format"
+	"github.com/spf13/afero"
+)
+
+// blobCount returns the number of shared blobs stored in c.
+func blobCount(t *testing.T, c *Cache) int {
+	t.Helper()
+	var n int
+	_ = afero.Walk(c.fs, c.blobsDir(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// TestWithDedup tests that identical byte data is stored once within and
+// across entries, and that GC removes blobs only once no entry uses them.
+func TestWithDedup(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open("/cache", WithFs(fs), WithDedup(), WithCompression(CompressionZstd))
+	assertNoError(t, err, "Open")
+	defer cache.Close()
+
+	schema := bytes.Repeat([]byte("message Event { string id = 1; }\n"), 1000)
+	var keys []Key
+	for _, stage := range []string{"parse", "validate", "load"} {
+		key := cache.Key().String("stage", stage).Build()
+		err := cache.Put(key).
+			Bytes("schema", schema).
+			BytesFrom("schema-copy", bytes.NewReader(schema)).
+			Bytes("log", []byte(stage+" ok")).
+			Commit()
+		assertNoError(t, err, "Commit "+stage)
+		keys = append(keys, key)
+	}
+	if n := blobCount(t, cache); n != 4 {
+		t.Errorf("%d blobs stored, want 4 (one schema, three logs)", n)
+	}
+
+	for _, key := range keys {
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, "Get")
+		for _, name := range []string{"schema", "schema-copy"} {
+			if !bytes.Equal(result.Bytes(name), schema) {
+				t.Errorf("%s does not round-trip", name)
+			}
+		}
+	}
+	report, err := cache.Verify()
+	assertNoError(t, err, "Verify")
+	if !report.OK() {
+		t.Errorf("Verify: %+v", report)
+	}
+
+	// Shared blobs outlive the entries they were written for
+	assertNoError(t, cache.Delete(keys[0]), "Delete")
+	assertNoError(t, cache.Delete(keys[1]), "Delete")
+	if removed, _, err := cache.GC(); err != nil || removed != 2 {
+		t.Errorf("GC = %d, %v; want the 2 logs removed", removed, err)
+	}
+	result, err := cache.Get(keys[2])
+	assertCacheHit(t, result, err, "Get after GC")
+	if !bytes.Equal(result.Bytes("schema"), schema) {
+		t.Error("schema lost by GC while still referenced")
+	}
+	assertNoError(t, cache.Delete(keys[2]), "Delete")
+	if _, _, err := cache.GC(); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if n := blobCount(t, cache); n != 0 {
+		t.Errorf("%d blobs left after every entry was removed", n)
+	}
+}
+
+// TestWithDedupTransfer tests exporting an entry with shared blobs to
+// another cache.
+func TestWithDedupTransfer(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	src, err := Open("/src", WithFs(fs), WithDedup())
+	assertNoError(t, err, "Open src")
+	dst, err := Open("/dst", WithFs(fs))
+	assertNoError(t, err, "Open dst")
+
+	key := src.Key().String("stage", "load").Build()
+	assertNoError(t, src.Put(key).Bytes("a", []byte("same")).Bytes("b", []byte("same")).Commit(), "Commit")
+
+	data, err := src.ExportManifest(key.Hash())
+	assertNoError(t, err, "ExportManifest")
+	m, err := format.Unmarshal(data)
+	assertNoError(t, err, "parse manifest")
+	objects := slices.Compact(slices.Sorted(maps.Values(m.OutputData)))
+	if len(objects) != 1 {
+		t.Fatalf("exported objects = %v, want one shared blob", objects)
+	}
+	f, err := src.OpenObject(key.Hash(), objects[0])
+	assertNoError(t, err, "OpenObject")
+	err = dst.StageObject(key.Hash(), objects[0], f)
+	_ = f.Close()
+	assertNoError(t, err, "StageObject")
+	assertNoError(t, dst.ImportManifest(key.Hash(), data), "ImportManifest")
+
+	result, err := dst.Get(dst.Key().String("stage", "load").Build())
+	assertCacheHit(t, result, err, "Get imported")
+	assertEqual(t, string(result.Bytes("a"))+string(result.Bytes("b")), "samesame", "imported data")
+
+	// Merging copies the blob into the entry
+	merged, err := Open("/merged", WithFs(fs))
+	assertNoError(t, err, "Open merged")
+	if added, err := merged.Merge(src, ConflictSkip); err != nil || added != 1 {
+		t.Fatalf("Merge = %d, %v", added, err)
+	}
+	result, err = merged.Get(merged.Key().String("stage", "load").Build())
+	assertCacheHit(t, result, err, "Get merged")
+	assertEqual(t, string(result.Bytes("b")), "same", "merged data")
+}
+
+// TestWithDedupCompressionChange tests that a cache reopened with another
+// compression does not reuse blobs stored with the previous one.
+func TestWithDedupCompressionChange(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	payload := bytes.Repeat([]byte("generated code\n"), 100)
+	compressions := []CompressionType{CompressionGzip, CompressionNone, CompressionZstd}
+	for i, compression := range compressions {
+		cache, err := Open("/cache", WithFs(fs), WithDedup(), WithCompression(compression))
+		assertNoError(t, err, "Open")
+		key := cache.Key().String("commit", string(rune('a'+i))).Build()
+		assertNoError(t, cache.Put(key).Bytes("out", payload).Commit(), "Commit")
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, "Get with compression "+string(compression))
+		data, err := result.BytesErr("out")
+		assertNoError(t, err, "BytesErr")
+		if !bytes.Equal(data, payload) {
+			t.Errorf("data committed with compression %q does not round-trip", compression)
+		}
+		assertNoError(t, cache.Close(), "Close")
+	}
+	cache, err := Open("/cache", WithFs(fs), WithDedup())
+	assertNoError(t, err, "Open")
+	defer cache.Close()
+	if n := blobCount(t, cache); n != len(compressions) {
+		t.Errorf("%d blobs stored, want one per compression (%d)", n, len(compressions))
+	}
+}
@@ -0,0 +1,70 @@
+package granular
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// EntryInfo describes everything recorded about a cache entry: the summary
+// reported by Entries plus the key inputs and output names from its manifest.
+type EntryInfo struct {
+	Entry
+
+	HashAlgo    string            // Hash algorithm the key was built with
+	Inputs      []string          // Descriptions of the key inputs, in key order (e.g. "file:main.go")
+	Extras      map[string]string // Key components added with String, Version, and Env
+	Files       []string          // Names of cached files, sorted
+	Data        []string          // Names of cached byte data, sorted
+	OutputHash  string            // Hash of the outputs, checked by Get
+	Compression CompressionType   // Compression applied to cached data
+}
+
+// Describe returns the full description of the entry with the given key hash,
+// as reported by Key.Hash or Entry.KeyHash. It does not verify the entry or
+// update its access time.
+//
+// Returns ErrCacheMiss if there is no such entry (or it belongs to another
+// namespace), and an error wrapping ErrCacheCorrupted if its manifest cannot
+// be parsed.
+func (c *Cache) Describe(keyHash string) (*EntryInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, err := c.manifestPath(keyHash); err != nil {
+		return nil, err
+	}
+
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	m, err := c.loadManifest(keyHash)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+	}
+	if !inNamespace(c.namespace, m.Namespace) {
+		return nil, ErrCacheMiss
+	}
+
+	// Legacy manifests without HashAlgo were written with the default
+	hashAlgo := m.HashAlgo
+	if hashAlgo == "" {
+		hashAlgo = DefaultHashAlgoName
+	}
+
+	return &EntryInfo{
+		Entry:       c.newEntry(keyHash, m),
+		HashAlgo:    hashAlgo,
+		Inputs:      slices.Clone(m.InputDescs),
+		Extras:      maps.Clone(m.ExtraData),
+		Files:       slices.Sorted(maps.Keys(m.OutputFiles)),
+		Data:        slices.Sorted(maps.Keys(m.OutputData)),
+		OutputHash:  m.OutputHash,
+		Compression: CompressionType(m.Compression),
+	}, nil
+}
@@ -0,0 +1,117 @@
+package granular
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-describe-test")
+
+	input := filepath.Join(tempDir, "main.go")
+	createTestFile(t, memFs, input, []byte("package main"))
+	output := filepath.Join(tempDir, "app")
+	createTestFile(t, memFs, output, []byte("binary"))
+
+	key := cache.Key().File(input).Version("1.2.3").Build()
+	err := cache.Put(key).
+		File("binary", output).
+		Bytes("log", []byte("ok")).
+		Bytes("stats", []byte("{}")).
+		Meta("compiler", "go").
+		Tag("team", "infra").
+		Describe("go build ./cmd/app").
+		Commit()
+	assertNoError(t, err, "Commit")
+
+	info, err := cache.Describe(key.Hash())
+	assertNoError(t, err, "Describe")
+
+	assertEqual(t, info.KeyHash, key.Hash(), "KeyHash")
+	assertEqual(t, info.HashAlgo, DefaultHashAlgoName, "HashAlgo")
+	if !slices.Equal(info.Inputs, []string{"file:" + input}) {
+		t.Errorf("Inputs = %v", info.Inputs)
+	}
+	if info.Extras["version"] != "1.2.3" {
+		t.Errorf("Extras = %v", info.Extras)
+	}
+	if !slices.Equal(info.Files, []string{"binary"}) {
+		t.Errorf("Files = %v", info.Files)
+	}
+	if !slices.Equal(info.Data, []string{"log", "stats"}) {
+		t.Errorf("Data = %v", info.Data)
+	}
+	assertEqual(t, info.Meta["compiler"], "go", "Meta")
+	assertEqual(t, info.Tags["team"], "infra", "Tags")
+	assertEqual(t, info.Description, "go build ./cmd/app", "Description")
+	if info.FileCount != 3 {
+		t.Errorf("FileCount = %d, want 3", info.FileCount)
+	}
+	if info.OutputHash == "" {
+		t.Error("OutputHash is empty")
+	}
+}
+
+// TestResultInputs tests that Get reports the inputs recorded for an entry.
+func TestResultInputs(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-result-inputs-test")
+
+	input := filepath.Join(tempDir, "go.sum")
+	createTestFile(t, memFs, input, []byte("deps"))
+	key := cache.Key().File(input).Glob(filepath.Join(tempDir, "*.go")).Version("2").Build()
+	assertNoError(t, cache.Put(key).Bytes("app", []byte("binary")).Commit(), "Commit")
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	want := []string{"file:" + input, "glob:" + filepath.Join(tempDir, "*.go")}
+	if !slices.Equal(result.Inputs(), want) {
+		t.Errorf("Inputs = %v, want %v", result.Inputs(), want)
+	}
+	assertEqual(t, result.Extras()["version"], "2", "Extras")
+
+	// The returned values are copies
+	result.Inputs()[0] = "changed"
+	result.Extras()["version"] = "changed"
+	assertEqual(t, result.Inputs()[0], want[0], "Inputs after modifying a copy")
+	assertEqual(t, result.Extras()["version"], "2", "Extras after modifying a copy")
+}
+
+// TestWriteBuilderDescribe tests that an entry's description is returned by
+// Get and Entries, with and without the index.
+func TestWriteBuilderDescribe(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithIndex()}} {
+		cache, _ := setupClockCache(t, options...)
+		key := cache.Key().String("proto", "schema.proto").Build()
+		err := cache.Put(key).Bytes("api", []byte("package api")).Describe("protoc gen for schema.proto").Commit()
+		assertNoError(t, err, "Commit")
+		plain := cache.Key().String("proto", "other.proto").Build()
+		assertNoError(t, cache.Put(plain).Bytes("api", nil).Commit(), "Commit")
+
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, "Get")
+		assertEqual(t, result.Description(), "protoc gen for schema.proto", "Result.Description")
+
+		entries, err := cache.Entries()
+		assertNoError(t, err, "Entries")
+		for _, entry := range entries {
+			want := ""
+			if entry.KeyHash == key.Hash() {
+				want = "protoc gen for schema.proto"
+			}
+			assertEqual(t, entry.Description, want, "Entry.Description")
+		}
+	}
+}
+
+func TestDescribe_Missing(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-describe-missing-test")
+
+	if _, err := cache.Describe("0123456789abcdef"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Expected ErrCacheMiss, got %v", err)
+	}
+	if _, err := cache.Describe("a"); !errors.Is(err, ErrInvalidKeyHash) {
+		t.Errorf("Expected ErrInvalidKeyHash, got %v", err)
+	}
+}
@@ -222,6 +222,11 @@ Fail-fast vs accumulate-all-errors:
 The cache uses the following directory structure:
 
 	.cache/
+	├── history/ (previous results, with WithHistory)
+	│   └── ab/
+	│       └── abcd1234.../
+	│           └── 1/ (one directory per generation)
+	├── index.json (entry index, with WithIndex)
 	├── manifests/
 	│   └── ab/
 	│       └── abcd1234....json (cache metadata)
@@ -231,9 +236,26 @@ The cache uses the following directory structure:
 	            ├── file.output.txt (cached files)
 	            └── data.result.dat (cached byte data)
 
+# API Stability
+
+The public API consists of the exported identifiers of this package and of
+the hashing subpackage. Within a major version they follow semantic
+versioning: exported functions, methods, types, and option constructors are
+not removed or changed incompatibly, and key hashes for an unchanged set of
+inputs and options stay the same. Hashing changes that would alter existing
+keys, such as reading overlapping inputs once (WithSkipRepeatedFiles), are
+therefore opt-in options.
+
+Everything under internal/ (the manifest representation, pooled I/O
+buffers) is an implementation detail and may change in any release. The
+on-disk manifest format is versioned separately; entries written by an older
+format version remain readable.
+
 # Performance Considerations
 
-  - xxHash64: Fast, non-cryptographic hash by default
+  - xxHash64: Fast, non-cryptographic hash by default; WithSHA256 or
+    WithHashAlgo(Blake3) for collision resistance when inputs may be
+    adversarial, BLAKE3 hashing large inputs on all cores
   - Buffer Pooling: Reuses buffers to reduce GC pressure
   - Two-Level Sharding: Efficient filesystem operations
   - No Global State: Fully concurrent-safe
@@ -244,7 +266,7 @@ Build system caching:
 
 	key := cache.Key().
 	    Glob("*.go").
-	    String("GOOS", runtime.GOOS).
+	    Platform(). // GOOS and GOARCH
 	    Build()
 
 Code generation:
@@ -16,14 +16,31 @@ var (
 	ErrCacheCorrupted = errors.New("cache entry corrupted")
 
 	// ErrHashAlgoMismatch is returned when a cache entry was created with a different
-	// hash algorithm than the one currently configured. The entry is treated as a miss
-	// since the key hash would be different.
+	// hash algorithm than the one currently configured. Open returns it for a cache
+	// whose entries were made with a different algorithm, and Get for a single
+	// entry whose manifest records one.
 	ErrHashAlgoMismatch = errors.New("hash algorithm mismatch")
 
 	// ErrCompressionMismatch indicates a cache entry was created with a different
 	// compression type than the one currently configured. Get() auto-evicts such
 	// entries and returns ErrCacheMiss so callers can recompute transparently.
 	ErrCompressionMismatch = errors.New("compression type mismatch")
+
+	// ErrNotCache is returned by OpenExisting when the directory does not
+	// hold a granular cache.
+	ErrNotCache = errors.New("not a granular cache")
+
+	// ErrLockTimeout is returned by LockKey and GetOrLock when another
+	// process holds the key's lock for longer than WithLockTimeout allows.
+	ErrLockTimeout = errors.New("timed out waiting for key lock")
+
+	// ErrManifestTooLarge is returned by Commit when the values an entry
+	// stores in its manifest exceed the limit set with WithMaxInlineSize.
+	ErrManifestTooLarge = errors.New("manifest too large")
+
+	// ErrEntryExists is returned by Commit under ConflictError, and by Merge
+	// with ConflictError, when the cache already has an entry for the key.
+	ErrEntryExists = errors.New("cache entry already exists")
 )
 
 // ValidationError represents one or more validation errors that occurred
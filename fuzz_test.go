@@ -338,10 +338,13 @@ func FuzzManifestOutputHash(f *testing.F) {
 		afero.WriteFile(fs, file1, data1, 0o644)
 		afero.WriteFile(fs, file2, data2, 0o644)
 
+		afero.WriteFile(fs, "data1.dat", data1, 0o644)
+		afero.WriteFile(fs, "data2.dat", data2, 0o644)
+
 		outputs := []string{file1, file2}
-		outputData := map[string][]byte{
-			"data1": data1,
-			"data2": data2,
+		outputData := map[string]string{
+			"data1": "data1.dat",
+			"data2": "data2.dat",
 		}
 		outputMeta := map[string]string{
 			"meta1": "value1",
@@ -3,9 +3,11 @@ package granular
 import (
 	"errors"
 	"slices"
+	"strings"
 	"sync/atomic"
 	"testing"
 
+	"github.com/gophersatwork/granular/hashing"
 	"github.com/spf13/afero"
 )
 
@@ -237,6 +239,30 @@ func TestExpandGlob_EdgeCases(t *testing.T) {
 	}
 }
 
+// TestExpandGlob_RelativeOnOsFs tests patterns without a directory against
+// the working directory of the OS filesystem, which cannot walk "".
+func TestExpandGlob_RelativeOnOsFs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fs := afero.NewOsFs()
+	createTestFile(t, fs, "go.mod", []byte("module x"))
+	createTestFile(t, fs, "api.proto", []byte("syntax"))
+	createTestFile(t, fs, "sub/types.proto", []byte("syntax"))
+
+	for pattern, want := range map[string][]string{
+		"go.mod":     {"go.mod"},
+		"*.proto":    {"api.proto"},
+		"**/*.proto": {"api.proto", "sub/types.proto"},
+	} {
+		matches, err := expandGlob(pattern, fs)
+		if err != nil {
+			t.Fatalf("expandGlob(%q) failed: %v", pattern, err)
+		}
+		if !slices.Equal(matches, want) {
+			t.Errorf("expandGlob(%q) = %v, want %v", pattern, matches, want)
+		}
+	}
+}
+
 // TestMatchesGlobPattern tests the matchesGlobPattern function directly
 func TestMatchesGlobPattern(t *testing.T) {
 	tests := []struct {
@@ -579,9 +605,11 @@ func TestGlobExcludeInDir(t *testing.T) {
 }
 
 // openCountingFs tracks Open calls for directories to count walks.
+// Directories under skipPrefix, such as the cache root, are not counted.
 type openCountingFs struct {
 	afero.Fs
 	openDirCount atomic.Int64
+	skipPrefix   string
 }
 
 func (o *openCountingFs) Open(name string) (afero.File, error) {
@@ -594,7 +622,7 @@ func (o *openCountingFs) Open(name string) (afero.File, error) {
 	if err != nil {
 		return file, nil
 	}
-	if stat.IsDir() {
+	if stat.IsDir() && (o.skipPrefix == "" || !strings.HasPrefix(name, o.skipPrefix)) {
 		o.openDirCount.Add(1)
 	}
 	return file, nil
@@ -699,6 +727,39 @@ func TestGlobCachingMultiplePatterns(t *testing.T) {
 	}
 }
 
+// TestInputsExpandedOnce verifies that Glob and Dir inputs walk the tree at
+// most once across a Get-miss, Commit, Has, Get sequence, including globs
+// without matches.
+func TestInputsExpandedOnce(t *testing.T) {
+	countingFs := &openCountingFs{Fs: setupGlobTestFs(t), skipPrefix: "/cache/"}
+	cache, err := Open("/cache", WithFs(countingFs))
+	assertNoError(t, err, "Open")
+
+	// Opens caused by one walk of the Dir input
+	before := countingFs.openDirCount.Load()
+	if _, err := hashing.DirFiles(countingFs, "src"); err != nil {
+		t.Fatalf("DirFiles failed: %v", err)
+	}
+	oneWalk := countingFs.openDirCount.Load() - before
+
+	key := cache.Key().Glob("src/**/*.go").Glob("src/**/*.rs").Dir("src").Build()
+	countingFs.openDirCount.Store(0)
+
+	if _, err := cache.Get(key); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Get = %v, want ErrCacheMiss", err)
+	}
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("x")).Commit(), "Commit")
+	if !cache.Has(key) {
+		t.Fatal("Has = false after Commit")
+	}
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+
+	if got := countingFs.openDirCount.Load(); got != oneWalk {
+		t.Errorf("directories opened %d times after Build, want %d (one Dir walk)", got, oneWalk)
+	}
+}
+
 // TestGlobCachingWithError verifies that errors during glob expansion are handled correctly.
 func TestGlobCachingWithError(t *testing.T) {
 	fs := afero.NewMemMapFs()
@@ -733,7 +794,8 @@ func TestGlobInputString(t *testing.T) {
 	}
 }
 
-// TestGlobInputHashFallback verifies the fallback path in hash() when matches is nil.
+// TestGlobInputHashFallback verifies the fallback path in hash() when the
+// glob was not expanded by KeyBuilder.Glob.
 func TestGlobInputHashFallback(t *testing.T) {
 	fs := setupGlobTestFs(t)
 
@@ -751,7 +813,7 @@ func TestGlobInputHashFallback(t *testing.T) {
 	}()
 
 	h := cache.newHash()
-	err = g.hash(h, fs)
+	err = g.hash(h, cache.hasher(), fs)
 	if err != nil {
 		t.Fatalf("hash failed: %v", err)
 	}
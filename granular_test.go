@@ -2,11 +2,20 @@ package granular
 
 import (
 	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/spf13/afero"
@@ -169,6 +178,70 @@ func TestBytesInput(t *testing.T) {
 	assertCacheMiss(t, result, err, "Get after bytes modification")
 }
 
+// TestWriteBuilderBytesStreaming tests storing byte data without copying it
+// in memory: BytesNoCopy, BytesFrom, and large Bytes spilled to disk.
+func TestWriteBuilderBytesStreaming(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-bytes-streaming-test")
+	large := bytes.Repeat([]byte("0123456789abcdef"), spillThreshold/16+1)
+	noCopy := []byte("not copied")
+
+	key := cache.Key().String("stage", "render").Build()
+	wb := cache.Put(key).
+		Bytes("large", large).
+		BytesNoCopy("small", noCopy).
+		Bytes("replaced", []byte("old")).
+		BytesFrom("replaced", strings.NewReader("streamed")).
+		BytesFrom("log", strings.NewReader("build ok"))
+	if n := len(wb.data); n != 1 {
+		t.Errorf("%d data held in memory, want 1", n)
+	}
+	assertNoError(t, wb.Commit(), "Commit")
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	if !bytes.Equal(result.Bytes("large"), large) {
+		t.Error("large data does not round-trip")
+	}
+	for name, want := range map[string]string{"small": "not copied", "replaced": "streamed", "log": "build ok"} {
+		assertEqual(t, string(result.Bytes(name)), want, name)
+	}
+	if entries, _ := afero.ReadDir(memFs, filepath.Join(tempDir, "tmp")); len(entries) != 0 {
+		t.Errorf("spilled data left behind: %d entries in tmp", len(entries))
+	}
+
+	// Read errors fail the commit
+	err = cache.Put(cache.Key().String("stage", "broken").Build()).
+		BytesFrom("log", iotest.ErrReader(errors.New("connection reset"))).
+		Commit()
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("BytesFrom with a failing reader: Commit = %v", err)
+	}
+}
+
+// TestWriteBuilderAbandonedSpill tests that a builder dropped without Commit
+// removes its spilled data once garbage collected.
+func TestWriteBuilderAbandonedSpill(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-abandoned-spill-test")
+	large := bytes.Repeat([]byte("0123456789abcdef"), spillThreshold/16+1)
+	func() {
+		_ = cache.Put(cache.Key().String("stage", "abandoned").Build()).Bytes("large", large)
+	}()
+	tmp := filepath.Join(tempDir, "tmp")
+	if entries, _ := afero.ReadDir(memFs, tmp); len(entries) != 1 {
+		t.Fatalf("%d entries in tmp after spilling, want 1", len(entries))
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		runtime.GC()
+		if entries, _ := afero.ReadDir(memFs, tmp); len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("spilled data of an abandoned builder left behind")
+		}
+	}
+}
+
 func TestDirectoryInput(t *testing.T) {
 	// Setup test cache and filesystem
 	cache, memFs, tempDir := setupTestCache(t, "granular-dir-test")
@@ -210,8 +283,12 @@ func TestDirectoryInput(t *testing.T) {
 	resultGet, err := cache.Get(key)
 	assertCacheHit(t, resultGet, err, "second Get with directory input")
 
-	// Modify a file that should be included
+	// Modify a file that should be included; keys hash their inputs once,
+	// so build a new key to pick up the change
 	createTestFile(t, memFs, filepath.Join(subDir, "important.txt"), []byte("modified"))
+	key = cache.Key().
+		Dir(testDir, "*.log").
+		Build()
 
 	// Get should be a miss after modification
 	result, err = cache.Get(key)
@@ -219,6 +296,9 @@ func TestDirectoryInput(t *testing.T) {
 
 	// Modify a file that should be excluded
 	createTestFile(t, memFs, filepath.Join(testDir, "file2.log"), []byte("new log content"))
+	key = cache.Key().
+		Dir(testDir, "*.log").
+		Build()
 
 	// Store in cache again with the modified excluded file
 	err = cache.Put(key).
@@ -279,6 +359,209 @@ func TestMultiFileStorage(t *testing.T) {
 	}
 }
 
+func TestWriteBuilderFiles(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-files-test")
+	gen := filepath.Join(tempDir, "gen")
+	for _, name := range []string{"api.pb.go", "client.pb.go", "server.pb.go"} {
+		createTestFile(t, memFs, filepath.Join(gen, name), []byte("package "+name))
+	}
+
+	key := cache.Key().String("stage", "codegen").Build()
+	err := cache.Put(key).
+		Files(map[string]string{"api": filepath.Join(gen, "api.pb.go")}).
+		FileList([]string{"client.pb.go", "server.pb.go"}, gen).
+		Commit()
+	assertNoError(t, err, "Commit")
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	for _, name := range []string{"api", "client.pb.go", "server.pb.go"} {
+		if !result.HasFile(name) {
+			t.Errorf("Expected file %q", name)
+		}
+	}
+
+	// Every bad file is reported, without WithAccumulateErrors
+	err = cache.Put(cache.Key().String("stage", "broken").Build()).
+		FileList([]string{"missing1.go", "api.pb.go", "missing2.go"}, gen).
+		Commit()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+	if len(verr.Errors) != 2 {
+		t.Fatalf("Expected 2 errors, got %d: %v", len(verr.Errors), verr.Errors)
+	}
+	for i, want := range []string{"missing1.go", "missing2.go"} {
+		if !strings.Contains(verr.Errors[i].Error(), want) {
+			t.Errorf("Error %d = %v, want it to name %s", i, verr.Errors[i], want)
+		}
+	}
+}
+
+func TestWriteBuilderGlob(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-glob-test")
+	dist := filepath.Join(tempDir, "dist")
+	files := map[string]string{
+		"index.html":       "<html>",
+		"js/app.js":        "app",
+		"js/vendor/lib.js": "lib",
+	}
+	for name, content := range files {
+		createTestFile(t, memFs, filepath.Join(dist, filepath.FromSlash(name)), []byte(content))
+	}
+	literal := filepath.Join(tempDir, "literal.js")
+	createTestFile(t, memFs, literal, []byte("literal"))
+
+	key := cache.Key().String("stage", "bundle").Build()
+	err := cache.Put(key).Glob(dist, filepath.Join(dist, "**")).
+		File("js%2Fapp.js", literal). // Must not collide with "js/app.js"
+		Commit()
+	assertNoError(t, err, "Commit")
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	files["js%2Fapp.js"] = "literal"
+	for name, want := range files {
+		got, err := afero.ReadFile(memFs, result.File(name))
+		assertNoError(t, err, "ReadFile "+name)
+		assertEqual(t, string(got), want, "content of "+name)
+	}
+
+	for _, tc := range []struct{ prefix, pattern, want string }{
+		{dist, filepath.Join(dist, "*.css"), "matched no files"},
+		{filepath.Join(dist, "js"), filepath.Join(dist, "**"), "outside"},
+	} {
+		err := cache.Put(cache.Key().String("pattern", tc.pattern).Build()).Glob(tc.prefix, tc.pattern).Commit()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Glob(%q, %q) error = %v, want %q", tc.prefix, tc.pattern, err, tc.want)
+		}
+	}
+}
+
+func TestTypedMeta(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-typed-meta-test")
+	built := time.Date(2025, 3, 14, 15, 9, 26, 535897932, time.FixedZone("CET", 3600))
+
+	key := cache.Key().String("stage", "test").Build()
+	err := cache.Put(key).
+		MetaInt("tests", -1342).
+		MetaBool("race", true).
+		MetaTime("built", built).
+		MetaDuration("elapsed", 90*time.Second).
+		Meta("count", "many").
+		Commit()
+	assertNoError(t, err, "Commit")
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	if n, err := result.MetaInt("tests"); err != nil || n != -1342 {
+		t.Errorf("MetaInt = %d, %v; want -1342", n, err)
+	}
+	if b, err := result.MetaBool("race"); err != nil || !b {
+		t.Errorf("MetaBool = %v, %v; want true", b, err)
+	}
+	if got, err := result.MetaTime("built"); err != nil || !got.Equal(built) {
+		t.Errorf("MetaTime = %v, %v; want %v", got, err, built)
+	}
+	if d, err := result.MetaDuration("elapsed"); err != nil || d != 90*time.Second {
+		t.Errorf("MetaDuration = %v, %v; want 1m30s", d, err)
+	}
+	assertEqual(t, result.Meta("elapsed"), "1m30s", "raw duration")
+
+	if _, err := result.MetaInt("count"); err == nil {
+		t.Error("MetaInt of a non-integer succeeded")
+	}
+	if _, err := result.MetaBool("missing"); err == nil || !strings.Contains(err.Error(), "not set") {
+		t.Errorf("MetaBool of a missing key = %v, want a not set error", err)
+	}
+
+	type toolchain struct {
+		Go   string
+		Tags []string
+	}
+	jsonKey := cache.Key().String("stage", "json").Build()
+	want := toolchain{Go: "1.24.1", Tags: []string{"netgo", "osusergo"}}
+	assertNoError(t, cache.Put(jsonKey).MetaJSON("toolchain", want).Commit(), "Commit JSON")
+	result, err = cache.Get(jsonKey)
+	assertCacheHit(t, result, err, "Get JSON")
+	var got toolchain
+	assertNoError(t, result.MetaJSON("toolchain", &got), "MetaJSON")
+	if got.Go != want.Go || !slices.Equal(got.Tags, want.Tags) {
+		t.Errorf("MetaJSON = %+v, want %+v", got, want)
+	}
+	var n int
+	if err := result.MetaJSON("toolchain", &n); err == nil {
+		t.Error("MetaJSON into a mismatched type succeeded")
+	}
+
+	// Values that cannot be encoded fail the commit
+	err = cache.Put(cache.Key().String("stage", "bad").Build()).MetaJSON("ch", make(chan int)).Commit()
+	if err == nil || !strings.Contains(err.Error(), "encode metadata") {
+		t.Errorf("MetaJSON(chan) error = %v", err)
+	}
+}
+
+// TestCommitKeepsUnchangedOutputs tests that recommitting a key leaves
+// cached files whose content did not change in place, and replaces the rest.
+func TestCommitKeepsUnchangedOutputs(t *testing.T) {
+	for _, ct := range []CompressionType{CompressionNone, CompressionZstd} {
+		t.Run(cmp.Or(string(ct), "none"), func(t *testing.T) {
+			memFs := afero.NewMemMapFs()
+			cache, err := Open("/cache", WithFs(memFs), WithCompression(ct))
+			assertNoError(t, err, "Open")
+			defer cache.Close()
+			createTestFile(t, memFs, "/out/app", []byte("binary"))
+			createTestFile(t, memFs, "/out/app.sym", []byte("symbols"))
+
+			key := cache.Key().String("stage", "link").Build()
+			commit := func() *Result {
+				t.Helper()
+				err := cache.Put(key).File("app", "/out/app").File("sym", "/out/app.sym").Commit()
+				assertNoError(t, err, "Commit")
+				result, err := cache.Get(key)
+				assertCacheHit(t, result, err, "Get")
+				return result
+			}
+			result := commit()
+
+			// Backdate the objects to tell whether they are rewritten
+			old := time.Now().Add(-time.Hour)
+			for _, name := range []string{"app", "sym"} {
+				assertNoError(t, memFs.Chtimes(result.File(name), old, old), "Chtimes")
+			}
+			createTestFile(t, memFs, "/out/app.sym", []byte("new symbols"))
+			result = commit()
+
+			info, err := memFs.Stat(result.File("app"))
+			assertNoError(t, err, "Stat")
+			if !info.ModTime().Equal(old) {
+				t.Error("unchanged output was copied again")
+			}
+			info, err = memFs.Stat(result.File("sym"))
+			assertNoError(t, err, "Stat")
+			if info.ModTime().Equal(old) {
+				t.Error("changed output was not copied")
+			}
+			for name, want := range map[string]string{"app": "binary", "sym": "new symbols"} {
+				rc, err := cache.fs.Open(result.File(name))
+				assertNoError(t, err, "Open "+name)
+				r, err := decompressReader(rc, ct)
+				assertNoError(t, err, "decompress "+name)
+				got, err := io.ReadAll(r)
+				assertNoError(t, err, "ReadAll "+name)
+				rc.Close()
+				assertEqual(t, string(got), want, name)
+			}
+			report, err := cache.Verify()
+			assertNoError(t, err, "Verify")
+			if !report.OK() {
+				t.Errorf("Verify after recommit: %+v", report)
+			}
+		})
+	}
+}
+
 func TestHasAndDelete(t *testing.T) {
 	cache, memFs, tempDir := setupTestCache(t, "granular-has-delete-test")
 
@@ -442,6 +725,44 @@ func assertEqual(t *testing.T, actual, expected, context string) {
 	}
 }
 
+// TestCacheStatsBreakdown tests that Stats, Entry, and Result.Size account
+// for files, data, and manifests separately.
+func TestCacheStatsBreakdown(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-stats-breakdown-test")
+
+	outputFile := filepath.Join(tempDir, "output.txt")
+	createTestFile(t, memFs, outputFile, []byte("0123456789"))
+	key := cache.Key().String("target", "app").Build()
+	err := cache.Put(key).
+		File("out", outputFile).
+		Bytes("log", []byte("twenty bytes of data")).
+		Commit()
+	assertNoError(t, err, "Put")
+
+	// Get rewrites the manifest's access time, so measure afterwards
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+
+	stats, err := cache.Stats()
+	assertNoError(t, err, "Stats")
+	if stats.FileSize != 10 || stats.DataSize != 20 || stats.ManifestSize == 0 {
+		t.Fatalf("Expected 10 file bytes, 20 data bytes, and a manifest, got %+v", stats)
+	}
+	if stats.TotalSize != stats.FileSize+stats.DataSize+stats.ManifestSize {
+		t.Fatalf("TotalSize %d is not the sum of its parts: %+v", stats.TotalSize, stats)
+	}
+
+	entries, err := cache.Entries()
+	assertNoError(t, err, "Entries")
+	if len(entries) != 1 || entries[0].Size != 30 || entries[0].ManifestSize != stats.ManifestSize {
+		t.Fatalf("Expected one entry of 30 object bytes, got %+v", entries)
+	}
+
+	if got := result.Size(); got != stats.TotalSize {
+		t.Fatalf("Result.Size() = %d, want %d", got, stats.TotalSize)
+	}
+}
+
 // TestCacheStats tests the Stats() method.
 func TestCacheStats(t *testing.T) {
 	cache, memFs, tempDir := setupTestCache(t, "granular-stats-test")
@@ -646,6 +967,42 @@ func TestCacheEntries(t *testing.T) {
 	}
 }
 
+// TestCacheEntriesIter tests that EntriesIter streams every entry without
+// blocking writers for the whole walk.
+func TestCacheEntriesIter(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-entries-iter-test")
+
+	want := make(map[string]bool)
+	for i := range 20 {
+		key := cache.Key().String("i", fmt.Sprint(i)).Build()
+		err := cache.Put(key).Bytes("data", []byte("x")).Commit()
+		assertNoError(t, err, "Put entry")
+		want[key.Hash()] = true
+	}
+
+	seen := make(map[string]bool)
+	for entry, err := range cache.EntriesIter() {
+		assertNoError(t, err, "EntriesIter")
+		if seen[entry.KeyHash] {
+			t.Fatalf("Entry %s yielded twice", entry.KeyHash)
+		}
+		seen[entry.KeyHash] = true
+
+		// A write-locking operation must not deadlock mid-iteration
+		_, err = cache.DeleteWhere(func(e Entry) bool { return e.KeyHash == entry.KeyHash })
+		assertNoError(t, err, "DeleteWhere during iteration")
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("Expected %d entries, got %d", len(want), len(seen))
+	}
+
+	entries, err := cache.Entries()
+	assertNoError(t, err, "Entries after deleting")
+	if len(entries) != 0 {
+		t.Fatalf("Expected no entries after deleting all, got %d", len(entries))
+	}
+}
+
 // TestResultCopyFile tests the Result.CopyFile() method.
 func TestResultCopyFile(t *testing.T) {
 	cache, memFs, tempDir := setupTestCache(t, "granular-copyfile-test")
@@ -780,6 +1137,111 @@ func TestKeyBuilderEnv(t *testing.T) {
 	}
 }
 
+// TestKeyBuilderRuntime tests that Platform and Runtime add the platform
+// and toolchain components to the key.
+func TestKeyBuilderRuntime(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-runtime-test")
+
+	_, extras := cache.Key().String("test", "data").Platform().Build().Describe()
+	assertEqual(t, extras["runtime:goos"], runtime.GOOS, "goos")
+	assertEqual(t, extras["runtime:goarch"], runtime.GOARCH, "goarch")
+	if _, ok := extras["runtime:go"]; ok {
+		t.Error("Platform added the Go version")
+	}
+
+	key := cache.Key().String("test", "data").Runtime().Build()
+	_, extras = key.Describe()
+	assertEqual(t, extras["runtime:go"], runtime.Version(), "go version")
+	assertEqual(t, extras["runtime:goos"], runtime.GOOS, "goos")
+
+	// The components match writing them out by hand
+	manual := cache.Key().String("test", "data").
+		String("runtime:goos", runtime.GOOS).
+		String("runtime:goarch", runtime.GOARCH).
+		String("runtime:go", runtime.Version()).
+		Build()
+	assertEqual(t, key.Hash(), manual.Hash(), "key hash")
+	if key.Hash() == cache.Key().String("test", "data").Build().Hash() {
+		t.Error("Runtime did not change the key")
+	}
+}
+
+// TestKeyBuilderFileIfExists tests that optional files hash their presence
+// and contents without failing validation when missing.
+func TestKeyBuilderFileIfExists(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-optional-test")
+	path := filepath.Join(tempDir, ".env.local")
+
+	hash := func() string {
+		t.Helper()
+		kb := cache.Key().String("app", "web").FileIfExists(path)
+		if errs := kb.Errors(); errs != nil {
+			t.Fatalf("FileIfExists recorded errors: %v", errs)
+		}
+		h, err := kb.HashErr()
+		assertNoError(t, err, "HashErr")
+		return h
+	}
+
+	absent := hash()
+	if again := hash(); again != absent {
+		t.Fatal("hash of a missing optional file is not stable")
+	}
+
+	createTestFile(t, memFs, path, nil)
+	empty := hash()
+	if empty == absent {
+		t.Error("an empty optional file hashes like a missing one")
+	}
+	if required := cache.Key().String("app", "web").File(path).Hash(); required == empty {
+		t.Error("FileIfExists hashes like File")
+	}
+
+	createTestFile(t, memFs, path, []byte("DEBUG=1"))
+	if set := hash(); set == empty || set == absent {
+		t.Error("changing an optional file did not change the key")
+	}
+
+	assertNoError(t, memFs.Remove(path), "Remove")
+	if removed := hash(); removed != absent {
+		t.Error("removing an optional file did not restore the key")
+	}
+}
+
+// TestKeyBuilderDirWith tests that DirWith keys ignore skipped files and
+// that plain Dir keys are unchanged.
+func TestKeyBuilderDirWith(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-dirwith-test")
+	project := filepath.Join(tempDir, "project")
+	createTestFile(t, memFs, filepath.Join(project, "main.go"), []byte("package main"))
+	createTestFile(t, memFs, filepath.Join(project, ".git", "HEAD"), []byte("ref: main"))
+	createTestFile(t, memFs, filepath.Join(project, "node_modules", "x.js"), []byte("x"))
+
+	opts := DirOptions{SkipHidden: true, ExcludeDirs: []string{"node_modules"}}
+	before := cache.Key().DirWith(project, opts).Hash()
+	if before == "" || before == cache.Key().Dir(project).Hash() {
+		t.Fatal("DirWith options did not change the key")
+	}
+	if cache.Key().Dir(project, "*.tmp").Hash() != cache.Key().DirWith(project, DirOptions{Exclude: []string{"*.tmp"}}).Hash() {
+		t.Error("Dir and DirWith with the same excludes hash differently")
+	}
+
+	createTestFile(t, memFs, filepath.Join(project, ".git", "HEAD"), []byte("ref: feature"))
+	createTestFile(t, memFs, filepath.Join(project, "node_modules", "x.js"), []byte("y"))
+	if after := cache.Key().DirWith(project, opts).Hash(); after != before {
+		t.Error("changes to skipped files changed the key")
+	}
+
+	createTestFile(t, memFs, filepath.Join(project, "main.go"), []byte("package main // v2"))
+	if after := cache.Key().DirWith(project, opts).Hash(); after == before {
+		t.Error("changes to covered files did not change the key")
+	}
+
+	if errs := cache.Key().DirWith(project, DirOptions{ExcludeDirs: []string{"["}}).Errors(); len(errs) != 1 {
+		t.Errorf("invalid ExcludeDirs pattern recorded %v, want one error", errs)
+	}
+}
+
 // TestKeyBuilderHash tests the Hash() methods.
 func TestKeyBuilderHash(t *testing.T) {
 	cache, _, _ := setupTestCache(t, "granular-hash-test")
@@ -844,7 +1306,97 @@ func TestKeyBuilderBuildClones(t *testing.T) {
 	}
 }
 
+// fileOpenCountingFs counts how often each file is opened.
+type fileOpenCountingFs struct {
+	afero.Fs
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (f *fileOpenCountingFs) Open(name string) (afero.File, error) {
+	f.mu.Lock()
+	f.opens[name]++
+	f.mu.Unlock()
+	return f.Fs.Open(name)
+}
+
+func (f *fileOpenCountingFs) count(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.opens[name]
+}
+
+// TestKeyHashMemoized verifies that a key reads its inputs once across a
+// Get-miss, Commit, Has, Get sequence and concurrent hashing, and that new
+// keys pick up changed inputs.
+func TestKeyHashMemoized(t *testing.T) {
+	fs := &fileOpenCountingFs{Fs: afero.NewMemMapFs(), opens: make(map[string]int)}
+	cache, err := Open("/cache", WithFs(fs))
+	assertNoError(t, err, "Open")
+	createTestFile(t, fs, "/src/main.go", []byte("package main"))
+
+	key := cache.Key().File("/src/main.go").Build()
+	_, err = cache.Get(key)
+	if !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Get = %v, want ErrCacheMiss", err)
+	}
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("x")).Commit(), "Commit")
+	if !cache.Has(key) {
+		t.Fatal("Has = false after Commit")
+	}
+	copied := key
+	result, err := cache.Get(copied)
+	assertCacheHit(t, result, err, "Get")
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() { key.Hash() })
+	}
+	wg.Wait()
+	if n := fs.count("/src/main.go"); n != 1 {
+		t.Errorf("input opened %d times, want 1", n)
+	}
+
+	// A built key keeps its hash; a new key sees the change
+	hash := key.Hash()
+	createTestFile(t, fs, "/src/main.go", []byte("package changed"))
+	assertEqual(t, key.Hash(), hash, "hash of existing key after change")
+	if cache.Key().File("/src/main.go").Build().Hash() == hash {
+		t.Error("new key did not pick up the changed input")
+	}
+}
+
 // TestCacheGC tests the GC() method for cleaning orphaned objects.
+// TestKeyDescribe tests describing a key without hashing it, and that the
+// descriptions match those recorded in the manifest.
+func TestKeyDescribe(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-key-describe-test")
+	input := filepath.Join(tempDir, "main.go")
+	createTestFile(t, memFs, input, []byte("package main"))
+
+	key := cache.Key().File(input).Bytes([]byte("abc")).Version("1.2.3").String("arch", "arm64").Build()
+	inputs, extras := key.Describe()
+	if want := []string{"file:" + input, "bytes:3"}; !slices.Equal(inputs, want) {
+		t.Errorf("Describe inputs = %v, want %v", inputs, want)
+	}
+	if len(extras) != 2 || extras["version"] != "1.2.3" || extras["arch"] != "arm64" {
+		t.Errorf("Describe extras = %v", extras)
+	}
+	assertEqual(t, key.String(), "file:"+input+" bytes:3 arch=arm64 version=1.2.3", "String")
+
+	// Describing reads no input, so it works on a key whose file is gone
+	assertNoError(t, memFs.Remove(input), "Remove")
+	assertEqual(t, fmt.Sprint(key), key.String(), "Stringer")
+
+	createTestFile(t, memFs, input, []byte("package main"))
+	assertNoError(t, cache.Put(key).Bytes("out", nil).Commit(), "Commit")
+	info, err := cache.Describe(key.Hash())
+	assertNoError(t, err, "Describe entry")
+	if !slices.Equal(info.Inputs, inputs) {
+		t.Errorf("manifest inputs = %v, want %v", info.Inputs, inputs)
+	}
+}
+
 func TestCacheGC(t *testing.T) {
 	cache, memFs, tempDir := setupTestCache(t, "granular-gc-test")
 
@@ -907,6 +1459,37 @@ func TestCacheGC(t *testing.T) {
 	assertCacheHit(t, result, err, "Get valid entry after GC")
 }
 
+// TestCacheGCStaleCommits tests that GC removes the staging directories of
+// interrupted commits and rollbacks, but not those of commits that may be
+// in progress.
+func TestCacheGCStaleCommits(t *testing.T) {
+	cache, memFs, tempDir := setupTestCache(t, "granular-gc-commits-test")
+	stale := filepath.Join(tempDir, "tmp", "commit-deadbeef12345678-1")
+	staleRollback := filepath.Join(tempDir, "tmp", "rollback-deadbeef12345678-1")
+	live := filepath.Join(tempDir, "tmp", "commit-deadbeef12345678-2")
+	createTestFile(t, memFs, filepath.Join(stale, "file.out.bin"), []byte("partial"))
+	createTestFile(t, memFs, filepath.Join(staleRollback, "file.out.bin"), []byte("partial"))
+	createTestFile(t, memFs, filepath.Join(live, "file.out.bin"), []byte("partial"))
+	old := time.Now().Add(-2 * stagingStaleAfter)
+	for _, path := range []string{stale, filepath.Join(stale, "file.out.bin"), staleRollback, filepath.Join(staleRollback, "file.out.bin"), live} {
+		assertNoError(t, memFs.Chtimes(path, old, old), "Chtimes")
+	}
+
+	removed, reclaimed, err := cache.GC()
+	assertNoError(t, err, "GC")
+	if removed != 2 || reclaimed != int64(2*len("partial")) {
+		t.Errorf("GC = %d dirs, %d bytes; want 2, %d", removed, reclaimed, 2*len("partial"))
+	}
+	for _, path := range []string{stale, staleRollback} {
+		if exists, _ := afero.DirExists(memFs, path); exists {
+			t.Errorf("Expected stale staging directory %s to be removed", path)
+		}
+	}
+	if exists, _ := afero.DirExists(memFs, live); !exists {
+		t.Error("Expected staging directory with a recent write to remain")
+	}
+}
+
 // TestCacheGCNoOrphans tests GC when there are no orphans to clean.
 func TestCacheGCNoOrphans(t *testing.T) {
 	cache, memFs, tempDir := setupTestCache(t, "granular-gc-no-orphans-test")
@@ -1520,3 +2103,218 @@ func TestMetricsOnPanicNilSilentRecover(t *testing.T) {
 		t.Fatalf("Expected ErrCacheMiss, got: %v", err)
 	}
 }
+
+// TestManifestStoresDataOutOfLine verifies that Bytes() blobs live in .dat files
+// in the object directory and never inline in the manifest JSON.
+func TestManifestStoresDataOutOfLine(t *testing.T) {
+	cache, memFs, _ := setupTestCache(t, "granular-manifest-size-test")
+
+	key := cache.Key().String("k", "v").Build()
+	blob := bytes.Repeat([]byte("x"), 1<<20)
+
+	err := cache.Put(key).Bytes("blob", blob).Commit()
+	assertNoError(t, err, "Put")
+
+	keyHash, err := key.computeHash()
+	assertNoError(t, err, "computeHash")
+
+	mPath, err := cache.manifestPath(keyHash)
+	assertNoError(t, err, "manifestPath")
+	info, err := memFs.Stat(mPath)
+	assertNoError(t, err, "stat manifest")
+	if info.Size() > 4096 {
+		t.Fatalf("Expected a small manifest, got %d bytes", info.Size())
+	}
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	if result.dataCache != nil {
+		t.Fatal("Expected data to be loaded lazily, but Get populated the data cache")
+	}
+	assertBytesEqual(t, result.Bytes("blob"), blob, "lazy-loaded blob")
+}
+
+func TestCommitIfAbsent(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-commit-if-absent-test")
+	key := cache.Key().String("target", "app").Build()
+
+	stored, err := cache.Put(key).Bytes("out", []byte("first")).CommitIfAbsent()
+	assertNoError(t, err, "first CommitIfAbsent")
+	if !stored {
+		t.Fatal("Expected first CommitIfAbsent to store the entry")
+	}
+
+	wb := cache.Put(key).Bytes("out", []byte("second"))
+	stored, err = wb.CommitIfAbsent()
+	assertNoError(t, err, "second CommitIfAbsent")
+	if stored {
+		t.Error("Expected second CommitIfAbsent to be a no-op")
+	}
+	if err := wb.Commit(); err == nil {
+		t.Error("Expected reuse of a skipped WriteBuilder to fail")
+	}
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get after CommitIfAbsent")
+	assertEqual(t, string(result.Bytes("out")), "first", "stored data")
+
+	// A corrupted entry does not count as present
+	m, err := cache.loadManifest(key.Hash())
+	assertNoError(t, err, "loadManifest")
+	assertNoError(t, afero.WriteFile(cache.fs, m.OutputData["out"], []byte("TAMPERED"), 0o644), "corrupting .dat file")
+	stored, err = cache.Put(key).Bytes("out", []byte("third")).CommitIfAbsent()
+	assertNoError(t, err, "CommitIfAbsent over corrupted entry")
+	if !stored {
+		t.Error("Expected CommitIfAbsent to replace a corrupted entry")
+	}
+	result, err = cache.Get(key)
+	assertCacheHit(t, result, err, "Get after replacing corrupted entry")
+	assertEqual(t, string(result.Bytes("out")), "third", "replaced data")
+}
+
+func TestCommitOnConflict(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-commit-on-conflict-test")
+	key := cache.Key().String("target", "app").Build()
+	get := func(context string) string {
+		t.Helper()
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, context)
+		return string(result.Bytes("out"))
+	}
+
+	// Without an entry, every policy stores
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("first")).OnConflict(ConflictError).Commit(), "first Commit")
+	assertEqual(t, get("Get after first Commit"), "first", "stored data")
+
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("skipped")).OnConflict(ConflictSkip).Commit(), "Commit with ConflictSkip")
+	assertEqual(t, get("Get after ConflictSkip"), "first", "data kept by ConflictSkip")
+
+	err := cache.Put(key).Bytes("out", []byte("rejected")).OnConflict(ConflictError).Commit()
+	if !errors.Is(err, ErrEntryExists) {
+		t.Errorf("Commit with ConflictError = %v, want ErrEntryExists", err)
+	}
+	assertEqual(t, get("Get after ConflictError"), "first", "data kept by ConflictError")
+
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("replaced")).OnConflict(ConflictOverwrite).Commit(), "Commit with ConflictOverwrite")
+	assertEqual(t, get("Get after ConflictOverwrite"), "replaced", "data replaced by ConflictOverwrite")
+
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("default")).Commit(), "Commit")
+	assertEqual(t, get("Get after Commit"), "default", "data replaced by default")
+}
+
+func TestGetMulti(t *testing.T) {
+	var gets atomic.Int32
+	cache, err := Open(".cache", WithFs(afero.NewMemMapFs()), WithMetrics(&MetricsHooks{
+		OnGet: func(string, bool, int64, time.Duration) { gets.Add(1) },
+	}))
+	assertNoError(t, err, "Open")
+
+	var keys []Key
+	for i := range 40 {
+		key := cache.Key().String("stage", strconv.Itoa(i)).Build()
+		if i%2 == 0 {
+			assertNoError(t, cache.Put(key).Bytes("out", []byte(strconv.Itoa(i))).Commit(), "Commit")
+		}
+		keys = append(keys, key)
+	}
+	keys = append(keys, cache.Key().File("/does/not/exist").Build())
+
+	results, errs := cache.GetMulti(keys)
+	if len(results) != len(keys) || len(errs) != len(keys) {
+		t.Fatalf("Expected %d results and errors, got %d and %d", len(keys), len(results), len(errs))
+	}
+	for i := range 40 {
+		if i%2 == 1 {
+			if results[i] != nil || !errors.Is(errs[i], ErrCacheMiss) {
+				t.Errorf("key %d: expected miss, got %v, %v", i, results[i], errs[i])
+			}
+			continue
+		}
+		assertCacheHit(t, results[i], errs[i], fmt.Sprintf("key %d", i))
+		assertEqual(t, string(results[i].Bytes("out")), strconv.Itoa(i), fmt.Sprintf("key %d data", i))
+	}
+	var verr *ValidationError
+	if !errors.As(errs[40], &verr) {
+		t.Errorf("Expected ValidationError for invalid key, got %v", errs[40])
+	}
+	if n := gets.Load(); n != 40 {
+		t.Errorf("Expected 40 OnGet calls, got %d", n)
+	}
+
+	if results, errs := cache.GetMulti(nil); len(results) != 0 || len(errs) != 0 {
+		t.Errorf("GetMulti(nil) = %v, %v", results, errs)
+	}
+}
+
+// TestResultOpen tests that Get does not read data blobs and that Open
+// streams them without loading them into the Result.
+func TestResultOpen(t *testing.T) {
+	payload := bytes.Repeat([]byte("test log line\n"), 100_000)
+	for _, ct := range []CompressionType{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(cmp.Or(string(ct), "none"), func(t *testing.T) {
+			fs := &fileOpenCountingFs{Fs: afero.NewMemMapFs(), opens: make(map[string]int)}
+			cache, err := Open("/cache", WithFs(fs), WithCompression(ct), WithVerifyOnGet(false))
+			assertNoError(t, err, "Open")
+			key := cache.Key().String("test", "suite").Build()
+			assertNoError(t, cache.Put(key).Bytes("log", payload).Meta("status", "ok").Commit(), "Commit")
+			fs.opens = make(map[string]int) // Commit hashes the blob
+
+			result, err := cache.Get(key)
+			assertCacheHit(t, result, err, "Get")
+			dataPath := result.dataPaths["log"]
+			if n := fs.count(dataPath); n != 0 {
+				t.Fatalf("Get opened the data blob %d times", n)
+			}
+			assertEqual(t, result.Meta("status"), "ok", "metadata")
+
+			rc, err := result.Open("log")
+			assertNoError(t, err, "Open data")
+			got, err := io.ReadAll(rc)
+			assertNoError(t, err, "read data")
+			assertNoError(t, rc.Close(), "close data")
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("streamed %d bytes, want the %d stored", len(got), len(payload))
+			}
+			if result.dataCache != nil {
+				t.Error("Open loaded the data into the Result")
+			}
+
+			if _, err := result.Open("missing"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Open of missing data = %v, want os.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+// TestGetWithFallback tests that a miss on the exact key returns the first
+// fallback that hits.
+func TestGetWithFallback(t *testing.T) {
+	cache := OpenTemp()
+	feature := cache.Key().String("branch", "feature").Build()
+	main := cache.Key().String("branch", "main").Build()
+	release := cache.Key().String("branch", "release").Build()
+	assertNoError(t, cache.Put(main).Bytes("out", []byte("main")).Commit(), "Commit main")
+	assertNoError(t, cache.Put(release).Bytes("out", []byte("release")).Commit(), "Commit release")
+
+	result, err := cache.GetWithFallback(feature, cache.Key().String("branch", "gone").Build(), main, release)
+	assertCacheHit(t, result, err, "GetWithFallback")
+	assertEqual(t, string(result.Bytes("out")), "main", "fallback data")
+	if result.KeyHash() != main.Hash() {
+		t.Errorf("fallback KeyHash = %s, want the main key's %s", result.KeyHash(), main.Hash())
+	}
+
+	// An exact hit wins over fallbacks
+	assertNoError(t, cache.Put(feature).Bytes("out", []byte("feature")).Commit(), "Commit feature")
+	result, err = cache.GetWithFallback(feature, main)
+	assertCacheHit(t, result, err, "GetWithFallback exact")
+	if result.KeyHash() != feature.Hash() {
+		t.Errorf("exact KeyHash = %s, want %s", result.KeyHash(), feature.Hash())
+	}
+
+	if _, err := cache.GetWithFallback(cache.Key().String("branch", "x").Build()); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("GetWithFallback with no hits = %v, want ErrCacheMiss", err)
+	}
+	invalid := cache.Key().File("/missing").Build()
+	if _, err := cache.GetWithFallback(cache.Key().String("branch", "x").Build(), invalid, main); errors.Is(err, ErrCacheMiss) || err == nil {
+		t.Errorf("GetWithFallback with an invalid fallback = %v, want a validation error", err)
+	}
+}
@@ -2,6 +2,7 @@ package granular
 
 import (
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -41,16 +42,33 @@ func TestHashAlgoMismatch(t *testing.T) {
 		t.Fatalf("Close failed: %v", err)
 	}
 
-	// Open same cache with xxHash (default) - different key hash means cache miss
-	cacheXX, err := Open(".cache", WithFs(fs), WithXXHash())
+	// Reopening with xxHash (default) must fail rather than miss on every key
+	_, err = Open(".cache", WithFs(fs), WithXXHash())
+	if !errors.Is(err, ErrHashAlgoMismatch) {
+		t.Fatalf("Expected ErrHashAlgoMismatch from Open, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "sha256") || !strings.Contains(err.Error(), "xxhash64") {
+		t.Errorf("Expected error naming both algorithms, got: %v", err)
+	}
+
+	// Once cleared with the old algorithm, the cache can switch
+	cacheSHA, err = Open(".cache", WithFs(fs), WithSHA256())
 	if err != nil {
 		t.Fatalf("Open failed: %v", err)
 	}
+	if err := cacheSHA.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	_ = cacheSHA.Close()
+
+	cacheXX, err := Open(".cache", WithFs(fs), WithXXHash())
+	if err != nil {
+		t.Fatalf("Open after Clear failed: %v", err)
+	}
 	defer func() {
 		_ = cacheXX.Close()
 	}()
 
-	// Same logical key with different hash function = different key hash = cache miss
 	keyXX := cacheXX.Key().String("id", "test").Build()
 	_, err = cacheXX.Get(keyXX)
 	if !errors.Is(err, ErrCacheMiss) {
@@ -238,6 +256,58 @@ func TestWithSHA256(t *testing.T) {
 	if result == nil {
 		t.Fatal("Expected result")
 	}
+
+	// 256-bit keys work wherever key hashes are taken as input
+	keyHash := key.Hash()
+	if len(keyHash) != 64 {
+		t.Fatalf("key hash %q has %d hex digits, want 64", keyHash, len(keyHash))
+	}
+	if _, err := cache.Describe(keyHash); err != nil {
+		t.Errorf("Describe failed: %v", err)
+	}
+	if _, err := cache.ExportManifest(keyHash); err != nil {
+		t.Errorf("ExportManifest failed: %v", err)
+	}
+}
+
+// TestWithHashAlgo tests selecting built-in algorithms by name, including
+// BLAKE3 on inputs large enough to be hashed in parallel.
+func TestWithHashAlgo(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	large := make([]byte, 3<<20)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	createTestFile(t, fs, "dataset.bin", large)
+
+	hashes := map[HashAlgo]string{}
+	for _, algo := range []HashAlgo{XXHash64, SHA256, Blake3} {
+		cache, err := Open(".cache-"+string(algo), WithFs(fs), WithHashAlgo(algo))
+		assertNoError(t, err, "Open")
+
+		key := cache.Key().File("dataset.bin").Build()
+		assertNoError(t, cache.Put(key).Bytes("rows", large).Commit(), "Commit")
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, string(algo))
+
+		info, err := cache.Describe(key.Hash())
+		assertNoError(t, err, "Describe")
+		assertEqual(t, info.HashAlgo, string(algo), "recorded algorithm")
+		hashes[algo] = key.Hash()
+	}
+
+	if len(hashes[Blake3]) != 64 || hashes[Blake3] == hashes[SHA256] {
+		t.Errorf("BLAKE3 key = %q, want 64 hex digits distinct from SHA-256", hashes[Blake3])
+	}
+	plain, err := Open(".cache-"+string(XXHash64), WithFs(fs))
+	assertNoError(t, err, "Open")
+	if plain.Key().File("dataset.bin").Hash() != hashes[XXHash64] {
+		t.Error("WithHashAlgo(XXHash64) keys differ from the default")
+	}
+
+	if _, err := Open(".cache", WithFs(fs), WithHashAlgo("md5")); err == nil || !strings.Contains(err.Error(), "md5") {
+		t.Errorf("Open with unknown algorithm = %v, want an error naming it", err)
+	}
 }
 
 // TestDefaultHashAlgoName tests that the default hash algorithm name is set correctly
@@ -360,3 +430,27 @@ func TestLegacyManifestBackwardsCompatibility(t *testing.T) {
 		t.Fatal("Expected result for legacy manifest")
 	}
 }
+
+// TestOpenChecksLegacyCacheHashAlgo tests that a cache written before the
+// metadata file existed is checked against its manifests.
+func TestOpenChecksLegacyCacheHashAlgo(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithSHA256())
+	assertNoError(t, err, "Open")
+	key := cache.Key().String("id", "legacy").Build()
+	assertNoError(t, cache.Put(key).Bytes("output", []byte("data")).Commit(), "Commit")
+	assertNoError(t, cache.Close(), "Close")
+	assertNoError(t, fs.Remove(".cache/"+metaFileName), "Remove metadata")
+
+	if _, err := Open(".cache", WithFs(fs)); !errors.Is(err, ErrHashAlgoMismatch) {
+		t.Fatalf("Open with different algorithm = %v, want ErrHashAlgoMismatch", err)
+	}
+
+	cache, err = Open(".cache", WithFs(fs), WithSHA256())
+	assertNoError(t, err, "Open with the same algorithm")
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "legacy entry")
+	if _, err := afero.ReadFile(fs, ".cache/"+metaFileName); err != nil {
+		t.Errorf("metadata not recorded on reopen: %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package hashing
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// ExpandGlob expands a glob pattern (supporting **) and returns matching file paths.
+// A pattern whose base directory does not exist yields no matches and no error.
+func ExpandGlob(fs afero.Fs, pattern string) ([]string, error) {
+	return (&Hasher{}).ExpandGlob(fs, pattern)
+}
+
+// ExpandGlob is like the package-level ExpandGlob, but stops with
+// ErrInputTooLarge as soon as more than MaxFiles files match.
+func (hs *Hasher) ExpandGlob(fs afero.Fs, pattern string) ([]string, error) {
+	hasRecursive := strings.Contains(pattern, "**")
+
+	// Determine base directory
+	baseDir := "."
+	if hasRecursive {
+		parts := strings.Split(pattern, "**")
+		baseDir = filepath.Dir(parts[0])
+		if baseDir == "." && parts[0] != "" && !strings.HasSuffix(parts[0], "/") && !strings.HasSuffix(parts[0], string(filepath.Separator)) {
+			baseDir = parts[0]
+		}
+	} else {
+		baseDir = filepath.Dir(pattern)
+	}
+
+	if baseDir == "." {
+		baseDir = ""
+	}
+
+	// Check if base directory exists
+	if baseDir != "" {
+		exists, err := afero.DirExists(fs, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, nil // No matches, not an error
+		}
+	}
+
+	// Walk and match files. Patterns without a directory walk ".", since
+	// the OS filesystem cannot stat an empty path.
+	root := cmp.Or(baseDir, ".")
+	var matches []string
+	err := afero.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// For non-recursive patterns, skip subdirectories to match standard
+			// glob semantics: src/*.go matches only files directly in src/, not
+			// files in src/pkg/ or deeper.
+			if !hasRecursive && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if hasRecursive {
+			if Match(pattern, path) {
+				matches = append(matches, path)
+				return hs.checkFileCount(len(matches))
+			}
+		} else {
+			filePattern := filepath.Base(pattern)
+			matched, err := filepath.Match(filePattern, filepath.Base(path))
+			if err != nil {
+				return err
+			}
+			if matched {
+				matches = append(matches, path)
+				return hs.checkFileCount(len(matches))
+			}
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+// Match reports whether path matches pattern, where a "**" path component
+// matches zero or more directories. Both are compared with forward slashes.
+func Match(pattern, path string) bool {
+	pattern = filepath.ToSlash(pattern)
+	path = filepath.ToSlash(path)
+
+	return MatchParts(strings.Split(path, "/"), strings.Split(pattern, "/"))
+}
+
+// MatchParts matches pre-split path components against pre-split pattern
+// components with the same semantics as Match.
+func MatchParts(pathParts, patternParts []string) bool {
+	return matchParts(pathParts, patternParts, 0, 0)
+}
+
+// matchParts recursively matches path parts against pattern parts.
+func matchParts(pathParts, patternParts []string, pathIdx, patternIdx int) bool {
+	if patternIdx >= len(patternParts) {
+		return pathIdx >= len(pathParts)
+	}
+
+	if pathIdx >= len(pathParts) {
+		for i := patternIdx; i < len(patternParts); i++ {
+			if patternParts[i] != "**" {
+				return false
+			}
+		}
+		return true
+	}
+
+	patternPart := patternParts[patternIdx]
+	pathPart := pathParts[pathIdx]
+
+	if patternPart == "**" {
+		if matchParts(pathParts, patternParts, pathIdx, patternIdx+1) {
+			return true
+		}
+		return matchParts(pathParts, patternParts, pathIdx+1, patternIdx)
+	}
+
+	matched, err := filepath.Match(patternPart, pathPart)
+	if err != nil || !matched {
+		return false
+	}
+
+	return matchParts(pathParts, patternParts, pathIdx+1, patternIdx+1)
+}
+
+// DirOptions selects the files of a directory covered by DirFilesWith and
+// DirWith. The zero value covers every regular file, recursively.
+type DirOptions struct {
+	// Exclude holds patterns matched against file basenames; matching
+	// files are skipped.
+	Exclude []string
+
+	// ExcludeDirs holds patterns matched against directory basenames;
+	// matching directories are skipped with everything under them (for
+	// example "node_modules").
+	ExcludeDirs []string
+
+	// SkipHidden skips files and directories whose names start with a dot,
+	// such as .git, .idea, and .DS_Store. The directory itself is included
+	// even if its own name starts with a dot.
+	SkipHidden bool
+
+	// MaxDepth, if positive, limits recursion: 1 covers only the files
+	// directly in the directory, 2 also those in its subdirectories, and so
+	// on.
+	MaxDepth int
+}
+
+// DirFiles returns every regular file under path, recursively.
+// exclude patterns match against basenames only.
+func DirFiles(fs afero.Fs, path string, exclude ...string) ([]string, error) {
+	return (&Hasher{}).DirFiles(fs, path, exclude...)
+}
+
+// DirFiles is like the package-level DirFiles, but stops with
+// ErrInputTooLarge as soon as more than MaxFiles files are found.
+func (hs *Hasher) DirFiles(fs afero.Fs, path string, exclude ...string) ([]string, error) {
+	return hs.DirFilesWith(fs, path, DirOptions{Exclude: exclude})
+}
+
+// DirFilesWith returns the regular files under path selected by opts. Like
+// DirFiles, it stops with ErrInputTooLarge as soon as more than MaxFiles
+// files are found.
+func (hs *Hasher) DirFilesWith(fs afero.Fs, path string, opts DirOptions) ([]string, error) {
+	var files []string
+	err := afero.Walk(fs, path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == path && info.IsDir() {
+			return nil
+		}
+
+		name := filepath.Base(p)
+		if opts.SkipHidden && p != path && strings.HasPrefix(name, ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			matched, err := matchAny(opts.ExcludeDirs, name)
+			if err != nil {
+				return err
+			}
+			if matched || opts.MaxDepth > 0 && depth(path, p) >= opts.MaxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Check exclusions (basename only)
+		matched, err := matchAny(opts.Exclude, name)
+		if err != nil || matched {
+			return err
+		}
+
+		files = append(files, p)
+		return hs.checkFileCount(len(files))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dir %s: %w", path, err)
+	}
+	return files, nil
+}
+
+// matchAny reports whether name matches any of patterns.
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := filepath.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid exclude pattern %s: %w", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// depth returns how many levels below root p is: 1 for its direct entries.
+func depth(root, p string) int {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
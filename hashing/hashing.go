@@ -0,0 +1,325 @@
+// Package hashing exposes the deterministic content-hashing primitives used by
+// granular to build cache keys.
+//
+// Tools can use it to compute digests that are byte-for-byte compatible with
+// the way granular hashes File, Glob, Dir, and Bytes inputs (for example, to
+// print a "content fingerprint" in logs) without opening a Cache. The
+// granular package itself is built on these functions, so there is exactly
+// one canonical implementation.
+//
+// Example:
+//
+//	h := xxhash.New()
+//	if err := hashing.Dir(h, afero.NewOsFs(), "src", "*.tmp"); err != nil {
+//	    return err
+//	}
+//	fmt.Println("fingerprint:", hashing.Sum(h))
+package hashing
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophersatwork/granular/internal/iobuf"
+	"github.com/spf13/afero"
+)
+
+// DefaultBufferSize is the size of the buffer used when streaming content into a hash.
+const DefaultBufferSize = iobuf.Size
+
+// ErrInputTooLarge is returned when a set of files exceeds the MaxFiles or
+// MaxBytes limit of a Hasher.
+var ErrInputTooLarge = errors.New("input too large")
+
+// FileEvent describes a single file hashed by a Hasher.
+type FileEvent struct {
+	Path    string        // Path of the file as passed to the Hasher
+	Bytes   int64         // Number of content bytes hashed
+	Elapsed time.Duration // Time spent hashing the file
+	Repeat  bool          // Whether the file was hashed before and skipped (see SkipRepeats)
+}
+
+// Hasher hashes files with optional instrumentation.
+// The zero value is ready to use and produces the same digests as the
+// package-level functions; configuration other than FoldCase and
+// SkipRepeats never changes the digest.
+type Hasher struct {
+	// FoldCase lowercases paths before they are hashed, so trees checked
+	// out on case-insensitive filesystems (macOS, Windows) hash the same
+	// whatever the casing of their directory names.
+	FoldCase bool
+
+	// Base, if set, is the absolute directory paths are hashed relative to,
+	// so a tree hashes the same wherever it is checked out. Relative paths
+	// are resolved against the working directory first; paths outside Base
+	// are hashed as given.
+	Base string
+
+	// OnFile, if set, is called after each file has been hashed.
+	OnFile func(FileEvent)
+
+	// OnProgress, if set, is called every few megabytes while a file is
+	// hashed, with the number of bytes of the file hashed so far. OnFile
+	// reports the final count.
+	OnProgress func(path string, bytes int64)
+
+	// Now returns the current time for Elapsed measurements.
+	// If nil, time.Now is used.
+	Now func() time.Time
+
+	// BufferSize is the size of the buffer used to stream content into the
+	// hash. Buffers are pooled per size. If not positive,
+	// DefaultBufferSize is used.
+	BufferSize int
+
+	// MaxFiles and MaxBytes, if positive, limit the number of files and
+	// their total size in a single Files, Glob, or Dir call. Exceeding
+	// either fails with ErrInputTooLarge before the files are read, so a
+	// directory pointed at the wrong place fails fast instead of hashing
+	// for minutes.
+	MaxFiles int
+	MaxBytes int64
+
+	// SkipRepeats makes the Hasher read each file once: a file it has
+	// already hashed is hashed again as a short marker instead of its
+	// content, so overlapping inputs, such as a Dir and a Glob covering the
+	// same tree, cost no more than one. A digest covering a file twice
+	// differs from the one without SkipRepeats. Files are the same if their
+	// absolute paths are, in the form returned by Path. Key hashing sets it
+	// with granular.WithSkipRepeatedFiles.
+	SkipRepeats bool
+
+	seen map[string]struct{} // Files hashed so far, for SkipRepeats
+}
+
+// Reader streams the content of r into w using a pooled buffer.
+func Reader(w io.Writer, r io.Reader) error {
+	return (&Hasher{}).Reader(w, r)
+}
+
+// Reader streams the content of r into w using a pooled buffer.
+func (hs *Hasher) Reader(w io.Writer, r io.Reader) error {
+	_, err := hs.copyPooled(w, r)
+	return err
+}
+
+// File streams the content of the file at path into w.
+func File(w io.Writer, fs afero.Fs, path string) error {
+	return (&Hasher{}).File(w, fs, path)
+}
+
+// Files hashes a set of files the way granular hashes Glob and Dir inputs:
+// the number of files first, then each path followed by its content.
+// Paths are sorted in place for deterministic ordering.
+func Files(w io.Writer, fs afero.Fs, paths []string) error {
+	return (&Hasher{}).Files(w, fs, paths)
+}
+
+// Glob expands pattern (supporting ** for recursive matching) and hashes the
+// matched files with Files.
+func Glob(w io.Writer, fs afero.Fs, pattern string) error {
+	return (&Hasher{}).Glob(w, fs, pattern)
+}
+
+// Dir hashes every regular file under path recursively with Files.
+// exclude patterns match against basenames only.
+func Dir(w io.Writer, fs afero.Fs, path string, exclude ...string) error {
+	return (&Hasher{}).Dir(w, fs, path, exclude...)
+}
+
+// File streams the content of the file at path into w.
+func (hs *Hasher) File(w io.Writer, fs afero.Fs, path string) error {
+	if hs.SkipRepeats {
+		id := path
+		if abs, err := filepath.Abs(path); err == nil {
+			id = abs
+		}
+		id = hs.Path(id)
+		if _, ok := hs.seen[id]; ok {
+			Field(w, "repeat")
+			if hs.OnFile != nil {
+				hs.OnFile(FileEvent{Path: path, Repeat: true})
+			}
+			return nil
+		}
+		defer func() {
+			if hs.seen == nil {
+				hs.seen = make(map[string]struct{})
+			}
+			hs.seen[id] = struct{}{}
+		}()
+	}
+
+	var start time.Time
+	if hs.OnFile != nil {
+		start = hs.now()
+	}
+
+	file, err := fs.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if hs.OnProgress != nil {
+		r = &iobuf.ProgressReader{R: file, Report: func(n int64) { hs.OnProgress(path, n) }}
+	}
+	n, err := hs.copyPooled(w, r)
+	if err != nil {
+		return fmt.Errorf("failed to hash file %s: %w", path, err)
+	}
+
+	if hs.OnFile != nil {
+		hs.OnFile(FileEvent{Path: path, Bytes: n, Elapsed: hs.now().Sub(start)})
+	}
+	return nil
+}
+
+// Files hashes a set of files the way granular hashes Glob and Dir inputs:
+// the number of files first, then each path followed by its content.
+// Paths are hashed in the form returned by Path, so the same tree hashes the
+// same on Windows and Unix, and are sorted in place by that form for
+// deterministic ordering.
+func (hs *Hasher) Files(w io.Writer, fs afero.Fs, paths []string) error {
+	if err := hs.checkFileCount(len(paths)); err != nil {
+		return err
+	}
+	if hs.MaxBytes > 0 {
+		var total int64
+		for _, path := range paths {
+			info, err := fs.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to stat file %s: %w", path, err)
+			}
+			if total += info.Size(); total > hs.MaxBytes {
+				return fmt.Errorf("%w: more than %d bytes in %d files", ErrInputTooLarge, hs.MaxBytes, len(paths))
+			}
+		}
+	}
+
+	slices.SortFunc(paths, func(a, b string) int {
+		return strings.Compare(hs.Path(a), hs.Path(b))
+	})
+
+	_, _ = io.WriteString(w, strconv.Itoa(len(paths)))
+
+	for _, path := range paths {
+		_, _ = io.WriteString(w, hs.Path(path))
+		if err := hs.File(w, fs, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Glob expands pattern (supporting ** for recursive matching) and hashes the
+// matched files with Files.
+func (hs *Hasher) Glob(w io.Writer, fs afero.Fs, pattern string) error {
+	matches, err := hs.ExpandGlob(fs, pattern)
+	if err != nil {
+		return fmt.Errorf("glob %s: %w", pattern, err)
+	}
+	return hs.Files(w, fs, matches)
+}
+
+// Dir hashes every regular file under path recursively with Files.
+// exclude patterns match against basenames only.
+func (hs *Hasher) Dir(w io.Writer, fs afero.Fs, path string, exclude ...string) error {
+	files, err := hs.DirFiles(fs, path, exclude...)
+	if err != nil {
+		return err
+	}
+	return hs.Files(w, fs, files)
+}
+
+// DirWith hashes the files under path selected by opts with Files, the way
+// granular hashes KeyBuilder.DirWith inputs.
+func (hs *Hasher) DirWith(w io.Writer, fs afero.Fs, path string, opts DirOptions) error {
+	files, err := hs.DirFilesWith(fs, path, opts)
+	if err != nil {
+		return err
+	}
+	return hs.Files(w, fs, files)
+}
+
+// checkFileCount returns ErrInputTooLarge if n files exceed MaxFiles.
+func (hs *Hasher) checkFileCount(n int) error {
+	if hs.MaxFiles > 0 && n > hs.MaxFiles {
+		return fmt.Errorf("%w: more than %d files", ErrInputTooLarge, hs.MaxFiles)
+	}
+	return nil
+}
+
+// now returns the current time from the configured clock.
+func (hs *Hasher) now() time.Time {
+	if hs.Now != nil {
+		return hs.Now()
+	}
+	return time.Now()
+}
+
+// copyPooled copies r into w using a pooled buffer and returns the byte count.
+func (hs *Hasher) copyPooled(w io.Writer, r io.Reader) (int64, error) {
+	pool := iobuf.For(hs.BufferSize)
+	bufPtr := pool.Get()
+	buffer := *bufPtr
+	defer pool.Put(bufPtr)
+
+	// Hide any WriteTo method: *os.File implements it by copying through a
+	// buffer of its own, which would bypass the configured buffer size
+	n, err := io.CopyBuffer(w, struct{ io.Reader }{r}, buffer)
+	if err != nil {
+		return n, fmt.Errorf("failed to copy content: %w", err)
+	}
+	return n, nil
+}
+
+// Portable returns path with forward slashes and an upper-case drive
+// letter, the form in which paths are hashed. On Unix it returns path
+// unchanged.
+func Portable(path string) string {
+	if vol := filepath.VolumeName(path); len(vol) == 2 && vol[1] == ':' {
+		path = strings.ToUpper(vol) + path[2:]
+	}
+	return filepath.ToSlash(path)
+}
+
+// Path returns path in the form in which it is hashed: relative to Base if
+// set, Portable, and lowercased if FoldCase is set.
+func (hs *Hasher) Path(path string) string {
+	if hs.Base != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			if rel, err := filepath.Rel(hs.Base, abs); err == nil && filepath.IsLocal(rel) {
+				path = rel
+			}
+		}
+	}
+	path = Portable(path)
+	if hs.FoldCase {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// Field writes s to w with a length prefix ("<len>:<s>").
+// Length-prefixing every variable-length field prevents ambiguous framing:
+// Field("ab") + Field("cd") never collides with Field("a") + Field("bcd").
+func Field(w io.Writer, s string) {
+	_, _ = io.WriteString(w, strconv.Itoa(len(s)))
+	_, _ = io.WriteString(w, ":")
+	_, _ = io.WriteString(w, s)
+}
+
+// Sum returns the current digest of h as a lowercase hex string.
+func Sum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,340 @@
+package hashing_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/hashing"
+	"github.com/spf13/afero"
+)
+
+func setupFs(t *testing.T) afero.Fs {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"src/main.go":     "package main",
+		"src/util.go":     "package util",
+		"src/pkg/a.go":    "package pkg",
+		"src/notes.tmp":   "scratch",
+		"config/app.json": "{}",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(fs, path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	return fs
+}
+
+// TestCompatibleWithKeyHash verifies that digests built from the hashing
+// primitives match the key hashes computed by a Cache.
+func TestCompatibleWithKeyHash(t *testing.T) {
+	fs := setupFs(t)
+	cache, err := granular.Open(".cache", granular.WithFs(fs))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	skipping, err := granular.Open(".cache", granular.WithFs(fs), granular.WithSkipRepeatedFiles())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		key    granular.Key
+		digest func(h *xxhash.Digest) error
+	}{
+		{
+			name: "file",
+			key:  cache.Key().File("src/main.go").Build(),
+			digest: func(h *xxhash.Digest) error {
+				hashing.Field(h, "file:src/main.go")
+				return hashing.File(h, fs, "src/main.go")
+			},
+		},
+		{
+			name: "glob",
+			key:  cache.Key().Glob("src/**/*.go").Build(),
+			digest: func(h *xxhash.Digest) error {
+				hashing.Field(h, "glob:src/**/*.go")
+				return hashing.Glob(h, fs, "src/**/*.go")
+			},
+		},
+		{
+			name: "dir with extras",
+			key:  cache.Key().Dir("src", "*.tmp").Version("1").Build(),
+			digest: func(h *xxhash.Digest) error {
+				hashing.Field(h, "dir:src(exclude:*.tmp)")
+				if err := hashing.Dir(h, fs, "src", "*.tmp"); err != nil {
+					return err
+				}
+				hashing.Field(h, "version")
+				hashing.Field(h, "1")
+				return nil
+			},
+		},
+		{
+			name: "overlapping inputs",
+			key:  cache.Key().Dir("src").Glob("src/*.go").Build(),
+			digest: func(h *xxhash.Digest) error {
+				hashing.Field(h, "dir:src")
+				if err := hashing.Dir(h, fs, "src"); err != nil {
+					return err
+				}
+				hashing.Field(h, "glob:src/*.go")
+				return hashing.Glob(h, fs, "src/*.go")
+			},
+		},
+		{
+			name: "overlapping inputs skipping repeats",
+			key:  skipping.Key().Dir("src").Glob("src/*.go").Build(),
+			digest: func(h *xxhash.Digest) error {
+				hs := &hashing.Hasher{SkipRepeats: true}
+				hashing.Field(h, "dir:src")
+				if err := hs.Dir(h, fs, "src"); err != nil {
+					return err
+				}
+				hashing.Field(h, "glob:src/*.go")
+				return hs.Glob(h, fs, "src/*.go")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := xxhash.New()
+			if err := tt.digest(h); err != nil {
+				t.Fatalf("digest failed: %v", err)
+			}
+			if got, want := hashing.Sum(h), tt.key.Hash(); got != want {
+				t.Errorf("digest = %s, key hash = %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDirFilesExclude(t *testing.T) {
+	fs := setupFs(t)
+
+	files, err := hashing.DirFiles(fs, "src", "*.tmp")
+	if err != nil {
+		t.Fatalf("DirFiles failed: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d: %v", len(files), files)
+	}
+	for _, f := range files {
+		if f == "src/notes.tmp" {
+			t.Errorf("excluded file returned: %s", f)
+		}
+	}
+}
+
+func TestDirFilesWith(t *testing.T) {
+	fs := setupFs(t)
+	for _, path := range []string{".hidden/a.go", "src/.env", "src/.git/HEAD", "src/node_modules/x/index.js", "src/pkg/deep/b.go"} {
+		if err := afero.WriteFile(fs, path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		path string
+		opts hashing.DirOptions
+		want []string
+	}{
+		{"all", "src", hashing.DirOptions{}, []string{
+			"src/.env", "src/.git/HEAD", "src/main.go", "src/node_modules/x/index.js",
+			"src/notes.tmp", "src/pkg/a.go", "src/pkg/deep/b.go", "src/util.go",
+		}},
+		{"skip hidden", "src", hashing.DirOptions{SkipHidden: true, ExcludeDirs: []string{"node_*"}}, []string{
+			"src/main.go", "src/notes.tmp", "src/pkg/a.go", "src/pkg/deep/b.go", "src/util.go",
+		}},
+		{"depth 1", "src", hashing.DirOptions{MaxDepth: 1, Exclude: []string{"*.tmp"}}, []string{
+			"src/.env", "src/main.go", "src/util.go",
+		}},
+		{"depth 2", "src", hashing.DirOptions{MaxDepth: 2, SkipHidden: true}, []string{
+			"src/main.go", "src/notes.tmp", "src/pkg/a.go", "src/util.go",
+		}},
+		{"hidden root", ".hidden", hashing.DirOptions{SkipHidden: true}, []string{".hidden/a.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := (&hashing.Hasher{}).DirFilesWith(fs, tt.path, tt.opts)
+			if err != nil {
+				t.Fatalf("DirFilesWith failed: %v", err)
+			}
+			if !slices.Equal(files, tt.want) {
+				t.Errorf("DirFilesWith = %v, want %v", files, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilesOrderIndependent(t *testing.T) {
+	fs := setupFs(t)
+
+	h1 := xxhash.New()
+	if err := hashing.Files(h1, fs, []string{"src/main.go", "src/util.go"}); err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	h2 := xxhash.New()
+	if err := hashing.Files(h2, fs, []string{"src/util.go", "src/main.go"}); err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if hashing.Sum(h1) != hashing.Sum(h2) {
+		t.Error("Files digest depends on argument order")
+	}
+}
+
+func TestFilesPortable(t *testing.T) {
+	fs := setupFs(t)
+
+	h1 := xxhash.New()
+	if err := hashing.Files(h1, fs, []string{"src/main.go", "src/util.go"}); err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	h2 := xxhash.New()
+	if err := hashing.Files(h2, fs, []string{filepath.FromSlash("src/util.go"), filepath.FromSlash("src/main.go")}); err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if hashing.Sum(h1) != hashing.Sum(h2) {
+		t.Error("Files digest depends on path separators")
+	}
+}
+
+func TestPortable(t *testing.T) {
+	tests := map[string]string{
+		"src/main.go":  "src/main.go",
+		"/abs/main.go": "/abs/main.go",
+	}
+	if runtime.GOOS == "windows" {
+		tests[`src\main.go`] = "src/main.go"
+		tests[`c:\repo\main.go`] = "C:/repo/main.go"
+		tests[`C:/repo/main.go`] = "C:/repo/main.go"
+	} else {
+		tests[`src\main.go`] = `src\main.go` // a valid file name on Unix
+	}
+	for path, want := range tests {
+		if got := hashing.Portable(path); got != want {
+			t.Errorf("Portable(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestPathBase(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &hashing.Hasher{Base: filepath.Dir(wd)}
+	tests := map[string]string{
+		filepath.Join(wd, "src", "main.go"): filepath.Base(wd) + "/src/main.go",
+		filepath.Join("src", "main.go"):     filepath.Base(wd) + "/src/main.go",
+		filepath.Join("..", "main.go"):      "main.go",
+	}
+	outside := filepath.Join(filepath.Dir(filepath.Dir(wd)), "main.go")
+	tests[outside] = hashing.Portable(outside)
+	for path, want := range tests {
+		if got := hs.Path(path); got != want {
+			t.Errorf("Path(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestBufferSizeIndependent(t *testing.T) {
+	fs := setupFs(t)
+
+	want := xxhash.New()
+	if err := hashing.Dir(want, fs, "src"); err != nil {
+		t.Fatalf("Dir failed: %v", err)
+	}
+	for _, size := range []int{1, 5, 4 << 20} {
+		h := xxhash.New()
+		hs := &hashing.Hasher{BufferSize: size}
+		if err := hs.Dir(h, fs, "src"); err != nil {
+			t.Fatalf("Dir with %d-byte buffers failed: %v", size, err)
+		}
+		if hashing.Sum(h) != hashing.Sum(want) {
+			t.Errorf("digest with %d-byte buffers differs", size)
+		}
+	}
+}
+
+func TestSkipRepeats(t *testing.T) {
+	fs := setupFs(t)
+
+	var reads, repeats []string
+	hs := &hashing.Hasher{SkipRepeats: true, OnFile: func(ev hashing.FileEvent) {
+		if ev.Repeat {
+			repeats = append(repeats, ev.Path)
+		} else {
+			reads = append(reads, ev.Path)
+		}
+	}}
+	h := xxhash.New()
+	if err := hs.Glob(h, fs, "src/*.go"); err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if err := hs.File(h, fs, "./src/main.go"); err != nil {
+		t.Fatalf("File failed: %v", err)
+	}
+	if err := hs.Dir(h, fs, "src"); err != nil {
+		t.Fatalf("Dir failed: %v", err)
+	}
+	if want := []string{"src/main.go", "src/util.go", "src/notes.tmp", "src/pkg/a.go"}; !slices.Equal(reads, want) {
+		t.Errorf("read %v, want %v", reads, want)
+	}
+	if want := []string{"./src/main.go", "src/main.go", "src/util.go"}; !slices.Equal(repeats, want) {
+		t.Errorf("skipped %v, want %v", repeats, want)
+	}
+
+	// Without repeats, the digest is the one of a plain Hasher
+	plain, skipping := xxhash.New(), xxhash.New()
+	if err := hashing.Dir(plain, fs, "src"); err != nil {
+		t.Fatalf("Dir failed: %v", err)
+	}
+	if err := (&hashing.Hasher{SkipRepeats: true}).Dir(skipping, fs, "src"); err != nil {
+		t.Fatalf("Dir failed: %v", err)
+	}
+	if hashing.Sum(plain) != hashing.Sum(skipping) {
+		t.Error("SkipRepeats changed the digest of files hashed once")
+	}
+}
+
+func TestFieldFraming(t *testing.T) {
+	h1 := xxhash.New()
+	hashing.Field(h1, "ab")
+	hashing.Field(h1, "cd")
+
+	h2 := xxhash.New()
+	hashing.Field(h2, "a")
+	hashing.Field(h2, "bcd")
+
+	if hashing.Sum(h1) == hashing.Sum(h2) {
+		t.Error("length-prefixed fields collided")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"src/**/*.go", "src/pkg/a.go", true},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/*.go", "src/pkg/a.go", false},
+		{"**", "a/b/c", true},
+	}
+	for _, tt := range tests {
+		if got := hashing.Match(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
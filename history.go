@@ -0,0 +1,511 @@
+package granular
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/spf13/afero"
+)
+
+// historyManifest is the name of the manifest in a generation directory.
+const historyManifest = "manifest.json"
+
+// historyDir returns the path to the directory holding the previous results
+// kept by WithHistory.
+func (c *Cache) historyDir() string {
+	return filepath.Join(c.root, "history")
+}
+
+// historyPath returns the directory holding the previous results of the
+// entry for keyHash, one subdirectory per generation.
+func (c *Cache) historyPath(keyHash string) (string, error) {
+	if len(keyHash) < hashPrefixLen {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKeyHash, keyHash)
+	}
+	return filepath.Join(c.historyDir(), keyHash[:hashPrefixLen], keyHash), nil
+}
+
+// generationPath returns the directory of one generation of keyHash.
+func (c *Cache) generationPath(keyHash string, gen int) (string, error) {
+	dir, err := c.historyPath(keyHash)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(gen)), nil
+}
+
+// generations returns the generations kept for keyHash, newest first.
+// Generations are numbered from 1 in the order their results were replaced.
+func (c *Cache) generations(keyHash string) ([]int, error) {
+	dir, err := c.historyPath(keyHash)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := afero.ReadDir(c.fs, dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	var gens []int
+	for _, info := range infos {
+		if gen, err := strconv.Atoi(info.Name()); err == nil && gen > 0 && info.IsDir() {
+			gens = append(gens, gen)
+		}
+	}
+	slices.SortFunc(gens, func(a, b int) int { return cmp.Compare(b, a) })
+	return gens, nil
+}
+
+// archiveEntry moves the current entry of keyHash into its history before
+// it is replaced, and drops the generations beyond those WithHistory keeps.
+// Nothing is kept if history is disabled, there is no readable entry, or the
+// entry's output hash is unchanged, so rewriting identical outputs does not
+// push older results out. The caller holds the key's lock.
+//
+// Keeping history is best effort: the entry is being replaced either way,
+// so a failure is reported to the metrics hooks and logger, not returned.
+func (c *Cache) archiveEntry(keyHash, unchanged string) {
+	if c.history <= 0 {
+		return
+	}
+	m, err := c.loadManifest(keyHash)
+	if err != nil || m.OutputHash == unchanged {
+		return
+	}
+	gens, err := c.generations(keyHash)
+	if err != nil {
+		c.recordError("history", err)
+		return
+	}
+	next := 1
+	if len(gens) > 0 {
+		next = gens[0] + 1
+	}
+	genDir, err := c.generationPath(keyHash, next)
+	if err == nil {
+		err = c.archiveManifest(m, genDir)
+	}
+	if err != nil {
+		_ = c.fs.RemoveAll(genDir)
+		c.recordError("history", fmt.Errorf("failed to keep previous result of %s: %w", keyHash, err))
+		return
+	}
+	for _, gen := range gens[min(len(gens), c.history-1):] {
+		if dir, err := c.generationPath(keyHash, gen); err == nil {
+			_ = c.fs.RemoveAll(dir)
+		}
+	}
+}
+
+// archiveManifest moves the objects of the entry described by m into
+// genDir and writes a manifest pointing at them there. Shared blobs (see
+// WithDedup) stay where they are.
+func (c *Cache) archiveManifest(m *manifest, genDir string) error {
+	if err := c.mkdirAll(genDir); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	move := func(paths map[string]string) (map[string]string, error) {
+		moved := make(map[string]string, len(paths))
+		for name, path := range paths {
+			if isBlobPath(path) {
+				moved[name] = path
+				continue
+			}
+			dst := filepath.Join(genDir, filepath.Base(path))
+			if err := c.fs.Rename(path, dst); err != nil {
+				return nil, fmt.Errorf("failed to move object %s: %w", name, err)
+			}
+			moved[name] = dst
+		}
+		return moved, nil
+	}
+	archived := *m
+	var err error
+	if archived.OutputFiles, err = move(m.OutputFiles); err != nil {
+		return err
+	}
+	if archived.OutputData, err = move(m.OutputData); err != nil {
+		return err
+	}
+	data, err := marshalManifest(&archived)
+	if err != nil {
+		return err
+	}
+	if err := atomicWriteFile(c.fs, filepath.Join(genDir, historyManifest), data, c.fileMode, c.durable); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// loadGeneration returns the manifest of generation gen of keyHash, with
+// object paths in the generation's directory. It returns ErrCacheMiss if the
+// generation does not exist or cannot be read with the cache's hash algorithm
+// and compression, and ErrCacheCorrupted if it fails verification. The
+// caller holds the key's lock.
+func (c *Cache) loadGeneration(keyHash string, gen int) (*manifest, error) {
+	genDir, err := c.generationPath(keyHash, gen)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(c.fs, filepath.Join(genDir, historyManifest))
+	if err != nil {
+		return nil, ErrCacheMiss
+	}
+	m, err := unmarshalManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCacheCorrupted, err)
+	}
+	if err := validateObjectPaths(keyHash, m, func(dir string) bool { return dir == genDir }); err != nil {
+		return nil, err
+	}
+	if cmp.Or(m.HashAlgo, DefaultHashAlgoName) != c.hashAlgoName || CompressionType(m.Compression) != c.compression {
+		return nil, ErrCacheMiss
+	}
+	if c.verifyOnGet {
+		// The output hash covers the paths the objects had in the entry
+		objectDir, err := c.objectPath(keyHash)
+		if err != nil {
+			return nil, err
+		}
+		inObjectDir := func(path string) string { return filepath.Join(objectDir, filepath.Base(path)) }
+		sum, err := c.outputHash(slices.Collect(maps.Values(m.OutputFiles)), m.OutputData, m.OutputMeta, inObjectDir)
+		if err != nil || sum != m.OutputHash {
+			return nil, ErrCacheCorrupted
+		}
+	}
+	return m, nil
+}
+
+// generationResult returns the result kept as generation gen of keyHash,
+// failing as loadGeneration does.
+func (c *Cache) generationResult(keyHash string, gen int) (*Result, error) {
+	m, err := c.loadGeneration(keyHash, gen)
+	if err != nil {
+		return nil, err
+	}
+	result := c.newResult(keyHash, m)
+	result.generation = gen
+	return result, nil
+}
+
+// History returns the previous results of key kept by WithHistory, newest
+// first. It returns no results if the entry was never replaced while history
+// was enabled. Generations that cannot be read or fail verification are left
+// out.
+//
+// Example:
+//
+//	history, err := cache.History(key)
+//	for _, old := range history {
+//		fmt.Println(old.CreatedAt(), old.Meta("toolchain"))
+//	}
+func (c *Cache) History(key Key) ([]*Result, error) {
+	if len(key.errors) > 0 {
+		return nil, newValidationError(key.errors)
+	}
+	keyHash, err := key.computeHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute key hash: %w", err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	gens, err := c.generations(keyHash)
+	if err != nil {
+		return nil, err
+	}
+	var results []*Result
+	for _, gen := range gens {
+		if result, err := c.generationResult(keyHash, gen); err == nil {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// Previous returns the result this one replaced, as kept by WithHistory, or
+// ErrCacheMiss if none was kept. Called on a result from History, it returns
+// the next older one.
+//
+// Example:
+//
+//	result, _ := cache.Get(key)
+//	if old, err := result.Previous(); err == nil {
+//		diff(old.File("app"), result.File("app"))
+//	}
+func (r *Result) Previous() (*Result, error) {
+	c := r.cache
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.keyLocks.lockKey(r.keyHash)
+	defer c.keyLocks.unlockKey(r.keyHash)
+
+	gens, err := c.generations(r.keyHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, gen := range gens {
+		if r.generation > 0 && gen >= r.generation {
+			continue
+		}
+		if result, err := c.generationResult(r.keyHash, gen); err == nil {
+			return result, nil
+		}
+	}
+	return nil, ErrCacheMiss
+}
+
+// Rollback makes an older result of key current again: the one History
+// returns at index generations-1, so 1 restores the result the current entry
+// replaced. Use it when a bad toolchain or input poisoned an entry, to
+// restore the last good result without rebuilding it.
+//
+// The restored result leaves the history. With WithHistory, the current
+// entry, if any, goes into the history like any replaced entry, so a
+// rollback can itself be undone with Rollback(key, 1). Rollback returns an
+// error wrapping ErrCacheMiss if fewer than generations previous results
+// are kept. If restoring fails, the result goes back into the history.
+//
+// Example:
+//
+//	// go1.26.3 miscompiled the last build; go back to yesterday's binary
+//	err := cache.Rollback(key, 1)
+func (c *Cache) Rollback(key Key, generations int) error {
+	if len(key.errors) > 0 {
+		return newValidationError(key.errors)
+	}
+	if generations < 1 {
+		return fmt.Errorf("invalid generations %d: must be at least 1", generations)
+	}
+	keyHash, err := key.computeHash()
+	if err != nil {
+		return fmt.Errorf("failed to compute key hash: %w", err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	// Find the generation, counting only those History returns
+	gens, err := c.generations(keyHash)
+	if err != nil {
+		return err
+	}
+	var m *manifest
+	var gen, found int
+	for _, gen = range gens {
+		if m, err = c.loadGeneration(keyHash, gen); err == nil {
+			if found++; found == generations {
+				break
+			}
+		}
+	}
+	if found < generations {
+		return fmt.Errorf("%w: %d previous results of %s kept, cannot go back %d", ErrCacheMiss, found, keyHash, generations)
+	}
+
+	// Record the in-flight rollback so Open can clean it up after a crash
+	if c.durable {
+		endJournal, err := c.beginJournal(keyHash)
+		if err != nil {
+			return err
+		}
+		defer endJournal()
+	}
+
+	// Take the generation out of the history first, so pruning the history
+	// when the current entry goes in cannot remove it
+	genDir, err := c.generationPath(keyHash, gen)
+	if err != nil {
+		return err
+	}
+	stageDir := filepath.Join(c.root, "tmp", "rollback-"+keyHash+"-"+randomSuffix())
+	if err := c.mkdirAll(filepath.Dir(stageDir)); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	if err := c.fs.Rename(genDir, stageDir); err != nil {
+		return fmt.Errorf("failed to stage previous result: %w", err)
+	}
+
+	// Move the objects into place, remembering each move so a failure can
+	// put the generation back together in the history
+	var objectDir string
+	var moved [][2]string
+	restore := func(paths map[string]string) (map[string]string, error) {
+		restored := make(map[string]string, len(paths))
+		for name, path := range paths {
+			if isBlobPath(path) {
+				restored[name] = path
+				continue
+			}
+			src := filepath.Join(stageDir, filepath.Base(path))
+			dst := filepath.Join(objectDir, filepath.Base(path))
+			if err := c.fs.Rename(src, dst); err != nil {
+				return nil, fmt.Errorf("failed to move object %s: %w", name, err)
+			}
+			moved = append(moved, [2]string{src, dst})
+			restored[name] = dst
+		}
+		return restored, nil
+	}
+	c.archiveEntry(keyHash, "")
+	err = c.removeByHash(keyHash)
+	if err != nil {
+		err = fmt.Errorf("failed to remove current entry: %w", err)
+	}
+	if err == nil {
+		objectDir, err = c.objectPath(keyHash)
+	}
+	if err == nil {
+		if err = c.mkdirAll(objectDir); err != nil {
+			err = fmt.Errorf("failed to create object directory: %w", err)
+		}
+	}
+	var files map[string]string
+	data := m.OutputData
+	if err == nil {
+		files, err = restore(m.OutputFiles)
+	}
+	if err == nil {
+		data, err = restore(m.OutputData)
+	}
+	if err == nil {
+		restored := *m
+		restored.OutputFiles, restored.OutputData = files, data
+		restored.AccessedAt = c.now()
+		err = c.saveManifest(&restored)
+	}
+	if err != nil {
+		for _, move := range moved {
+			_ = c.fs.Rename(move[1], move[0])
+		}
+		if objectDir != "" {
+			_ = c.removeByHash(keyHash)
+		}
+		c.unstageGeneration(keyHash, gen, m, stageDir)
+		return fmt.Errorf("failed to restore previous result of %s: %w", keyHash, err)
+	}
+	_ = c.fs.RemoveAll(stageDir)
+	c.log(slog.LevelInfo, "cache entry rolled back", keyHashAttr(keyHash), slog.Int("generations", generations))
+	return nil
+}
+
+// unstageGeneration puts a generation Rollback failed to restore back into
+// the history of keyHash. The current entry may have been archived under the
+// same generation number in the meantime, in which case the staged one is
+// kept as the newest and its manifest m rewritten to point there. If even
+// that fails, the staged directory is left for GC rather than deleted.
+func (c *Cache) unstageGeneration(keyHash string, gen int, m *manifest, stageDir string) {
+	genDir, err := c.generationPath(keyHash, gen)
+	if err != nil {
+		return
+	}
+	renumbered := false
+	if exists, _ := afero.Exists(c.fs, genDir); exists {
+		gens, err := c.generations(keyHash)
+		if err != nil || len(gens) == 0 {
+			return
+		}
+		if genDir, err = c.generationPath(keyHash, gens[0]+1); err != nil {
+			return
+		}
+		renumbered = true
+	}
+	if err = c.mkdirAll(filepath.Dir(genDir)); err == nil {
+		err = c.fs.Rename(stageDir, genDir)
+	}
+	if err == nil && renumbered {
+		inGenDir := func(path string) string {
+			if isBlobPath(path) {
+				return path
+			}
+			return filepath.Join(genDir, filepath.Base(path))
+		}
+		moved := *m
+		moved.OutputFiles = mapPaths(m.OutputFiles, inGenDir)
+		moved.OutputData = mapPaths(m.OutputData, inGenDir)
+		var data []byte
+		if data, err = marshalManifest(&moved); err == nil {
+			err = atomicWriteFile(c.fs, filepath.Join(genDir, historyManifest), data, c.fileMode, c.durable)
+		}
+	}
+	if err != nil {
+		c.recordError("history", fmt.Errorf("failed to return previous result of %s to the history: %w", keyHash, err))
+	}
+}
+
+// removeOrphanHistory removes the history of keys not in valid, which have
+// no entry, and returns the number of keys whose history it removed and the
+// bytes reclaimed.
+func (c *Cache) removeOrphanHistory(valid map[string]bool) (int, int64, error) {
+	shards, err := afero.ReadDir(c.fs, c.historyDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var removed int
+	var reclaimed int64
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		keys, err := afero.ReadDir(c.fs, filepath.Join(c.historyDir(), shard.Name()))
+		if err != nil {
+			return removed, reclaimed, err
+		}
+		for _, key := range keys {
+			if valid[key.Name()] {
+				continue
+			}
+			dir := filepath.Join(c.historyDir(), shard.Name(), key.Name())
+			size, _ := c.dirSize(dir)
+			if err := c.fs.RemoveAll(dir); err == nil {
+				removed++
+				reclaimed += size
+			}
+		}
+	}
+	return removed, reclaimed, nil
+}
+
+// historyBlobs adds the shared blobs referenced by kept generations to
+// referenced, so GC does not remove them.
+func (c *Cache) historyBlobs(referenced map[string]bool) error {
+	if exists, err := afero.DirExists(c.fs, c.historyDir()); err != nil || !exists {
+		return err
+	}
+	return afero.Walk(c.fs, c.historyDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != historyManifest {
+			return err
+		}
+		data, err := afero.ReadFile(c.fs, path)
+		if err != nil {
+			return err
+		}
+		m, err := unmarshalManifest(data)
+		if err != nil {
+			return nil // unreadable generations are never loaded
+		}
+		for path := range maps.Values(m.OutputData) {
+			if isBlobPath(path) {
+				referenced[path] = true
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,327 @@
+package granular
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestHistory(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open("/cache", WithFs(fs), WithHistory(2))
+	assertNoError(t, err, "Open")
+	key := cache.Key().String("target", "app").Build()
+	commit := func(version string) {
+		t.Helper()
+		assertNoError(t, afero.WriteFile(fs, "/src/app", []byte("binary "+version), 0o644), "writing source")
+		err := cache.Put(key).File("app", "/src/app").Bytes("log", []byte("log "+version)).Meta("version", version).Commit()
+		assertNoError(t, err, "Commit "+version)
+	}
+	versions := func(results []*Result) []string {
+		var versions []string
+		for _, r := range results {
+			data, err := afero.ReadFile(fs, r.File("app"))
+			assertNoError(t, err, "reading previous file")
+			assertEqual(t, string(data), "binary "+r.Meta("version"), "previous file")
+			assertEqual(t, string(r.Bytes("log")), "log "+r.Meta("version"), "previous data")
+			if !r.Valid() || r.Size() == 0 {
+				t.Errorf("previous result %s: Valid() = %v, Size() = %d", r.Meta("version"), r.Valid(), r.Size())
+			}
+			versions = append(versions, r.Meta("version"))
+		}
+		return versions
+	}
+
+	commit("v1")
+	history, err := cache.History(key)
+	assertNoError(t, err, "History")
+	if len(history) != 0 {
+		t.Fatalf("History of a new entry = %v, want none", versions(history))
+	}
+
+	commit("v2")
+	commit("v2") // unchanged outputs keep no generation
+	commit("v3")
+	history, err = cache.History(key)
+	assertNoError(t, err, "History")
+	assertEqual(t, fmt.Sprint(versions(history)), "[v2 v1]", "History")
+
+	commit("v4")
+	history, err = cache.History(key)
+	assertNoError(t, err, "History")
+	assertEqual(t, fmt.Sprint(versions(history)), "[v3 v2]", "History after v1 was dropped")
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	assertEqual(t, result.Meta("version"), "v4", "current version")
+	var chain []*Result
+	for r, err := result.Previous(); err == nil; r, err = r.Previous() {
+		chain = append(chain, r)
+	}
+	assertEqual(t, fmt.Sprint(versions(chain)), "[v3 v2]", "Previous chain")
+	if _, err := chain[len(chain)-1].Previous(); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Previous of the oldest result = %v, want ErrCacheMiss", err)
+	}
+
+	// History goes with the entry
+	assertNoError(t, cache.Delete(key), "Delete")
+	history, err = cache.History(key)
+	assertNoError(t, err, "History after Delete")
+	if len(history) != 0 {
+		t.Errorf("Expected 0 previous results after Delete, got %d", len(history))
+	}
+	if chain[0].Valid() {
+		t.Error("Expected a previous result to be invalid after Delete")
+	}
+}
+
+func TestHistory_Size(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open("/cache", WithFs(fs), WithHistory(2))
+	assertNoError(t, err, "Open")
+	key := cache.Key().String("target", "app").Build()
+	other := cache.Key().String("target", "lib").Build()
+	for _, data := range []string{"0123456789", "abcdefghij", "ABCDEFGHIJ"} {
+		assertNoError(t, cache.Put(key).Bytes("out", []byte(data)).Commit(), "Commit")
+	}
+	assertNoError(t, cache.Put(other).Bytes("out", []byte("lib")).Commit(), "Commit")
+
+	// Previous results count towards their entry
+	entries, err := cache.Entries()
+	assertNoError(t, err, "Entries")
+	for _, entry := range entries {
+		switch {
+		case entry.KeyHash == key.Hash() && entry.HistorySize <= 20:
+			t.Errorf("entry HistorySize = %d, want more than the 20 bytes of its two previous results", entry.HistorySize)
+		case entry.Size != entry.FileSize+entry.DataSize+entry.HistorySize:
+			t.Errorf("entry %s Size = %d, want FileSize + DataSize + HistorySize", entry.KeyHash, entry.Size)
+		}
+	}
+	stats, err := cache.Stats()
+	assertNoError(t, err, "Stats")
+	if stats.HistorySize == 0 || stats.TotalSize != stats.FileSize+stats.DataSize+stats.ManifestSize+stats.HistorySize {
+		t.Errorf("Stats = %+v, want the history counted in TotalSize", stats)
+	}
+
+	// Pruning to the size of the other entry evicts the history too
+	_, freed, err := cache.PruneToSize(3)
+	assertNoError(t, err, "PruneToSize")
+	if freed < 30 {
+		t.Errorf("PruneToSize freed %d bytes, want at least 30", freed)
+	}
+	if exists, _ := afero.DirExists(fs, "/cache/history/"+key.Hash()[:2]+"/"+key.Hash()); exists {
+		t.Error("Expected the history to be removed with its entry")
+	}
+
+	// GC removes history left behind by an entry removed as corrupted
+	for _, data := range []string{"one", "two"} {
+		assertNoError(t, cache.Put(key).Bytes("out", []byte(data)).Commit(), "Commit")
+	}
+	mPath, err := cache.manifestPath(key.Hash())
+	assertNoError(t, err, "manifestPath")
+	assertNoError(t, fs.Remove(mPath), "removing manifest")
+	removed, _, err := cache.GC()
+	assertNoError(t, err, "GC")
+	if removed == 0 {
+		t.Error("Expected GC to remove something")
+	}
+	history, err := cache.History(key)
+	assertNoError(t, err, "History after GC")
+	if len(history) != 0 {
+		t.Errorf("Expected 0 previous results after GC, got %d", len(history))
+	}
+}
+
+func TestHistory_Disabled(t *testing.T) {
+	cache := OpenTemp()
+	key := cache.Key().String("target", "app").Build()
+	for _, data := range []string{"one", "two"} {
+		assertNoError(t, cache.Put(key).Bytes("out", []byte(data)).Commit(), "Commit")
+	}
+	history, err := cache.History(key)
+	assertNoError(t, err, "History")
+	if len(history) != 0 {
+		t.Errorf("Expected 0 previous results without WithHistory, got %d", len(history))
+	}
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	if _, err := result.Previous(); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Previous without WithHistory = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestHistory_KeepsSharedBlobs(t *testing.T) {
+	cache, err := Open("/cache", WithFs(afero.NewMemMapFs()), WithHistory(1), WithDedup())
+	assertNoError(t, err, "Open")
+	key := cache.Key().String("target", "schema").Build()
+	assertNoError(t, cache.Put(key).Bytes("schema", []byte("old schema")).Commit(), "Commit")
+	assertNoError(t, cache.Put(key).Bytes("schema", []byte("new schema")).Commit(), "Commit")
+
+	_, _, err = cache.GC()
+	assertNoError(t, err, "GC")
+	history, err := cache.History(key)
+	assertNoError(t, err, "History")
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 previous result, got %d", len(history))
+	}
+	data, err := history[0].BytesErr("schema")
+	assertNoError(t, err, "reading previous blob after GC")
+	assertEqual(t, string(data), "old schema", "previous blob")
+}
+
+func TestHistory_Merge(t *testing.T) {
+	src := OpenTemp()
+	dst, err := Open("/cache", WithFs(afero.NewMemMapFs()), WithHistory(1))
+	assertNoError(t, err, "Open")
+	key := dst.Key().String("target", "app").Build()
+	assertNoError(t, src.Put(src.Key().String("target", "app").Build()).Bytes("out", []byte("merged")).Commit(), "Commit to source")
+	assertNoError(t, dst.Put(key).Bytes("out", []byte("local")).Commit(), "Commit")
+
+	_, err = dst.Merge(src, ConflictOverwrite)
+	assertNoError(t, err, "Merge")
+	history, err := dst.History(key)
+	assertNoError(t, err, "History")
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 previous result, got %d", len(history))
+	}
+	assertEqual(t, string(history[0].Bytes("out")), "local", "entry replaced by Merge")
+}
+
+func TestRollback(t *testing.T) {
+	cache, err := Open("/cache", WithFs(afero.NewMemMapFs()), WithHistory(2))
+	assertNoError(t, err, "Open")
+	key := cache.Key().String("target", "app").Build()
+	current := func() string {
+		t.Helper()
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, "Get")
+		return string(result.Bytes("out"))
+	}
+	for _, data := range []string{"good", "bad", "worse"} {
+		assertNoError(t, cache.Put(key).Bytes("out", []byte(data)).Commit(), "Commit "+data)
+	}
+
+	assertNoError(t, cache.Rollback(key, 2), "Rollback 2")
+	assertEqual(t, current(), "good", "data after Rollback 2")
+	history, err := cache.History(key)
+	assertNoError(t, err, "History")
+	var kept []string
+	for _, r := range history {
+		kept = append(kept, string(r.Bytes("out")))
+	}
+	// The restored result left the history; the replaced one went in, and
+	// pruning to two generations did not remove the restored one first
+	assertEqual(t, fmt.Sprint(kept), "[worse bad]", "history after Rollback")
+
+	// A rollback can be undone
+	assertNoError(t, cache.Rollback(key, 1), "Rollback 1")
+	assertEqual(t, current(), "worse", "data after undoing the rollback")
+
+	if err := cache.Rollback(key, 3); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Rollback past the history = %v, want ErrCacheMiss", err)
+	}
+	if err := cache.Rollback(key, 0); err == nil {
+		t.Error("Expected Rollback(key, 0) to fail")
+	}
+	assertEqual(t, current(), "worse", "data after failed rollbacks")
+
+	report, err := cache.Verify()
+	assertNoError(t, err, "Verify")
+	if !report.OK() {
+		t.Errorf("Cache failed verification after rollbacks: %+v", report)
+	}
+}
+
+func TestRollback_CorruptedEntry(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open("/cache", WithFs(fs), WithHistory(1))
+	assertNoError(t, err, "Open")
+	key := cache.Key().String("target", "app").Build()
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("good")).Commit(), "Commit")
+	assertNoError(t, cache.Put(key).Bytes("out", []byte("bad")).Commit(), "Commit")
+
+	// Get removes the corrupted entry but keeps its history
+	mPath, err := cache.manifestPath(key.Hash())
+	assertNoError(t, err, "manifestPath")
+	assertNoError(t, afero.WriteFile(fs, mPath, []byte("{"), 0o644), "corrupting manifest")
+	if _, err := cache.Get(key); err == nil {
+		t.Fatal("Expected Get of a corrupted entry to fail")
+	}
+
+	assertNoError(t, cache.Rollback(key, 1), "Rollback")
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get after Rollback")
+	assertEqual(t, string(result.Bytes("out")), "good", "restored data")
+}
+
+// failRenameFs fails renames to paths under dir that end in suffix.
+type failRenameFs struct {
+	afero.Fs
+	dir    string
+	suffix string
+}
+
+func (f *failRenameFs) Rename(oldname, newname string) error {
+	if f.dir != "" && strings.HasPrefix(newname, f.dir) && strings.HasSuffix(newname, f.suffix) {
+		return errors.New("injected rename failure")
+	}
+	return f.Fs.Rename(oldname, newname)
+}
+
+// TestRollback_Failure tests that a rollback failing to restore the previous
+// result puts it back into the history instead of losing it.
+func TestRollback_Failure(t *testing.T) {
+	tests := []struct {
+		name   string
+		dir    string
+		suffix string
+	}{
+		{"object", "objects", "file.app"},
+		{"manifest", "manifests", ".json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &failRenameFs{Fs: afero.NewMemMapFs()}
+			cache, err := Open("/cache", WithFs(fs), WithHistory(3), WithDurableWrites())
+			assertNoError(t, err, "Open")
+			key := cache.Key().String("target", "app").Build()
+			for _, data := range []string{"good", "bad", "worse"} {
+				assertNoError(t, afero.WriteFile(fs, "/src/app", []byte(data), 0o644), "writing source")
+				assertNoError(t, cache.Put(key).File("app", "/src/app").Bytes("out", []byte(data)).Commit(), "Commit "+data)
+			}
+
+			fs.dir, fs.suffix = filepath.Join("/cache", tt.dir), tt.suffix
+			if err := cache.Rollback(key, 1); err == nil {
+				t.Fatal("Expected Rollback to fail")
+			}
+			fs.dir = ""
+
+			history, err := cache.History(key)
+			assertNoError(t, err, "History")
+			var kept []string
+			for _, r := range history {
+				kept = append(kept, string(r.Bytes("out")))
+				data, err := afero.ReadFile(fs, r.File("app"))
+				assertNoError(t, err, "reading previous file")
+				assertEqual(t, string(data), string(r.Bytes("out")), "previous file")
+			}
+			assertEqual(t, fmt.Sprint(kept), "[bad worse good]", "history after failed Rollback")
+
+			// The result can still be restored once the failure is gone
+			assertNoError(t, cache.Rollback(key, 1), "Rollback")
+			result, err := cache.Get(key)
+			assertCacheHit(t, result, err, "Get after Rollback")
+			assertEqual(t, string(result.Bytes("out")), "bad", "restored data")
+
+			tmp, err := afero.ReadDir(fs, "/cache/tmp")
+			assertNoError(t, err, "reading staging directory")
+			if len(tmp) != 0 {
+				t.Errorf("Expected no staging directories left, got %d", len(tmp))
+			}
+		})
+	}
+}
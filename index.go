@@ -0,0 +1,245 @@
+package granular
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// indexFileName is the name of the index snapshot under the cache root.
+const indexFileName = "index.json"
+
+// indexVersion is the version of the index snapshot format. Snapshots with a
+// different version are ignored and the index is rebuilt.
+const indexVersion = 3
+
+// index is an in-memory summary of every entry, kept in step with the
+// manifests so Stats and Entries don't have to walk the cache.
+//
+// The snapshot on disk is only trusted if it was written by a clean Close:
+// the first change after it has been loaded or saved removes it, so a
+// process that crashes leaves no snapshot and the next Open rebuilds the
+// index from the manifests. The snapshot also records the modification
+// time of every manifest as the index last saw it. Open lists the manifests
+// and rebuilds the index if any was added, removed, or rewritten since, as
+// by another process or a Cache opened without WithIndex.
+type index struct {
+	mu       sync.Mutex
+	entries  map[string]Entry
+	modTimes map[string]int64 // manifest modification times, in Unix nanoseconds
+	dirty    bool             // true once the on-disk snapshot has been invalidated
+}
+
+// indexFile is the on-disk representation of the index.
+type indexFile struct {
+	Version  int              `json:"version"`
+	Entries  []Entry          `json:"entries"`
+	ModTimes map[string]int64 `json:"modTimes"`
+}
+
+// indexPath returns the path to the index snapshot.
+func (c *Cache) indexPath() string {
+	return filepath.Join(c.root, indexFileName)
+}
+
+// loadIndex loads the index snapshot, or rebuilds the index by walking the
+// manifests if there is no usable snapshot or the manifests have changed
+// since it was saved.
+func (c *Cache) loadIndex() error {
+	if data, err := afero.ReadFile(c.fs, c.indexPath()); err == nil {
+		var f indexFile
+		if json.Unmarshal(data, &f) == nil && f.Version == indexVersion {
+			modTimes, err := c.manifestModTimes()
+			if err != nil {
+				return err
+			}
+			if maps.Equal(modTimes, f.ModTimes) {
+				c.index.entries = make(map[string]Entry, len(f.Entries))
+				for _, entry := range f.Entries {
+					c.index.entries[entry.KeyHash] = entry
+				}
+				c.index.modTimes = modTimes
+				return nil
+			}
+		}
+	}
+	return c.rebuildIndex()
+}
+
+// rebuildIndex replaces the index contents with the entries found by walking
+// the manifests. Caller must hold c.mu (or be opening the cache).
+func (c *Cache) rebuildIndex() error {
+	// Modification times are taken first: a manifest rewritten during the
+	// walk then fails the check on the next Open rather than passing it
+	modTimes, err := c.manifestModTimes()
+	if err != nil {
+		return err
+	}
+	var walkErr error
+	entries := make(map[string]Entry)
+	for entry := range c.entriesIn("", &walkErr, nil) {
+		entries[entry.KeyHash] = entry
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	c.index.entries = entries
+	c.index.modTimes = modTimes
+	c.index.invalidate(c.fs, c.indexPath())
+	return nil
+}
+
+// manifestModTimes lists the manifests with their modification times,
+// without reading them.
+func (c *Cache) manifestModTimes() (map[string]int64, error) {
+	modTimes := make(map[string]int64)
+	err := afero.Walk(c.fs, c.manifestDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && path == c.manifestDir() {
+				return nil
+			}
+			return err
+		}
+		if keyHash, ok := strings.CutSuffix(info.Name(), ".json"); ok && !info.IsDir() {
+			modTimes[keyHash] = info.ModTime().UnixNano()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests: %w", err)
+	}
+	return modTimes, nil
+}
+
+// RebuildIndex rebuilds the index enabled by WithIndex from the manifests on
+// disk. Use it after another process has modified the cache directory.
+// It is a no-op if the index is not enabled.
+func (c *Cache) RebuildIndex() error {
+	if c.index == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rebuildIndex()
+}
+
+// saveIndex writes the index snapshot if it has changed since it was loaded.
+func (c *Cache) saveIndex() error {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+
+	if !c.index.dirty {
+		return nil
+	}
+	f := indexFile{
+		Version: indexVersion,
+		Entries: slices.SortedFunc(maps.Values(c.index.entries), func(a, b Entry) int {
+			return strings.Compare(a.KeyHash, b.KeyHash)
+		}),
+		ModTimes: c.index.modTimes,
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	if err := atomicWriteFile(c.fs, c.indexPath(), data, c.fileMode, c.durable); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	c.index.dirty = false
+	return nil
+}
+
+// invalidate removes the on-disk snapshot before the first change to the
+// in-memory index. Caller must hold idx.mu.
+func (idx *index) invalidate(fs afero.Fs, path string) {
+	if idx.dirty {
+		return
+	}
+	if err := fs.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Leave dirty unset so the next change retries; a stale snapshot
+		// must never survive a change.
+		return
+	}
+	idx.dirty = true
+}
+
+// indexPut records the entry described by m, just written to mPath, if
+// the index is enabled.
+func (c *Cache) indexPut(m *manifest, mPath string) {
+	if c.index == nil {
+		return
+	}
+	entry := c.newEntry(m.KeyHash, m)
+	info, statErr := c.fs.Stat(mPath)
+
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	c.index.invalidate(c.fs, c.indexPath())
+	c.index.entries[m.KeyHash] = entry
+	if statErr != nil {
+		// Without a time the snapshot cannot match, so the next Open
+		// rebuilds the index
+		delete(c.index.modTimes, m.KeyHash)
+		return
+	}
+	c.index.modTimes[m.KeyHash] = info.ModTime().UnixNano()
+}
+
+// indexRemove forgets the entry with the given key hash, if the index is enabled.
+func (c *Cache) indexRemove(keyHash string) {
+	if c.index == nil {
+		return
+	}
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	if _, ok := c.index.entries[keyHash]; !ok {
+		return
+	}
+	c.index.invalidate(c.fs, c.indexPath())
+	delete(c.index.entries, keyHash)
+	delete(c.index.modTimes, keyHash)
+}
+
+// indexReset forgets all entries, if the index is enabled.
+func (c *Cache) indexReset() {
+	if c.index == nil {
+		return
+	}
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	c.index.invalidate(c.fs, c.indexPath())
+	clear(c.index.entries)
+	clear(c.index.modTimes)
+}
+
+// indexedEntries returns copies of the indexed entries in c's namespace,
+// sorted by key hash.
+func (c *Cache) indexedEntries() []Entry {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+
+	entries := make([]Entry, 0, len(c.index.entries))
+	for _, entry := range c.index.entries {
+		if !inNamespace(c.namespace, entry.Namespace) {
+			continue
+		}
+		entry.Tags = maps.Clone(entry.Tags)
+		entry.Meta = maps.Clone(entry.Meta)
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.KeyHash, b.KeyHash)
+	})
+	return entries
+}
@@ -0,0 +1,164 @@
+package granular
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestWithIndex_MatchesWalk(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	indexed, err := Open(".cache", WithFs(fs), WithIndex())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	walked, err := Open(".cache", WithFs(fs))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	keys := []Key{
+		putSized(t, indexed, "a", 10),
+		putSized(t, indexed, "b", 20),
+		putSized(t, indexed, "c", 40),
+	}
+	if _, err := indexed.Get(keys[0]); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := indexed.Delete(keys[1]); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	got, err := indexed.Stats()
+	assertNoError(t, err, "indexed Stats")
+	want, err := walked.Stats()
+	assertNoError(t, err, "walked Stats")
+	if got.Entries != want.Entries || got.TotalSize != want.TotalSize {
+		t.Errorf("indexed Stats = %+v, walked Stats = %+v", got, want)
+	}
+
+	gotEntries, err := indexed.Entries()
+	assertNoError(t, err, "indexed Entries")
+	wantEntries, err := walked.Entries()
+	assertNoError(t, err, "walked Entries")
+	if len(gotEntries) != len(wantEntries) {
+		t.Fatalf("indexed Entries has %d entries, walked has %d", len(gotEntries), len(wantEntries))
+	}
+	for i := range gotEntries {
+		g, w := gotEntries[i], wantEntries[i]
+		if g.KeyHash != w.KeyHash || g.Size != w.Size || !g.AccessedAt.Equal(w.AccessedAt) {
+			t.Errorf("entry %d: indexed %+v, walked %+v", i, g, w)
+		}
+	}
+
+	if err := indexed.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	stats, err := indexed.Stats()
+	assertNoError(t, err, "Stats after Clear")
+	if stats.Entries != 0 {
+		t.Errorf("Expected 0 entries after Clear, got %d", stats.Entries)
+	}
+}
+
+func TestWithIndex_SnapshotOnClose(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithIndex())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := putSized(t, cache, "a", 10)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	indexPath := filepath.Join(".cache", indexFileName)
+	if exists, _ := afero.Exists(fs, indexPath); !exists {
+		t.Fatal("Expected index snapshot after Close")
+	}
+
+	// An unchanged cache is answered from the snapshot
+	reopened, err := Open(".cache", WithFs(fs), WithIndex())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	stats, _ := reopened.Stats()
+	if stats.Entries != 1 {
+		t.Fatalf("Expected snapshot with 1 entry, got %d", stats.Entries)
+	}
+	assertNoError(t, reopened.Close(), "Close")
+
+	// Remove the manifest behind the index's back: the reopened cache
+	// notices and rebuilds the index
+	manifestPath, err := cache.manifestPath(key.Hash())
+	assertNoError(t, err, "manifestPath")
+	assertNoError(t, fs.Remove(manifestPath), "remove manifest")
+
+	reopened, err = Open(".cache", WithFs(fs), WithIndex())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	stats, _ = reopened.Stats()
+	if stats.Entries != 0 {
+		t.Errorf("Expected 0 entries after the manifest was removed, got %d", stats.Entries)
+	}
+}
+
+// TestWithIndex_StaleSnapshot tests that Open rebuilds the index when a
+// Cache opened without WithIndex changed the manifests after the snapshot
+// was saved.
+func TestWithIndex_StaleSnapshot(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithIndex())
+	assertNoError(t, err, "Open")
+	replaced := putSized(t, cache, "a", 10)
+	deleted := putSized(t, cache, "b", 10)
+	assertNoError(t, cache.Close(), "Close")
+
+	plain, err := Open(".cache", WithFs(fs))
+	assertNoError(t, err, "Open without WithIndex")
+	assertNoError(t, plain.Put(replaced).Bytes("data", make([]byte, 30)).Commit(), "Commit")
+	assertNoError(t, plain.Delete(deleted), "Delete")
+	putSized(t, plain, "c", 5)
+
+	reopened, err := Open(".cache", WithFs(fs), WithIndex())
+	assertNoError(t, err, "Open")
+	stats, err := reopened.Stats()
+	assertNoError(t, err, "Stats")
+	if stats.Entries != 2 || stats.DataSize != 35 {
+		t.Errorf("Expected rebuilt index with 2 entries / 35 bytes, got %+v", stats)
+	}
+}
+
+func TestWithIndex_RebuiltAfterCrash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithIndex())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	putSized(t, cache, "a", 10)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Reopen and change the cache, then "crash" without Close
+	cache, err = Open(".cache", WithFs(fs), WithIndex())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	putSized(t, cache, "b", 20)
+
+	if exists, _ := afero.Exists(fs, filepath.Join(".cache", indexFileName)); exists {
+		t.Fatal("Snapshot must be removed once the index changes")
+	}
+
+	reopened, err := Open(".cache", WithFs(fs), WithIndex())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	stats, _ := reopened.Stats()
+	if stats.Entries != 2 || stats.DataSize != 30 {
+		t.Errorf("Expected rebuilt index with 2 entries / 30 bytes, got %+v", stats)
+	}
+}
@@ -0,0 +1,282 @@
+// Package blake3 implements the BLAKE3 hash function with a 256-bit output.
+//
+// BLAKE3 hashes its input as a tree of 1KB chunks, so independent parts of
+// a large input can be hashed at the same time. Digest buffers large writes
+// and hashes whole subtrees of them on all available cores; small inputs are
+// hashed on the calling goroutine. The digest is the same either way.
+package blake3
+
+//go:generate go run gen.go
+
+import (
+	"encoding/binary"
+	"hash"
+	"runtime"
+	"sync"
+)
+
+// Size is the size of a BLAKE3 digest in bytes.
+const Size = 32
+
+const (
+	blockLen = 64
+	chunkLen = 1024
+
+	// subtreeChunks is the number of chunks hashed by one goroutine. It
+	// must be a power of two so that subtrees are complete.
+	subtreeChunks = 64
+	subtreeLen    = subtreeChunks * chunkLen
+)
+
+// Domain separation flags.
+const (
+	flagChunkStart = 1 << 0
+	flagChunkEnd   = 1 << 1
+	flagParent     = 1 << 2
+	flagRoot       = 1 << 3
+)
+
+var iv = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+// words decodes a block of up to 64 bytes, zero-padded.
+func words(b []byte) [16]uint32 {
+	if len(b) < blockLen {
+		var padded [blockLen]byte
+		copy(padded[:], b)
+		b = padded[:]
+	}
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return m
+}
+
+// output is a compression that has not run yet: it yields either a chaining
+// value or, with the root flag, the digest.
+type output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *output) chainingValue() [8]uint32 {
+	s := compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	return [8]uint32(s[:8])
+}
+
+func (o *output) root(b []byte) []byte {
+	s := compress(&o.cv, &o.block, 0, o.blockLen, o.flags|flagRoot)
+	for _, w := range s[:8] {
+		b = binary.LittleEndian.AppendUint32(b, w)
+	}
+	return b
+}
+
+func parentOutput(left, right [8]uint32) output {
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return output{cv: iv, block: block, blockLen: blockLen, flags: flagParent}
+}
+
+// chunkState hashes the blocks of one chunk.
+type chunkState struct {
+	cv         [8]uint32
+	counter    uint64
+	block      [blockLen]byte
+	blockLen   int
+	compressed int
+}
+
+func newChunkState(counter uint64) chunkState {
+	return chunkState{cv: iv, counter: counter}
+}
+
+func (cs *chunkState) len() int {
+	return blockLen*cs.compressed + cs.blockLen
+}
+
+func (cs *chunkState) startFlag() uint32 {
+	if cs.compressed == 0 {
+		return flagChunkStart
+	}
+	return 0
+}
+
+func (cs *chunkState) update(p []byte) {
+	for len(p) > 0 {
+		// The last block is compressed by output, with the chunk end flag
+		if cs.blockLen == blockLen {
+			m := words(cs.block[:])
+			s := compress(&cs.cv, &m, cs.counter, blockLen, cs.startFlag())
+			cs.cv = [8]uint32(s[:8])
+			cs.compressed++
+			cs.blockLen = 0
+		}
+		n := copy(cs.block[cs.blockLen:], p)
+		cs.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (cs *chunkState) output() output {
+	return output{
+		cv:       cs.cv,
+		block:    words(cs.block[:cs.blockLen]),
+		counter:  cs.counter,
+		blockLen: uint32(cs.blockLen),
+		flags:    cs.startFlag() | flagChunkEnd,
+	}
+}
+
+// chunkCV returns the chaining value of a complete, non-root chunk. It is
+// what chunkState computes, without copying the blocks.
+func chunkCV(chunk []byte, counter uint64) [8]uint32 {
+	cv := iv
+	for i := 0; i < chunkLen; i += blockLen {
+		var flags uint32
+		switch i {
+		case 0:
+			flags = flagChunkStart
+		case chunkLen - blockLen:
+			flags = flagChunkEnd
+		}
+		m := words(chunk[i : i+blockLen])
+		s := compress(&cv, &m, counter, blockLen, flags)
+		cv = [8]uint32(s[:8])
+	}
+	return cv
+}
+
+// subtreeCV returns the chaining value of a complete, non-root subtree of
+// subtreeChunks chunks starting at chunk counter.
+func subtreeCV(data []byte, counter uint64) [8]uint32 {
+	var cvs [subtreeChunks][8]uint32
+	for i := range cvs {
+		cvs[i] = chunkCV(data[i*chunkLen:(i+1)*chunkLen], counter+uint64(i))
+	}
+	for n := subtreeChunks; n > 1; n /= 2 {
+		for i := range n / 2 {
+			o := parentOutput(cvs[2*i], cvs[2*i+1])
+			cvs[i] = o.chainingValue()
+		}
+	}
+	return cvs[0]
+}
+
+// Digest is a BLAKE3 hash.Hash. The zero value is not usable; use New.
+type Digest struct {
+	// stack holds the chaining values of the complete subtrees hashed so
+	// far, largest first, one per set bit of chunks
+	stack  [][8]uint32
+	chunks uint64 // Number of chunks covered by stack
+
+	// buf holds input not yet covered by stack. At least one byte is always
+	// kept back so that the last chunk can be finalized as the root.
+	buf []byte
+
+	workers int // Subtrees hashed concurrently
+}
+
+// New returns a BLAKE3 hash computing a 32-byte digest, hashing large
+// inputs on up to GOMAXPROCS goroutines.
+func New() hash.Hash {
+	return &Digest{workers: runtime.GOMAXPROCS(0)}
+}
+
+// Size returns the digest size in bytes.
+func (d *Digest) Size() int { return Size }
+
+// BlockSize returns the BLAKE3 block size in bytes.
+func (d *Digest) BlockSize() int { return blockLen }
+
+// Reset resets the hash to its initial state.
+func (d *Digest) Reset() {
+	d.stack = d.stack[:0]
+	d.chunks = 0
+	d.buf = d.buf[:0]
+}
+
+// Write adds p to the input. It never returns an error.
+func (d *Digest) Write(p []byte) (int, error) {
+	batch := d.workers * subtreeLen
+	n := len(p)
+	for len(d.buf)+len(p) > batch {
+		if len(d.buf) == 0 && len(p) > batch {
+			// Hash straight from p when nothing is buffered
+			d.hashSubtrees(p[:batch])
+			p = p[batch:]
+			continue
+		}
+		take := batch - len(d.buf)
+		d.buf = append(d.buf, p[:take]...)
+		p = p[take:]
+		if len(p) == 0 {
+			break // Keep the batch back: it may hold the root chunk
+		}
+		d.hashSubtrees(d.buf)
+		d.buf = d.buf[:0]
+	}
+	d.buf = append(d.buf, p...)
+	return n, nil
+}
+
+// hashSubtrees hashes data, a whole number of subtrees that is not the end
+// of the input, concurrently and pushes the subtrees on the stack.
+func (d *Digest) hashSubtrees(data []byte) {
+	cvs := make([][8]uint32, len(data)/subtreeLen)
+	var wg sync.WaitGroup
+	for i := range cvs {
+		wg.Go(func() {
+			cvs[i] = subtreeCV(data[i*subtreeLen:(i+1)*subtreeLen], d.chunks+uint64(i*subtreeChunks))
+		})
+	}
+	wg.Wait()
+	for _, cv := range cvs {
+		d.push(cv, subtreeChunks)
+	}
+}
+
+// push adds the chaining value of a complete subtree of n chunks, merging
+// it with the subtrees on the stack it completes, like carries in binary
+// addition.
+func (d *Digest) push(cv [8]uint32, n uint64) {
+	d.chunks += n
+	for units := d.chunks / n; units&1 == 0; units >>= 1 {
+		o := parentOutput(d.stack[len(d.stack)-1], cv)
+		cv = o.chainingValue()
+		d.stack = d.stack[:len(d.stack)-1]
+	}
+	d.stack = append(d.stack, cv)
+}
+
+// Sum appends the digest of the input written so far to b. It does not
+// change the state of the hash.
+func (d *Digest) Sum(b []byte) []byte {
+	// Hash the buffered tail serially on a copy of the stack, holding back
+	// the last chunk for the root
+	t := Digest{stack: append([][8]uint32(nil), d.stack...), chunks: d.chunks}
+	cs := newChunkState(t.chunks)
+	for p := d.buf; len(p) > 0; {
+		if cs.len() == chunkLen {
+			o := cs.output()
+			t.push(o.chainingValue(), 1)
+			cs = newChunkState(t.chunks)
+		}
+		n := min(chunkLen-cs.len(), len(p))
+		cs.update(p[:n])
+		p = p[n:]
+	}
+
+	o := cs.output()
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		o = parentOutput(t.stack[i], o.chainingValue())
+	}
+	return o.root(b)
+}
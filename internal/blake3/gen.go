@@ -0,0 +1,89 @@
+//go:build ignore
+
+// gen writes compress.go: the BLAKE3 compression function with its seven
+// rounds unrolled and the message permutation folded into the word indices.
+//
+// Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+)
+
+var msgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+// quarterRounds are the state words mixed by each quarter-round of a round:
+// columns, then diagonals.
+var quarterRounds = [8][4]int{
+	{0, 4, 8, 12},
+	{1, 5, 9, 13},
+	{2, 6, 10, 14},
+	{3, 7, 11, 15},
+	{0, 5, 10, 15},
+	{1, 6, 11, 12},
+	{2, 7, 8, 13},
+	{3, 4, 9, 14},
+}
+
+func main() {
+	var b bytes.Buffer
+	b.WriteString(`// Code generated by gen.go; DO NOT EDIT.
+
+package blake3
+
+import "math/bits"
+
+// compress runs the compression function on one block and returns the full
+// 16-word state; the first 8 words are the new chaining value. The seven
+// rounds are unrolled with the message permutation applied to the word
+// indices, which keeps the state in registers.
+func compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	m0, m1, m2, m3, m4, m5, m6, m7 := block[0], block[1], block[2], block[3], block[4], block[5], block[6], block[7]
+	m8, m9, m10, m11, m12, m13, m14, m15 := block[8], block[9], block[10], block[11], block[12], block[13], block[14], block[15]
+	s0, s1, s2, s3, s4, s5, s6, s7 := cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7]
+	s8, s9, s10, s11 := iv[0], iv[1], iv[2], iv[3]
+	s12, s13, s14, s15 := uint32(counter), uint32(counter>>32), blockLen, flags
+`)
+
+	schedule := [16]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	for r := range 7 {
+		fmt.Fprintf(&b, "\n\t// Round %d\n", r+1)
+		for i, q := range quarterRounds {
+			a, bb, c, d := q[0], q[1], q[2], q[3]
+			mx, my := schedule[2*i], schedule[2*i+1]
+			fmt.Fprintf(&b, "\ts%d += s%d + m%d\n", a, bb, mx)
+			fmt.Fprintf(&b, "\ts%d = bits.RotateLeft32(s%d^s%d, -16)\n", d, d, a)
+			fmt.Fprintf(&b, "\ts%d += s%d\n", c, d)
+			fmt.Fprintf(&b, "\ts%d = bits.RotateLeft32(s%d^s%d, -12)\n", bb, bb, c)
+			fmt.Fprintf(&b, "\ts%d += s%d + m%d\n", a, bb, my)
+			fmt.Fprintf(&b, "\ts%d = bits.RotateLeft32(s%d^s%d, -8)\n", d, d, a)
+			fmt.Fprintf(&b, "\ts%d += s%d\n", c, d)
+			fmt.Fprintf(&b, "\ts%d = bits.RotateLeft32(s%d^s%d, -7)\n", bb, bb, c)
+		}
+		var next [16]int
+		for i, j := range msgPermutation {
+			next[i] = schedule[j]
+		}
+		schedule = next
+	}
+
+	b.WriteString(`
+	return [16]uint32{
+		s0 ^ s8, s1 ^ s9, s2 ^ s10, s3 ^ s11, s4 ^ s12, s5 ^ s13, s6 ^ s14, s7 ^ s15,
+		s8 ^ cv[0], s9 ^ cv[1], s10 ^ cv[2], s11 ^ cv[3], s12 ^ cv[4], s13 ^ cv[5], s14 ^ cv[6], s15 ^ cv[7],
+	}
+}
+`)
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("compress.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
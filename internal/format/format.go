@@ -0,0 +1,56 @@
+// Package format defines granular's on-disk manifest format.
+//
+// The manifest is an implementation detail of the cache and is kept internal
+// so its Go representation can evolve without breaking the public API.
+// Compatibility of the persisted JSON is tracked by the Version field.
+package format
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Version is the manifest format version written by this release.
+// Version 0 is the legacy format that did not record the hash algorithm.
+const Version = 1
+
+// Manifest describes a single cached computation.
+type Manifest struct {
+	// Manifest metadata
+	Version  int    `json:"version"`  // Manifest format version (0 = legacy, 1 = current)
+	HashAlgo string `json:"hashAlgo"` // Hash algorithm identifier (e.g., "xxhash64")
+
+	// Key information
+	KeyHash    string            `json:"keyHash"`             // Hash of the key
+	Namespace  string            `json:"namespace,omitempty"` // Namespace the key was built in
+	InputDescs []string          `json:"inputs"`              // String descriptions of inputs
+	ExtraData  map[string]string `json:"extra"`               // Extra key components
+
+	// Result information (multi-file support)
+	OutputFiles map[string]string `json:"outputs"`    // name -> cached file path
+	OutputData  map[string]string `json:"outputData"` // name -> path to .dat file
+	OutputMeta  map[string]string `json:"outputMeta"` // metadata key-value pairs
+	OutputHash  string            `json:"outputHash"` // Hash of outputs
+	Compression string            `json:"compression,omitzero"`
+	Tags        map[string]string `json:"tags,omitempty"`        // entry labels for grouping and queries
+	RefreshAt   time.Time         `json:"refreshAt,omitzero"`    // When the entry passes its soft TTL; zero if it has none
+	Description string            `json:"description,omitempty"` // Human-readable summary set with WriteBuilder.Describe
+
+	// Metadata
+	CreatedAt  time.Time `json:"createdAt"`  // When the cache entry was created
+	AccessedAt time.Time `json:"accessedAt"` // When the cache entry was last accessed
+}
+
+// Marshal encodes a manifest as indented JSON.
+func Marshal(m *Manifest) ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Unmarshal decodes a manifest from JSON.
+func Unmarshal(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,92 @@
+// Package iobuf provides the pooled I/O buffers shared by hashing and
+// file copies, so large transfers do not allocate a buffer per call, and
+// progress reporting for those transfers.
+package iobuf
+
+import (
+	"io"
+	"sync"
+)
+
+// Size is the size of the default pooled buffers.
+const Size = 32 * 1024 // 32KB
+
+// Pool is a pool of byte slices of one size used for streaming file I/O.
+type Pool struct {
+	pool sync.Pool
+}
+
+// pools holds one Pool per buffer size, shared by every cache using that
+// size.
+var pools sync.Map // int -> *Pool
+
+// For returns the pool of buffers of the given size, or of Size if size is
+// not positive.
+func For(size int) *Pool {
+	if size <= 0 {
+		size = Size
+	}
+	if p, ok := pools.Load(size); ok {
+		return p.(*Pool)
+	}
+	p := &Pool{}
+	p.pool.New = func() any {
+		buffer := make([]byte, size)
+		return &buffer
+	}
+	actual, _ := pools.LoadOrStore(size, p)
+	return actual.(*Pool)
+}
+
+// Get returns a buffer from the pool. Callers must return it with Put.
+func (p *Pool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put returns a buffer obtained from Get to the pool.
+func (p *Pool) Put(buf *[]byte) {
+	p.pool.Put(buf)
+}
+
+// Get returns a buffer of Size bytes from the default pool. Callers must
+// return it with Put.
+func Get() *[]byte {
+	return For(Size).Get()
+}
+
+// Put returns a buffer obtained from Get to the default pool.
+func Put(buf *[]byte) {
+	For(Size).Put(buf)
+}
+
+// ProgressInterval is the number of bytes read between calls to a
+// ProgressReader's Report function.
+const ProgressInterval = 4 << 20 // 4MB
+
+// ProgressReader reports how many bytes have been read from R every
+// ProgressInterval bytes.
+type ProgressReader struct {
+	R      io.Reader
+	Report func(n int64)
+
+	n    int64
+	next int64
+}
+
+func (p *ProgressReader) Read(b []byte) (int, error) {
+	n, err := p.R.Read(b)
+	p.n += int64(n)
+	if p.next == 0 {
+		p.next = ProgressInterval
+	}
+	if p.n >= p.next {
+		p.Report(p.n)
+		p.next = p.n + ProgressInterval
+	}
+	return n, err
+}
+
+// N returns the number of bytes read so far.
+func (p *ProgressReader) N() int64 {
+	return p.n
+}
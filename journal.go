@@ -0,0 +1,121 @@
+package granular
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// journalDir returns the path to the write journal directory.
+func (c *Cache) journalDir() string {
+	return filepath.Join(c.root, "journal")
+}
+
+// beginJournal records an in-flight commit for keyHash. The record is
+// durable before any object is written, and is refreshed in the background,
+// like a key lock, until the returned function is called to remove it once
+// the commit has completed or been rolled back.
+func (c *Cache) beginJournal(keyHash string) (end func(), err error) {
+	if err := c.mkdirAll(c.journalDir()); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	path := filepath.Join(c.journalDir(), keyHash+"."+randomSuffix())
+	if err := writeFileSynced(c.fs, path, []byte(keyHash), defaultFileMode); err != nil {
+		return nil, fmt.Errorf("failed to write journal record: %w", err)
+	}
+	if err := c.chmodFile(path); err != nil {
+		_ = c.fs.Remove(path)
+		return nil, fmt.Errorf("failed to write journal record: %w", err)
+	}
+	now := c.now()
+	_ = c.fs.Chtimes(path, now, now)
+	syncDir(c.fs, c.journalDir())
+
+	stop := c.keepAlive(path, lockHeartbeat)
+	return func() {
+		stop()
+		c.endJournal(path)
+	}, nil
+}
+
+// endJournal removes a journal record once its commit has completed or been
+// rolled back.
+func (c *Cache) endJournal(path string) {
+	_ = c.fs.Remove(path)
+}
+
+// replayJournal recovers commits that were interrupted by a crash or power
+// loss. A record whose owner has not refreshed it for lockStaleAfter belongs
+// to a process that is gone, however long ago its commit started; records
+// still being refreshed belong to commits in progress and are left alone.
+// For every abandoned record, the entry is kept only if its manifest exists
+// and its outputs verify; otherwise any partial objects and manifest are
+// removed. Processed records are deleted.
+func (c *Cache) replayJournal() error {
+	infos, err := afero.ReadDir(c.fs, c.journalDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	cutoff := c.now().Add(-lockStaleAfter)
+	for _, info := range infos {
+		if info.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		keyHash, _, _ := strings.Cut(info.Name(), ".")
+
+		if !c.entryIntact(keyHash) {
+			if err := c.removeByHash(keyHash); err != nil {
+				return fmt.Errorf("failed to remove interrupted entry %s: %w", keyHash, err)
+			}
+		}
+		c.endJournal(filepath.Join(c.journalDir(), info.Name()))
+	}
+	return nil
+}
+
+// entryIntact reports whether the entry for keyHash has a readable manifest
+// whose outputs match the stored hash.
+func (c *Cache) entryIntact(keyHash string) bool {
+	m, err := c.loadManifest(keyHash)
+	if err != nil {
+		return false
+	}
+	return c.verifyOutputHash(m) == nil
+}
+
+// writeFileSynced writes data to path and flushes it to stable storage
+// before closing the file.
+func writeFileSynced(fs afero.Fs, path string, data []byte, perm os.FileMode) error {
+	f, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, writeErr := f.Write(data)
+	syncErr := f.Sync()
+	closeErr := f.Close()
+	if writeErr != nil {
+		return writeErr
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+// syncDir flushes a directory's entries (new files, renames) to stable storage.
+// Best effort: some platforms and filesystems do not support syncing directories.
+func syncDir(fs afero.Fs, dir string) {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,99 @@
+package granular
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// TestDurableWrites_RoundTrip tests that durable commits work and leave no journal records.
+func TestDurableWrites_RoundTrip(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithDurableWrites())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	afero.WriteFile(fs, "out.txt", []byte("output"), 0o644)
+	key := cache.Key().String("k", "v").Build()
+	if err := cache.Put(key).File("out", "out.txt").Bytes("data", []byte("payload")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	result, err := cache.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := string(result.Bytes("data")); got != "payload" {
+		t.Errorf("Expected %q, got %q", "payload", got)
+	}
+
+	records, err := afero.ReadDir(fs, cache.journalDir())
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("Expected empty journal after commit, got %d records", len(records))
+	}
+}
+
+// TestDurableWrites_ReplayJournal tests that Open rolls back interrupted commits.
+func TestDurableWrites_ReplayJournal(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache, err := Open(".cache", WithFs(fs), WithNowFunc(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// A complete entry whose journal record was never removed
+	completeKey := cache.Key().String("k", "complete").Build()
+	if err := cache.Put(completeKey).Bytes("data", []byte("ok")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// An interrupted commit: objects written, manifest never saved
+	interrupted := "ab00112233445566"
+	objectDir, _ := cache.objectPath(interrupted)
+	afero.WriteFile(fs, filepath.Join(objectDir, "data.data.dat"), nil, 0o644)
+
+	// A commit that may still be in progress in another process
+	inProgress := "cd00112233445566"
+	inProgressDir, _ := cache.objectPath(inProgress)
+	afero.WriteFile(fs, filepath.Join(inProgressDir, "data.data.dat"), nil, 0o644)
+
+	fs.MkdirAll(cache.journalDir(), 0o755)
+	// Abandoned minutes ago: the owner stopped refreshing the record
+	stale := now.Add(-2 * lockStaleAfter)
+	for _, keyHash := range []string{completeKey.Hash(), interrupted} {
+		path := filepath.Join(cache.journalDir(), keyHash+".1")
+		afero.WriteFile(fs, path, []byte(keyHash), 0o644)
+		fs.Chtimes(path, stale, stale)
+	}
+	freshRecord := filepath.Join(cache.journalDir(), inProgress+".1")
+	afero.WriteFile(fs, freshRecord, []byte(inProgress), 0o644)
+	fs.Chtimes(freshRecord, now, now)
+
+	// Reopen with durable writes to replay the journal
+	cache, err = Open(".cache", WithFs(fs), WithDurableWrites(), WithNowFunc(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if !cache.Has(completeKey) {
+		t.Error("Expected complete entry to survive replay")
+	}
+	if exists, _ := afero.DirExists(fs, objectDir); exists {
+		t.Error("Expected interrupted commit objects to be removed")
+	}
+	if exists, _ := afero.DirExists(fs, inProgressDir); !exists {
+		t.Error("Expected in-progress commit objects to be left alone")
+	}
+
+	records, _ := afero.ReadDir(fs, cache.journalDir())
+	if len(records) != 1 || records[0].Name() != filepath.Base(freshRecord) {
+		t.Errorf("Expected only the fresh journal record to remain, got %d records", len(records))
+	}
+}
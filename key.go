@@ -2,14 +2,20 @@ package granular
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"hash"
+	"log/slog"
 	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/gophersatwork/granular/hashing"
 	"github.com/spf13/afero"
 )
 
@@ -26,148 +32,145 @@ type KeyBuilder struct {
 
 // Key represents an opaque cache key.
 // Users should not construct this directly, use Cache.Key() instead.
+//
+// A Key hashes its inputs once: the first successful Hash, Get, Has, or
+// Commit reads the input files, and later calls (on the key or its copies)
+// reuse the result. Build a new Key to pick up changes to the input files.
 type Key struct {
 	inputs []input
 	extras map[string]string
 	cache  *Cache
-	errors []error // Validation errors from key building
+	errors []error   // Validation errors from key building
+	memo   *hashMemo // Hash shared by copies of the key; nil for the zero Key
+}
+
+// hashMemo holds the hash of a key once it has been computed. Failures are
+// not remembered, so a key whose files were briefly unreadable can be
+// hashed again.
+type hashMemo struct {
+	mu   sync.Mutex
+	hash string
 }
 
 // input is the internal interface for cache inputs.
 // This is not exported - users interact via KeyBuilder methods.
 type input interface {
-	hash(h hash.Hash, fs afero.Fs) error
+	hash(h hash.Hash, hs *hashing.Hasher, fs afero.Fs) error
+	// describe returns the descriptor hashed into the key, with paths in
+	// the form hs hashes them.
+	describe(hs *hashing.Hasher) string
 	String() string
 }
 
 // fileInput represents a single file input.
 type fileInput struct {
-	path string
+	path     string
+	optional bool // Added with FileIfExists: a missing file is hashed as absent
 }
 
-func (f fileInput) hash(h hash.Hash, fs afero.Fs) error {
-	file, err := fs.Open(f.path)
+func (f fileInput) hash(h hash.Hash, hs *hashing.Hasher, fs afero.Fs) error {
+	if !f.optional {
+		return hs.File(h, fs, f.path)
+	}
+
+	// Mark presence so a missing file and an empty one hash differently
+	exists, err := afero.Exists(fs, f.path)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", f.path, err)
+		return fmt.Errorf("failed to check file %s: %w", f.path, err)
+	}
+	if !exists {
+		hashing.Field(h, "absent")
+		return nil
 	}
-	defer file.Close()
+	hashing.Field(h, "present")
+	return hs.File(h, fs, f.path)
+}
 
-	if err := hashFile(file, h); err != nil {
-		return fmt.Errorf("failed to hash file %s: %w", f.path, err)
+func (f fileInput) describe(hs *hashing.Hasher) string {
+	if f.optional {
+		return fmt.Sprintf("file?:%s", hs.Path(f.path))
 	}
-	return nil
+	return fmt.Sprintf("file:%s", hs.Path(f.path))
 }
 
 func (f fileInput) String() string {
-	return fmt.Sprintf("file:%s", f.path)
+	return f.describe(&hashing.Hasher{})
 }
 
 // globInput represents a glob pattern input.
 type globInput struct {
-	pattern string
-	matches []string // Cached expansion result
+	pattern  string
+	matches  []string // Expansion captured by KeyBuilder.Glob, shared by copies of the key
+	expanded bool     // Whether matches holds the expansion; it may be empty
 }
 
-func (g globInput) hash(h hash.Hash, fs afero.Fs) error {
-	matches := g.matches
-	if matches == nil {
-		// Fallback if not cached (shouldn't happen in normal flow)
-		var err error
-		matches, err = expandGlob(g.pattern, fs)
+func (g globInput) hash(h hash.Hash, hs *hashing.Hasher, fs afero.Fs) error {
+	if !g.expanded {
+		// Keys built after a fail-fast validation error skip expansion, but
+		// they never get this far
+		matches, err := hs.ExpandGlob(fs, g.pattern)
 		if err != nil {
 			return fmt.Errorf("glob %s: %w", g.pattern, err)
 		}
+		return hs.Files(h, fs, matches)
 	}
 
-	// Sort for deterministic ordering (may already be sorted)
-	slices.Sort(matches)
-
-	// Hash count of matches
-	_, _ = fmt.Fprintf(h, "%d", len(matches))
-
-	// Hash each matched file
-	for _, match := range matches {
-		h.Write([]byte(match))
-		file, err := fs.Open(match)
-		if err != nil {
-			return fmt.Errorf("failed to open glob match %s: %w", match, err)
-		}
-		if err := hashFile(file, h); err != nil {
-			file.Close()
-			return fmt.Errorf("failed to hash glob match %s: %w", match, err)
-		}
-		file.Close()
+	// Files sorts in place; the captured matches are shared, so sort a copy
+	if err := hs.Files(h, fs, slices.Clone(g.matches)); err != nil {
+		return fmt.Errorf("glob %s: %w", g.pattern, err)
 	}
-
 	return nil
 }
 
+func (g globInput) describe(hs *hashing.Hasher) string {
+	return fmt.Sprintf("glob:%s", hs.Path(g.pattern))
+}
+
 func (g globInput) String() string {
-	return fmt.Sprintf("glob:%s", g.pattern)
+	return g.describe(&hashing.Hasher{})
 }
 
 // dirInput represents a directory input.
 type dirInput struct {
-	path    string
-	exclude []string
+	path string
+	opts DirOptions
 }
 
-func (d dirInput) hash(h hash.Hash, fs afero.Fs) error {
-	var files []string
-	err := afero.Walk(fs, d.path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-
-		// Check exclusions (basename only)
-		for _, pattern := range d.exclude {
-			matched, err := filepath.Match(pattern, filepath.Base(path))
-			if err != nil {
-				return fmt.Errorf("invalid exclude pattern %s: %w", pattern, err)
-			}
-			if matched {
-				return nil
-			}
-		}
-
-		files = append(files, path)
-		return nil
-	})
+func (d dirInput) hash(h hash.Hash, hs *hashing.Hasher, fs afero.Fs) error {
+	files, err := hs.DirFilesWith(fs, d.path, d.opts)
 	if err != nil {
+		return err
+	}
+	if err := hs.Files(h, fs, files); err != nil {
 		return fmt.Errorf("dir %s: %w", d.path, err)
 	}
+	return nil
+}
 
-	// Sort for deterministic ordering
-	slices.Sort(files)
-
-	// Hash count of files
-	_, _ = fmt.Fprintf(h, "%d", len(files))
-
-	// Hash each file
-	for _, filePath := range files {
-		h.Write([]byte(filePath))
-		file, err := fs.Open(filePath)
-		if err != nil {
-			return fmt.Errorf("failed to open dir file %s: %w", filePath, err)
-		}
-		if err := hashFile(file, h); err != nil {
-			file.Close()
-			return fmt.Errorf("failed to hash dir file %s: %w", filePath, err)
-		}
-		file.Close()
+func (d dirInput) describe(hs *hashing.Hasher) string {
+	// Plain Dir inputs keep the descriptor they had before DirWith, so
+	// their keys are unchanged
+	var opts []string
+	if len(d.opts.Exclude) > 0 {
+		opts = append(opts, "exclude:"+strings.Join(d.opts.Exclude, ","))
 	}
-
-	return nil
+	if len(d.opts.ExcludeDirs) > 0 {
+		opts = append(opts, "excludeDirs:"+strings.Join(d.opts.ExcludeDirs, ","))
+	}
+	if d.opts.SkipHidden {
+		opts = append(opts, "skipHidden")
+	}
+	if d.opts.MaxDepth > 0 {
+		opts = append(opts, fmt.Sprintf("maxDepth:%d", d.opts.MaxDepth))
+	}
+	if len(opts) == 0 {
+		return fmt.Sprintf("dir:%s", hs.Path(d.path))
+	}
+	return fmt.Sprintf("dir:%s(%s)", hs.Path(d.path), strings.Join(opts, ";"))
 }
 
 func (d dirInput) String() string {
-	if len(d.exclude) == 0 {
-		return fmt.Sprintf("dir:%s", d.path)
-	}
-	return fmt.Sprintf("dir:%s(exclude:%s)", d.path, strings.Join(d.exclude, ","))
+	return d.describe(&hashing.Hasher{})
 }
 
 // bytesInput represents raw byte data input.
@@ -176,8 +179,12 @@ type bytesInput struct {
 	name string
 }
 
-func (b bytesInput) hash(h hash.Hash, fs afero.Fs) error {
-	return hashFile(bytes.NewReader(b.data), h)
+func (b bytesInput) hash(h hash.Hash, hs *hashing.Hasher, fs afero.Fs) error {
+	return hs.Reader(h, bytes.NewReader(b.data))
+}
+
+func (b bytesInput) describe(*hashing.Hasher) string {
+	return b.String()
 }
 
 func (b bytesInput) String() string {
@@ -209,6 +216,19 @@ func (kb *KeyBuilder) File(path string) *KeyBuilder {
 	return kb
 }
 
+// FileIfExists adds an optional file input to the cache key. If the file
+// exists, its contents are hashed as with File; if it does not, its absence
+// is, so creating or deleting the file changes the key. Unlike File, a
+// missing file is not a validation error.
+//
+// Example:
+//
+//	key := cache.Key().File("config.yaml").FileIfExists(".env.local").Build()
+func (kb *KeyBuilder) FileIfExists(path string) *KeyBuilder {
+	kb.inputs = append(kb.inputs, fileInput{path: path, optional: true})
+	return kb
+}
+
 // Glob adds a glob pattern input to the cache key.
 // Patterns support ** for recursive matching.
 // Validates the pattern and accumulates any errors.
@@ -221,15 +241,43 @@ func (kb *KeyBuilder) Glob(pattern string) *KeyBuilder {
 	}
 
 	// Expand glob during validation and cache the result
-	matches, err := expandGlob(pattern, kb.cache.fs)
+	matches, err := kb.cache.hasher().ExpandGlob(kb.cache.fs, pattern)
+	if errors.Is(err, hashing.ErrInputTooLarge) {
+		kb.errors = append(kb.errors, fmt.Errorf("glob pattern %s: %w", pattern, err))
+		kb.inputs = append(kb.inputs, globInput{pattern: pattern})
+		return kb
+	}
 	if err != nil {
 		kb.errors = append(kb.errors, fmt.Errorf("invalid glob pattern %s: %w", pattern, err))
 		kb.inputs = append(kb.inputs, globInput{pattern: pattern})
 		return kb
 	}
 
-	// Cache the matches
-	kb.inputs = append(kb.inputs, globInput{pattern: pattern, matches: matches})
+	// Capture the matches so hashing does not walk the tree again, even when
+	// there are none
+	kb.inputs = append(kb.inputs, globInput{pattern: pattern, matches: matches, expanded: true})
+	return kb
+}
+
+// GlobRequire is like Glob but records a validation error if the pattern
+// matches fewer than min files. A mistyped pattern otherwise produces a key
+// over an empty set, which keeps hitting no matter how the intended files
+// change.
+//
+// Example:
+//
+//	key := cache.Key().GlobRequire("proto/**/*.proto", 1).Build()
+func (kb *KeyBuilder) GlobRequire(pattern string, min int) *KeyBuilder {
+	errs := len(kb.errors)
+	kb.Glob(pattern)
+	if len(kb.errors) > errs {
+		return kb
+	}
+
+	g, ok := kb.inputs[len(kb.inputs)-1].(globInput)
+	if ok && g.expanded && len(g.matches) < min {
+		kb.errors = append(kb.errors, fmt.Errorf("glob pattern %s matched %d files, want at least %d", pattern, len(g.matches), min))
+	}
 	return kb
 }
 
@@ -239,9 +287,28 @@ func (kb *KeyBuilder) Glob(pattern string) *KeyBuilder {
 // Validates the directory and patterns, accumulating any errors.
 // Errors are only surfaced when Get() or Commit() is called.
 func (kb *KeyBuilder) Dir(path string, exclude ...string) *KeyBuilder {
+	return kb.DirWith(path, DirOptions{Exclude: exclude})
+}
+
+// DirOptions selects the files of a directory covered by a DirWith input:
+// file and directory exclusions, whether to skip hidden entries, and how
+// deep to recurse. The zero value covers every file, like Dir.
+type DirOptions = hashing.DirOptions
+
+// DirWith adds a directory input covering the files selected by opts. It is
+// validated like Dir.
+//
+// Example:
+//
+//	key := cache.Key().DirWith(".", granular.DirOptions{
+//		SkipHidden:  true,             // .git, .idea, ...
+//		ExcludeDirs: []string{"node_modules", "dist"},
+//		MaxDepth:    4,
+//	}).Build()
+func (kb *KeyBuilder) DirWith(path string, opts DirOptions) *KeyBuilder {
 	// If fail-fast and already have errors, skip validation
 	if !kb.accumulateErrors && len(kb.errors) > 0 {
-		kb.inputs = append(kb.inputs, dirInput{path: path, exclude: exclude})
+		kb.inputs = append(kb.inputs, dirInput{path: path, opts: opts})
 		return kb
 	}
 
@@ -254,7 +321,7 @@ func (kb *KeyBuilder) Dir(path string, exclude ...string) *KeyBuilder {
 	}
 
 	// Validate exclude patterns
-	for _, pattern := range exclude {
+	for _, pattern := range slices.Concat(opts.Exclude, opts.ExcludeDirs) {
 		_, err := filepath.Match(pattern, "test")
 		if err != nil {
 			kb.errors = append(kb.errors, fmt.Errorf("invalid exclude pattern %s: %w", pattern, err))
@@ -265,7 +332,7 @@ func (kb *KeyBuilder) Dir(path string, exclude ...string) *KeyBuilder {
 		}
 	}
 
-	kb.inputs = append(kb.inputs, dirInput{path: path, exclude: exclude})
+	kb.inputs = append(kb.inputs, dirInput{path: path, opts: opts})
 	return kb
 }
 
@@ -310,6 +377,31 @@ func (kb *KeyBuilder) Env(key string) *KeyBuilder {
 	return kb.String("env:"+key, os.Getenv(key))
 }
 
+// Platform adds the operating system and architecture the program runs
+// on (runtime.GOOS and runtime.GOARCH), so outputs built for one platform
+// are never served to another through a shared cache.
+//
+// Example:
+//
+//	key := cache.Key().Glob("src/**/*.c").Platform().Build()
+func (kb *KeyBuilder) Platform() *KeyBuilder {
+	return kb.String("runtime:goos", runtime.GOOS).String("runtime:goarch", runtime.GOARCH)
+}
+
+// Runtime is Platform plus the version of the Go toolchain the program was
+// built with (runtime.Version), for results that depend on the Go runtime
+// or standard library, such as memoized function results or encoded Go
+// values. It is the toolchain of the running program, not of the go
+// command on PATH; key on the go binary's version to cache go command
+// output.
+//
+// Example:
+//
+//	key := cache.Key().String("query", q).Runtime().Build()
+func (kb *KeyBuilder) Runtime() *KeyBuilder {
+	return kb.Platform().String("runtime:go", runtime.Version())
+}
+
 // Build finalizes the key builder and returns an opaque Key.
 // Validation errors are not returned here but will be surfaced
 // when the key is used in Get() or Commit().
@@ -319,24 +411,47 @@ func (kb *KeyBuilder) Build() Key {
 		extras: maps.Clone(kb.extras),
 		cache:  kb.cache,
 		errors: slices.Clone(kb.errors),
+		memo:   &hashMemo{},
 	}
 }
 
 // Hash computes and returns the hash of this key as a hex string.
 // This is useful for debugging and logging.
-// Returns empty string if there are validation errors.
+// Returns empty string if there are validation errors; use HashErr to
+// find out why.
 func (kb *KeyBuilder) Hash() string {
-	key := kb.Build()
-	compHash, err := key.computeHash()
+	compHash, err := kb.HashErr()
 	if err != nil {
 		return ""
 	}
 	return compHash
 }
 
+// HashErr is like Hash but returns the error that prevented hashing
+// (validation errors or failures reading inputs) instead of an empty string.
+func (kb *KeyBuilder) HashErr() (string, error) {
+	return kb.Build().computeHash()
+}
+
+// Errors returns the validation errors recorded so far, such as missing
+// files or invalid glob patterns, or nil if there are none. They are the
+// errors Get and Commit would report in a ValidationError, so tooling can
+// reject a bad key before using it.
+//
+// Example:
+//
+//	kb := cache.Key().File("go.mod").Glob("**/*.proto")
+//	for _, err := range kb.Errors() {
+//		log.Printf("bad key input: %v", err)
+//	}
+func (kb *KeyBuilder) Errors() []error {
+	return slices.Clone(kb.errors)
+}
+
 // Hash returns the hash of this key as a hex string.
 // This is useful for debugging and logging.
-// Returns empty string if there are validation errors.
+// Returns empty string if there are validation errors; use HashErr to
+// find out why.
 func (k Key) Hash() string {
 	compHash, err := k.computeHash()
 	if err != nil {
@@ -345,9 +460,63 @@ func (k Key) Hash() string {
 	return compHash
 }
 
-// computeHash calculates the hash for this key.
-// Returns an error if there are validation errors from key building.
+// HashErr is like Hash but returns the error that prevented hashing
+// (validation errors or failures reading inputs) instead of an empty string.
+func (k Key) HashErr() (string, error) {
+	return k.computeHash()
+}
+
+// Describe returns what went into the key without hashing it: the
+// descriptions of its inputs in the order they were added (e.g.
+// "file:main.go", "glob:**/*.proto"), and the components added with String,
+// Version, and Env. They are recorded in the manifest of entries stored under
+// the key, and reported by Cache.Describe.
+func (k Key) Describe() (inputs []string, extras map[string]string) {
+	inputs = make([]string, len(k.inputs))
+	for i, ki := range k.inputs {
+		inputs[i] = ki.String()
+	}
+	return inputs, maps.Clone(k.extras)
+}
+
+// String describes the key for logging, without reading its inputs: its
+// input descriptions in order, then its extras sorted by name.
+//
+// Example output:
+//
+//	file:go.mod glob:**/*.go version=1.2.3
+func (k Key) String() string {
+	inputs, extras := k.Describe()
+	for _, name := range slices.Sorted(maps.Keys(extras)) {
+		inputs = append(inputs, name+"="+extras[name])
+	}
+	return strings.Join(inputs, " ")
+}
+
+// computeHash returns the hash of this key, computing it on first use.
+// Concurrent callers wait for a single computation instead of each reading
+// the inputs. Returns an error if there are validation errors from key
+// building.
 func (k Key) computeHash() (string, error) {
+	if k.memo == nil {
+		return k.hashInputs(nil)
+	}
+	k.memo.mu.Lock()
+	defer k.memo.mu.Unlock()
+	if k.memo.hash != "" {
+		return k.memo.hash, nil
+	}
+	keyHash, err := k.hashInputs(nil)
+	if err != nil {
+		return "", err
+	}
+	k.memo.hash = keyHash
+	return keyHash, nil
+}
+
+// hashInputs calculates the hash for this key from its inputs, recording
+// each step in trace if it is not nil.
+func (k Key) hashInputs(trace *KeyTrace) (string, error) {
 	// Check for validation errors first
 	if len(k.errors) > 0 {
 		return "", newValidationError(k.errors)
@@ -360,16 +529,53 @@ func (k Key) computeHash() (string, error) {
 		})
 	}
 
+	var keyStart time.Time
+	if k.cache.logging() || k.cache.recordLatency {
+		keyStart = k.cache.now()
+		defer k.cache.observeLatency(&k.cache.counters.hashLatency, keyStart)
+	}
+
 	h := k.cache.newHash()
+	hs := k.cache.hasher()
+	hs.SkipRepeats = k.cache.skipRepeats
+	if trace != nil {
+		k.cache.traceFiles(hs, trace)
+	}
+
+	// Salt keys built in a namespace view so they never collide with the
+	// same inputs in another namespace. Root keys are unchanged.
+	if ns := k.cache.namespace; ns != "" {
+		hashing.Field(h, "namespace")
+		hashing.Field(h, ns)
+	}
+	// Mix in the cache salt, so bumping it orphans every existing entry.
+	// Keys of unsalted caches are unchanged.
+	if salt := k.cache.salt; salt != "" {
+		hashing.Field(h, "salt")
+		hashing.Field(h, salt)
+	}
 
 	// Hash all inputs with length-prefixed descriptors to prevent collisions
 	for _, hi := range k.inputs {
-		desc := hi.String()
-		fmt.Fprintf(h, "%d:", len(desc))
-		h.Write([]byte(desc))
-		if err := hi.hash(h, k.cache.fs); err != nil {
+		desc := hi.describe(hs)
+		hashing.Field(h, desc)
+
+		var start time.Time
+		if k.cache.slow.Hash > 0 {
+			start = k.cache.now()
+		}
+		if trace != nil {
+			trace.Inputs = append(trace.Inputs, InputTrace{Desc: desc})
+		}
+		if err := hi.hash(h, hs, k.cache.fs); err != nil {
 			return "", err
 		}
+		if trace != nil {
+			trace.Inputs[len(trace.Inputs)-1].Digest = hashing.Sum(h)
+		}
+		if k.cache.slow.Hash > 0 {
+			k.cache.reportIfSlow(SlowOpHash, desc, k.cache.now().Sub(start))
+		}
 	}
 
 	// Hash extras in sorted order for determinism
@@ -379,124 +585,30 @@ func (k Key) computeHash() (string, error) {
 		for _, key := range keys {
 			// Length-prefix key and value to prevent collisions:
 			// String("ab","cd") vs String("a","bcd") must hash differently.
-			fmt.Fprintf(h, "%d:", len(key))
-			h.Write([]byte(key))
-			fmt.Fprintf(h, "%d:", len(k.extras[key]))
-			h.Write([]byte(k.extras[key]))
+			hashing.Field(h, key)
+			hashing.Field(h, k.extras[key])
 		}
 	}
 
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+	keyHash := hashing.Sum(h)
+	if k.cache.logging() {
+		k.cache.log(slog.LevelDebug, "key hashed", keyHashAttr(keyHash),
+			slog.Int("inputs", len(k.inputs)+len(k.extras)), durationAttr(k.cache.now().Sub(keyStart)))
+	}
+	return keyHash, nil
 }
 
 // expandGlob expands a glob pattern (supporting **) and returns matching file paths.
 func expandGlob(pattern string, fs afero.Fs) ([]string, error) {
-	hasRecursive := strings.Contains(pattern, "**")
-
-	// Determine base directory
-	baseDir := "."
-	if hasRecursive {
-		parts := strings.Split(pattern, "**")
-		baseDir = filepath.Dir(parts[0])
-		if baseDir == "." && parts[0] != "" && !strings.HasSuffix(parts[0], "/") && !strings.HasSuffix(parts[0], string(filepath.Separator)) {
-			baseDir = parts[0]
-		}
-	} else {
-		baseDir = filepath.Dir(pattern)
-	}
-
-	if baseDir == "." {
-		baseDir = ""
-	}
-
-	// Check if base directory exists
-	if baseDir != "" {
-		exists, err := afero.DirExists(fs, baseDir)
-		if err != nil {
-			return nil, err
-		}
-		if !exists {
-			return nil, nil // No matches, not an error
-		}
-	}
-
-	// Walk and match files
-	var matches []string
-	err := afero.Walk(fs, baseDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			// For non-recursive patterns, skip subdirectories to match standard
-			// glob semantics: src/*.go matches only files directly in src/, not
-			// files in src/pkg/ or deeper.
-			if !hasRecursive && path != baseDir {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		if hasRecursive {
-			if matchesGlobPattern(path, pattern) {
-				matches = append(matches, path)
-			}
-		} else {
-			filePattern := filepath.Base(pattern)
-			matched, err := filepath.Match(filePattern, filepath.Base(path))
-			if err != nil {
-				return err
-			}
-			if matched {
-				matches = append(matches, path)
-			}
-		}
-
-		return nil
-	})
-
-	return matches, err
+	return hashing.ExpandGlob(fs, pattern)
 }
 
 // matchesGlobPattern checks if a path matches a pattern with ** support.
 func matchesGlobPattern(path, pattern string) bool {
-	pattern = filepath.ToSlash(pattern)
-	path = filepath.ToSlash(path)
-
-	patternParts := strings.Split(pattern, "/")
-	pathParts := strings.Split(path, "/")
-
-	return matchGlobParts(pathParts, patternParts, 0, 0)
+	return hashing.Match(pattern, path)
 }
 
-// matchGlobParts recursively matches path parts against pattern parts.
+// matchGlobParts matches path parts against pattern parts starting at the given indices.
 func matchGlobParts(pathParts, patternParts []string, pathIdx, patternIdx int) bool {
-	if patternIdx >= len(patternParts) {
-		return pathIdx >= len(pathParts)
-	}
-
-	if pathIdx >= len(pathParts) {
-		for i := patternIdx; i < len(patternParts); i++ {
-			if patternParts[i] != "**" {
-				return false
-			}
-		}
-		return true
-	}
-
-	patternPart := patternParts[patternIdx]
-	pathPart := pathParts[pathIdx]
-
-	if patternPart == "**" {
-		if matchGlobParts(pathParts, patternParts, pathIdx, patternIdx+1) {
-			return true
-		}
-		return matchGlobParts(pathParts, patternParts, pathIdx+1, patternIdx)
-	}
-
-	matched, err := filepath.Match(patternPart, pathPart)
-	if err != nil || !matched {
-		return false
-	}
-
-	return matchGlobParts(pathParts, patternParts, pathIdx+1, patternIdx+1)
+	return hashing.MatchParts(pathParts[pathIdx:], patternParts[patternIdx:])
 }
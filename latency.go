@@ -0,0 +1,159 @@
+package granular
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram is a latency distribution recorded with WithLatencyHistograms.
+type Histogram struct {
+	Bounds []time.Duration // Upper bound of each bucket but the last, ascending
+	Counts []int64         // Operations per bucket; the last counts those above every bound
+	Count  int64           // Total number of operations
+	Sum    time.Duration   // Total time spent in them
+}
+
+// Mean returns the average latency, or 0 if no operations were recorded.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an upper bound on the q-quantile latency (q in [0, 1]):
+// the bound of the bucket holding it. It returns 0 if no operations were
+// recorded, and the largest bound, which the quantile exceeds, if it falls
+// in the overflow bucket.
+//
+// Example:
+//
+//	p99 := cache.Metrics().GetLatency.Quantile(0.99)
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 || len(h.Bounds) == 0 {
+		return 0
+	}
+	// The quantile is the rank-th smallest operation, counting from 1
+	rank := max(int64(math.Ceil(q*float64(h.Count))), 1)
+	var seen int64
+	for i, n := range h.Counts[:len(h.Bounds)] {
+		if seen += n; seen >= rank {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// latencyHistogram holds the live counts behind a Histogram.
+type latencyHistogram struct {
+	counts [len(latencyBounds) + 1]atomic.Int64
+	sum    atomic.Int64 // nanoseconds
+}
+
+// latencyBounds are the upper bounds of the buckets latency histograms
+// count operations in. Operations slower than the last bound are counted in
+// an extra overflow bucket.
+var latencyBounds = [...]time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i, _ := slices.BinarySearch(latencyBounds[:], d)
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *latencyHistogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: slices.Clone(latencyBounds[:]),
+		Counts: make([]int64, len(h.counts)),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// observeLatency records the latency of an operation if histograms are
+// enabled.
+func (c *Cache) observeLatency(h *latencyHistogram, start time.Time) {
+	if c.recordLatency {
+		h.observe(c.now().Sub(start))
+	}
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format, for serving from a /metrics endpoint. Counters are named
+// granular_<name>_total and latency histograms
+// granular_operation_duration_seconds, labeled by operation.
+//
+// Example:
+//
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+//		_ = cache.Metrics().WritePrometheus(w)
+//	})
+func (m Metrics) WritePrometheus(w io.Writer) error {
+	p := &promWriter{w: w}
+	p.counter("hits", "Lookups that returned an entry.", m.Hits)
+	p.counter("misses", "Lookups that found no usable entry.", m.Misses)
+	p.counter("puts", "Successful commits.", m.Puts)
+	p.counter("evictions", "Entries removed by deletion, pruning, or eviction.", m.Evictions)
+	p.counter("served_bytes", "Total size of entries returned by hits.", m.BytesServed)
+
+	const name = "granular_operation_duration_seconds"
+	p.printf("# HELP %s Latency of cache operations.\n# TYPE %s histogram\n", name, name)
+	for _, op := range []struct {
+		name string
+		h    Histogram
+	}{
+		{"hash", m.HashLatency},
+		{"get", m.GetLatency},
+		{"commit", m.CommitLatency},
+		{"restore", m.RestoreLatency},
+	} {
+		var cumulative int64
+		for i, bound := range op.h.Bounds {
+			cumulative += op.h.Counts[i]
+			p.printf("%s_bucket{op=%q,le=\"%g\"} %d\n", name, op.name, bound.Seconds(), cumulative)
+		}
+		p.printf("%s_bucket{op=%q,le=\"+Inf\"} %d\n", name, op.name, op.h.Count)
+		p.printf("%s_sum{op=%q} %g\n", name, op.name, op.h.Sum.Seconds())
+		p.printf("%s_count{op=%q} %d\n", name, op.name, op.h.Count)
+	}
+	return p.err
+}
+
+// promWriter writes exposition lines, keeping the first error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (p *promWriter) counter(name, help string, value int64) {
+	name = "granular_" + name + "_total"
+	p.printf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
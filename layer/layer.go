@@ -0,0 +1,393 @@
+// Package layer caches directory trees as layers in a granular cache.
+//
+// Artifacts such as node_modules trees or unpacked container images change
+// by a few files from one commit to the next. Storing each version in full
+// wastes space and time; a layer instead stores only the files that differ
+// from a parent layer, and Restore reconstructs the whole tree from the
+// chain of layers:
+//
+//	// On main: store the tree in full
+//	info, err := layer.Put(cache, mainKey, "node_modules")
+//
+//	// On a branch: store only what changed since main
+//	info, err = layer.Put(cache, branchKey, "node_modules", layer.WithParent(mainKey))
+//
+//	// Anywhere: rebuild the branch's tree
+//	info, err = layer.Restore(cache, branchKey, "node_modules")
+//
+// A layer depends on every layer below it. If one of them has been evicted
+// or deleted, Restore reports a miss, like any other missing entry. Chains
+// are kept short by storing a full base layer once they reach the maximum
+// depth (see WithMaxDepth).
+//
+// Trees are read from and restored to the operating system, like the files
+// of toolcache; the cache should use the default OS filesystem. Regular
+// files and symbolic links are stored; empty directories are not. Links
+// must stay inside the tree: Put and Restore reject absolute links and
+// links that resolve outside it, and Restore never writes through a
+// symbolic link, so a tree from a shared cache cannot write outside dir.
+package layer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/hashing"
+	"github.com/spf13/afero"
+)
+
+// DefaultMaxDepth is the maximum number of layers stacked on a base layer
+// unless WithMaxDepth says otherwise.
+const DefaultMaxDepth = 10
+
+// Names under which layers are stored.
+const (
+	treeName   = "layer.tree"
+	parentMeta = "layer.parent"
+	depthMeta  = "layer.depth"
+)
+
+// tree lists every file of a layer's tree, including those stored in
+// parent layers.
+type tree struct {
+	Parent string          `json:"parent,omitempty"` // key hash of the parent layer; empty for a base layer
+	Depth  int             `json:"depth"`            // number of layers below this one
+	Files  map[string]file `json:"files"`            // slash-separated path relative to the tree root -> file
+}
+
+// file describes one file of a tree.
+type file struct {
+	Digest string      `json:"digest,omitempty"` // SHA-256 of the contents of a regular file
+	Mode   fs.FileMode `json:"mode"`
+	Link   string      `json:"link,omitempty"`   // target of a symbolic link
+	Object string      `json:"object,omitempty"` // name of the cached file holding the contents, if this layer stores them
+}
+
+// Info describes a stored or restored layer.
+type Info struct {
+	KeyHash string // hash of the key the tree is stored under
+	Parent  string // key hash of the parent layer; empty for a base layer
+	Depth   int    // number of layers below this one
+	Files   int    // files in the tree
+	Stored  int    // files whose contents are stored in this layer
+}
+
+type options struct {
+	parent   *granular.Key
+	maxDepth int
+}
+
+// Option configures Put.
+type Option func(*options)
+
+// WithParent stores the tree as a layer on top of the one stored under
+// parent, keeping only the files that differ from it. If parent has no
+// layer, or its chain has reached the maximum depth, the tree is stored in
+// full instead.
+//
+// Example:
+//
+//	info, err := layer.Put(cache, branchKey, "node_modules", layer.WithParent(mainKey))
+func WithParent(parent granular.Key) Option {
+	return func(o *options) {
+		o.parent = &parent
+	}
+}
+
+// WithMaxDepth sets how many layers may be stacked on a base layer before
+// Put stores a tree in full again. Deeper chains save more space but make
+// Restore read more layers and depend on more entries. The default is
+// DefaultMaxDepth; values below 0 are treated as 0, which always stores
+// trees in full.
+//
+// Example:
+//
+//	info, err := layer.Put(cache, key, "image", layer.WithParent(prev), layer.WithMaxDepth(3))
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = max(n, 0)
+	}
+}
+
+// Put stores the tree rooted at dir under key. With WithParent, only the
+// files whose contents differ from the parent layer are stored; otherwise
+// the tree is stored in full as a base layer.
+func Put(cache *granular.Cache, key granular.Key, dir string, opts ...Option) (*Info, error) {
+	o := options{maxDepth: DefaultMaxDepth}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	keyHash, err := key.HashErr()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tree{}
+	if t.Files, err = list(dir); err != nil {
+		return nil, err
+	}
+	for path, f := range t.Files {
+		if f.Link != "" && !insideTree(t.Files, path) {
+			return nil, fmt.Errorf("layer: link %s -> %s points outside %s", path, f.Link, dir)
+		}
+	}
+	var parent *tree
+	if o.parent != nil {
+		parentHash, err := o.parent.HashErr()
+		if err != nil {
+			return nil, fmt.Errorf("parent key: %w", err)
+		}
+		parent, _, err = load(cache, parentHash)
+		if err != nil && !errors.Is(err, granular.ErrCacheMiss) {
+			return nil, err
+		}
+		if parent != nil && parent.Depth < o.maxDepth {
+			t.Parent, t.Depth = parentHash, parent.Depth+1
+		} else {
+			parent = nil
+		}
+	}
+
+	info := &Info{KeyHash: keyHash, Parent: t.Parent, Depth: t.Depth, Files: len(t.Files)}
+	out := cache.Put(layerKey(cache, keyHash)).
+		Meta(parentMeta, t.Parent).
+		MetaInt(depthMeta, int64(t.Depth))
+	for i, path := range slices.Sorted(maps.Keys(t.Files)) {
+		f := t.Files[path]
+		if f.Link != "" {
+			continue
+		}
+		if parent != nil && parent.Files[path].Digest == f.Digest {
+			continue
+		}
+		f.Object = "f" + strconv.Itoa(i)
+		t.Files[path] = f
+		out.File(f.Object, filepath.Join(dir, filepath.FromSlash(path)))
+		info.Stored++
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Bytes(treeName, data).Commit(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Restore writes the tree stored under key into dir, reading each file from
+// the nearest layer that stores it. Files in dir that are not part of the
+// tree are left alone; restore into an empty directory to get exactly the
+// stored tree.
+//
+// It returns granular.ErrCacheMiss if the layer or any layer below it is
+// missing.
+func Restore(cache *granular.Cache, key granular.Key, dir string) (*Info, error) {
+	keyHash, err := key.HashErr()
+	if err != nil {
+		return nil, err
+	}
+	top, result, err := load(cache, keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the chain down to the base layer
+	chain := []*granular.Result{result}
+	trees := []*tree{top}
+	for t := top; t.Parent != ""; {
+		parentHash, depth := t.Parent, t.Depth
+		if t, result, err = load(cache, parentHash); err != nil {
+			return nil, fmt.Errorf("parent layer %s: %w", parentHash, err)
+		}
+		if t.Depth != depth-1 {
+			return nil, fmt.Errorf("%w: layer %s: parent %s has depth %d, want %d", granular.ErrCacheCorrupted, keyHash, parentHash, t.Depth, depth-1)
+		}
+		chain, trees = append(chain, result), append(trees, t)
+	}
+
+	info := &Info{KeyHash: keyHash, Parent: top.Parent, Depth: top.Depth, Files: len(top.Files)}
+	for _, path := range slices.Sorted(maps.Keys(top.Files)) {
+		f := top.Files[path]
+		if !filepath.IsLocal(filepath.FromSlash(path)) {
+			return nil, fmt.Errorf("%w: layer %s: path %q escapes the tree", granular.ErrCacheCorrupted, keyHash, path)
+		}
+		if f.Link != "" && !insideTree(top.Files, path) {
+			return nil, fmt.Errorf("%w: layer %s: link %s -> %s points outside the tree", granular.ErrCacheCorrupted, keyHash, path, f.Link)
+		}
+		if err := checkParents(dir, path); err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return nil, err
+		}
+		if f.Link != "" {
+			if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			if err := os.Symlink(f.Link, dst); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		i := slices.IndexFunc(trees, func(t *tree) bool { return t.Files[path].Object != "" })
+		if i < 0 || trees[i].Files[path].Digest != f.Digest {
+			return nil, fmt.Errorf("%w: layer %s: no layer stores %s", granular.ErrCacheCorrupted, keyHash, path)
+		}
+		if i == 0 {
+			info.Stored++
+		}
+		if err := chain[i].CopyFile(trees[i].Files[path].Object, dst); err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(dst, f.Mode.Perm()); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// maxLinkHops is how many links insideTree follows before giving up, like
+// the limit of the operating system.
+const maxLinkHops = 40
+
+// insideTree reports whether the link at path resolves inside the tree,
+// following the links of files on the way. The target need not exist.
+func insideTree(files map[string]file, path string) bool {
+	parent := strings.Split(path, "/")
+	_, ok := resolve(files, parent[:len(parent)-1], files[path].Link, 0)
+	return ok
+}
+
+// resolve resolves target relative to the directory dir of the tree, given
+// as path components, and returns the components of the result. It reports
+// false if the target is absolute, climbs above the tree, or follows more
+// than maxLinkHops links.
+func resolve(files map[string]file, dir []string, target string, hops int) ([]string, bool) {
+	target = filepath.ToSlash(target)
+	if path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) || filepath.VolumeName(filepath.FromSlash(target)) != "" {
+		return nil, false
+	}
+	cur := slices.Clone(dir)
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(cur) == 0 {
+				return nil, false
+			}
+			cur = cur[:len(cur)-1]
+		default:
+			cur = append(cur, part)
+			f, ok := files[strings.Join(cur, "/")]
+			if !ok || f.Link == "" {
+				continue
+			}
+			if hops >= maxLinkHops {
+				return nil, false
+			}
+			if cur, ok = resolve(files, cur[:len(cur)-1], f.Link, hops+1); !ok {
+				return nil, false
+			}
+		}
+	}
+	return cur, true
+}
+
+// checkParents returns an error if a directory on the way from dir to the
+// file at path is a symbolic link, so that restoring the file cannot write
+// through it, outside dir.
+func checkParents(dir, path string) error {
+	cur := dir
+	parts := strings.Split(path, "/")
+	for _, part := range parts[:len(parts)-1] {
+		cur = filepath.Join(cur, part)
+		info, err := os.Lstat(cur)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("layer: cannot restore %s: %s is a symbolic link", path, cur)
+		}
+	}
+	return nil
+}
+
+// layerKey returns the key a layer is stored under. Layers refer to their
+// parents by key hash, so they are stored under a key derived from the hash
+// of the caller's key.
+func layerKey(cache *granular.Cache, keyHash string) granular.Key {
+	return cache.Key().String("layer.key", keyHash).Build()
+}
+
+// load returns the tree of the layer stored for keyHash and its entry.
+func load(cache *granular.Cache, keyHash string) (*tree, *granular.Result, error) {
+	result, err := cache.Get(layerKey(cache, keyHash))
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := result.BytesErr(treeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	var t tree
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, nil, fmt.Errorf("%w: layer %s: %v", granular.ErrCacheCorrupted, keyHash, err)
+	}
+	return &t, result, nil
+}
+
+// list returns the files of the tree rooted at dir.
+func list(dir string) (map[string]file, error) {
+	osFs := afero.NewOsFs()
+	files := make(map[string]file)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := file{Mode: info.Mode()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if f.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			h := sha256.New()
+			if err := hashing.File(h, osFs, path); err != nil {
+				return err
+			}
+			f.Digest = hashing.Sum(h)
+		default:
+			return fmt.Errorf("layer: %s is not a regular file or symbolic link", path)
+		}
+		files[filepath.ToSlash(rel)] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
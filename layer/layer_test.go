@@ -0,0 +1,242 @@
+package layer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+)
+
+// writeTree replaces the tree at dir with the given files.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the regular files of the tree at dir.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPutRestore(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := granular.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	tree := filepath.Join(dir, "node_modules")
+	key := func(commit string) granular.Key { return cache.Key().String("commit", commit).Build() }
+
+	v1 := map[string]string{"a/index.js": "a1", "b/index.js": "b1", "c/index.js": "c1"}
+	writeTree(t, tree, v1)
+	info, err := Put(cache, key("1"), tree)
+	if err != nil {
+		t.Fatalf("Put base failed: %v", err)
+	}
+	if info.Depth != 0 || info.Parent != "" || info.Stored != 3 {
+		t.Errorf("base layer = %+v, want depth 0 storing all 3 files", info)
+	}
+
+	// Change one file, add one, and delete one: only the changes are stored
+	v2 := map[string]string{"a/index.js": "a2", "b/index.js": "b1", "d/index.js": "d1"}
+	writeTree(t, tree, v2)
+	info, err = Put(cache, key("2"), tree, WithParent(key("1")))
+	if err != nil {
+		t.Fatalf("Put layer failed: %v", err)
+	}
+	if info.Depth != 1 || info.Parent != key("1").Hash() || info.Files != 3 || info.Stored != 2 {
+		t.Errorf("layer = %+v, want depth 1 storing 2 of 3 files", info)
+	}
+	if err := os.Symlink("a/index.js", filepath.Join(tree, "main.js")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(tree, "b/index.js"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Put(cache, key("3"), tree, WithParent(key("2"))); err != nil {
+		t.Fatalf("Put second layer failed: %v", err)
+	}
+
+	restored := filepath.Join(dir, "restored")
+	info, err = Restore(cache, key("3"), restored)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if info.Depth != 2 || info.Stored != 0 {
+		t.Errorf("restored layer = %+v, want depth 2 storing no files", info)
+	}
+	if got := readTree(t, restored); !maps.Equal(got, v2) {
+		t.Errorf("restored tree = %v, want %v", got, v2)
+	}
+	if target, err := os.Readlink(filepath.Join(restored, "main.js")); err != nil || target != "a/index.js" {
+		t.Errorf("restored link = %q, %v", target, err)
+	}
+	if fi, err := os.Stat(filepath.Join(restored, "b/index.js")); err != nil || fi.Mode().Perm() != 0o755 {
+		t.Errorf("restored mode = %v, %v; want 0755", fi.Mode(), err)
+	}
+
+	// A layer whose parent is gone cannot be restored
+	if err := cache.Delete(layerKey(cache, key("1").Hash())); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := Restore(cache, key("3"), t.TempDir()); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Restore without a parent = %v, want ErrCacheMiss", err)
+	}
+	if _, err := Restore(cache, key("missing"), t.TempDir()); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Restore of a missing layer = %v, want ErrCacheMiss", err)
+	}
+
+	// Without its parent, a layer is stored in full
+	info, err = Put(cache, key("4"), tree, WithParent(key("1")))
+	if err != nil {
+		t.Fatalf("Put on a missing parent failed: %v", err)
+	}
+	if info.Depth != 0 || info.Parent != "" || info.Stored != 3 {
+		t.Errorf("layer on a missing parent = %+v, want a base layer", info)
+	}
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := granular.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	tree := filepath.Join(dir, "tree")
+	key := func(n int) granular.Key { return cache.Key().String("n", strconv.Itoa(n)).Build() }
+
+	var depths []int
+	for n := range 4 {
+		writeTree(t, tree, map[string]string{"f": string(rune('a' + n))})
+		var opts []Option
+		if n > 0 {
+			opts = append(opts, WithParent(key(n-1)), WithMaxDepth(2))
+		}
+		info, err := Put(cache, key(n), tree, opts...)
+		if err != nil {
+			t.Fatalf("Put %d failed: %v", n, err)
+		}
+		depths = append(depths, info.Depth)
+	}
+	if want := []int{0, 1, 2, 0}; !slices.Equal(depths, want) {
+		t.Errorf("depths = %v, want %v", depths, want)
+	}
+}
+
+func TestEscapingLinks(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := granular.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Put refuses links that leave the tree, directly or through another link
+	src := filepath.Join(dir, "tree")
+	for name, links := range map[string]map[string]string{
+		"absolute": {"a": outside},
+		"relative": {"a": "../outside"},
+		"chained":  {"sub/a": "../b", "b": "../outside"},
+	} {
+		writeTree(t, src, map[string]string{"sub/f": "f"})
+		for link, target := range links {
+			if err := os.Symlink(target, filepath.Join(src, filepath.FromSlash(link))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := Put(cache, cache.Key().String("name", name).Build(), src); err == nil {
+			t.Errorf("Put of a tree with %s escaping links succeeded", name)
+		}
+	}
+	writeTree(t, src, map[string]string{"sub/f": "f"})
+	if err := os.Symlink("../sub/f", filepath.Join(src, "sub", "g")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Put(cache, cache.Key().String("name", "inside").Build(), src); err != nil {
+		t.Errorf("Put of a tree with a link inside it failed: %v", err)
+	}
+
+	// A tree from a shared cache may have been written by anyone
+	store := func(name string, files map[string]file) granular.Key {
+		t.Helper()
+		key := cache.Key().String("name", name).Build()
+		data, err := json.Marshal(&tree{Files: files})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := cache.Put(layerKey(cache, key.Hash())).File("x", filepath.Join(src, "sub", "f")).Bytes(treeName, data)
+		if err := out.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		return key
+	}
+	x := file{Digest: "x", Mode: 0o644, Object: "x"}
+	for name, files := range map[string]map[string]file{
+		"write through":  {"a": {Mode: fs.ModeSymlink, Link: "../outside"}, "a/b": x},
+		"absolute write": {"a": {Mode: fs.ModeSymlink, Link: outside}, "a/b": x},
+	} {
+		if _, err := Restore(cache, store(name, files), filepath.Join(dir, "restored", name)); !errors.Is(err, granular.ErrCacheCorrupted) {
+			t.Errorf("Restore of %s = %v, want ErrCacheCorrupted", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Restore wrote %d files outside its directory", len(entries))
+	}
+
+	// Nor does Restore write through a link already in dir
+	restored := filepath.Join(dir, "existing")
+	if err := os.Mkdir(restored, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(restored, "a")); err != nil {
+		t.Fatal(err)
+	}
+	plain := store("plain", map[string]file{"a/b": x})
+	if _, err := Restore(cache, plain, restored); err == nil {
+		t.Error("Restore through an existing link succeeded")
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Restore wrote %d files outside its directory", len(entries))
+	}
+	if _, err := Restore(cache, plain, t.TempDir()); err != nil {
+		t.Errorf("Restore into an empty directory failed: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package granular
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// defaultLockTimeout is how long LockKey waits for another holder when
+// WithLockTimeout is not set.
+const defaultLockTimeout = 10 * time.Minute
+
+// lockPollInterval is how often LockKey checks whether a key locked by
+// another process has been released.
+const lockPollInterval = 50 * time.Millisecond
+
+// lockStaleAfter is how long a lock file may go without a heartbeat before
+// waiters treat its holder as crashed and break the lock. Holders refresh
+// their lock file every lockHeartbeat.
+const (
+	lockStaleAfter = time.Minute
+	lockHeartbeat  = lockStaleAfter / 4
+)
+
+// locksDir returns the path to the key lock directory.
+func (c *Cache) locksDir() string {
+	return filepath.Join(c.root, "locks")
+}
+
+// lockPath returns the path of the lock file for keyHash.
+func (c *Cache) lockPath(keyHash string) string {
+	return filepath.Join(c.locksDir(), keyHash+".lock")
+}
+
+// LockKey acquires a lock on key that is shared by every process using the
+// same cache directory, so that when several processes miss on the same key
+// only one of them does the expensive work. It blocks until the lock is
+// free, ctx is done, or the timeout set with WithLockTimeout expires, in
+// which case it returns ErrLockTimeout.
+//
+// The returned function releases the lock and must be called once the
+// entry has been stored (or the work abandoned). While the lock is held, its
+// lock file is refreshed in the background; a lock whose holder crashed is
+// broken after a minute without refresh.
+//
+// Most callers want GetOrLock, which re-checks the cache once the lock is
+// acquired.
+//
+// Example:
+//
+//	unlock, err := cache.LockKey(ctx, key)
+//	if err != nil {
+//		return err
+//	}
+//	defer unlock()
+func (c *Cache) LockKey(ctx context.Context, key Key) (unlock func(), err error) {
+	if len(key.errors) > 0 {
+		return nil, newValidationError(key.errors)
+	}
+	keyHash, err := key.computeHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute key hash: %w", err)
+	}
+	if err := c.mkdirAll(c.locksDir()); err != nil {
+		return nil, fmt.Errorf("failed to create locks directory: %w", err)
+	}
+
+	token := randomSuffix()
+	timeout := time.NewTimer(cmp.Or(c.lockTimeout, defaultLockTimeout))
+	defer timeout.Stop()
+	for {
+		acquired, err := c.tryLock(keyHash, token)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return c.holdLock(c.lockPath(keyHash), token), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, fmt.Errorf("%w %s", ErrLockTimeout, keyHash)
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// tryLock creates the lock file for keyHash, breaking it first if its
+// holder has stopped refreshing it. It reports false if another holder has
+// it.
+func (c *Cache) tryLock(keyHash, token string) (bool, error) {
+	path := c.lockPath(keyHash)
+	f, err := c.fs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, defaultFileMode)
+	if err == nil {
+		_, writeErr := f.WriteString(token)
+		closeErr := f.Close()
+		if err := errors.Join(writeErr, closeErr, c.chmodFile(path)); err != nil {
+			_ = c.fs.Remove(path)
+			return false, fmt.Errorf("failed to write lock file: %w", err)
+		}
+		now := c.now()
+		_ = c.fs.Chtimes(path, now, now)
+		return true, nil
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return false, fmt.Errorf("failed to create lock file: %w", err)
+	}
+
+	info, err := c.fs.Stat(path)
+	if err != nil {
+		// Released between the create and the stat; try again next poll
+		return false, nil
+	}
+	if c.now().Sub(info.ModTime()) > lockStaleAfter {
+		c.breakLock(keyHash)
+	}
+	return false, nil
+}
+
+// breakLock removes the lock file for keyHash if it is stale. Several
+// waiters may find the same stale lock, and one of them may break it and
+// take the lock before another gets to break it too, so the lock file is
+// first renamed to a name of its own: only one waiter gets each file, and it
+// checks that the file it got is the stale one before removing it. A fresh
+// lock taken by mistake is put back, unless yet another holder has taken
+// the key meanwhile.
+func (c *Cache) breakLock(keyHash string) {
+	path := c.lockPath(keyHash)
+	broken := path + "." + randomSuffix() + ".broken"
+	if err := c.fs.Rename(path, broken); err != nil {
+		// Released or broken by another waiter
+		return
+	}
+	info, err := c.fs.Stat(broken)
+	if err == nil && c.now().Sub(info.ModTime()) > lockStaleAfter {
+		c.log(slog.LevelWarn, "breaking stale lock", keyHashAttr(keyHash))
+		_ = c.fs.Remove(broken)
+		return
+	}
+
+	token, err := afero.ReadFile(c.fs, broken)
+	_ = c.fs.Remove(broken)
+	if err != nil {
+		return
+	}
+	f, err := c.fs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, defaultFileMode)
+	if err != nil {
+		c.log(slog.LevelWarn, "lost a fresh lock while breaking a stale one", keyHashAttr(keyHash))
+		return
+	}
+	_, writeErr := f.Write(token)
+	if err := errors.Join(writeErr, f.Close(), c.chmodFile(path)); err != nil {
+		_ = c.fs.Remove(path)
+		return
+	}
+	now := c.now()
+	_ = c.fs.Chtimes(path, now, now)
+}
+
+// holdLock refreshes the lock file at path until the returned function is
+// called, which stops the refresh and removes the lock file if it still
+// belongs to token.
+func (c *Cache) holdLock(path, token string) func() {
+	stop := c.keepAlive(path, lockHeartbeat)
+	return sync.OnceFunc(func() {
+		stop()
+		// A lock broken as stale may since have been taken by someone else
+		if data, err := afero.ReadFile(c.fs, path); err == nil && string(data) == token {
+			_ = c.fs.Remove(path)
+		}
+	})
+}
+
+// keepAlive sets the modification time of path to now every interval, so
+// that others can tell its owner is alive, until the returned function is
+// called. Calling it more than once is harmless.
+func (c *Cache) keepAlive(path string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				now := c.now()
+				_ = c.fs.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return sync.OnceFunc(func() {
+		close(done)
+		<-stopped
+	})
+}
+
+// GetOrLock looks up key like Get. On a miss, it takes the key's lock with
+// LockKey and looks again, so that a process that waited for another one to
+// store the entry gets a hit instead of redoing the work. It returns:
+//
+//   - (result, nil, nil) on a hit, with no lock held;
+//   - (nil, unlock, ErrCacheMiss) on a miss, holding the lock: compute and
+//     Put the entry, then call unlock;
+//   - (nil, nil, err) on any other error, including ErrLockTimeout and
+//     ctx.Err() while waiting for the lock.
+//
+// Example:
+//
+//	result, unlock, err := cache.GetOrLock(ctx, key)
+//	if errors.Is(err, granular.ErrCacheMiss) {
+//		defer unlock()
+//		// build, then cache.Put(key)...Commit()
+//	}
+func (c *Cache) GetOrLock(ctx context.Context, key Key) (*Result, func(), error) {
+	result, err := c.Get(key)
+	if !errors.Is(err, ErrCacheMiss) {
+		return result, nil, err
+	}
+
+	unlock, err := c.LockKey(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err = c.Get(key)
+	if !errors.Is(err, ErrCacheMiss) {
+		unlock()
+		return result, nil, err
+	}
+	return nil, unlock, err
+}
@@ -0,0 +1,160 @@
+package granular
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// openProcesses opens n caches on the same filesystem and directory, as
+// separate processes sharing a cache directory would.
+func openProcesses(t *testing.T, n int, options ...Option) []*Cache {
+	t.Helper()
+	options = append([]Option{WithFs(afero.NewMemMapFs())}, options...)
+	caches := make([]*Cache, n)
+	for i := range caches {
+		cache, err := Open(".cache", options...)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		caches[i] = cache
+	}
+	return caches
+}
+
+func TestGetOrLock(t *testing.T) {
+	caches := openProcesses(t, 2)
+	first, second := caches[0], caches[1]
+	key := first.Key().String("artifact", "bootstrap").Build()
+
+	result, unlock, err := first.GetOrLock(t.Context(), key)
+	if !errors.Is(err, ErrCacheMiss) || result != nil || unlock == nil {
+		t.Fatalf("first GetOrLock = %v, %v, want a miss holding the lock", result, err)
+	}
+
+	// The second process misses too, and waits for the first to store the
+	// entry instead of building it again
+	type lookup struct {
+		result *Result
+		unlock func()
+		err    error
+	}
+	done := make(chan lookup, 1)
+	go func() {
+		result, unlock, err := second.GetOrLock(t.Context(), key)
+		done <- lookup{result, unlock, err}
+	}()
+
+	select {
+	case got := <-done:
+		t.Fatalf("second GetOrLock returned while the key was locked: %v", got.err)
+	case <-time.After(3 * lockPollInterval):
+	}
+
+	if err := first.Put(key).Bytes("artifact", []byte("built")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	unlock()
+
+	got := <-done
+	assertCacheHit(t, got.result, got.err, "second GetOrLock")
+	assertEqual(t, string(got.result.Bytes("artifact")), "built", "artifact")
+	if got.unlock != nil {
+		t.Error("GetOrLock returned an unlock function on a hit")
+	}
+
+	// The lock is released: the next miss takes it without waiting
+	other := first.Key().String("artifact", "other").Build()
+	_, unlock, err = second.GetOrLock(t.Context(), other)
+	if !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("GetOrLock on another key = %v, want a miss", err)
+	}
+	unlock()
+	unlock() // Releasing twice is harmless
+}
+
+func TestLockKey_Timeout(t *testing.T) {
+	caches := openProcesses(t, 2, WithLockTimeout(2*lockPollInterval))
+	key := caches[0].Key().String("artifact", "bootstrap").Build()
+
+	unlock, err := caches[0].LockKey(t.Context(), key)
+	assertNoError(t, err, "LockKey")
+	defer unlock()
+
+	if _, err := caches[1].LockKey(t.Context(), key); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("LockKey on a held key = %v, want ErrLockTimeout", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, _, err := caches[1].GetOrLock(ctx, key); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetOrLock with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestLockKey_Stale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	caches := openProcesses(t, 3,
+		WithNowFunc(func() time.Time { return now }),
+		WithLockTimeout(3*lockPollInterval))
+	key := caches[0].Key().String("artifact", "bootstrap").Build()
+
+	crashed, err := caches[0].LockKey(t.Context(), key)
+	assertNoError(t, err, "LockKey")
+
+	// A holder that stops refreshing its lock is treated as crashed
+	now = now.Add(2 * lockStaleAfter)
+	unlock, err := caches[1].LockKey(t.Context(), key)
+	assertNoError(t, err, "LockKey on a stale lock")
+	defer unlock()
+
+	// A waiter that also found the lock stale, but breaks it only now,
+	// leaves the new holder's lock in place
+	caches[2].breakLock(key.Hash())
+	if _, err := caches[2].LockKey(t.Context(), key); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("LockKey after a late break = %v, want ErrLockTimeout", err)
+	}
+
+	// Releasing the broken lock leaves the new holder's lock in place
+	crashed()
+	if _, err := caches[2].LockKey(t.Context(), key); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("LockKey after the stale holder released = %v, want ErrLockTimeout", err)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	var now atomic.Int64
+	now.Store(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix())
+	cache, memFs, tempDir := setupTestCache(t, "granular-keepalive-test")
+	cache.nowFunc = func() time.Time { return time.Unix(now.Load(), 0) }
+	path := tempDir + "/tmp/commit-test"
+	assertNoError(t, cache.mkdirAll(path), "mkdirAll")
+
+	stop := cache.keepAlive(path, time.Millisecond)
+	later := now.Add(int64(2 * stagingStaleAfter / time.Second))
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		info, err := memFs.Stat(path)
+		assertNoError(t, err, "Stat")
+		if info.ModTime().Unix() == later {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("mtime %v never refreshed to %v", info.ModTime(), time.Unix(later, 0))
+		}
+	}
+	stop()
+	stop()
+
+	// Nothing refreshes the path once stopped
+	now.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	info, err := memFs.Stat(path)
+	assertNoError(t, err, "Stat after stop")
+	if got := info.ModTime().Unix(); got != later {
+		t.Errorf("mtime refreshed to %v after stop", time.Unix(got, 0))
+	}
+}
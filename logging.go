@@ -0,0 +1,33 @@
+package granular
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Attribute keys used in log records, kept consistent across operations.
+const (
+	logKeyHash  = "key_hash"
+	logBytes    = "bytes"
+	logDuration = "duration"
+)
+
+// log emits a record through the logger configured with WithLogger.
+// It is a no-op when no logger is set.
+func (c *Cache) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	if c.logger == nil {
+		return
+	}
+	c.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// logging reports whether a logger is configured, so callers can skip
+// clock reads that would only feed log records.
+func (c *Cache) logging() bool {
+	return c.logger != nil
+}
+
+func keyHashAttr(keyHash string) slog.Attr   { return slog.String(logKeyHash, keyHash) }
+func bytesAttr(n int64) slog.Attr            { return slog.Int64(logBytes, n) }
+func durationAttr(d time.Duration) slog.Attr { return slog.Duration(logDuration, d) }
@@ -0,0 +1,82 @@
+package granular
+
+import (
+	"sync"
+	"time"
+)
+
+// PrunePolicy selects the work done by each background maintenance pass
+// started with WithAutoPrune. Zero-valued fields disable the corresponding step.
+type PrunePolicy struct {
+	MaxAge         time.Duration // Remove entries created longer ago than this (Prune)
+	MaxIdle        time.Duration // Remove entries not accessed for this long (PruneUnused)
+	MaxSize        int64         // Evict least-recently-accessed entries down to this many bytes (PruneToSize)
+	CollectOrphans bool          // Remove object directories without a manifest (GC)
+}
+
+// maintenance holds the state of the background maintenance goroutine.
+type maintenance struct {
+	interval time.Duration
+	policy   PrunePolicy
+	stop     chan struct{}
+	done     sync.WaitGroup
+	once     sync.Once
+}
+
+// startMaintenance launches the background maintenance goroutine if
+// WithAutoPrune was configured.
+func (c *Cache) startMaintenance() {
+	m := c.maintenance
+	if m == nil || m.interval <= 0 {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done.Go(func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				c.runMaintenance(m.policy)
+			}
+		}
+	})
+}
+
+// stopMaintenance stops the background maintenance goroutine and waits for
+// an in-progress pass to finish. It is safe to call more than once.
+func (c *Cache) stopMaintenance() {
+	m := c.maintenance
+	if m == nil || m.stop == nil {
+		return
+	}
+	m.once.Do(func() { close(m.stop) })
+	m.done.Wait()
+}
+
+// runMaintenance performs a single maintenance pass. Errors are reported
+// through MetricsHooks.OnError and do not stop later steps or passes.
+func (c *Cache) runMaintenance(p PrunePolicy) {
+	if p.MaxAge > 0 {
+		if _, err := c.Prune(p.MaxAge); err != nil {
+			c.recordError("autoprune", err)
+		}
+	}
+	if p.MaxIdle > 0 {
+		if _, err := c.PruneUnused(p.MaxIdle); err != nil {
+			c.recordError("autoprune", err)
+		}
+	}
+	if p.MaxSize > 0 {
+		if _, _, err := c.PruneToSize(p.MaxSize); err != nil {
+			c.recordError("autoprune", err)
+		}
+	}
+	if p.CollectOrphans {
+		if _, _, err := c.GC(); err != nil {
+			c.recordError("autoprune", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package granular
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// waitFor polls cond until it returns true or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWithAutoPrune(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithAutoPrune(10*time.Millisecond, PrunePolicy{
+		MaxAge:         time.Nanosecond,
+		CollectOrphans: true,
+	}))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer cache.Close()
+
+	key := putSized(t, cache, "a", 10)
+
+	orphanDir := filepath.Join(".cache", "objects", "de", "deadbeef12345678")
+	createTestDir(t, fs, orphanDir)
+	createTestFile(t, fs, filepath.Join(orphanDir, "orphan.txt"), []byte("orphan"))
+
+	waitFor(t, "expired entry to be pruned", func() bool { return !cache.Has(key) })
+	waitFor(t, "orphan to be collected", func() bool {
+		exists, _ := afero.DirExists(fs, orphanDir)
+		return !exists
+	})
+}
+
+func TestWithAutoPrune_StoppedByClose(t *testing.T) {
+	cache, err := Open(".cache", WithFs(afero.NewMemMapFs()), WithAutoPrune(time.Millisecond, PrunePolicy{
+		MaxAge: time.Nanosecond,
+	}))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	// Close is idempotent
+	if err := cache.Close(); err != nil {
+		t.Fatalf("second Close failed: %v", err)
+	}
+
+	key := putSized(t, cache, "a", 10)
+	time.Sleep(20 * time.Millisecond)
+	if !cache.Has(key) {
+		t.Error("Entry pruned after Close")
+	}
+}
@@ -3,16 +3,17 @@ package granular
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"maps"
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/gophersatwork/granular/internal/format"
 	"github.com/spf13/afero"
 )
 
@@ -36,16 +37,28 @@ func randomSuffix() string {
 
 // atomicWriteFile writes data to a file atomically using a temp file and rename.
 // This ensures that the file is either fully written or not present at all,
-// preventing corruption from crashes during write.
-func atomicWriteFile(fs afero.Fs, path string, data []byte, perm os.FileMode) error {
+// preventing corruption from crashes during write. If durable is true, the
+// data and the rename are also flushed to stable storage. A non-zero mode is
+// set exactly; otherwise the file gets defaultFileMode less the umask.
+func atomicWriteFile(fs afero.Fs, path string, data []byte, mode os.FileMode, durable bool) error {
 	tmpPath := path + ".tmp." + randomSuffix()
 
 	// Write to temp file
-	if err := afero.WriteFile(fs, tmpPath, data, perm); err != nil {
+	write := afero.WriteFile
+	if durable {
+		write = writeFileSynced
+	}
+	if err := write(fs, tmpPath, data, defaultFileMode); err != nil {
 		// Attempt cleanup on error
 		_ = fs.Remove(tmpPath)
 		return fmt.Errorf("failed to write temp file: %w", err)
 	}
+	if mode != 0 {
+		if err := fs.Chmod(tmpPath, mode); err != nil {
+			_ = fs.Remove(tmpPath)
+			return fmt.Errorf("failed to set temp file mode: %w", err)
+		}
+	}
 
 	// Atomic rename to final path
 	if err := fs.Rename(tmpPath, path); err != nil {
@@ -54,32 +67,16 @@ func atomicWriteFile(fs afero.Fs, path string, data []byte, perm os.FileMode) er
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
+	if durable {
+		syncDir(fs, filepath.Dir(path))
+	}
+
 	return nil
 }
 
 // manifest represents a cache manifest file (internal use only).
-// It contains metadata about a cached computation.
-type manifest struct {
-	// Manifest metadata
-	Version  int    `json:"version"`  // Manifest format version (0 = legacy, 1 = current)
-	HashAlgo string `json:"hashAlgo"` // Hash algorithm identifier (e.g., "xxhash64")
-
-	// Key information
-	KeyHash    string            `json:"keyHash"` // Hash of the key
-	InputDescs []string          `json:"inputs"`  // String descriptions of inputs
-	ExtraData  map[string]string `json:"extra"`   // Extra key components
-
-	// Result information (multi-file support)
-	OutputFiles map[string]string `json:"outputs"`    // name -> cached file path
-	OutputData  map[string]string `json:"outputData"` // name -> path to .dat file
-	OutputMeta  map[string]string `json:"outputMeta"` // metadata key-value pairs
-	OutputHash  string            `json:"outputHash"` // Hash of outputs
-	Compression CompressionType   `json:"compression,omitzero"`
-
-	// Metadata
-	CreatedAt  time.Time `json:"createdAt"`  // When the cache entry was created
-	AccessedAt time.Time `json:"accessedAt"` // When the cache entry was last accessed
-}
+// It contains metadata about a cached computation; see internal/format.
+type manifest = format.Manifest
 
 // saveManifest saves a manifest to disk using the cache's filesystem.
 // Uses atomic write pattern to prevent corruption from crashes during write.
@@ -91,24 +88,49 @@ func (c *Cache) saveManifest(m *manifest) error {
 
 	// Create the manifest directory if it doesn't exist
 	manifestDir := filepath.Dir(mPath)
-	if err := c.fs.MkdirAll(manifestDir, 0o755); err != nil {
+	if err := c.mkdirAll(manifestDir); err != nil {
 		return fmt.Errorf("failed to create manifest directory: %w", err)
 	}
 
-	// Marshal the manifest to JSON
-	data, err := json.MarshalIndent(m, "", "  ")
+	data, err := marshalManifest(m)
 	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %w", err)
+		return err
 	}
 
 	// Write atomically using temp file + rename
-	if err := atomicWriteFile(c.fs, mPath, data, 0o644); err != nil {
+	if err := atomicWriteFile(c.fs, mPath, data, c.fileMode, c.durable); err != nil {
 		return fmt.Errorf("failed to write manifest: %w", err)
 	}
+	c.indexPut(m, mPath)
 
 	return nil
 }
 
+// marshalManifest encodes m for storage. Object paths are stored with
+// forward slashes so manifests read the same on every platform.
+func marshalManifest(m *manifest) ([]byte, error) {
+	stored := *m
+	stored.OutputFiles = mapPaths(m.OutputFiles, filepath.ToSlash)
+	stored.OutputData = mapPaths(m.OutputData, filepath.ToSlash)
+	data, err := format.Marshal(&stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return data, nil
+}
+
+// unmarshalManifest decodes a stored manifest, converting its object paths
+// back to the platform's form.
+func unmarshalManifest(data []byte) (*manifest, error) {
+	m, err := format.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	m.OutputFiles = mapPaths(m.OutputFiles, filepath.FromSlash)
+	m.OutputData = mapPaths(m.OutputData, filepath.FromSlash)
+	return m, nil
+}
+
 // loadManifest loads a manifest from disk using the cache's filesystem.
 func (c *Cache) loadManifest(keyHash string) (*manifest, error) {
 	mPath, err := c.manifestPath(keyHash)
@@ -122,30 +144,104 @@ func (c *Cache) loadManifest(keyHash string) (*manifest, error) {
 		return nil, fmt.Errorf("failed to read manifest: %w", err)
 	}
 
-	// Unmarshal the manifest
-	var m manifest
-	if err := json.Unmarshal(data, &m); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	m, err := unmarshalManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateManifestPaths(keyHash, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// mapPaths returns a copy of paths with fn applied to every path. A nil map
+// stays nil.
+func mapPaths(paths map[string]string, fn func(string) string) map[string]string {
+	if paths == nil {
+		return nil
 	}
+	mapped := make(map[string]string, len(paths))
+	for name, path := range paths {
+		mapped[name] = fn(path)
+	}
+	return mapped
+}
 
-	return &m, nil
+// validateManifestPaths checks that every logical name in m is a valid name
+// and that every object it points to lives in the object directory of
+// keyHash, or for data, is a shared blob (see WithDedup). Manifests may come
+// from another process or a shared backend, so they are checked before their
+// paths are read, copied, or returned by Result.File.
+func validateManifestPaths(keyHash string, m *manifest) error {
+	return validateObjectPaths(keyHash, m, func(dir string) bool { return filepath.Base(dir) == keyHash })
+}
+
+// validateObjectPaths is validateManifestPaths for objects stored in the
+// directories inDir accepts.
+func validateObjectPaths(keyHash string, m *manifest, inDir func(dir string) bool) error {
+	// File names may be paths captured by WriteBuilder.Glob
+	for _, outputs := range []struct {
+		objects  map[string]string
+		validate func(string) error
+		blobs    bool
+	}{{m.OutputFiles, validatePathName, false}, {m.OutputData, validateName, true}} {
+		for name, path := range outputs.objects {
+			if err := outputs.validate(name); err != nil {
+				return fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+			}
+			inEntry := inDir(filepath.Dir(path)) || outputs.blobs && isBlobPath(path)
+			if filepath.Clean(path) != path || !inEntry {
+				return fmt.Errorf("%w: manifest %s: object %q is outside the entry", ErrCacheCorrupted, keyHash, path)
+			}
+			if err := validateObjectName(filepath.Base(path)); err != nil {
+				return fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+			}
+		}
+	}
+	return nil
 }
 
 // computeOutputHash calculates the hash for the outputs using the cache's filesystem.
-func (c *Cache) computeOutputHash(outputs []string, outputData map[string][]byte, outputMeta map[string]string) (string, error) {
+// outputData maps data names to the .dat files holding their (possibly compressed)
+// bytes; blobs are streamed from disk so they are never materialized in memory.
+func (c *Cache) computeOutputHash(outputs []string, outputData map[string]string, outputMeta map[string]string) (string, error) {
+	return c.outputHash(outputs, outputData, outputMeta, nil)
+}
+
+// portableOutputHash is like computeOutputHash but hashes the base names of
+// output files instead of their full paths, so it does not depend on where
+// the cache lives. It is the output hash sent over the wire by ExportManifest.
+func (c *Cache) portableOutputHash(outputs []string, outputData map[string]string, outputMeta map[string]string) (string, error) {
+	return c.outputHash(outputs, outputData, outputMeta, filepath.Base)
+}
+
+// outputHash hashes outputs like computeOutputHash, but hashes the name
+// given by rename for each output file path instead of the path itself.
+// rename may be nil. Outputs are hashed in the order of their names, which
+// for files of one directory is the order of their paths.
+func (c *Cache) outputHash(outputs []string, outputData map[string]string, outputMeta map[string]string, rename func(string) string) (string, error) {
 	h := c.newHash()
 
 	// Hash output files
 	// Sort for deterministic ordering
-	slices.Sort(outputs)
+	if rename == nil {
+		slices.Sort(outputs)
+	} else {
+		slices.SortFunc(outputs, func(a, b string) int { return strings.Compare(rename(a), rename(b)) })
+	}
 
 	// Hash the number of outputs first
 	fmt.Fprintf(h, "%d", len(outputs))
 
 	// Hash each output file with length-prefixed path to prevent collisions
 	for _, output := range outputs {
-		fmt.Fprintf(h, "%d:", len(output))
-		h.Write([]byte(output))
+		name := output
+		if rename != nil {
+			name = rename(output)
+		}
+		fmt.Fprintf(h, "%d:", len(name))
+		h.Write([]byte(name))
 
 		if err := c.hashOutputFile(h, output); err != nil {
 			return "", err
@@ -163,7 +259,9 @@ func (c *Cache) computeOutputHash(outputs []string, outputData map[string][]byte
 	for _, k := range dataKeys {
 		fmt.Fprintf(h, "%d:", len(k))
 		h.Write([]byte(k))
-		h.Write(outputData[k])
+		if err := c.hashOutputFile(h, outputData[k]); err != nil {
+			return "", err
+		}
 	}
 
 	// Hash output meta
@@ -186,7 +284,6 @@ func (c *Cache) computeOutputHash(outputs []string, outputData map[string][]byte
 }
 
 // hashOutputFile hashes a single output file's content into h.
-// Properly defers buffer pool return to avoid leaks on error.
 func (c *Cache) hashOutputFile(h io.Writer, path string) error {
 	file, err := c.fs.Open(path)
 	if err != nil {
@@ -194,13 +291,11 @@ func (c *Cache) hashOutputFile(h io.Writer, path string) error {
 	}
 	defer func() { _ = file.Close() }()
 
-	bufPtr := bufferPool.Get().(*[]byte)
-	buffer := *bufPtr
-	defer bufferPool.Put(bufPtr)
-
-	if _, err := io.CopyBuffer(h, file, buffer); err != nil {
+	r, done := c.trackProgress(ProgressOpHash, path, file)
+	if err := c.hasher().Reader(h, r); err != nil {
 		return fmt.Errorf("failed to read output file %s: %w", path, err)
 	}
+	done()
 
 	return nil
 }
@@ -213,19 +308,9 @@ func (c *Cache) verifyOutputHash(m *manifest) error {
 	// m.OutputFiles maps logical names to cached file paths
 	cachedPaths := slices.Collect(maps.Values(m.OutputFiles))
 
-	// Load data from .dat files for hash verification
-	// Read the raw (possibly compressed) data to match what was stored during commit
-	outputData := make(map[string][]byte, len(m.OutputData))
-	for name, dataPath := range m.OutputData {
-		data, err := afero.ReadFile(c.fs, dataPath)
-		if err != nil {
-			return fmt.Errorf("failed to read data file %s: %w", dataPath, err)
-		}
-		outputData[name] = data
-	}
-
-	// Compute hash from the cached files (raw, possibly compressed) and loaded data
-	computedHash, err := c.computeOutputHash(cachedPaths, outputData, m.OutputMeta)
+	// Compute hash from the cached files and .dat files (raw, possibly compressed),
+	// streaming both so large blobs are not loaded into memory just to verify them
+	computedHash, err := c.computeOutputHash(cachedPaths, m.OutputData, m.OutputMeta)
 	if err != nil {
 		return fmt.Errorf("failed to compute hash for verification: %w", err)
 	}
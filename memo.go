@@ -0,0 +1,54 @@
+package granular
+
+import (
+	"errors"
+)
+
+// Func returns a memoized version of fn that stores its results in the cache.
+//
+// keyFn describes the input to the cache key: it is called with a fresh
+// KeyBuilder for every call and should add everything the result depends on,
+// such as files read by fn and a version that changes along with fn or the
+// shape of O. Results are stored as with PutValue, so O must round-trip
+// through the cache's codec (JSON by default, see WithCodec).
+//
+// Errors returned by fn are not cached. The cache never makes a call fail:
+// when reading or storing a result fails, fn is called and its result
+// returned as if there were no cache, and the error is reported to the
+// logger and metrics.
+//
+// Example:
+//
+//	parse := granular.Func(cache, parseConfig, func(kb *granular.KeyBuilder, path string) {
+//		kb.File(path).Version("parse-v2")
+//	})
+//	cfg, err := parse("app.yaml")
+func Func[I, O any](c *Cache, fn func(I) (O, error), keyFn func(kb *KeyBuilder, in I)) func(I) (O, error) {
+	return func(in I) (O, error) {
+		kb := c.Key()
+		keyFn(kb, in)
+		key := kb.Build()
+
+		out, err := GetValue[O](c, key)
+		if err == nil {
+			return out, nil
+		}
+		if _, ok := errors.AsType[*ValidationError](err); ok {
+			// Nothing can be stored under an invalid key
+			c.recordError("func", err)
+			return fn(in)
+		}
+		if !errors.Is(err, ErrCacheMiss) {
+			c.recordError("func", err)
+		}
+
+		out, err = fn(in)
+		if err != nil {
+			return out, err
+		}
+		if err := PutValue(c, key, out); err != nil {
+			c.recordError("func", err)
+		}
+		return out, nil
+	}
+}
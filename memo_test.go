@@ -0,0 +1,103 @@
+package granular
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+type wordCount struct {
+	Words int
+	Lines int
+}
+
+func TestFunc(t *testing.T) {
+	cache, fs, tempDir := setupTestCache(t, "granular-func-test")
+	path := tempDir + "/input.txt"
+	createTestFile(t, fs, path, []byte("a b c\nd e\n"))
+
+	calls := 0
+	count := Func(cache, func(path string) (wordCount, error) {
+		calls++
+		data, err := afero.ReadFile(fs, path)
+		if err != nil {
+			return wordCount{}, err
+		}
+		return wordCount{Words: len(strings.Fields(string(data))), Lines: strings.Count(string(data), "\n")}, nil
+	}, func(kb *KeyBuilder, path string) {
+		kb.File(path).Version("count-v1")
+	})
+
+	for range 3 {
+		got, err := count(path)
+		assertNoError(t, err, "memoized call")
+		if got != (wordCount{Words: 5, Lines: 2}) {
+			t.Fatalf("count = %+v", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+
+	// Changing the input invalidates the result
+	createTestFile(t, fs, path, []byte("a\n"))
+	got, err := count(path)
+	assertNoError(t, err, "call after input change")
+	if got != (wordCount{Words: 1, Lines: 1}) || calls != 2 {
+		t.Errorf("count = %+v after %d calls, want recomputed", got, calls)
+	}
+}
+
+func TestFunc_Errors(t *testing.T) {
+	var errs []string
+	cache := OpenTemp()
+	cache.metrics = &MetricsHooks{OnError: func(op string, err error) { errs = append(errs, op) }}
+
+	boom := errors.New("boom")
+	calls := 0
+	fn := Func(cache, func(n int) (int, error) {
+		calls++
+		if n < 0 {
+			return 0, boom
+		}
+		return n * 2, nil
+	}, func(kb *KeyBuilder, n int) {
+		kb.String("n", strings.Repeat("x", max(n, 0)))
+	})
+
+	// Errors from fn are returned and not cached
+	for range 2 {
+		if _, err := fn(-1); !errors.Is(err, boom) {
+			t.Fatalf("fn(-1) = %v, want boom", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("failing fn called %d times, want 2", calls)
+	}
+
+	// An undecodable cached result is recomputed and replaced
+	key := cache.Key().String("n", "xxx").Build()
+	if err := cache.Put(key).Bytes(valueName, []byte("not json")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if got, err := fn(3); err != nil || got != 6 {
+		t.Errorf("fn(3) = %d, %v, want 6", got, err)
+	}
+	if len(errs) != 1 || errs[0] != "func" {
+		t.Errorf("reported errors = %v, want one func error", errs)
+	}
+	calls = 0
+	if got, err := fn(3); err != nil || got != 6 || calls != 0 {
+		t.Errorf("fn(3) = %d, %v after %d calls, want cached 6", got, err, calls)
+	}
+
+	// An invalid key bypasses the cache
+	missing := Func(cache, func(string) (string, error) { return "computed", nil }, func(kb *KeyBuilder, path string) {
+		kb.File(path)
+	})
+	if got, err := missing("/does/not/exist"); err != nil || got != "computed" {
+		t.Errorf("call with invalid key = %q, %v", got, err)
+	}
+}
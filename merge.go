@@ -0,0 +1,232 @@
+package granular
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gophersatwork/granular/internal/iobuf"
+	"github.com/spf13/afero"
+)
+
+// ConflictPolicy decides what Merge does when an entry exists in both caches,
+// and what a commit does when the cache already has an entry for its key
+// (see WriteBuilder.OnConflict).
+type ConflictPolicy int
+
+const (
+	ConflictSkip      ConflictPolicy = iota // Keep the existing entry
+	ConflictOverwrite                       // Replace the existing entry with the source entry
+	ConflictError                           // Keep the existing entry and fail with ErrEntryExists
+)
+
+// Merge copies every entry of src into c and returns the number of entries
+// added or replaced. src may live on a different filesystem; if src is a
+// namespace view, only that namespace is merged.
+//
+// Source entries are verified before they are copied, and entries that fail
+// verification are skipped, so merging never spreads corruption. Both caches
+// must use the same hash algorithm (ErrHashAlgoMismatch) and compression
+// (ErrCompressionMismatch). Entries keep their timestamps, metadata, and tags.
+//
+// The caches are never locked at the same time: each entry is staged under
+// c's root while src is locked, then moved into place while c is locked.
+//
+// Example:
+//
+//	local, _ := granular.Open(filepath.Join(home, ".cache", "build"))
+//	team, _ := granular.Open("/mnt/shared/build-cache")
+//	added, err := team.Merge(local, granular.ConflictSkip)
+func (c *Cache) Merge(src *Cache, policy ConflictPolicy) (added int, err error) {
+	return c.merge(context.Background(), src, policy, nil)
+}
+
+// merge copies the entries of src that filter accepts into c, like Merge.
+// A nil filter accepts every entry. ctx is checked between entries.
+func (c *Cache) merge(ctx context.Context, src *Cache, policy ConflictPolicy, filter func(Entry) bool) (added int, err error) {
+	if src.hashAlgoName != c.hashAlgoName {
+		return 0, fmt.Errorf("%w: source uses %s, destination uses %s", ErrHashAlgoMismatch, src.hashAlgoName, c.hashAlgoName)
+	}
+	if src.compression != c.compression {
+		return 0, fmt.Errorf("%w: source compression %q, destination compression %q", ErrCompressionMismatch, src.compression, c.compression)
+	}
+	if src.mu == c.mu {
+		return 0, nil // src is c or a view of it; merging into itself is a no-op
+	}
+
+	entries, err := src.Entries()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list source entries: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return added, err
+		}
+		if filter != nil && !filter(entry) {
+			continue
+		}
+		if policy != ConflictOverwrite && c.hasManifest(entry.KeyHash) {
+			if policy == ConflictError {
+				return added, fmt.Errorf("%w: %s", ErrEntryExists, entry.KeyHash)
+			}
+			continue // don't copy an entry only to drop it
+		}
+		m, stageDir, err := src.stageEntry(entry.KeyHash, c)
+		if err != nil {
+			return added, err
+		}
+		if m == nil {
+			continue // removed concurrently or failed verification
+		}
+		ok, err := c.adoptEntry(m, stageDir, policy)
+		_ = c.fs.RemoveAll(stageDir)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+	}
+
+	if c.maxSize > 0 {
+		c.mu.Lock()
+		err = c.evictIfNeeded(0)
+		c.mu.Unlock()
+	}
+	return added, err
+}
+
+// stageEntry copies the verified objects of the entry with the given key
+// hash into a fresh staging directory under dst's root. It returns the
+// source manifest and the staging directory, or a nil manifest if the entry
+// no longer exists or fails verification.
+func (c *Cache) stageEntry(keyHash string, dst *Cache) (*manifest, string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	m, err := c.loadManifest(keyHash)
+	if err != nil {
+		return nil, "", nil
+	}
+	if CompressionType(m.Compression) != c.compression || c.verifyOutputHash(m) != nil {
+		return nil, "", nil
+	}
+
+	stageDir := filepath.Join(dst.root, "tmp", "merge-"+keyHash+"-"+randomSuffix())
+	if err := dst.mkdirAll(stageDir); err != nil {
+		return nil, "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	for _, path := range slices.Concat(slices.Collect(maps.Values(m.OutputFiles)), slices.Collect(maps.Values(m.OutputData))) {
+		staged := filepath.Join(stageDir, filepath.Base(path))
+		if err := errors.Join(copyAcross(c.fs, path, dst.fs, staged, dst.buffers()), dst.chmodFile(staged)); err != nil {
+			_ = dst.fs.RemoveAll(stageDir)
+			return nil, "", fmt.Errorf("failed to copy entry %s: %w", keyHash, err)
+		}
+	}
+	return m, stageDir, nil
+}
+
+// adoptEntry moves a staged entry into place and writes its manifest,
+// rewriting object paths for this cache. It reports whether the entry was
+// added; with ConflictSkip an existing entry is left alone, and with
+// ConflictError it is left alone and ErrEntryExists is returned.
+func (c *Cache) adoptEntry(m *manifest, stageDir string, policy ConflictPolicy) (bool, error) {
+	keyHash := m.KeyHash
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	manifestPath, err := c.manifestPath(keyHash)
+	if err != nil {
+		return false, err
+	}
+	exists, err := afero.Exists(c.fs, manifestPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to check manifest: %w", err)
+	}
+	if exists {
+		switch policy {
+		case ConflictSkip:
+			return false, nil
+		case ConflictError:
+			return false, fmt.Errorf("%w: %s", ErrEntryExists, keyHash)
+		}
+		c.archiveEntry(keyHash, "")
+		if err := c.removeByHash(keyHash); err != nil {
+			return false, fmt.Errorf("failed to replace entry %s: %w", keyHash, err)
+		}
+	}
+
+	objectDir, err := c.objectPath(keyHash)
+	if err != nil {
+		return false, err
+	}
+	if err := c.mkdirAll(objectDir); err != nil {
+		return false, fmt.Errorf("failed to create object directory: %w", err)
+	}
+	// Names sharing a blob (see WithDedup) share one staged object
+	done := make(map[string]bool)
+	relocate := func(paths map[string]string) (map[string]string, error) {
+		moved := make(map[string]string, len(paths))
+		for name, path := range paths {
+			dst := filepath.Join(objectDir, filepath.Base(path))
+			if !done[dst] {
+				if err := c.fs.Rename(filepath.Join(stageDir, filepath.Base(path)), dst); err != nil {
+					return nil, fmt.Errorf("failed to move object %s: %w", name, err)
+				}
+				done[dst] = true
+			}
+			moved[name] = dst
+		}
+		return moved, nil
+	}
+	files, err := relocate(m.OutputFiles)
+	if err == nil {
+		m.OutputData, err = relocate(m.OutputData)
+	}
+	if err != nil {
+		_ = c.fs.RemoveAll(objectDir)
+		return false, err
+	}
+	m.OutputFiles = files
+
+	// Object paths are part of the output hash, so recompute it for the new location
+	m.OutputHash, err = c.computeOutputHash(slices.Collect(maps.Values(m.OutputFiles)), m.OutputData, m.OutputMeta)
+	if err == nil {
+		err = c.saveManifest(m)
+	}
+	if err != nil {
+		_ = c.fs.RemoveAll(objectDir)
+		return false, fmt.Errorf("failed to write manifest for %s: %w", keyHash, err)
+	}
+	return true, nil
+}
+
+// copyAcross copies a file between filesystems byte for byte.
+func copyAcross(srcFs afero.Fs, srcPath string, dstFs afero.Fs, dstPath string, buffers *iobuf.Pool) error {
+	in, err := srcFs.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := dstFs.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return err
+	}
+
+	bufPtr := buffers.Get()
+	defer buffers.Put(bufPtr)
+	_, copyErr := io.CopyBuffer(out, in, *bufPtr)
+	return errors.Join(copyErr, out.Close())
+}
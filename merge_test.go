@@ -0,0 +1,107 @@
+package granular
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMerge(t *testing.T) {
+	// Different filesystems and roots, so object paths must be rewritten
+	src, err := Open("laptop", WithFs(afero.NewMemMapFs()))
+	if err != nil {
+		t.Fatalf("Open src failed: %v", err)
+	}
+	dst, err := Open("team", WithFs(afero.NewMemMapFs()))
+	if err != nil {
+		t.Fatalf("Open dst failed: %v", err)
+	}
+
+	onlySrc := src.Key().String("k", "only-src").Build()
+	if err := src.Put(onlySrc).Bytes("data", []byte("from laptop")).Meta("m", "v").Tag("t", "x").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	shared := src.Key().String("k", "shared").Build()
+	if err := src.Put(shared).Bytes("data", []byte("laptop version")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := dst.Put(shared).Bytes("data", []byte("team version")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	added, err := dst.Merge(src, ConflictSkip)
+	assertNoError(t, err, "Merge")
+	if added != 1 {
+		t.Fatalf("Expected 1 entry added, got %d", added)
+	}
+
+	result, err := dst.Get(onlySrc)
+	assertNoError(t, err, "Get merged entry")
+	data, err := result.BytesErr("data")
+	assertNoError(t, err, "Bytes")
+	assertBytesEqual(t, data, []byte("from laptop"), "merged data")
+	assertEqual(t, result.Meta("m"), "v", "merged metadata")
+	assertEqual(t, result.Tag("t"), "x", "merged tag")
+
+	result, err = dst.Get(shared)
+	assertNoError(t, err, "Get shared entry")
+	data = result.Bytes("data")
+	assertBytesEqual(t, data, []byte("team version"), "ConflictSkip keeps existing entry")
+
+	if _, err := dst.Merge(src, ConflictError); !errors.Is(err, ErrEntryExists) {
+		t.Errorf("Merge with ConflictError = %v, want ErrEntryExists", err)
+	}
+
+	added, err = dst.Merge(src, ConflictOverwrite)
+	assertNoError(t, err, "Merge overwrite")
+	if added != 2 {
+		t.Fatalf("Expected 2 entries added, got %d", added)
+	}
+	result, err = dst.Get(shared)
+	assertNoError(t, err, "Get shared entry")
+	data = result.Bytes("data")
+	assertBytesEqual(t, data, []byte("laptop version"), "ConflictOverwrite replaces entry")
+
+	report, err := dst.Verify()
+	assertNoError(t, err, "Verify")
+	if !report.OK() {
+		t.Errorf("Merged cache failed verification: %+v", report)
+	}
+}
+
+func TestMerge_SkipsCorruptedEntries(t *testing.T) {
+	srcFs := afero.NewMemMapFs()
+	src, err := Open("src", WithFs(srcFs))
+	if err != nil {
+		t.Fatalf("Open src failed: %v", err)
+	}
+	dst := OpenTemp()
+
+	key := putSized(t, src, "a", 10)
+	damageEntry(t, src, srcFs, key)
+
+	added, err := dst.Merge(src, ConflictOverwrite)
+	assertNoError(t, err, "Merge")
+	if added != 0 || dst.Has(key) {
+		t.Errorf("Corrupted entry must not be merged (added=%d)", added)
+	}
+}
+
+func TestMerge_IncompatibleCaches(t *testing.T) {
+	src, err := Open("src", WithFs(afero.NewMemMapFs()), WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("Open src failed: %v", err)
+	}
+	if _, err := OpenTemp().Merge(src, ConflictSkip); !errors.Is(err, ErrCompressionMismatch) {
+		t.Errorf("Expected ErrCompressionMismatch, got %v", err)
+	}
+
+	src, err = Open("src", WithFs(afero.NewMemMapFs()), WithSHA256())
+	if err != nil {
+		t.Fatalf("Open src failed: %v", err)
+	}
+	if _, err := OpenTemp().Merge(src, ConflictSkip); !errors.Is(err, ErrHashAlgoMismatch) {
+		t.Errorf("Expected ErrHashAlgoMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package granular
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// metaFileName is the name of the cache metadata file under the cache root.
+const metaFileName = "granular.json"
+
+// metaVersion is the version of the cache layout described by the metadata
+// file. Caches with a newer version are refused rather than misread.
+const metaVersion = 1
+
+// cacheMeta is the on-disk representation of the cache metadata. It marks
+// the directory as a granular cache and records settings that every entry
+// depends on, so a cache opened with different ones fails loudly instead of
+// missing on every key.
+type cacheMeta struct {
+	FormatVersion int    `json:"formatVersion"`
+	HashAlgo      string `json:"hashAlgo"`
+	CreatedBy     string `json:"createdBy,omitempty"` // Release that created the cache
+}
+
+// createdBy names this release of granular, as recorded in new caches.
+func createdBy() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath {
+			return "granular " + info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				return "granular " + dep.Version
+			}
+		}
+	}
+	return "granular"
+}
+
+// modulePath is the module path of granular.
+const modulePath = "github.com/gophersatwork/granular"
+
+// metaPath returns the path to the cache metadata file.
+func (c *Cache) metaPath() string {
+	return filepath.Join(c.root, metaFileName)
+}
+
+// isCache reports whether the cache root holds a granular cache: one with
+// a metadata file, or one from before the metadata file existed, which has
+// both the manifests and objects directories.
+func (c *Cache) isCache() (bool, error) {
+	if _, err := c.fs.Stat(c.metaPath()); err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read cache metadata: %w", err)
+	}
+	for _, dir := range []string{c.manifestDir(), c.objectsDir()} {
+		info, err := c.fs.Stat(dir)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to stat %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkMeta compares the metadata recorded at the cache root with the
+// cache's configuration, and records it if there is none yet.
+//
+// A cache holding entries made with a different hash algorithm fails with
+// ErrHashAlgoMismatch: none of them could ever be hit. Caches from before
+// the metadata file existed are checked against one of their manifests. An
+// empty cache, such as one that has just been cleared, adopts the configured
+// algorithm.
+func (c *Cache) checkMeta() error {
+	var recorded string
+	meta := cacheMeta{CreatedBy: createdBy()}
+	data, err := afero.ReadFile(c.fs, c.metaPath())
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return fmt.Errorf("failed to parse cache metadata %s: %w", c.metaPath(), err)
+		}
+		if meta.FormatVersion > metaVersion {
+			return fmt.Errorf("cache %s has format version %d, newer than the supported %d; it was created by %s",
+				c.root, meta.FormatVersion, metaVersion, cmp.Or(meta.CreatedBy, "an unknown release"))
+		}
+		if meta.HashAlgo == c.hashAlgoName && meta.FormatVersion == metaVersion {
+			return nil
+		}
+		recorded = meta.HashAlgo
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to read cache metadata: %w", err)
+	}
+
+	keyHash, err := c.anyManifest()
+	if err != nil {
+		return err
+	}
+	if keyHash != "" && recorded == "" {
+		// An unreadable manifest says nothing about the algorithm; Get
+		// reports it when the entry is looked up
+		recorded = c.hashAlgoName
+		if m, err := c.loadManifest(keyHash); err == nil {
+			recorded = cmp.Or(m.HashAlgo, DefaultHashAlgoName)
+		}
+	}
+	if keyHash != "" && recorded != c.hashAlgoName {
+		return fmt.Errorf("%w: cache %s holds entries made with %s but is configured with %s; open it with %s, or Clear it before switching",
+			ErrHashAlgoMismatch, c.root, recorded, c.hashAlgoName, recorded)
+	}
+
+	if c.readOnly {
+		return nil
+	}
+	meta.FormatVersion = metaVersion
+	meta.HashAlgo = c.hashAlgoName
+	data, err = json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache metadata: %w", err)
+	}
+	if err := atomicWriteFile(c.fs, c.metaPath(), data, c.fileMode, c.durable); err != nil {
+		return fmt.Errorf("failed to write cache metadata: %w", err)
+	}
+	return nil
+}
+
+// errFound stops a walk once it has found what it was looking for.
+var errFound = errors.New("found")
+
+// anyManifest returns the key hash of one of the cache's manifests, or ""
+// if it has none.
+func (c *Cache) anyManifest() (string, error) {
+	var found string
+	err := afero.Walk(c.fs, c.manifestDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".json") {
+			found = path
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errFound) {
+		return "", fmt.Errorf("failed to scan manifests: %w", err)
+	}
+	if found == "" {
+		return "", nil
+	}
+	return strings.TrimSuffix(filepath.Base(found), ".json"), nil
+}
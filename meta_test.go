@@ -0,0 +1,87 @@
+package granular
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// TestCacheMetadata tests the metadata file written at the cache root.
+func TestCacheMetadata(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithSHA256())
+	assertNoError(t, err, "Open")
+	assertNoError(t, cache.Close(), "Close")
+
+	data, err := afero.ReadFile(fs, ".cache/granular.json")
+	assertNoError(t, err, "read metadata")
+	var meta cacheMeta
+	assertNoError(t, json.Unmarshal(data, &meta), "parse metadata")
+	if meta.FormatVersion != metaVersion {
+		t.Errorf("formatVersion = %d, want %d", meta.FormatVersion, metaVersion)
+	}
+	assertEqual(t, meta.HashAlgo, "sha256", "hash algorithm")
+	if !strings.HasPrefix(meta.CreatedBy, "granular") {
+		t.Errorf("createdBy = %q, want it to name granular", meta.CreatedBy)
+	}
+
+	t.Run("NewerFormat", func(t *testing.T) {
+		newer, _ := json.Marshal(cacheMeta{FormatVersion: metaVersion + 1, HashAlgo: "sha256", CreatedBy: "granular v9.0.0"})
+		createTestFile(t, fs, ".newer/granular.json", newer)
+		_, err := Open(".newer", WithFs(fs), WithSHA256())
+		if err == nil || !strings.Contains(err.Error(), "granular v9.0.0") {
+			t.Errorf("Open of newer cache = %v, want an error naming its creator", err)
+		}
+	})
+}
+
+// TestOpenExisting tests that OpenExisting opens caches but never creates one.
+func TestOpenExisting(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := OpenExisting("missing", WithFs(fs)); !errors.Is(err, ErrNotCache) {
+			t.Fatalf("OpenExisting = %v, want ErrNotCache", err)
+		}
+		if exists, _ := afero.DirExists(fs, "missing"); exists {
+			t.Error("OpenExisting created the directory")
+		}
+	})
+
+	t.Run("UnrelatedDirectory", func(t *testing.T) {
+		createTestFile(t, fs, "src/main.go", []byte("package main"))
+		if _, err := OpenExisting("src", WithFs(fs)); !errors.Is(err, ErrNotCache) {
+			t.Fatalf("OpenExisting = %v, want ErrNotCache", err)
+		}
+		if exists, _ := afero.DirExists(fs, "src/manifests"); exists {
+			t.Error("OpenExisting created cache directories")
+		}
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		cache, err := Open(".cache", WithFs(fs))
+		assertNoError(t, err, "Open")
+		key := cache.Key().String("id", "existing").Build()
+		assertNoError(t, cache.Put(key).Bytes("out", []byte("data")).Commit(), "Commit")
+		assertNoError(t, cache.Close(), "Close")
+
+		cache, err = OpenExisting(".cache", WithFs(fs))
+		assertNoError(t, err, "OpenExisting")
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, "existing entry")
+	})
+
+	t.Run("LegacyCache", func(t *testing.T) {
+		// Caches from before the metadata file have only the directories
+		assertNoError(t, fs.MkdirAll("legacy/manifests", 0o755), "MkdirAll")
+		assertNoError(t, fs.MkdirAll("legacy/objects", 0o755), "MkdirAll")
+		_, err := OpenExisting("legacy", WithFs(fs))
+		assertNoError(t, err, "OpenExisting")
+		if exists, _ := afero.Exists(fs, "legacy/granular.json"); !exists {
+			t.Error("metadata not recorded for legacy cache")
+		}
+	})
+}
@@ -12,6 +12,11 @@ type MetricsHooks struct {
 	// OnMiss is called when a cache lookup doesn't find an entry.
 	OnMiss func(keyHash string)
 
+	// OnGet is called when a lookup completes, hit or not, with the time
+	// spent hashing the key and reading the entry. size is 0 unless hit is
+	// true. Lookups that fail before the key is hashed are not reported.
+	OnGet func(keyHash string, hit bool, size int64, duration time.Duration)
+
 	// OnPut is called when an entry is stored in the cache.
 	// size is the total size of the stored files and data.
 	OnPut func(keyHash string, size int64, duration time.Duration)
@@ -22,6 +27,11 @@ type MetricsHooks struct {
 	// OnError is called when an operation fails.
 	OnError func(op string, err error)
 
+	// OnSlow is called when an operation exceeds its soft time limit
+	// (see WithSlowThresholds). op is one of SlowOpHash, SlowOpGet, or
+	// SlowOpCommit; subject names the offending input, file, or key hash.
+	OnSlow func(op string, subject string, elapsed time.Duration)
+
 	// OnPanic is called when a metrics hook panics.
 	// Receives the hook name (e.g. "OnHit") and the recovered panic value.
 	// If nil, panics are silently recovered (default behavior).
@@ -56,6 +66,13 @@ func (h *MetricsHooks) miss(keyHash string) {
 	}
 }
 
+func (h *MetricsHooks) get(keyHash string, hit bool, size int64, duration time.Duration) {
+	if h != nil && h.OnGet != nil {
+		defer h.recoverHook("OnGet")
+		h.OnGet(keyHash, hit, size, duration)
+	}
+}
+
 func (h *MetricsHooks) put(keyHash string, size int64, duration time.Duration) {
 	if h != nil && h.OnPut != nil {
 		defer h.recoverHook("OnPut")
@@ -77,6 +94,13 @@ func (h *MetricsHooks) error(op string, err error) {
 	}
 }
 
+func (h *MetricsHooks) slow(op, subject string, elapsed time.Duration) {
+	if h != nil && h.OnSlow != nil {
+		defer h.recoverHook("OnSlow")
+		h.OnSlow(op, subject, elapsed)
+	}
+}
+
 // recoverHook recovers from panics in metrics hooks.
 // If OnPanic is set, the recovered value is reported; otherwise silently discarded.
 func (h *MetricsHooks) recoverHook(hookName string) {
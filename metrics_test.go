@@ -2,6 +2,7 @@ package granular
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -361,6 +362,61 @@ func TestMetricsHooks_OnEvict_Expired(t *testing.T) {
 	}
 }
 
+func TestMetricsHooks_OnGet(t *testing.T) {
+	type getEvent struct {
+		keyHash  string
+		hit      bool
+		size     int64
+		duration time.Duration
+	}
+	var events []getEvent
+
+	hooks := &MetricsHooks{
+		OnGet: func(keyHash string, hit bool, size int64, duration time.Duration) {
+			events = append(events, getEvent{keyHash, hit, size, duration})
+		},
+	}
+
+	// Every clock read advances one second
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ticks int
+	nowFunc := func() time.Time {
+		ticks++
+		return base.Add(time.Duration(ticks) * time.Second)
+	}
+
+	cache, err := Open("", WithFs(afero.NewMemMapFs()), WithMetrics(hooks), WithNowFunc(nowFunc))
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+	defer cache.Close()
+
+	key := cache.Key().String("k", "v").Build()
+	cache.Get(key)
+	if err := cache.Put(key).Bytes("data", make([]byte, 42)).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 OnGet events, got %d", len(events))
+	}
+	miss, hit := events[0], events[1]
+	if miss.keyHash != key.Hash() || miss.hit || miss.size != 0 {
+		t.Errorf("unexpected miss event: %+v", miss)
+	}
+	if hit.keyHash != key.Hash() || !hit.hit || hit.size != 42 {
+		t.Errorf("unexpected hit event: %+v", hit)
+	}
+	for _, ev := range events {
+		if ev.duration <= 0 {
+			t.Errorf("expected positive duration, got %v", ev.duration)
+		}
+	}
+}
+
 func TestMetricsHooks_NilHooksDoNotPanic(t *testing.T) {
 	// Cache with nil metrics
 	cache, err := Open("", WithFs(afero.NewMemMapFs()))
@@ -479,3 +535,249 @@ func TestMetricsHooks_HelperMethodsNilSafe(t *testing.T) {
 	h.evict("keyhash", 100, EvictReasonLRU)
 	h.error("op", nil)
 }
+
+func TestMetricsHooks_OnSlow(t *testing.T) {
+	type slowEvent struct {
+		op, subject string
+	}
+	var events []slowEvent
+
+	hooks := &MetricsHooks{
+		OnSlow: func(op, subject string, elapsed time.Duration) {
+			if elapsed <= 500*time.Millisecond {
+				t.Errorf("OnSlow called for %s %s under threshold: %v", op, subject, elapsed)
+			}
+			events = append(events, slowEvent{op, subject})
+		},
+	}
+
+	// Every clock read advances one second, so every timed operation is slow.
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ticks int
+	nowFunc := func() time.Time {
+		ticks++
+		return base.Add(time.Duration(ticks) * time.Second)
+	}
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "src/a.txt", []byte("a"), 0o644)
+	afero.WriteFile(fs, "src/b.txt", []byte("b"), 0o644)
+
+	cache, err := Open("", WithFs(fs), WithMetrics(hooks), WithNowFunc(nowFunc),
+		WithSlowThresholds(SlowThresholds{
+			Hash:   500 * time.Millisecond,
+			Get:    500 * time.Millisecond,
+			Commit: 500 * time.Millisecond,
+		}))
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+	defer cache.Close()
+
+	key := cache.Key().Dir("src").Build()
+	if _, err := cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+
+	seen := make(map[slowEvent]bool)
+	for _, ev := range events {
+		seen[ev] = true
+	}
+	for _, want := range []slowEvent{
+		{SlowOpHash, "src/a.txt"},
+		{SlowOpHash, "src/b.txt"},
+		{SlowOpHash, "dir:src"},
+		{SlowOpGet, key.Hash()},
+	} {
+		if !seen[want] {
+			t.Errorf("expected OnSlow(%q, %q), got %v", want.op, want.subject, events)
+		}
+	}
+
+	events = nil
+	if err := cache.Put(key).Meta("m", "v").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if len(events) == 0 || events[len(events)-1] != (slowEvent{SlowOpCommit, key.Hash()}) {
+		t.Errorf("expected final OnSlow(commit, %s), got %v", key.Hash(), events)
+	}
+}
+
+func TestMetricsHooks_OnSlowDisabledByDefault(t *testing.T) {
+	var called atomic.Int32
+	hooks := &MetricsHooks{
+		OnSlow: func(string, string, time.Duration) { called.Add(1) },
+	}
+
+	cache, err := Open("", WithFs(afero.NewMemMapFs()), WithMetrics(hooks))
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+	defer cache.Close()
+
+	key := cache.Key().String("k", "v").Build()
+	_, _ = cache.Get(key)
+	_ = cache.Put(key).Meta("m", "v").Commit()
+
+	if called.Load() != 0 {
+		t.Errorf("expected no OnSlow calls without thresholds, got %d", called.Load())
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	cache, err := Open("", WithFs(afero.NewMemMapFs()))
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+	defer cache.Close()
+
+	if rate := cache.Metrics().HitRate(); rate != 0 {
+		t.Errorf("HitRate with no lookups = %v, want 0", rate)
+	}
+
+	key := cache.Key().String("k", "v").Build()
+	if _, err := cache.Get(key); err == nil {
+		t.Fatal("expected miss")
+	}
+	if err := cache.Put(key).Bytes("data", make([]byte, 100)).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	for range 3 {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if err := cache.Delete(key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	m := cache.Metrics()
+	if m.Hits != 3 || m.Misses != 1 || m.Puts != 1 || m.Evictions != 1 {
+		t.Errorf("unexpected counters: %+v", m)
+	}
+	if m.BytesServed != 300 {
+		t.Errorf("BytesServed = %d, want 300", m.BytesServed)
+	}
+	if rate := m.HitRate(); rate != 0.75 {
+		t.Errorf("HitRate = %v, want 0.75", rate)
+	}
+}
+
+func TestCacheMetrics_LatencyHistograms(t *testing.T) {
+	// Every clock read advances 3ms, so every operation takes a few of them
+	var mu sync.Mutex
+	now := time.Unix(0, 0)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(3 * time.Millisecond)
+		return now
+	}
+	fs := afero.NewMemMapFs()
+	cache, err := Open("/cache", WithFs(fs), WithNowFunc(clock), WithLatencyHistograms())
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+	defer cache.Close()
+
+	if err := afero.WriteFile(fs, "/src/out.bin", []byte("output"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	key := cache.Key().String("k", "v").Build()
+	if _, err := cache.Get(key); err == nil {
+		t.Fatal("expected miss")
+	}
+	if err := cache.Put(key).File("out.bin", "/src/out.bin").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	result, err := cache.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := result.CopyFile("out.bin", "/dst/out.bin"); err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+
+	m := cache.Metrics()
+	for name, tc := range map[string]struct {
+		h    Histogram
+		want int64
+	}{
+		"hash":    {m.HashLatency, 1}, // the key's hash is computed once
+		"get":     {m.GetLatency, 2},
+		"commit":  {m.CommitLatency, 1},
+		"restore": {m.RestoreLatency, 1},
+	} {
+		if tc.h.Count != tc.want {
+			t.Errorf("%s: Count = %d, want %d", name, tc.h.Count, tc.want)
+		}
+		if len(tc.h.Counts) != len(tc.h.Bounds)+1 {
+			t.Errorf("%s: %d counts for %d bounds", name, len(tc.h.Counts), len(tc.h.Bounds))
+		}
+		if tc.h.Sum < time.Duration(tc.want)*3*time.Millisecond || tc.h.Mean() < 3*time.Millisecond {
+			t.Errorf("%s: Sum = %v, Mean = %v, want at least one clock step per operation", name, tc.h.Sum, tc.h.Mean())
+		}
+	}
+
+	var buf strings.Builder
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus failed: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE granular_hits_total counter\ngranular_hits_total 1\n",
+		"granular_misses_total 1\n",
+		"# TYPE granular_operation_duration_seconds histogram\n",
+		`granular_operation_duration_seconds_bucket{op="get",le="+Inf"} 2` + "\n",
+		`granular_operation_duration_seconds_count{op="restore"} 1` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("exposition missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	// Without the option, nothing is recorded
+	plain, err := Open("", WithFs(afero.NewMemMapFs()))
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+	defer plain.Close()
+	if _, err := plain.Get(plain.Key().String("k", "v").Build()); err == nil {
+		t.Fatal("expected miss")
+	}
+	if m := plain.Metrics(); m.GetLatency.Count != 0 || m.HashLatency.Count != 0 {
+		t.Errorf("latencies recorded without WithLatencyHistograms: get %d, hash %d", m.GetLatency.Count, m.HashLatency.Count)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := Histogram{
+		Bounds: []time.Duration{time.Millisecond, 10 * time.Millisecond, time.Second},
+		Counts: []int64{90, 8, 1, 1},
+		Count:  100,
+		Sum:    3 * time.Second,
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.5, time.Millisecond},
+		{0.95, 10 * time.Millisecond},
+		{0.99, time.Second},
+		{1, time.Second}, // in the overflow bucket: the largest bound
+	} {
+		if got := h.Quantile(tc.q); got != tc.want {
+			t.Errorf("Quantile(%v) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+	if got := h.Mean(); got != 30*time.Millisecond {
+		t.Errorf("Mean = %v, want 30ms", got)
+	}
+	if got := (Histogram{}).Quantile(0.5); got != 0 {
+		t.Errorf("Quantile of an empty histogram = %v, want 0", got)
+	}
+	fast := Histogram{Bounds: h.Bounds, Counts: []int64{5, 0, 0, 0}, Count: 5}
+	if got := fast.Quantile(1); got != time.Millisecond {
+		t.Errorf("Quantile(1) with every operation in the first bucket = %v, want 1ms", got)
+	}
+}
@@ -0,0 +1,89 @@
+package granular
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestNamespace_KeysAreSalted(t *testing.T) {
+	cache, err := Open(".cache", WithFs(afero.NewMemMapFs()))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	a, b := cache.Namespace("a"), cache.Namespace("b")
+
+	rootKey := cache.Key().String("k", "v").Build()
+	aKey := a.Key().String("k", "v").Build()
+	bKey := b.Key().String("k", "v").Build()
+	if rootKey.Hash() == aKey.Hash() || aKey.Hash() == bKey.Hash() {
+		t.Fatal("Expected identical inputs to hash differently across namespaces")
+	}
+
+	if err := a.Put(aKey).Bytes("data", []byte("from a")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if !a.Has(aKey) {
+		t.Error("Expected aKey to be cached in a")
+	}
+	if b.Has(bKey) {
+		t.Error("Expected bKey not to be cached in b")
+	}
+	if cache.Has(rootKey) {
+		t.Error("Expected rootKey not to be cached in cache")
+	}
+}
+
+func TestNamespace_ScopedOperations(t *testing.T) {
+	cache, err := Open(".cache", WithFs(afero.NewMemMapFs()))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	a, b := cache.Namespace("a"), cache.Namespace("b")
+	nested := a.Namespace("sub")
+
+	rootKey := putSized(t, cache, "root", 10)
+	aKey := putSized(t, a, "a", 20)
+	nestedKey := putSized(t, nested, "nested", 40)
+	bKey := putSized(t, b, "b", 80)
+
+	stats, err := a.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Entries != 2 || stats.FileSize+stats.DataSize != 60 {
+		t.Errorf("Namespace a stats = %d entries / %d bytes, want 2 / 60", stats.Entries, stats.FileSize+stats.DataSize)
+	}
+
+	entries, err := nested.Entries()
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].KeyHash != nestedKey.Hash() || entries[0].Namespace != "a/sub" {
+		t.Errorf("Unexpected nested entries: %+v", entries)
+	}
+
+	all, err := cache.Entries()
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	if len(all) != 4 {
+		t.Errorf("Root cache should see all 4 entries, got %d", len(all))
+	}
+
+	if err := a.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if a.Has(aKey) {
+		t.Error("Expected aKey not to be cached in a")
+	}
+	if nested.Has(nestedKey) {
+		t.Error("Expected nestedKey not to be cached in nested")
+	}
+	if !b.Has(bKey) {
+		t.Error("Expected bKey to be cached in b")
+	}
+	if !cache.Has(rootKey) {
+		t.Error("Expected rootKey to be cached in cache")
+	}
+}
@@ -0,0 +1,491 @@
+// Package oci shares granular cache entries through an OCI container
+// registry.
+//
+// Registries are the one blob store most organizations already run, with
+// authentication, replication, and retention policies in place. A
+// Repository packages each cache entry as an OCI artifact tagged with its key
+// hash: the portable manifest is one layer and every object another, so
+// objects shared between entries are uploaded and stored once.
+//
+//	repo, err := oci.New("ghcr.io/acme/build-cache", oci.WithBasicAuth("ci", token))
+//	err = repo.Push(ctx, cache, key.Hash())
+//
+//	// elsewhere
+//	if err := repo.Pull(ctx, cache, key.Hash()); err == nil {
+//		result, _ := cache.Get(key)
+//	}
+//
+// The client speaks the OCI distribution API directly and has no
+// dependencies. It supports anonymous, basic, and bearer token
+// authentication.
+package oci
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/internal/format"
+)
+
+// Media types of the artifacts written by Push.
+const (
+	ArtifactType      = "application/vnd.gophersatwork.granular.entry.v1"
+	ManifestMediaType = "application/vnd.gophersatwork.granular.manifest.v1+json"
+	ObjectMediaType   = "application/vnd.gophersatwork.granular.object.v1"
+
+	imageManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	emptyMediaType         = "application/vnd.oci.empty.v1+json"
+	titleAnnotation        = "org.opencontainers.image.title"
+)
+
+const (
+	// maxManifestSize bounds the size of downloaded OCI and granular
+	// manifests.
+	maxManifestSize = 16 << 20
+
+	// emptyDigest is the digest of the empty JSON object "{}", the config of
+	// every artifact.
+	emptyDigest = "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+)
+
+// descriptor references a blob from an OCI manifest.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest is an OCI image manifest carrying an artifact.
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	ArtifactType  string       `json:"artifactType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+// Repository copies entries between a local cache and a repository in an OCI
+// registry. It is safe for concurrent use.
+type Repository struct {
+	scheme   string
+	host     string
+	name     string
+	user     string
+	password string
+	client   *http.Client
+
+	mu    sync.Mutex
+	token string // bearer token from the last challenge
+}
+
+// Option configures a Repository.
+type Option func(*Repository)
+
+// WithBasicAuth sets the credentials used to log in to the registry, either
+// directly or to obtain bearer tokens.
+func WithBasicAuth(user, password string) Option {
+	return func(r *Repository) {
+		r.user, r.password = user, password
+	}
+}
+
+// WithHTTPClient sets the client used for requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *Repository) {
+		r.client = client
+	}
+}
+
+// WithPlainHTTP talks to the registry over HTTP instead of HTTPS, for local
+// registries.
+func WithPlainHTTP() Option {
+	return func(r *Repository) {
+		r.scheme = "http"
+	}
+}
+
+// New returns a Repository for ref, a repository name including the registry
+// host, such as "ghcr.io/acme/build-cache" or "localhost:5000/cache".
+func New(ref string, opts ...Option) (*Repository, error) {
+	host, name, ok := strings.Cut(ref, "/")
+	if !ok || name == "" || !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return nil, fmt.Errorf("oci: invalid repository %q: want <registry>/<name>", ref)
+	}
+	if name != strings.ToLower(name) || strings.ContainsAny(name, ":@") {
+		return nil, fmt.Errorf("oci: invalid repository name %q", name)
+	}
+	r := &Repository{scheme: "https", host: host, name: name, client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// Push uploads the entry with the given key hash from cache, tagged with the
+// key hash. Objects the registry already has are not uploaded again. It
+// returns granular.ErrCacheMiss if cache does not have the entry.
+func (r *Repository) Push(ctx context.Context, cache *granular.Cache, keyHash string) error {
+	data, err := cache.ExportManifest(keyHash)
+	if err != nil {
+		return err
+	}
+	objects, err := objectNames(data)
+	if err != nil {
+		return err
+	}
+
+	if err := r.pushBlob(ctx, emptyDigest, 2, bytesBody([]byte("{}"))); err != nil {
+		return err
+	}
+
+	layer := descriptor{MediaType: ManifestMediaType, Digest: digest(data), Size: int64(len(data))}
+	layer.Annotations = map[string]string{titleAnnotation: "manifest.json"}
+	if err := r.pushBlob(ctx, layer.Digest, layer.Size, bytesBody(data)); err != nil {
+		return err
+	}
+	layers := []descriptor{layer}
+
+	for _, object := range objects {
+		open := func() (io.ReadCloser, error) { return cache.OpenObject(keyHash, object) }
+		layer, err := describe(open)
+		if err != nil {
+			return err
+		}
+		layer.Annotations = map[string]string{titleAnnotation: object}
+		if err := r.pushBlob(ctx, layer.Digest, layer.Size, open); err != nil {
+			return err
+		}
+		layers = append(layers, layer)
+	}
+
+	m, err := json.Marshal(manifest{
+		SchemaVersion: 2,
+		MediaType:     imageManifestMediaType,
+		ArtifactType:  ArtifactType,
+		Config:        descriptor{MediaType: emptyMediaType, Digest: emptyDigest, Size: 2},
+		Layers:        layers,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(ctx, http.MethodPut, r.url("manifests", keyHash), http.Header{"Content-Type": {imageManifestMediaType}}, bytesBody(m), int64(len(m)))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// describe returns the descriptor of an object, reading it once.
+func describe(open func() (io.ReadCloser, error)) (descriptor, error) {
+	f, err := open()
+	if err != nil {
+		return descriptor{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return descriptor{}, err
+	}
+	return descriptor{MediaType: ObjectMediaType, Digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+// pushBlob uploads a blob unless the repository already has it. The upload is
+// monolithic: one POST to start it and one PUT with the content.
+func (r *Repository) pushBlob(ctx context.Context, dgst string, size int64, open func() (io.ReadCloser, error)) error {
+	resp, err := r.do(ctx, http.MethodHead, r.url("blobs", dgst), nil, nil, 0)
+	if err == nil {
+		return resp.Body.Close()
+	}
+	if !errors.Is(err, granular.ErrCacheMiss) {
+		return err
+	}
+
+	resp, err = r.do(ctx, http.MethodPost, r.url("blobs", "uploads/"), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("oci: upload of %s: missing or invalid Location", dgst)
+	}
+	query := location.Query()
+	query.Set("digest", dgst)
+	location.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err = r.do(ctx, http.MethodPut, location.String(), header, open, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Pull downloads the entry with the given key hash into cache. It returns
+// granular.ErrCacheMiss if the repository has no such tag. Every blob is
+// checked against its digest, and the entry against its manifest, before it
+// becomes visible in cache.
+func (r *Repository) Pull(ctx context.Context, cache *granular.Cache, keyHash string) error {
+	m, err := r.manifest(ctx, keyHash)
+	if err != nil {
+		return err
+	}
+	if m.ArtifactType != ArtifactType {
+		return fmt.Errorf("oci: %s:%s is not a granular entry (artifact type %q)", r.name, keyHash, m.ArtifactType)
+	}
+
+	var data []byte
+	for _, layer := range m.Layers {
+		switch layer.MediaType {
+		case ManifestMediaType:
+			if layer.Size > maxManifestSize {
+				return fmt.Errorf("oci: manifest of %s is too large (%d bytes)", keyHash, layer.Size)
+			}
+			var buf bytes.Buffer
+			if err := r.pullBlob(ctx, layer, func(rd io.Reader) error {
+				_, err := buf.ReadFrom(rd)
+				return err
+			}); err != nil {
+				return err
+			}
+			data = buf.Bytes()
+		case ObjectMediaType:
+			object := layer.Annotations[titleAnnotation]
+			if err := r.pullBlob(ctx, layer, func(rd io.Reader) error {
+				return cache.StageObject(keyHash, object, rd)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	if data == nil {
+		return fmt.Errorf("oci: %s:%s has no manifest layer", r.name, keyHash)
+	}
+	return cache.ImportManifest(keyHash, data)
+}
+
+// pullBlob downloads a blob and passes its content to consume, then checks
+// it against the descriptor.
+func (r *Repository) pullBlob(ctx context.Context, desc descriptor, consume func(io.Reader) error) error {
+	algo, want, ok := strings.Cut(desc.Digest, ":")
+	if !ok || algo != "sha256" {
+		return fmt.Errorf("oci: unsupported digest %q", desc.Digest)
+	}
+	resp, err := r.do(ctx, http.MethodGet, r.url("blobs", desc.Digest), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	rd := &digestReader{r: io.LimitReader(resp.Body, desc.Size), h: sha256.New()}
+	if err := consume(rd); err != nil {
+		return err
+	}
+	if rd.n != desc.Size || hex.EncodeToString(rd.h.Sum(nil)) != want {
+		return fmt.Errorf("%w: blob %s does not match its digest", granular.ErrCacheCorrupted, desc.Digest)
+	}
+	return nil
+}
+
+// digestReader hashes and counts what is read through it.
+type digestReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
+
+// Has reports whether the repository has the entry with the given key hash.
+func (r *Repository) Has(ctx context.Context, keyHash string) (bool, error) {
+	resp, err := r.do(ctx, http.MethodHead, r.url("manifests", keyHash), http.Header{"Accept": {imageManifestMediaType}}, nil, 0)
+	if errors.Is(err, granular.ErrCacheMiss) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, resp.Body.Close()
+}
+
+// manifest downloads the OCI manifest tagged tag.
+func (r *Repository) manifest(ctx context.Context, tag string) (*manifest, error) {
+	resp, err := r.do(ctx, http.MethodGet, r.url("manifests", tag), http.Header{"Accept": {imageManifestMediaType}}, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var m manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("oci: manifest %s:%s: %w", r.name, tag, err)
+	}
+	return &m, nil
+}
+
+func (r *Repository) url(kind, ref string) string {
+	return r.scheme + "://" + r.host + "/v2/" + r.name + "/" + kind + "/" + ref
+}
+
+// do sends a request and returns the response if it succeeded. body, if not
+// nil, is called for each attempt. When the registry answers with a bearer
+// challenge, a token is obtained and the request retried once. Error
+// statuses are turned into errors; 404 becomes granular.ErrCacheMiss.
+func (r *Repository) do(ctx context.Context, method, target string, header http.Header, body func() (io.ReadCloser, error), size int64) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			if req.Body, err = body(); err != nil {
+				return nil, err
+			}
+			req.ContentLength = size
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		r.mu.Lock()
+		token := r.token
+		r.mu.Unlock()
+		switch {
+		case token != "":
+			req.Header.Set("Authorization", "Bearer "+token)
+		case r.user != "" || r.password != "":
+			req.SetBasicAuth(r.user, r.password)
+		}
+		return r.client.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil {
+		return nil, fmt.Errorf("oci: %w", err)
+	}
+	if challenge := resp.Header.Get("Www-Authenticate"); resp.StatusCode == http.StatusUnauthorized && strings.HasPrefix(challenge, "Bearer ") {
+		_ = resp.Body.Close()
+		if err := r.login(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = send(); err != nil {
+			return nil, fmt.Errorf("oci: %w", err)
+		}
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, granular.ErrCacheMiss
+	}
+	return nil, fmt.Errorf("oci: %s %s: %s: %s", method, target, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// login obtains a bearer token for a WWW-Authenticate challenge, using the
+// basic credentials if set.
+func (r *Repository) login(ctx context.Context, challenge string) error {
+	params := parseChallenge(strings.TrimPrefix(challenge, "Bearer "))
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("oci: invalid auth challenge %q", challenge)
+	}
+	query := realm.Query()
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.user != "" || r.password != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oci: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oci: token request to %s: %s", realm.Host, resp.Status)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("oci: token response: %w", err)
+	}
+	r.mu.Lock()
+	r.token = cmp.Or(tok.Token, tok.AccessToken)
+	r.mu.Unlock()
+	return nil
+}
+
+// parseChallenge parses the comma-separated key="value" parameters of an
+// authentication challenge.
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		name, rest, ok := strings.Cut(strings.TrimLeft(s, " ,"), "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(name))] = value
+		s = rest
+	}
+	return params
+}
+
+func bytesBody(data []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// objectNames returns the names of the objects listed in a portable manifest.
+func objectNames(data []byte) ([]string, error) {
+	m, err := format.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	objects := slices.Concat(slices.Collect(maps.Values(m.OutputFiles)), slices.Collect(maps.Values(m.OutputData)))
+	slices.Sort(objects)
+	return slices.Compact(objects), nil // Names may share a blob
+}
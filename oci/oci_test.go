@@ -0,0 +1,221 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+)
+
+// fakeRegistry serves the subset of the OCI distribution API used by
+// Repository, behind bearer token authentication: requests without the token
+// get a challenge pointing at /token, which hands it out for the right basic
+// credentials. It counts blob uploads.
+type fakeRegistry struct {
+	*httptest.Server
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+	reg := &fakeRegistry{blobs: make(map[string][]byte), manifests: make(map[string][]byte)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "ci" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "tok-" + r.URL.Query().Get("scope")})
+	})
+	mux.HandleFunc("/v2/team/cache/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok-repository:team/cache:pull,push" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:team/cache:pull,push"`, reg.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.mu.Lock()
+		defer reg.mu.Unlock()
+
+		switch kind, ref, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/team/cache/"), "/"); {
+		case kind == "blobs" && ref == "uploads/" && r.Method == http.MethodPost:
+			w.Header().Set("Location", "/v2/team/cache/blobs/uploads/session?state=x")
+			w.WriteHeader(http.StatusAccepted)
+		case kind == "blobs" && ref == "uploads/session" && r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			sum := sha256.Sum256(data)
+			digest := "sha256:" + hex.EncodeToString(sum[:])
+			if r.URL.Query().Get("digest") != digest || r.URL.Query().Get("state") != "x" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reg.blobs[digest] = data
+			reg.uploads++
+			w.WriteHeader(http.StatusCreated)
+		case kind == "blobs":
+			data, ok := reg.blobs[ref]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		case kind == "manifests" && r.Method == http.MethodPut:
+			if r.Header.Get("Content-Type") != imageManifestMediaType {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			reg.manifests[ref], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case kind == "manifests":
+			data, ok := reg.manifests[ref]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", imageManifestMediaType)
+			_, _ = w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	reg.Server = httptest.NewServer(mux)
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+func (reg *fakeRegistry) repository(t *testing.T) *Repository {
+	t.Helper()
+	repo, err := New(strings.TrimPrefix(reg.URL, "http://")+"/team/cache",
+		WithPlainHTTP(), WithHTTPClient(reg.Client()), WithBasicAuth("ci", "secret"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return repo
+}
+
+func TestPushPull(t *testing.T) {
+	reg := newFakeRegistry(t)
+	repo := reg.repository(t)
+	ctx := t.Context()
+
+	src := granular.OpenTemp()
+	key := src.Key().String("target", "app").Build()
+	if err := src.Put(key).Bytes("out", []byte("built")).Bytes("log", []byte("ok")).Meta("tool", "go").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := repo.Push(ctx, src, key.Hash()); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	// config, manifest, and two objects
+	if reg.uploads != 4 {
+		t.Errorf("uploads = %d, want 4", reg.uploads)
+	}
+	if err := repo.Push(ctx, src, key.Hash()); err != nil {
+		t.Fatalf("second Push failed: %v", err)
+	}
+	if reg.uploads != 4 {
+		t.Errorf("uploads after second Push = %d, want 4 (blobs are deduplicated)", reg.uploads)
+	}
+	if has, err := repo.Has(ctx, key.Hash()); err != nil || !has {
+		t.Errorf("Has = %v, %v, want true", has, err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(reg.manifests[key.Hash()], &m); err != nil {
+		t.Fatalf("invalid OCI manifest: %v", err)
+	}
+	if m.ArtifactType != ArtifactType || m.Config.Digest != emptyDigest || len(m.Layers) != 3 {
+		t.Errorf("OCI manifest = %+v", m)
+	}
+
+	dst := granular.OpenTemp()
+	if err := repo.Pull(ctx, dst, key.Hash()); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	result, err := dst.Get(dst.Key().String("target", "app").Build())
+	if err != nil {
+		t.Fatalf("Get after Pull failed: %v", err)
+	}
+	if data, _ := result.BytesErr("out"); string(data) != "built" {
+		t.Errorf("pulled data = %q", data)
+	}
+	if got := result.Meta("tool"); got != "go" {
+		t.Errorf("pulled meta = %q", got)
+	}
+}
+
+func TestPullErrors(t *testing.T) {
+	reg := newFakeRegistry(t)
+	repo := reg.repository(t)
+	ctx := t.Context()
+	cache := granular.OpenTemp()
+
+	if err := repo.Pull(ctx, cache, "0123456789abcdef"); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Pull of missing tag = %v, want ErrCacheMiss", err)
+	}
+	if has, err := repo.Has(ctx, "0123456789abcdef"); err != nil || has {
+		t.Errorf("Has of missing tag = %v, %v, want false", has, err)
+	}
+	if err := repo.Push(ctx, cache, "0123456789abcdef"); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Push of missing entry = %v, want ErrCacheMiss", err)
+	}
+
+	src := granular.OpenTemp()
+	key := src.Key().String("target", "app").Build()
+	if err := src.Put(key).Bytes("out", []byte("built")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := repo.Push(ctx, src, key.Hash()); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	for digest, data := range reg.blobs {
+		if string(data) == "built" {
+			reg.blobs[digest] = []byte("tampered")
+		}
+	}
+	if err := repo.Pull(ctx, cache, key.Hash()); !errors.Is(err, granular.ErrCacheCorrupted) {
+		t.Errorf("Pull of tampered blob = %v, want ErrCacheCorrupted", err)
+	}
+	if _, err := cache.Get(cache.Key().String("target", "app").Build()); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Get after failed Pull = %v, want ErrCacheMiss", err)
+	}
+
+	reg.manifests["image"] = []byte(`{"schemaVersion":2,"artifactType":"application/vnd.example","layers":[]}`)
+	if err := repo.Pull(ctx, cache, "image"); err == nil || !strings.Contains(err.Error(), "not a granular entry") {
+		t.Errorf("Pull of foreign artifact = %v", err)
+	}
+
+	denied, _ := New(strings.TrimPrefix(reg.URL, "http://")+"/team/cache", WithPlainHTTP(), WithHTTPClient(reg.Client()))
+	if _, err := denied.Has(ctx, key.Hash()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Has without credentials = %v, want 401", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for ref, valid := range map[string]bool{
+		"ghcr.io/acme/build-cache": true,
+		"localhost:5000/cache":     true,
+		"localhost/cache":          true,
+		"acme/build-cache":         false,
+		"ghcr.io":                  false,
+		"ghcr.io/Acme/cache":       false,
+		"ghcr.io/acme/cache:tag":   false,
+	} {
+		if _, err := New(ref); (err == nil) != valid {
+			t.Errorf("New(%q) error = %v, want valid %v", ref, err, valid)
+		}
+	}
+}
@@ -2,15 +2,50 @@ package granular
 
 import (
 	"crypto/sha256"
+	"fmt"
 	"hash"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
+	"github.com/gophersatwork/granular/backend"
+	"github.com/gophersatwork/granular/internal/blake3"
 	"github.com/spf13/afero"
 )
 
 // DefaultHashAlgoName is the name of the default hash algorithm (xxhash64).
 const DefaultHashAlgoName = "xxhash64"
 
+// HashAlgo names a built-in hash algorithm, selected with WithHashAlgo. The
+// name is recorded in manifests.
+type HashAlgo string
+
+// Built-in hash algorithms.
+const (
+	// XXHash64 is the default: the fastest, with 64-bit keys, but not
+	// collision-resistant (see WithXXHash).
+	XXHash64 HashAlgo = DefaultHashAlgoName
+
+	// SHA256 is collision-resistant, with 256-bit keys (see WithSHA256).
+	SHA256 HashAlgo = "sha256"
+
+	// Blake3 is collision-resistant like SHA256, with 256-bit keys, and
+	// hashes large inputs on all cores: files of a few hundred kilobytes
+	// and up are split into subtrees hashed in parallel. It is implemented
+	// in pure Go, so on a single core it is slower than SHA256 (which uses
+	// CPU instructions where available) and it overtakes SHA256 from about
+	// four cores. xxHash64 remains faster still.
+	Blake3 HashAlgo = "blake3"
+)
+
+// hashAlgos maps the built-in algorithms to their hash functions.
+var hashAlgos = map[HashAlgo]HashFunc{
+	XXHash64: func() hash.Hash { return xxhash.New() },
+	SHA256:   sha256.New,
+	Blake3:   blake3.New,
+}
+
 // WithFs sets a custom filesystem for the cache.
 // This is primarily useful for testing with in-memory filesystems.
 //
@@ -27,8 +62,9 @@ func WithFs(fs afero.Fs) Option {
 // The name is stored in the manifest to detect algorithm changes.
 // The default is xxHash64, which provides excellent performance.
 //
-// Note: Changing the hash function will cause existing cache entries to be
-// treated as misses (ErrHashAlgoMismatch) since the key hash would differ.
+// Note: The name is also recorded at the cache root. Opening a cache that
+// holds entries made with a different name fails with ErrHashAlgoMismatch,
+// since none of its keys could be hit; Clear it with the old function first.
 //
 // Example:
 //
@@ -42,14 +78,52 @@ func WithHashFunc(name string, hashFunc func() hash.Hash) Option {
 
 // WithXXHash configures the cache to use xxHash64 (the default).
 // xxHash64 provides excellent performance for cache key hashing.
+//
+// Its 64-bit digests make accidental collisions negligible for caches of
+// realistic size (about one in a million at six million entries), but it is
+// not collision-resistant: whoever controls the inputs can construct two
+// with the same key. Use WithSHA256 for caches shared with untrusted parties.
 func WithXXHash() Option {
-	return WithHashFunc("xxhash64", func() hash.Hash { return xxhash.New() })
+	return WithHashAlgo(XXHash64)
 }
 
 // WithSHA256 configures the cache to use SHA-256 for hashing.
 // SHA-256 is slower than xxHash64 but provides cryptographic properties.
+//
+// Key hashes and output checksums become 256-bit: no one can feasibly craft
+// inputs that collide with another key or outputs that pass verification
+// with different content. Use it when keys cover third-party or untrusted
+// inputs, or when entries come from caches you do not control.
+//
+// The algorithm is recorded at the cache root. Opening a cache that holds
+// entries written with another algorithm fails with ErrHashAlgoMismatch, as
+// does Get for a single entry whose manifest records one. To switch, Clear
+// the cache with the old algorithm first, or use a fresh directory.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithSHA256())
 func WithSHA256() Option {
-	return WithHashFunc("sha256", sha256.New)
+	return WithHashAlgo(SHA256)
+}
+
+// WithHashAlgo configures the cache to use one of the built-in hash
+// algorithms: XXHash64 (the default), SHA256, or Blake3. Open fails for any
+// other name; use WithHashFunc for algorithms of your own.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithHashAlgo(granular.Blake3))
+func WithHashAlgo(algo HashAlgo) Option {
+	return func(c *Cache) {
+		hashFunc, ok := hashAlgos[algo]
+		if !ok {
+			c.optionErr = fmt.Errorf("unknown hash algorithm %q", algo)
+			return
+		}
+		c.hashFunc = hashFunc
+		c.hashAlgoName = string(algo)
+	}
 }
 
 // WithNowFunc sets a custom time function for the cache.
@@ -141,3 +215,560 @@ func WithMetrics(hooks *MetricsHooks) Option {
 		c.metrics = hooks
 	}
 }
+
+// WithStrictWalks makes operations that walk every manifest (Stats, Entries,
+// Prune, PruneUnused, GC, and size-based eviction) fail on the first manifest
+// that cannot be parsed, instead of silently skipping it.
+//
+// By default corrupted manifests are skipped (and cleaned up by operations that
+// hold the write lock); Stats.SkippedManifests reports how many were skipped.
+// Strict mode is useful for operators who would rather see loud failures than
+// statistics and pruning decisions skewed by hidden damage.
+//
+// Errors returned in strict mode wrap ErrCacheCorrupted.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithStrictWalks())
+func WithStrictWalks() Option {
+	return func(c *Cache) {
+		c.strictWalks = true
+	}
+}
+
+// WithSlowThresholds sets soft time limits for key hashing, Get, and Commit.
+// Operations that exceed a limit still complete normally, but are reported
+// through MetricsHooks.OnSlow together with the offending input, file, or key
+// hash. This makes it easy to find the one huge file that slows down every
+// key build.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache",
+//		granular.WithSlowThresholds(granular.SlowThresholds{
+//			Hash: 2 * time.Second,
+//			Get:  5 * time.Second,
+//		}),
+//		granular.WithMetrics(&granular.MetricsHooks{
+//			OnSlow: func(op, subject string, elapsed time.Duration) {
+//				log.Printf("slow %s: %s took %v", op, subject, elapsed)
+//			},
+//		}))
+func WithSlowThresholds(t SlowThresholds) Option {
+	return func(c *Cache) {
+		c.slow = t
+	}
+}
+
+// WithLatencyHistograms records how long key hashing, Get, Commit, and
+// Result.CopyFile take in latency histograms, reported by Metrics and
+// WritePrometheus. Averages hide tails, such as the occasional Get that
+// takes seconds on a cold network filesystem; histograms show them.
+// Recording costs two clock reads per operation, so it is off by default.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithLatencyHistograms())
+//	// ...
+//	m := cache.Metrics()
+//	log.Printf("get p99 <= %v over %d gets", m.GetLatency.Quantile(0.99), m.GetLatency.Count)
+func WithLatencyHistograms() Option {
+	return func(c *Cache) {
+		c.recordLatency = true
+	}
+}
+
+// WithVerifyOnGet controls whether Get re-hashes the cached output files and
+// data and compares them against the OutputHash stored at Commit time.
+//
+// Verification is enabled by default: bit rot and partial writes are detected,
+// the broken entry is removed, and Get returns ErrCacheCorrupted. Disabling it
+// makes hits on large artifacts cheaper, since Get then only reads the manifest
+// instead of every cached byte.
+//
+// Example:
+//
+//	// Trust the cache directory and skip re-hashing on every hit
+//	cache, err := granular.Open(".cache", granular.WithVerifyOnGet(false))
+func WithVerifyOnGet(verify bool) Option {
+	return func(c *Cache) {
+		c.verifyOnGet = verify
+	}
+}
+
+// WithSelfHeal makes Get treat a corrupted entry as a cache miss.
+//
+// Get always removes entries whose manifest cannot be parsed, whose object
+// files are missing, or whose outputs no longer match the stored hash. By
+// default it then returns ErrCacheCorrupted; with self-healing enabled it
+// returns ErrCacheMiss instead, so callers simply recompute and a single
+// damaged entry never breaks a pipeline run. Corruption is still reported
+// through MetricsHooks.OnError.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithSelfHeal())
+func WithSelfHeal() Option {
+	return func(c *Cache) {
+		c.selfHeal = true
+	}
+}
+
+// WithDurableWrites makes commits crash-safe.
+//
+// Object files and manifests are fsynced before they are renamed into place,
+// and the object directory is flushed before the manifest that references it
+// is written, so a manifest is never visible ahead of its data. In-flight
+// commits are recorded in a journal under the cache root, and each record is
+// refreshed while its commit runs; when a durable cache is opened, commits
+// whose record has not been refreshed for a minute, because their process
+// is gone, are either verified and kept or rolled back. Records still being
+// refreshed belong to commits running in another process and are left
+// alone.
+//
+// Durable writes are slower, especially on spinning disks and network
+// filesystems, so they are disabled by default.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithDurableWrites())
+func WithDurableWrites() Option {
+	return func(c *Cache) {
+		c.durable = true
+	}
+}
+
+// WithAutoPrune starts a background goroutine that performs a maintenance pass
+// every interval, until Close is called. Each pass runs the steps enabled in
+// policy, in order: TTL pruning (MaxAge, MaxIdle), size enforcement (MaxSize),
+// and orphan cleanup (CollectOrphans).
+//
+// Maintenance errors are reported through MetricsHooks.OnError with the
+// operation "autoprune"; evictions are reported through MetricsHooks.OnEvict
+// as usual. A non-positive interval disables background maintenance.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithAutoPrune(time.Hour, granular.PrunePolicy{
+//		MaxIdle:        7 * 24 * time.Hour,
+//		MaxSize:        10 << 30,
+//		CollectOrphans: true,
+//	}))
+//	defer cache.Close()
+func WithAutoPrune(interval time.Duration, policy PrunePolicy) Option {
+	return func(c *Cache) {
+		c.maintenance = &maintenance{interval: interval, policy: policy}
+	}
+}
+
+// WithLogger sets a structured logger for cache activity.
+//
+// Key hashing, hits, misses, commits, and evictions are logged at debug
+// level; pruning, garbage collection, and Clear at info level; operation
+// errors at warn level. Records use consistent attribute names: key_hash,
+// bytes, and duration. A nil logger disables logging, which is the default.
+//
+// Example:
+//
+//	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+//	cache, err := granular.Open(".cache", granular.WithLogger(logger))
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Cache) {
+		c.logger = logger
+	}
+}
+
+// WithIndex keeps an index of entry summaries so Stats and Entries are
+// answered from memory instead of walking and stat-ing every manifest.
+//
+// The index is loaded when the cache is opened and updated as entries are
+// stored, accessed, and removed. Close saves it as index.json under the cache
+// root; if the process exits without Close, or the manifests have been
+// added, removed, or rewritten since (by another process, or a Cache opened
+// without WithIndex), the next Open rebuilds it from the manifests. While
+// open, the index only sees changes made through this Cache: when several
+// processes share a cache directory, call RebuildIndex to pick up their
+// writes. Stats.SkippedManifests is always 0 when the index is used.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithIndex())
+//	defer cache.Close()
+func WithIndex() Option {
+	return func(c *Cache) {
+		c.index = &index{entries: make(map[string]Entry), modTimes: make(map[string]int64)}
+	}
+}
+
+// WithAccessTracking sets how often Get records access times.
+//
+// Every hit updates the entry's AccessedAt, which PruneUnused, PruneToSize,
+// and size-based eviction use to find unused entries. By default the
+// manifest is rewritten on every hit; with a debounce interval it is only
+// rewritten when the recorded access time is at least that old, so a hot
+// entry costs one manifest write per interval instead of one per Get.
+// Access times are then accurate to within the interval.
+//
+// Example:
+//
+//	// Record access at most once a minute per entry
+//	cache, err := granular.Open(".cache", granular.WithAccessTracking(time.Minute))
+func WithAccessTracking(debounce time.Duration) Option {
+	return func(c *Cache) {
+		c.accessDebounce = debounce
+	}
+}
+
+// WithBackend stores the whole cache in b, such as a cloud storage bucket.
+// It is shorthand for WithFs(backend.NewFs(b)); see the backend package for
+// the trade-offs of storing files in a blob store. Objects are buffered in
+// memory while they are written, so the largest object stored must fit in
+// memory. To keep large objects on a filesystem, store only the manifests in
+// b with WithManifestBackend.
+//
+// Example:
+//
+//	b := gcs.New("my-bucket", gcs.WithHTTPClient(client), gcs.WithPrefix("build-cache/"))
+//	cache, err := granular.Open("", granular.WithBackend(b))
+func WithBackend(b backend.Backend) Option {
+	return func(c *Cache) {
+		c.fs = backend.NewFs(b)
+	}
+}
+
+// WithManifestBackend stores manifests in b instead of the cache filesystem.
+// Objects stay on the filesystem set with WithFs (the local disk by default).
+//
+// Every Get and Has starts by reading a manifest, so keeping manifests in a
+// low-latency store such as Redis speeds up lookups on caches whose objects
+// live on a slow shared filesystem. Use a backend scoped to this cache: Clear
+// removes every key in it.
+//
+// Example:
+//
+//	// Manifests in Redis, objects on NFS
+//	rdb := redis.New("redis.internal:6379", redis.WithPrefix("build-cache:"))
+//	cache, err := granular.Open("/mnt/nfs/build-cache", granular.WithManifestBackend(rdb))
+func WithManifestBackend(b backend.Backend) Option {
+	return func(c *Cache) {
+		c.manifestBackend = b
+	}
+}
+
+// WithCodec sets how PutValue, GetValue, and Func encode values. The default
+// is JSONCodec. Entries written with one codec cannot be read with another.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithCodec(granular.GobCodec))
+func WithCodec(codec Codec) Option {
+	return func(c *Cache) {
+		c.codec = codec
+	}
+}
+
+// WithWatchInterval sets how often Watch polls the files backing a watched
+// key on filesystems without change notifications, such as in-memory ones.
+// Shorter intervals notice changes sooner at the cost of more stat calls.
+// The default is 500ms; a non-positive interval keeps the default. Watches
+// on the OS filesystem are notified by the operating system and do not
+// poll, unless it refuses a new watcher.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithWatchInterval(100*time.Millisecond))
+func WithWatchInterval(interval time.Duration) Option {
+	return func(c *Cache) {
+		c.watchInterval = max(interval, 0)
+	}
+}
+
+// WithBufferSize sets the size of the buffers used to stream files when
+// hashing key inputs and outputs and when copying files into and out of the
+// cache. The default is 32KB; caches of large artifacts can spend less time
+// in read calls with buffers of 1-4MB. Buffers are pooled per size and
+// shared by caches using the same size. Hashes do not depend on the buffer
+// size. A non-positive size keeps the default.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithBufferSize(1<<20))
+func WithBufferSize(n int) Option {
+	return func(c *Cache) {
+		c.bufferSize = max(n, 0)
+	}
+}
+
+// WithProgress sets a callback reporting progress while files are hashed
+// and copied: key inputs during Get, Has, and Commit, outputs copied into
+// the cache and hashed by Commit (and by Get with WithVerifyOnGet), and
+// files restored by Result.CopyFile. Each file is reported every few
+// megabytes and once more when it is done, so tools can show that a
+// multi-gigabyte key is being computed rather than appear frozen. Data
+// stored with WriteBuilder.Bytes is not reported.
+//
+// The callback runs synchronously on the goroutine doing the work, possibly
+// on several goroutines at once (for example in GetMulti), so it must be
+// fast and safe for concurrent use.
+//
+// Example:
+//
+//	var hashed atomic.Int64
+//	cache, err := granular.Open(".cache", granular.WithProgress(func(ev granular.ProgressEvent) {
+//		if ev.Done && ev.Op == granular.ProgressOpHash {
+//			fmt.Fprintf(os.Stderr, "\rhashed %d files", hashed.Add(1))
+//		}
+//	}))
+func WithProgress(fn func(ProgressEvent)) Option {
+	return func(c *Cache) {
+		c.onProgress = fn
+	}
+}
+
+// WithCaseInsensitivePaths makes the cache safe to share between
+// case-sensitive and case-insensitive filesystems (Linux and macOS or
+// Windows). Paths of File, Glob, and Dir inputs are lowercased before they
+// are hashed, so "Src/main.go" and "src/main.go" produce the same key. A
+// file or data name that differs only by case from one already added to
+// the WriteBuilder is a validation error, returned by Commit, since their
+// objects would overwrite each other on a case-insensitive filesystem.
+// Shard directories are named by lower-case key hashes and never collide.
+//
+// Keys computed with this option differ from keys computed without it, so
+// every process sharing a cache must use the same setting.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithCaseInsensitivePaths())
+func WithCaseInsensitivePaths() Option {
+	return func(c *Cache) {
+		c.foldCase = true
+	}
+}
+
+// WithBasePath hashes the paths of File, Glob, and Dir inputs relative to
+// root, typically the project root, instead of as given. Keys then stay the
+// same when the project is checked out in another directory or CI
+// workspace, or when a tool runs from a subdirectory: "/ci/ws/src/main.go",
+// "../src/main.go" run from "/ci/ws/cmd", and "src/main.go" run from
+// "/ci/ws" all hash as "src/main.go". Relative root and input paths are
+// resolved against the working directory; inputs outside root are hashed
+// as given.
+//
+// Keys computed with this option differ from keys computed without it, so
+// every process sharing a cache must use the same setting.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithBasePath("."))
+func WithBasePath(root string) Option {
+	return func(c *Cache) {
+		c.basePath = root
+	}
+}
+
+// WithSkipRepeatedFiles makes each key read every input file once. When
+// inputs overlap, as when a Dir and a Glob cover the same tree or one file
+// is added twice, later occurrences of a file are hashed as a reference to
+// the first instead of its content, which saves rereading large trees.
+//
+// Keys whose inputs overlap differ from keys computed without this option,
+// so every process sharing a cache must use the same setting. Keys without
+// overlapping inputs are unchanged.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithSkipRepeatedFiles())
+func WithSkipRepeatedFiles() Option {
+	return func(c *Cache) {
+		c.skipRepeats = true
+	}
+}
+
+// WithSalt mixes salt into every key hash. Changing the salt invalidates the
+// whole cache logically without deleting anything: keys computed with the
+// new salt never match entries stored with the old one, which stop being
+// hit and are removed by Prune or eviction like any other unused entry.
+// This is the way out after discovering that a bad toolchain produced
+// poisoned artifacts. An empty salt leaves keys unchanged.
+//
+// Every process sharing a cache must use the same salt to share entries.
+//
+// Example:
+//
+//	// Bumped after the broken compiler release of 2026-03
+//	cache, err := granular.Open(".cache", granular.WithSalt("gen-2"))
+func WithSalt(salt string) Option {
+	return func(c *Cache) {
+		c.salt = salt
+	}
+}
+
+// WithRefresher sets the callback that refreshes entries past the soft TTL
+// set with WriteBuilder.RefreshAfter. Get and GetMulti still return such
+// entries immediately and call refresh with their key in a background
+// goroutine, at most once at a time per entry; refresh typically recomputes
+// the result and stores it again with Put, which restarts the soft TTL.
+// Errors are reported through MetricsHooks.OnError, and the next hit
+// schedules another attempt. Close waits for running refreshes.
+//
+// Example:
+//
+//	var cache *granular.Cache
+//	cache, err := granular.Open(".cache", granular.WithRefresher(func(key granular.Key) error {
+//		deps, err := resolveDependencies()
+//		if err != nil {
+//			return err
+//		}
+//		return cache.Put(key).Bytes("deps", deps).RefreshAfter(time.Hour).Commit()
+//	}))
+func WithRefresher(refresh func(Key) error) Option {
+	return func(c *Cache) {
+		c.refresher = &refresher{fn: refresh}
+	}
+}
+
+// WithLockTimeout sets how long LockKey and GetOrLock wait for a key locked
+// by another process before giving up with ErrLockTimeout. The default is
+// 10 minutes; a non-positive timeout keeps the default.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithLockTimeout(time.Minute))
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(c *Cache) {
+		c.lockTimeout = max(timeout, 0)
+	}
+}
+
+// DefaultMaxInlineSize is the limit on the values stored in one manifest
+// unless WithMaxInlineSize says otherwise.
+const DefaultMaxInlineSize = 1 << 20
+
+// WithMaxInlineSize limits the total size of the values an entry stores
+// inline in its manifest: metadata, tags, the description, and the key's
+// input descriptions and String, Version, and Env components. Manifests are
+// parsed by every Get, Entries, and Stats call, so large values there slow
+// down the whole cache; a Commit over the limit fails with
+// ErrManifestTooLarge. Large values belong in Bytes, BytesFrom, or File
+// outputs, which are stored beside the manifest, and large key components
+// in KeyBuilder.Bytes, which is hashed but not stored.
+//
+// The default is DefaultMaxInlineSize (1 MiB); 0 keeps the default and a
+// negative size removes the limit.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithMaxInlineSize(64<<10))
+func WithMaxInlineSize(bytes int64) Option {
+	return func(c *Cache) {
+		c.maxInlineSize = bytes
+	}
+}
+
+// WithMaxInputFiles limits the number of files a single Glob or Dir key
+// input may cover. A larger input fails with an error wrapping
+// hashing.ErrInputTooLarge, reported by Get and Commit, as soon as the limit
+// is passed, instead of hashing a tree that was never meant to be an input
+// (such as a home directory). The default, 0, means no limit.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithMaxInputFiles(10_000))
+func WithMaxInputFiles(n int) Option {
+	return func(c *Cache) {
+		c.maxInputFiles = max(n, 0)
+	}
+}
+
+// WithMaxInputBytes limits the total size of the files a single Glob or Dir
+// key input may cover. Sizes are checked before any file is read; a larger
+// input fails with an error wrapping hashing.ErrInputTooLarge. The default,
+// 0, means no limit.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithMaxInputBytes(1<<30))
+func WithMaxInputBytes(n int64) Option {
+	return func(c *Cache) {
+		c.maxInputBytes = max(n, 0)
+	}
+}
+
+// WithDirMode sets the mode of the directories the cache creates under its
+// root (manifest and object shards, staging and lock directories). The mode
+// is applied exactly, regardless of the umask, so a cache shared by a group
+// can use setgid, group-writable directories and every member can write to
+// every shard. Unix-style special bits are accepted: 0o2775 and
+// os.ModeSetgid|0o775 are the same mode.
+//
+// By default directories are created 0o755 less the process umask. Modes of
+// existing directories are left alone.
+//
+// Example:
+//
+//	cache, err := granular.Open("/srv/team-cache",
+//		granular.WithDirMode(0o2775),
+//		granular.WithFileMode(0o664),
+//	)
+func WithDirMode(mode os.FileMode) Option {
+	return func(c *Cache) {
+		c.dirMode = unixMode(mode)
+	}
+}
+
+// WithFileMode sets the mode of the files the cache creates under its root
+// (objects, manifests, and metadata). Like WithDirMode, the mode is applied
+// exactly, regardless of the umask. Files restored with Result.CopyFile are
+// the caller's and are not affected.
+//
+// By default files are created 0o644 less the process umask.
+//
+// Example:
+//
+//	cache, err := granular.Open("/srv/team-cache", granular.WithFileMode(0o664))
+func WithFileMode(mode os.FileMode) Option {
+	return func(c *Cache) {
+		c.fileMode = unixMode(mode)
+	}
+}
+
+// WithDedup stores byte data added with WriteBuilder.Bytes, BytesNoCopy, and
+// BytesFrom once per content, in a blob area shared by all entries, instead
+// of in every entry that has it. Manifests reference blobs by digest, so
+// attaching the same large blob to many entries costs its size once.
+//
+// Removing an entry does not remove the blobs it references, which other
+// entries may share; GC removes the blobs no entry references. Entry sizes
+// and Stats count a shared blob in every entry that references it.
+//
+// Example:
+//
+//	// Every stage attaches the same 200MB schema
+//	cache, err := granular.Open(".cache", granular.WithDedup())
+func WithDedup() Option {
+	return func(c *Cache) {
+		c.dedup = true
+	}
+}
+
+// WithHistory keeps the last n results of every key when a commit or Merge
+// replaces them, for Cache.History and Result.Previous to return. It answers
+// "what did this artifact look like before it changed?" after the fact. A
+// commit whose outputs are identical to the entry's keeps nothing, so
+// rerunning a deterministic step does not push older results out.
+//
+// Previous results are moved, not copied, into history/ under the cache
+// root. They count towards the size of their entry, in Stats and against
+// WithMaxSize, and are removed with it by Delete, pruning, eviction, and
+// Clear; GC removes any left without an entry. The default, 0, keeps no
+// history.
+//
+// Example:
+//
+//	cache, err := granular.Open(".cache", granular.WithHistory(3))
+func WithHistory(n int) Option {
+	return func(c *Cache) {
+		c.history = max(n, 0)
+	}
+}
@@ -1,13 +1,21 @@
 package granular
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"hash"
 	"hash/fnv"
+	"log/slog"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gophersatwork/granular/hashing"
 	"github.com/spf13/afero"
 )
 
@@ -104,22 +112,11 @@ func TestWithHashFunc_Persistence(t *testing.T) {
 		t.Fatalf("Close failed: %v", err)
 	}
 
-	// Create new cache with different hash function - should not find entry
-	cacheDefault, err := Open(".cache", WithFs(fs))
-	if err != nil {
-		t.Fatalf("Open failed: %v", err)
-	}
-	defer func(cacheDefault *Cache) {
-		err := cacheDefault.Close()
-		if err != nil {
-			t.Fatalf("Close failed: %v", err)
-		}
-	}(cacheDefault)
-
-	key2 := cacheDefault.Key().File("test.txt").Build()
-	_, err = cacheDefault.Get(key2)
-	if !errors.Is(err, ErrCacheMiss) {
-		t.Errorf("Expected cache miss with different hash function, got: %v", err)
+	// Reopening with a different hash function is refused instead of
+	// missing on every key
+	_, err = Open(".cache", WithFs(fs))
+	if !errors.Is(err, ErrHashAlgoMismatch) {
+		t.Errorf("Expected ErrHashAlgoMismatch with different hash function, got: %v", err)
 	}
 }
 
@@ -789,7 +786,7 @@ func TestWithMaxSize_LRUOrder(t *testing.T) {
 
 	// Max size that fits only 2 entries of 500 bytes each (1000 bytes total)
 	// Adding a third entry (500 bytes) will trigger eviction
-	// Note: Stats.TotalSize only counts object directory sizes, not manifest files
+	// Note: MaxSize only counts object sizes (Entry.Size), not manifest files
 	maxSize := int64(1200)
 
 	// Use incrementing time
@@ -906,3 +903,470 @@ func TestWithMaxSize_EntrySizeLargerThanMax(t *testing.T) {
 		t.Error("Entry should not exist when it exceeds max cache size")
 	}
 }
+
+// writeCorruptedManifest writes an unparseable manifest for keyHash under root.
+func writeCorruptedManifest(t *testing.T, fs afero.Fs, root, keyHash string) string {
+	t.Helper()
+	dir := filepath.Join(root, "manifests", keyHash[:2])
+	if err := fs.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	path := filepath.Join(dir, keyHash+".json")
+	if err := afero.WriteFile(fs, path, []byte("NOT VALID JSON"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+// TestStats_SkippedManifests tests that Stats reports corrupted manifests it skipped.
+func TestStats_SkippedManifests(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	key := cache.Key().String("k", "v").Build()
+	if err := cache.Put(key).Meta("m", "1").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	writeCorruptedManifest(t, fs, ".cache", "ff00112233445566")
+	writeCorruptedManifest(t, fs, ".cache", "ee00112233445566")
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Entries != 1 {
+		t.Errorf("Expected 1 entry, got %d", stats.Entries)
+	}
+	if stats.SkippedManifests != 2 {
+		t.Errorf("Expected 2 skipped manifests, got %d", stats.SkippedManifests)
+	}
+}
+
+// TestWithStrictWalks tests that walks fail loudly on corrupted manifests.
+func TestWithStrictWalks(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs), WithStrictWalks())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	key := cache.Key().String("k", "v").Build()
+	if err := cache.Put(key).Meta("m", "1").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// A clean cache walks normally
+	if _, err := cache.Stats(); err != nil {
+		t.Fatalf("Stats on clean cache failed: %v", err)
+	}
+
+	corruptedPath := writeCorruptedManifest(t, fs, ".cache", "ff00112233445566")
+
+	if _, err := cache.Stats(); !errors.Is(err, ErrCacheCorrupted) {
+		t.Errorf("Stats: expected ErrCacheCorrupted, got %v", err)
+	}
+	if _, err := cache.Entries(); !errors.Is(err, ErrCacheCorrupted) {
+		t.Errorf("Entries: expected ErrCacheCorrupted, got %v", err)
+	}
+	if _, err := cache.Prune(0); !errors.Is(err, ErrCacheCorrupted) {
+		t.Errorf("Prune: expected ErrCacheCorrupted, got %v", err)
+	}
+
+	// Strict walks must not silently clean up the damage
+	exists, err := afero.Exists(fs, corruptedPath)
+	if err != nil || !exists {
+		t.Error("Expected corrupted manifest to be left in place")
+	}
+	if !cache.Has(key) {
+		t.Error("Expected valid entry to survive failed Prune")
+	}
+}
+
+// TestWithVerifyOnGet tests that disabling verification skips output re-hashing on Get.
+func TestWithVerifyOnGet(t *testing.T) {
+	for _, verify := range []bool{true, false} {
+		t.Run(fmt.Sprintf("verify=%v", verify), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			cache, err := Open(".cache", WithFs(fs), WithVerifyOnGet(verify))
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+
+			key := cache.Key().String("k", "v").Build()
+			if err := cache.Put(key).Bytes("data", []byte("original")).Commit(); err != nil {
+				t.Fatalf("Commit failed: %v", err)
+			}
+
+			m, err := cache.loadManifest(key.Hash())
+			if err != nil {
+				t.Fatalf("loadManifest failed: %v", err)
+			}
+			if err := afero.WriteFile(fs, m.OutputData["data"], []byte("TAMPERED"), 0o644); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+
+			result, err := cache.Get(key)
+			if verify {
+				if !errors.Is(err, ErrCacheCorrupted) {
+					t.Fatalf("Expected ErrCacheCorrupted, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected hit without verification, got %v", err)
+			}
+			if got := string(result.Bytes("data")); got != "TAMPERED" {
+				t.Errorf("Expected unverified data %q, got %q", "TAMPERED", got)
+			}
+		})
+	}
+}
+
+// TestWithSelfHeal tests that corrupted entries are evicted and reported as misses.
+func TestWithSelfHeal(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, fs afero.Fs, m *manifest, mPath string)
+	}{
+		{
+			name: "hash mismatch",
+			corrupt: func(t *testing.T, fs afero.Fs, m *manifest, _ string) {
+				if err := afero.WriteFile(fs, m.OutputData["data"], []byte("TAMPERED"), 0o644); err != nil {
+					t.Fatalf("WriteFile failed: %v", err)
+				}
+			},
+		},
+		{
+			name: "missing object",
+			corrupt: func(t *testing.T, fs afero.Fs, m *manifest, _ string) {
+				if err := fs.Remove(m.OutputData["data"]); err != nil {
+					t.Fatalf("Remove failed: %v", err)
+				}
+			},
+		},
+		{
+			name: "unreadable manifest",
+			corrupt: func(t *testing.T, fs afero.Fs, _ *manifest, mPath string) {
+				if err := afero.WriteFile(fs, mPath, []byte("NOT VALID JSON"), 0o644); err != nil {
+					t.Fatalf("WriteFile failed: %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			var reportedErr error
+			cache, err := Open(".cache", WithFs(fs), WithSelfHeal(), WithMetrics(&MetricsHooks{
+				OnError: func(op string, err error) { reportedErr = err },
+			}))
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+
+			key := cache.Key().String("k", "v").Build()
+			if err := cache.Put(key).Bytes("data", []byte("original")).Commit(); err != nil {
+				t.Fatalf("Commit failed: %v", err)
+			}
+			m, err := cache.loadManifest(key.Hash())
+			if err != nil {
+				t.Fatalf("loadManifest failed: %v", err)
+			}
+			mPath, _ := cache.manifestPath(key.Hash())
+			tt.corrupt(t, fs, m, mPath)
+
+			if _, err := cache.Get(key); !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("Expected ErrCacheMiss, got %v", err)
+			}
+			if !errors.Is(reportedErr, ErrCacheCorrupted) {
+				t.Errorf("Expected corruption reported via OnError, got %v", reportedErr)
+			}
+			if cache.Has(key) {
+				t.Error("Expected corrupted entry to be evicted")
+			}
+
+			// Recompute and store again
+			if err := cache.Put(key).Bytes("data", []byte("fresh")).Commit(); err != nil {
+				t.Fatalf("Commit after heal failed: %v", err)
+			}
+			result, err := cache.Get(key)
+			if err != nil {
+				t.Fatalf("Get after heal failed: %v", err)
+			}
+			if got := string(result.Bytes("data")); got != "fresh" {
+				t.Errorf("Expected %q, got %q", "fresh", got)
+			}
+		})
+	}
+}
+
+// TestWithLogger tests that cache activity is logged with consistent attributes
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	cache, err := Open("", WithFs(afero.NewMemMapFs()), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	key := cache.Key().String("k", "v").Build()
+	cache.Get(key)
+	if err := cache.Put(key).Bytes("data", []byte("hello")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := cache.Prune(0); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+
+	records := make(map[string]map[string]any)
+	for line := range strings.Lines(buf.String()) {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		records[rec["msg"].(string)] = rec
+	}
+
+	for msg, attrs := range map[string][]string{
+		"key hashed":   {"key_hash", "duration"},
+		"cache miss":   {"key_hash", "duration"},
+		"cache put":    {"key_hash", "bytes", "duration"},
+		"cache hit":    {"key_hash", "bytes", "duration"},
+		"cache evict":  {"key_hash", "bytes"},
+		"cache pruned": {"entries", "bytes"},
+	} {
+		rec, ok := records[msg]
+		if !ok {
+			t.Errorf("missing %q log record", msg)
+			continue
+		}
+		for _, attr := range attrs {
+			if _, ok := rec[attr]; !ok {
+				t.Errorf("%q record missing %q attribute: %v", msg, attr, rec)
+			}
+		}
+	}
+	if got := records["cache hit"]["key_hash"]; got != key.Hash() {
+		t.Errorf("cache hit key_hash = %v, want %s", got, key.Hash())
+	}
+}
+
+// TestWithBufferSize verifies that the buffer size changes neither key nor
+// output hashes, and that entries round-trip with large and tiny buffers.
+func TestWithBufferSize(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	content := bytes.Repeat([]byte("granular"), 100_000) // larger than every buffer
+	if err := afero.WriteFile(fs, "big.bin", content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var keyHashes []string
+	for _, size := range []int{0, 7, 1 << 20} {
+		cache, err := Open(fmt.Sprintf("/cache-%d", size), WithFs(fs), WithBufferSize(size), WithVerifyOnGet(true))
+		assertNoError(t, err, "Open")
+		key := cache.Key().File("big.bin").Build()
+		keyHashes = append(keyHashes, key.Hash())
+
+		assertNoError(t, cache.Put(key).File("out", "big.bin").Commit(), "Commit")
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, "Get")
+		dst := fmt.Sprintf("/restored-%d.bin", size)
+		assertNoError(t, result.CopyFile("out", dst), "CopyFile")
+		if got, _ := afero.ReadFile(fs, dst); !bytes.Equal(got, content) {
+			t.Errorf("size %d: restored file differs", size)
+		}
+	}
+	if keyHashes[0] != keyHashes[1] || keyHashes[0] != keyHashes[2] {
+		t.Errorf("key hashes differ by buffer size: %v", keyHashes)
+	}
+}
+
+// TestWithProgress verifies that hashing and copying a large file reports
+// intermediate progress and a final event with the full size.
+func TestWithProgress(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	content := bytes.Repeat([]byte("x"), 10<<20) // several progress intervals
+	if err := afero.WriteFile(fs, "big.bin", content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var events []ProgressEvent
+	cache, err := Open("/cache", WithFs(fs), WithProgress(func(ev ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}))
+	assertNoError(t, err, "Open")
+
+	key := cache.Key().File("big.bin").Build()
+	assertNoError(t, cache.Put(key).File("out", "big.bin").Commit(), "Commit")
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get")
+	assertNoError(t, result.CopyFile("out", "/restored.bin"), "CopyFile")
+
+	type file struct{ op, path string }
+	done := map[file]int64{}
+	partial := map[file]bool{}
+	for _, ev := range events {
+		f := file{ev.Op, ev.Path}
+		if ev.Done {
+			done[f] = ev.Bytes
+		} else if ev.Bytes < int64(len(content)) {
+			partial[f] = true
+		}
+	}
+	for _, f := range []file{
+		{ProgressOpHash, "big.bin"},
+		{ProgressOpCopy, "big.bin"},
+		{ProgressOpCopy, "/restored.bin"},
+	} {
+		if got, ok := done[f]; !ok || got != int64(len(content)) {
+			t.Errorf("%s %s: final bytes = %d (reported %v), want %d", f.op, f.path, got, ok, len(content))
+		}
+		if !partial[f] {
+			t.Errorf("%s %s: no intermediate progress reported", f.op, f.path)
+		}
+	}
+}
+
+// TestWithCaseInsensitivePaths verifies that input paths differing only by
+// case produce the same key, and that names differing only by case are
+// rejected.
+func TestWithCaseInsensitivePaths(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, dir := range []string{"Src", "src"} {
+		if err := afero.WriteFile(fs, dir+"/Main.go", []byte("package main"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	folded, err := Open("/folded", WithFs(fs), WithCaseInsensitivePaths())
+	assertNoError(t, err, "Open folded")
+	if a, b := folded.Key().Dir("Src").Build().Hash(), folded.Key().Dir("src").Build().Hash(); a != b {
+		t.Errorf("Dir keys differ by case: %s vs %s", a, b)
+	}
+	if a, b := folded.Key().File("Src/Main.go").Build().Hash(), folded.Key().File("src/Main.go").Build().Hash(); a != b {
+		t.Errorf("File keys differ by case: %s vs %s", a, b)
+	}
+
+	plain, err := Open("/plain", WithFs(fs))
+	assertNoError(t, err, "Open plain")
+	if plain.Key().Dir("Src").Build().Hash() == plain.Key().Dir("src").Build().Hash() {
+		t.Error("Dir keys equal by case without WithCaseInsensitivePaths")
+	}
+
+	key := folded.Key().Dir("src").Build()
+	err = folded.Put(key).Bytes("Out", []byte("a")).Bytes("out", []byte("b")).Commit()
+	if err == nil || !strings.Contains(err.Error(), "differs only by case") {
+		t.Errorf("Commit with names differing by case = %v, want error", err)
+	}
+	err = folded.Put(key).File("Main", "src/Main.go").Bytes("main", []byte("b")).Commit()
+	assertNoError(t, err, "Commit with a file and data named alike")
+}
+
+// TestWithBasePath verifies that the same tree checked out in two places
+// produces the same keys when hashed relative to its root.
+func TestWithBasePath(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, root := range []string{"/a/repo", "/b/checkout"} {
+		if err := afero.WriteFile(fs, root+"/src/main.go", []byte("package main"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := func(c *Cache, root string) []string {
+		return []string{
+			c.Key().File(root + "/src/main.go").Build().Hash(),
+			c.Key().Glob(root + "/src/*.go").Build().Hash(),
+			c.Key().Dir(root + "/src").Build().Hash(),
+		}
+	}
+
+	a, err := Open("/cache-a", WithFs(fs), WithBasePath("/a/repo"))
+	assertNoError(t, err, "Open a")
+	b, err := Open("/cache-b", WithFs(fs), WithBasePath("/b/checkout"))
+	assertNoError(t, err, "Open b")
+	if ka, kb := keys(a, "/a/repo"), keys(b, "/b/checkout"); !slices.Equal(ka, kb) {
+		t.Errorf("keys differ between checkouts: %v vs %v", ka, kb)
+	}
+
+	plain, err := Open("/cache-plain", WithFs(fs))
+	assertNoError(t, err, "Open plain")
+	if ka, kb := keys(plain, "/a/repo"), keys(plain, "/b/checkout"); ka[2] == kb[2] {
+		t.Error("Dir keys equal between checkouts without WithBasePath")
+	}
+}
+
+// TestWithSalt verifies that changing the salt invalidates existing entries
+// and that an empty salt leaves keys unchanged.
+func TestWithSalt(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	open := func(opts ...Option) *Cache {
+		t.Helper()
+		cache, err := Open("/cache", append([]Option{WithFs(fs)}, opts...)...)
+		assertNoError(t, err, "Open")
+		return cache
+	}
+
+	gen1 := open(WithSalt("gen-1"))
+	key := gen1.Key().String("target", "app").Build()
+	assertNoError(t, gen1.Put(key).Bytes("out", []byte("poisoned")).Commit(), "Commit")
+	result, err := gen1.Get(gen1.Key().String("target", "app").Build())
+	assertCacheHit(t, result, err, "Get with same salt")
+
+	gen2 := open(WithSalt("gen-2"))
+	result, err = gen2.Get(gen2.Key().String("target", "app").Build())
+	assertCacheMiss(t, result, err, "Get with bumped salt")
+
+	plain, unsalted := open(), open(WithSalt(""))
+	if plain.Key().String("target", "app").Build().Hash() != unsalted.Key().String("target", "app").Build().Hash() {
+		t.Error("empty salt changed the key hash")
+	}
+}
+
+func TestWithMaxInputFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		createTestFile(t, fs, filepath.Join("home", name), []byte("0123456789"))
+	}
+	open := func(opts ...Option) *Cache {
+		t.Helper()
+		cache, err := Open("/cache", append([]Option{WithFs(fs)}, opts...)...)
+		assertNoError(t, err, "Open")
+		return cache
+	}
+
+	limited := open(WithMaxInputFiles(2))
+	if _, err := limited.Get(limited.Key().Dir("home").Build()); !errors.Is(err, hashing.ErrInputTooLarge) || !strings.Contains(err.Error(), "home") {
+		t.Errorf("Get with oversized Dir = %v, want ErrInputTooLarge naming the directory", err)
+	}
+	errs := limited.Key().Glob("home/*.txt").Errors()
+	if len(errs) != 1 || !errors.Is(errs[0], hashing.ErrInputTooLarge) {
+		t.Errorf("Glob over the limit recorded %v, want ErrInputTooLarge", errs)
+	}
+	if _, err := limited.Key().Glob("home/a*").HashErr(); err != nil {
+		t.Errorf("Glob under the limit failed: %v", err)
+	}
+
+	sized := open(WithMaxInputBytes(25))
+	if _, err := sized.Key().Glob("home/*.txt").HashErr(); !errors.Is(err, hashing.ErrInputTooLarge) {
+		t.Errorf("HashErr with 30 bytes over a 25-byte limit = %v, want ErrInputTooLarge", err)
+	}
+	if _, err := sized.Key().Glob("home/[ab].txt").HashErr(); err != nil {
+		t.Errorf("Glob under the byte limit failed: %v", err)
+	}
+
+	// Limits never change the digest of inputs within them
+	if open().Key().Dir("home").Hash() != open(WithMaxInputFiles(3), WithMaxInputBytes(30)).Key().Dir("home").Hash() {
+		t.Error("input limits changed the key hash")
+	}
+}
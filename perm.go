@@ -0,0 +1,90 @@
+package granular
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// Modes for files and directories created under the cache root when no
+// WithFileMode or WithDirMode is set. The umask applies to them.
+const (
+	defaultDirMode  os.FileMode = 0o755
+	defaultFileMode os.FileMode = 0o644
+)
+
+// unixMode converts the Unix setuid, setgid, and sticky bits (0o4000,
+// 0o2000, 0o1000) in mode to their os.FileMode equivalents, so both
+// 0o2775 and os.ModeSetgid|0o775 request a setgid directory.
+func unixMode(mode os.FileMode) os.FileMode {
+	special := []struct {
+		bit, mode os.FileMode
+	}{
+		{0o4000, os.ModeSetuid},
+		{0o2000, os.ModeSetgid},
+		{0o1000, os.ModeSticky},
+	}
+	for _, s := range special {
+		if mode&s.bit != 0 {
+			mode = mode&^s.bit | s.mode
+		}
+	}
+	return mode
+}
+
+// mkdirAll creates path and any missing parents. Directories it creates get
+// exactly the mode set by WithDirMode, or defaultDirMode less the umask.
+func (c *Cache) mkdirAll(path string) error {
+	if c.dirMode == 0 {
+		return c.fs.MkdirAll(path, defaultDirMode)
+	}
+
+	// Find the directories MkdirAll will create, so that only they are
+	// chmodded: existing parents may belong to someone else
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := c.fs.Stat(dir); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, dir)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	if err := c.fs.MkdirAll(path, c.dirMode.Perm()); err != nil {
+		return err
+	}
+	// Parents first, in case the mode restricts access to their children
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := c.fs.Chmod(missing[i], c.dirMode|os.ModeDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createFile creates or truncates the file at path for writing, with the
+// cache's file mode.
+func (c *Cache) createFile(path string) (afero.File, error) {
+	f, err := c.fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.chmodFile(path); err != nil {
+		_ = f.Close()
+		_ = c.fs.Remove(path)
+		return nil, err
+	}
+	return f, nil
+}
+
+// chmodFile sets a file created under the cache root to the mode set by
+// WithFileMode, if any. Without one the file keeps the mode it was created
+// with, less the umask.
+func (c *Cache) chmodFile(path string) error {
+	if c.fileMode == 0 {
+		return nil
+	}
+	return c.fs.Chmod(path, c.fileMode)
+}
@@ -0,0 +1,90 @@
+package granular
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// TestWithDirModeAndFileMode tests that configured modes are applied exactly,
+// regardless of the umask, to everything the cache creates under its root.
+func TestWithDirModeAndFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions")
+	}
+	root := filepath.Join(t.TempDir(), "shared", "cache")
+	cache, err := Open(root, WithFs(afero.NewOsFs()), WithDirMode(0o2775), WithFileMode(0o664))
+	assertNoError(t, err, "Open")
+	defer cache.Close()
+
+	src := filepath.Join(t.TempDir(), "out.txt")
+	assertNoError(t, os.WriteFile(src, []byte("data"), 0o600), "WriteFile")
+	key := cache.Key().String("id", "shared").Build()
+	assertNoError(t, cache.Put(key).File("out", src).Commit(), "Commit")
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "shared entry")
+
+	objectDir, err := cache.objectPath(key.Hash())
+	assertNoError(t, err, "objectPath")
+	manifestPath, err := cache.manifestPath(key.Hash())
+	assertNoError(t, err, "manifestPath")
+
+	for _, dir := range []string{filepath.Dir(root), root, cache.objectsDir(), filepath.Dir(objectDir), objectDir, filepath.Dir(manifestPath)} {
+		info, err := os.Stat(dir)
+		assertNoError(t, err, "Stat")
+		if got := info.Mode() & (os.ModePerm | os.ModeSetgid); got != os.ModeSetgid|0o775 {
+			t.Errorf("%s mode = %v, want setgid 0o775", dir, got)
+		}
+	}
+	for _, file := range []string{manifestPath, result.File("out"), cache.metaPath()} {
+		info, err := os.Stat(file)
+		assertNoError(t, err, "Stat")
+		if got := info.Mode().Perm(); got != 0o664 {
+			t.Errorf("%s mode = %v, want 0o664", file, got)
+		}
+	}
+
+	// Existing parents are left alone
+	info, err := os.Stat(filepath.Dir(filepath.Dir(root)))
+	assertNoError(t, err, "Stat")
+	if info.Mode()&os.ModeSetgid != 0 {
+		t.Error("mode applied to a directory the cache did not create")
+	}
+
+	// Imported directories get the configured mode, not the archive's
+	private, err := Open(filepath.Join(t.TempDir(), "private"), WithFs(afero.NewOsFs()), WithDirMode(0o700))
+	assertNoError(t, err, "Open private cache")
+	defer private.Close()
+	other := private.Key().String("id", "private").Build()
+	assertNoError(t, private.Put(other).File("out", src).Commit(), "Commit private")
+	var archive bytes.Buffer
+	assertNoError(t, private.Export(&archive), "Export")
+	assertNoError(t, cache.Import(&archive), "Import")
+	importedDir, err := cache.objectPath(other.Hash())
+	assertNoError(t, err, "objectPath")
+	info, err = os.Stat(importedDir)
+	assertNoError(t, err, "Stat")
+	if got := info.Mode() & (os.ModePerm | os.ModeSetgid); got != os.ModeSetgid|0o775 {
+		t.Errorf("imported %s mode = %v, want setgid 0o775", importedDir, got)
+	}
+}
+
+// TestUnixMode tests the conversion of Unix special bits to os.FileMode.
+func TestUnixMode(t *testing.T) {
+	for in, want := range map[os.FileMode]os.FileMode{
+		0o755:                  0o755,
+		0o2775:                 os.ModeSetgid | 0o775,
+		os.ModeSetgid | 0o775:  os.ModeSetgid | 0o775,
+		0o1777:                 os.ModeSticky | 0o777,
+		0o4755:                 os.ModeSetuid | 0o755,
+		os.ModeSetuid | 0o2700: os.ModeSetuid | os.ModeSetgid | 0o700,
+	} {
+		if got := unixMode(in); got != want {
+			t.Errorf("unixMode(%o) = %v, want %v", uint32(in), got, want)
+		}
+	}
+}
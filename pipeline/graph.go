@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// dotColors are the fill colors of stages in WriteDOT, by status.
+var dotColors = map[Status]string{
+	Skipped: "lightgray",
+	Cached:  "palegreen",
+	Ran:     "gold",
+	Failed:  "salmon",
+}
+
+// WriteDOT writes the stages of the run and their dependencies as a Graphviz
+// digraph, with each stage colored by status: green if it was cached, yellow
+// if it ran, red if it failed, and gray if it was skipped. Edges point from a
+// dependency to the stages using it.
+//
+// Example:
+//
+//	report, err := p.Run(ctx)
+//	f, _ := os.Create("pipeline.dot")
+//	report.WriteDOT(f) // render with: dot -Tsvg pipeline.dot -o pipeline.svg
+func (r *Report) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph pipeline {")
+	fmt.Fprintln(bw, "\trankdir=LR;")
+	fmt.Fprintln(bw, "\tnode [shape=box, style=\"rounded,filled\"];")
+	for _, s := range r.Stages {
+		label := s.Name + "\n" + s.Status.String()
+		if s.Status == Ran || s.Status == Failed {
+			label += " in " + s.Duration.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(bw, "\t%s [label=%s, fillcolor=%s];\n", strconv.Quote(s.Name), strconv.Quote(label), dotColors[s.Status])
+	}
+	for _, s := range r.Stages {
+		for _, dep := range s.Deps {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", strconv.Quote(dep), strconv.Quote(s.Name))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// jsonReport is the JSON form of a Report.
+type jsonReport struct {
+	Stages []jsonStage `json:"stages"`
+}
+
+type jsonStage struct {
+	Name       string   `json:"name"`
+	Deps       []string `json:"deps"`
+	KeyHash    string   `json:"keyHash,omitempty"`
+	Status     string   `json:"status"`
+	DurationMs float64  `json:"durationMs"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the report as its dependency graph: a list of stages in
+// dependency order, each with the names of its dependencies, its key hash,
+// its status ("cached", "ran", "failed", or "skipped"), how long it took in
+// milliseconds, and its error if it failed. Results are not included.
+//
+// Example:
+//
+//	report, err := p.Run(ctx)
+//	data, _ := json.MarshalIndent(report, "", "  ")
+func (r *Report) MarshalJSON() ([]byte, error) {
+	out := jsonReport{Stages: make([]jsonStage, len(r.Stages))}
+	for i, s := range r.Stages {
+		js := jsonStage{
+			Name:       s.Name,
+			Deps:       s.Deps,
+			KeyHash:    s.KeyHash,
+			Status:     s.Status.String(),
+			DurationMs: float64(s.Duration) / float64(time.Millisecond),
+		}
+		if js.Deps == nil {
+			js.Deps = []string{}
+		}
+		if s.Err != nil {
+			js.Error = s.Err.Error()
+		}
+		out.Stages[i] = js
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+)
+
+// graphReport runs a pipeline where a is cached, b ran, c failed, and d was
+// skipped because it depends on c.
+func graphReport(t *testing.T) *Report {
+	t.Helper()
+	ok := func(context.Context, Results, *granular.WriteBuilder) error { return nil }
+	stages := []Stage{
+		{Name: "a", Inputs: func(kb *granular.KeyBuilder) { kb.Version("1") }, Run: ok},
+		{Name: "b", Deps: []string{"a"}, Run: ok},
+		{Name: "c", Deps: []string{"a"}, Run: func(context.Context, Results, *granular.WriteBuilder) error {
+			return errors.New(`bad "quote"`)
+		}},
+		{Name: "d", Deps: []string{"b", "c"}, Run: ok},
+	}
+	p := mustNew(t, stages, WithWorkers(1), WithKeepGoing())
+	if _, err := p.Run(t.Context(), "a"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	report, _ := p.Run(t.Context())
+	if got := statuses(report); got != "a=cached b=ran c=failed d=skipped" {
+		t.Fatalf("statuses = %s", got)
+	}
+	return report
+}
+
+func TestReport_WriteDOT(t *testing.T) {
+	var buf strings.Builder
+	if err := graphReport(t).WriteDOT(&buf); err != nil {
+		t.Fatalf("WriteDOT failed: %v", err)
+	}
+	dot := buf.String()
+	for _, want := range []string{
+		"digraph pipeline {",
+		`"a" [label="a\ncached", fillcolor=palegreen];`,
+		`"c" [label="c\nfailed in `,
+		`"d" [label="d\nskipped", fillcolor=lightgray];`,
+		`"a" -> "b";`,
+		`"c" -> "d";`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot)
+		}
+	}
+}
+
+func TestReport_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(graphReport(t))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var got struct {
+		Stages []struct {
+			Name    string   `json:"name"`
+			Deps    []string `json:"deps"`
+			KeyHash string   `json:"keyHash"`
+			Status  string   `json:"status"`
+			Error   string   `json:"error"`
+		} `json:"stages"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, data)
+	}
+	if len(got.Stages) != 4 {
+		t.Fatalf("got %d stages, want 4:\n%s", len(got.Stages), data)
+	}
+	a, c, d := got.Stages[0], got.Stages[2], got.Stages[3]
+	if a.Name != "a" || a.Status != "cached" || a.KeyHash == "" || a.Deps == nil {
+		t.Errorf("stage a = %+v", a)
+	}
+	if c.Status != "failed" || c.Error != `bad "quote"` {
+		t.Errorf("stage c = %+v", c)
+	}
+	if d.Status != "skipped" || strings.Join(d.Deps, ",") != "b,c" || d.KeyHash != "" {
+		t.Errorf("stage d = %+v", d)
+	}
+}
@@ -0,0 +1,417 @@
+// Package pipeline runs multi-stage computations whose stages are cached in a
+// granular cache.
+//
+// A Pipeline is a set of named stages. Each stage declares its own inputs and
+// the stages it depends on; the pipeline folds the keys of upstream stages
+// into the keys of downstream stages, so changing any input reruns exactly
+// the affected stages and everything downstream of them:
+//
+//	p, err := pipeline.New(cache, []pipeline.Stage{
+//		{
+//			Name:   "download",
+//			Inputs: func(kb *granular.KeyBuilder) { kb.String("url", dataURL).Version("v1") },
+//			Run: func(ctx context.Context, deps pipeline.Results, out *granular.WriteBuilder) error {
+//				if err := download(ctx, dataURL, "raw.csv"); err != nil {
+//					return err
+//				}
+//				out.File("data", "raw.csv")
+//				return nil
+//			},
+//		},
+//		{
+//			Name:   "clean",
+//			Deps:   []string{"download"},
+//			Inputs: func(kb *granular.KeyBuilder) { kb.Version("v3") },
+//			Run: func(ctx context.Context, deps pipeline.Results, out *granular.WriteBuilder) error {
+//				if err := clean(deps["download"].File("data"), "clean.csv"); err != nil {
+//					return err
+//				}
+//				out.File("data", "clean.csv")
+//				return nil
+//			},
+//		},
+//	})
+//	report, err := p.Run(ctx)
+//
+// A stage's outputs are whatever its Run function adds to the WriteBuilder;
+// downstream stages read them from the Results of their dependencies, which
+// are the same whether the upstream stage ran or was cached.
+//
+// Stages that do not depend on each other run concurrently, so their Run
+// functions must not share unsynchronized state; use WithWorkers(1) to run
+// one stage at a time.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/gophersatwork/granular"
+)
+
+// Stage is one step of a Pipeline.
+type Stage struct {
+	// Name identifies the stage. It must be unique within the pipeline.
+	Name string
+
+	// Deps names the stages whose outputs this stage uses. Their keys are
+	// part of this stage's key.
+	Deps []string
+
+	// Inputs adds the stage's own inputs to its key: files it reads other
+	// than upstream outputs, configuration, and a version to bump when Run
+	// changes. It may be nil.
+	Inputs func(kb *granular.KeyBuilder)
+
+	// Run computes the stage's outputs and adds them to out. deps holds the
+	// results of the stages named in Deps. Run is only called on a cache
+	// miss; if it returns an error, nothing is cached.
+	Run func(ctx context.Context, deps Results, out *granular.WriteBuilder) error
+}
+
+// Results maps stage names to their cached results.
+type Results map[string]*granular.Result
+
+// Status is the outcome of a stage in a run.
+type Status int
+
+const (
+	// Skipped stages were not reached, because the run was canceled or a
+	// stage they depend on failed.
+	Skipped Status = iota
+	// Cached stages were found in the cache and did not run.
+	Cached
+	// Ran stages missed the cache, ran, and were stored.
+	Ran
+	// Failed stages could not be looked up, run, or stored.
+	Failed
+)
+
+func (s Status) String() string {
+	switch s {
+	case Cached:
+		return "cached"
+	case Ran:
+		return "ran"
+	case Failed:
+		return "failed"
+	default:
+		return "skipped"
+	}
+}
+
+// StageReport describes what happened to one stage in a run.
+type StageReport struct {
+	Name     string
+	Deps     []string
+	KeyHash  string // empty if the key could not be computed
+	Status   Status
+	Duration time.Duration    // time spent looking up, running, and storing the stage
+	Err      error            // why the stage failed, if it did
+	Result   *granular.Result // the stage's outputs, if Cached or Ran
+}
+
+// Report describes a run of a pipeline.
+type Report struct {
+	// Stages lists the stages selected for the run in dependency order.
+	Stages []StageReport
+}
+
+// Stage returns the report of the named stage, or nil if it was not part
+// of the run.
+func (r *Report) Stage(name string) *StageReport {
+	for i := range r.Stages {
+		if r.Stages[i].Name == name {
+			return &r.Stages[i]
+		}
+	}
+	return nil
+}
+
+// Pipeline is a validated set of stages sharing a cache.
+type Pipeline struct {
+	cache     *granular.Cache
+	stages    map[string]Stage
+	order     []string // topological order
+	workers   int
+	keepGoing bool
+}
+
+// Option configures a Pipeline.
+type Option func(*Pipeline)
+
+// WithWorkers sets how many stages may run at the same time. The default is
+// runtime.GOMAXPROCS(0); 1 runs stages one after the other. Values below 1
+// are treated as 1.
+//
+// Example:
+//
+//	p, err := pipeline.New(cache, stages, pipeline.WithWorkers(4))
+func WithWorkers(n int) Option {
+	return func(p *Pipeline) {
+		p.workers = max(n, 1)
+	}
+}
+
+// WithKeepGoing makes Run carry on with the stages that do not depend on a
+// failed stage, instead of starting no further stages after a failure. Like
+// make -k, it reports as many failures as possible in one run.
+//
+// Example:
+//
+//	p, err := pipeline.New(cache, stages, pipeline.WithKeepGoing())
+func WithKeepGoing() Option {
+	return func(p *Pipeline) {
+		p.keepGoing = true
+	}
+}
+
+// New returns a pipeline of the given stages. It fails if stage names are
+// empty or duplicated, a stage has no Run function, a dependency names an
+// unknown stage, or dependencies form a cycle.
+func New(cache *granular.Cache, stages []Stage, opts ...Option) (*Pipeline, error) {
+	p := &Pipeline{
+		cache:   cache,
+		stages:  make(map[string]Stage, len(stages)),
+		workers: runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	for _, s := range stages {
+		if s.Name == "" {
+			return nil, errors.New("pipeline: stage with empty name")
+		}
+		if _, ok := p.stages[s.Name]; ok {
+			return nil, fmt.Errorf("pipeline: duplicate stage %q", s.Name)
+		}
+		if s.Run == nil {
+			return nil, fmt.Errorf("pipeline: stage %q has no Run function", s.Name)
+		}
+		s.Deps = slices.Clone(s.Deps)
+		p.stages[s.Name] = s
+	}
+
+	// Depth-first topological sort, in declaration order for determinism
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(stages))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("pipeline: dependency cycle %v", append(path, name))
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range p.stages[name].Deps {
+			if _, ok := p.stages[dep]; !ok {
+				return fmt.Errorf("pipeline: stage %q depends on unknown stage %q", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		p.order = append(p.order, name)
+		return nil
+	}
+	for _, s := range stages {
+		if err := visit(s.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Run brings the given target stages and everything they depend on up to
+// date, or the whole pipeline if no targets are given. Each stage is looked
+// up in the cache under a key folding in its inputs and the keys of its
+// dependencies, and run only on a miss. A stage starts as soon as all of its
+// dependencies are done, so independent stages run concurrently, up to the
+// limit set by WithWorkers.
+//
+// When a stage fails, Run starts no further stages unless WithKeepGoing is
+// set, in which case only the stages depending on the failed one are
+// skipped. Stages already running are always allowed to finish. The
+// returned error joins the errors of all failed stages; the report is
+// returned either way and tells which stages were cached, ran, failed, or
+// were skipped.
+func (p *Pipeline) Run(ctx context.Context, targets ...string) (*Report, error) {
+	selected, err := p.selected(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	index := make(map[string]int)
+	for _, name := range p.order {
+		if selected[name] {
+			index[name] = len(report.Stages)
+			report.Stages = append(report.Stages, StageReport{Name: name, Deps: slices.Clone(p.stages[name].Deps)})
+		}
+	}
+
+	// waiting counts the unfinished dependencies of each stage, and
+	// dependents lists the stages to notify when a stage succeeds.
+	waiting := make([]int, len(report.Stages))
+	dependents := make([][]int, len(report.Stages))
+	var ready []int
+	for i, sr := range report.Stages {
+		waiting[i] = len(sr.Deps)
+		for _, dep := range sr.Deps {
+			dependents[index[dep]] = append(dependents[index[dep]], i)
+		}
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	// Stages run in goroutines and report back on finished; the reports of
+	// dependencies are only read once they have been received, and each
+	// goroutine only writes the report of its own stage.
+	finished := make(chan int)
+	running := 0
+	stopped := false
+	var ctxErr error
+	for {
+		for !stopped && running < p.workers && len(ready) > 0 {
+			if ctxErr = ctx.Err(); ctxErr != nil {
+				stopped = true
+				break
+			}
+			i := ready[0]
+			ready = ready[1:]
+			sr := &report.Stages[i]
+			deps := make(Results, len(sr.Deps))
+			depHashes := make(map[string]string, len(sr.Deps))
+			for _, dep := range sr.Deps {
+				deps[dep] = report.Stages[index[dep]].Result
+				depHashes[dep] = report.Stages[index[dep]].KeyHash
+			}
+			running++
+			go func() {
+				p.runStage(ctx, sr, deps, depHashes)
+				finished <- i
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		i := <-finished
+		running--
+		if report.Stages[i].Status == Failed {
+			// Dependents are never released and stay Skipped
+			stopped = stopped || !p.keepGoing
+			continue
+		}
+		for _, d := range dependents[i] {
+			if waiting[d]--; waiting[d] == 0 {
+				// Keep ready in dependency order, so that a run with one
+				// worker visits stages in the same order as the report
+				at, _ := slices.BinarySearch(ready, d)
+				ready = slices.Insert(ready, at, d)
+			}
+		}
+	}
+
+	var errs []error
+	for _, sr := range report.Stages {
+		if sr.Status == Failed {
+			errs = append(errs, fmt.Errorf("stage %s: %w", sr.Name, sr.Err))
+		}
+	}
+	if ctxErr != nil {
+		errs = append(errs, ctxErr)
+	}
+	return report, errors.Join(errs...)
+}
+
+// selected returns the targets and their transitive dependencies.
+func (p *Pipeline) selected(targets []string) (map[string]bool, error) {
+	if len(targets) == 0 {
+		targets = p.order
+	}
+	selected := make(map[string]bool)
+	var add func(name string)
+	add = func(name string) {
+		if selected[name] {
+			return
+		}
+		selected[name] = true
+		for _, dep := range p.stages[name].Deps {
+			add(dep)
+		}
+	}
+	for _, name := range targets {
+		if _, ok := p.stages[name]; !ok {
+			return nil, fmt.Errorf("pipeline: unknown stage %q", name)
+		}
+		add(name)
+	}
+	return selected, nil
+}
+
+// key returns the key of a stage given the key hashes of its dependencies.
+func (p *Pipeline) key(s Stage, depHashes map[string]string) granular.Key {
+	kb := p.cache.Key().String("pipeline.stage", s.Name)
+	if s.Inputs != nil {
+		s.Inputs(kb)
+	}
+	for dep, hash := range depHashes {
+		kb.String("pipeline.dep."+dep, hash)
+	}
+	return kb.Build()
+}
+
+// runStage looks up a stage and runs it on a miss, filling in sr.
+func (p *Pipeline) runStage(ctx context.Context, sr *StageReport, deps Results, depHashes map[string]string) {
+	start := time.Now()
+	defer func() { sr.Duration = time.Since(start) }()
+	fail := func(err error) {
+		sr.Status, sr.Err = Failed, err
+	}
+
+	s := p.stages[sr.Name]
+	key := p.key(s, depHashes)
+	keyHash, err := key.HashErr()
+	if err != nil {
+		fail(err)
+		return
+	}
+	sr.KeyHash = keyHash
+
+	result, err := p.cache.Get(key)
+	if err == nil {
+		sr.Status, sr.Result = Cached, result
+		return
+	}
+	if !errors.Is(err, granular.ErrCacheMiss) {
+		fail(err)
+		return
+	}
+
+	out := p.cache.Put(key).Meta("pipeline.stage", s.Name)
+	if err := s.Run(ctx, deps, out); err != nil {
+		fail(err)
+		return
+	}
+	if err := out.Commit(); err != nil {
+		fail(fmt.Errorf("failed to store outputs: %w", err))
+		return
+	}
+	if sr.Result, err = p.cache.Get(key); err != nil {
+		fail(fmt.Errorf("failed to read stored outputs: %w", err))
+		return
+	}
+	sr.Status = Ran
+}
@@ -0,0 +1,262 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gophersatwork/granular"
+)
+
+// chain builds the pipeline extract -> transform -> load, where each stage
+// appends its name to its upstream output. The inputs of extract and the
+// version of load come from the pointers, and every run is recorded in runs.
+func chain(t *testing.T, cache *granular.Cache, source, loadVersion *string, runs *[]string) *Pipeline {
+	t.Helper()
+	stage := func(name, dep string, inputs func(kb *granular.KeyBuilder)) Stage {
+		s := Stage{
+			Name:   name,
+			Inputs: inputs,
+			Run: func(ctx context.Context, deps Results, out *granular.WriteBuilder) error {
+				*runs = append(*runs, name)
+				in := *source
+				if dep != "" {
+					in = string(deps[dep].Bytes("out"))
+				}
+				out.Bytes("out", []byte(in+">"+name))
+				return nil
+			},
+		}
+		if dep != "" {
+			s.Deps = []string{dep}
+		}
+		return s
+	}
+	p, err := New(cache, []Stage{
+		stage("load", "transform", func(kb *granular.KeyBuilder) { kb.Version(*loadVersion) }),
+		stage("extract", "", func(kb *granular.KeyBuilder) { kb.String("source", *source) }),
+		stage("transform", "extract", nil),
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return p
+}
+
+func statuses(r *Report) string {
+	var parts []string
+	for _, s := range r.Stages {
+		parts = append(parts, s.Name+"="+s.Status.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestRun(t *testing.T) {
+	cache := granular.OpenTemp()
+	source, version := "db", "v1"
+	var runs []string
+	p := chain(t, cache, &source, &version, &runs)
+	ctx := t.Context()
+
+	check := func(want string, wantRuns ...string) *Report {
+		t.Helper()
+		runs = nil
+		report, err := p.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if got := statuses(report); got != want {
+			t.Errorf("statuses = %s, want %s", got, want)
+		}
+		if strings.Join(runs, ",") != strings.Join(wantRuns, ",") {
+			t.Errorf("ran %v, want %v", runs, wantRuns)
+		}
+		return report
+	}
+
+	report := check("extract=ran transform=ran load=ran", "extract", "transform", "load")
+	if got := string(report.Stage("load").Result.Bytes("out")); got != "db>extract>transform>load" {
+		t.Errorf("load output = %q", got)
+	}
+	check("extract=cached transform=cached load=cached")
+
+	// Changing a downstream input reruns only that stage
+	version = "v2"
+	check("extract=cached transform=cached load=ran", "load")
+
+	// Changing an upstream input reruns everything downstream of it
+	source = "api"
+	report = check("extract=ran transform=ran load=ran", "extract", "transform", "load")
+	if got := string(report.Stage("load").Result.Bytes("out")); got != "api>extract>transform>load" {
+		t.Errorf("load output = %q", got)
+	}
+	if report.Stage("transform").KeyHash == "" || report.Stage("missing") != nil {
+		t.Error("unexpected Report.Stage results")
+	}
+}
+
+func TestRun_Targets(t *testing.T) {
+	cache := granular.OpenTemp()
+	source, version := "db", "v1"
+	var runs []string
+	p := chain(t, cache, &source, &version, &runs)
+
+	report, err := p.Run(t.Context(), "transform")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := statuses(report); got != "extract=ran transform=ran" {
+		t.Errorf("statuses = %s", got)
+	}
+	if _, err := p.Run(t.Context(), "nope"); err == nil {
+		t.Error("Run of unknown target succeeded")
+	}
+}
+
+func TestRun_Failure(t *testing.T) {
+	cache := granular.OpenTemp()
+	boom := errors.New("boom")
+	fail := true
+	p, err := New(cache, []Stage{
+		{Name: "a", Inputs: func(kb *granular.KeyBuilder) { kb.Version("1") }, Run: func(context.Context, Results, *granular.WriteBuilder) error {
+			return nil
+		}},
+		{Name: "b", Deps: []string{"a"}, Run: func(_ context.Context, _ Results, out *granular.WriteBuilder) error {
+			if fail {
+				return boom
+			}
+			out.Bytes("out", []byte("ok"))
+			return nil
+		}},
+		{Name: "c", Deps: []string{"b"}, Run: func(context.Context, Results, *granular.WriteBuilder) error {
+			return nil
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	report, err := p.Run(t.Context())
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), "stage b") {
+		t.Errorf("Run error = %v, want stage b: boom", err)
+	}
+	if got := statuses(report); got != "a=ran b=failed c=skipped" {
+		t.Errorf("statuses = %s", got)
+	}
+	if !errors.Is(report.Stage("b").Err, boom) {
+		t.Errorf("b.Err = %v", report.Stage("b").Err)
+	}
+
+	// Failures are not cached
+	fail = false
+	report, err = p.Run(t.Context())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := statuses(report); got != "a=cached b=ran c=ran" {
+		t.Errorf("statuses after fix = %s", got)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if report, err := p.Run(ctx); !errors.Is(err, context.Canceled) || statuses(report) != "a=skipped b=skipped c=skipped" {
+		t.Errorf("Run with canceled context = %v, %s", err, statuses(report))
+	}
+}
+
+func TestRun_Concurrent(t *testing.T) {
+	var active, peak atomic.Int32
+	started := make(chan struct{})
+	var once sync.Once
+	stage := func(name string, deps ...string) Stage {
+		return Stage{Name: name, Deps: deps, Run: func(ctx context.Context, _ Results, out *granular.WriteBuilder) error {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			if n == 2 {
+				once.Do(func() { close(started) })
+			}
+			// Hold the worker until another stage runs alongside this one
+			select {
+			case <-started:
+			case <-time.After(time.Second):
+			}
+			out.Bytes("out", []byte(name))
+			return nil
+		}}
+	}
+	stages := []Stage{stage("a"), stage("b"), stage("c"), stage("d"), stage("all", "a", "b", "c", "d")}
+
+	report, err := mustNew(t, stages, WithWorkers(2)).Run(t.Context())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := statuses(report); got != "a=ran b=ran c=ran d=ran all=ran" {
+		t.Errorf("statuses = %s", got)
+	}
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak concurrency = %d, want 2", got)
+	}
+}
+
+func TestRun_FailurePropagation(t *testing.T) {
+	ok := func(context.Context, Results, *granular.WriteBuilder) error { return nil }
+	fail := func(msg string) func(context.Context, Results, *granular.WriteBuilder) error {
+		return func(context.Context, Results, *granular.WriteBuilder) error { return errors.New(msg) }
+	}
+	stages := []Stage{
+		{Name: "bad", Run: fail("bad failed")},
+		{Name: "after-bad", Deps: []string{"bad"}, Run: ok},
+		{Name: "good", Run: ok},
+		{Name: "worse", Run: fail("worse failed")},
+	}
+
+	// By default no stage starts after a failure
+	report, err := mustNew(t, stages, WithWorkers(1)).Run(t.Context())
+	if err == nil || !strings.Contains(err.Error(), "stage bad: bad failed") || strings.Contains(err.Error(), "worse") {
+		t.Errorf("Run error = %v, want only stage bad", err)
+	}
+	if got := statuses(report); got != "bad=failed after-bad=skipped good=skipped worse=skipped" {
+		t.Errorf("statuses = %s", got)
+	}
+
+	// With WithKeepGoing only the dependents of failed stages are skipped
+	for _, workers := range []int{1, 4} {
+		report, err = mustNew(t, stages, WithWorkers(workers), WithKeepGoing()).Run(t.Context())
+		if err == nil || !strings.Contains(err.Error(), "stage bad") || !strings.Contains(err.Error(), "stage worse") {
+			t.Errorf("workers=%d: Run error = %v, want both failures", workers, err)
+		}
+		if got := statuses(report); got != "bad=failed after-bad=skipped good=ran worse=failed" {
+			t.Errorf("workers=%d: statuses = %s", workers, got)
+		}
+	}
+}
+
+func mustNew(t *testing.T, stages []Stage, opts ...Option) *Pipeline {
+	t.Helper()
+	p, err := New(granular.OpenTemp(), stages, opts...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return p
+}
+
+func TestNew_Errors(t *testing.T) {
+	run := func(context.Context, Results, *granular.WriteBuilder) error { return nil }
+	for name, stages := range map[string][]Stage{
+		"empty name":  {{Run: run}},
+		"duplicate":   {{Name: "a", Run: run}, {Name: "a", Run: run}},
+		"missing run": {{Name: "a"}},
+		"unknown dep": {{Name: "a", Deps: []string{"b"}, Run: run}},
+		"cycle":       {{Name: "a", Deps: []string{"c"}, Run: run}, {Name: "b", Deps: []string{"a"}, Run: run}, {Name: "c", Deps: []string{"b"}, Run: run}},
+	} {
+		if _, err := New(granular.OpenTemp(), stages); err == nil {
+			t.Errorf("%s: New succeeded", name)
+		}
+	}
+}
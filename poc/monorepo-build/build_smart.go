@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/pipeline"
 	"github.com/spf13/afero"
 )
 
@@ -60,7 +61,9 @@ func NewSmartBuilder(rootDir string) (*SmartBuilder, error) {
 	}, nil
 }
 
-// Build builds all packages with intelligent caching
+// Build builds all packages with intelligent caching. Packages are stages of
+// a pipeline, so independent packages build concurrently and a package is
+// rebuilt whenever it or one of its dependencies changed.
 func (b *SmartBuilder) Build() error {
 	fmt.Println("=== Smart Build (Granular Caching) ===")
 	fmt.Println("Analyzing dependencies and building only changed packages...")
@@ -69,38 +72,56 @@ func (b *SmartBuilder) Build() error {
 	packages := b.getPackages()
 	totalStart := time.Now()
 
-	var builtCount, cachedCount int
-	results := make([]BuildResult, 0)
-
-	// Build shared packages first
-	sharedPackages := filterSharedPackages(packages, true)
-	for _, pkg := range sharedPackages {
-		result, err := b.buildPackage(pkg)
+	stages := make([]pipeline.Stage, 0, len(packages))
+	byName := make(map[string]PackageInfo, len(packages))
+	for _, pkg := range packages {
+		byName[pkg.Name] = pkg
+		pkgHash, err := b.hashPackageFiles(pkg.Path)
 		if err != nil {
-			return fmt.Errorf("failed to build %s: %w", pkg.Name, err)
-		}
-		results = append(results, result)
-		if result.FromCache {
-			cachedCount++
-		} else {
-			builtCount++
+			return fmt.Errorf("failed to hash %s: %w", pkg.Name, err)
 		}
+		stages = append(stages, pipeline.Stage{
+			Name: pkg.Name,
+			Deps: b.dependencies[pkg.Name],
+			Inputs: func(kb *granular.KeyBuilder) {
+				kb.String("hash", pkgHash).String("go_version", "1.24")
+			},
+			Run: func(ctx context.Context, deps pipeline.Results, out *granular.WriteBuilder) error {
+				return b.executeBuild(pkg, out)
+			},
+		})
 	}
 
-	// Build services
-	servicePackages := filterSharedPackages(packages, false)
-	for _, pkg := range servicePackages {
-		result, err := b.buildPackage(pkg)
-		if err != nil {
-			return fmt.Errorf("failed to build %s: %w", pkg.Name, err)
-		}
-		results = append(results, result)
-		if result.FromCache {
+	p, err := pipeline.New(b.cache, stages)
+	if err != nil {
+		return err
+	}
+	report, err := p.Run(context.Background())
+
+	var builtCount, cachedCount int
+	results := make([]BuildResult, 0, len(report.Stages))
+	for _, stage := range report.Stages {
+		switch stage.Status {
+		case pipeline.Cached:
+			result, err := b.restore(byName[stage.Name], stage.Result)
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", stage.Name, err)
+			}
+			results = append(results, result)
 			cachedCount++
-		} else {
+			fmt.Printf("%-8s CACHED (%v)\n", stage.Name, stage.Duration)
+		case pipeline.Ran:
 			builtCount++
+			fmt.Printf("%-8s BUILT (%v)\n", stage.Name, stage.Duration)
+		case pipeline.Failed:
+			fmt.Printf("%-8s FAILED\n", stage.Name)
+		default:
+			fmt.Printf("%-8s SKIPPED\n", stage.Name)
 		}
 	}
+	if err != nil {
+		return err
+	}
 
 	totalDuration := time.Since(totalStart)
 
@@ -119,98 +140,46 @@ func (b *SmartBuilder) Build() error {
 	return nil
 }
 
-// buildPackage builds a single package with caching
-func (b *SmartBuilder) buildPackage(pkg PackageInfo) (BuildResult, error) {
-	start := time.Now()
+// restore puts the cached binary of a service back into bin/
+func (b *SmartBuilder) restore(pkg PackageInfo, cached *granular.Result) (BuildResult, error) {
 	result := BuildResult{
 		PackageName: pkg.Name,
-		BuildTime:   start,
+		BuildTime:   time.Now(),
+		FromCache:   true,
 	}
 
-	// Calculate cache key based on package contents and dependencies
-	key, err := b.calculateCacheKey(pkg)
-	if err != nil {
-		return result, fmt.Errorf("failed to calculate cache key: %w", err)
-	}
-
-	fmt.Printf("Building %s... ", pkg.Name)
-
-	// Check cache
-	cached, err := b.cache.Get(key)
-	if err != nil && !errors.Is(err, granular.ErrCacheMiss) {
-		return result, fmt.Errorf("failed to get from cache: %w", err)
-	}
-	if err == nil && cached != nil {
-		// Cache hit!
-		duration := time.Since(start)
-
-		// Restore binary if this is a service
-		if !pkg.IsShared {
-			binaryData := cached.Bytes("binary")
-			if len(binaryData) > 0 {
-				outputDir := filepath.Join(b.rootDir, "bin")
-				os.MkdirAll(outputDir, 0o755)
-				outputPath := filepath.Join(outputDir, pkg.Name)
-				if err := afero.WriteFile(b.fs, outputPath, binaryData, 0o755); err != nil {
-					fmt.Printf("FAILED (restore error)\n")
-					return result, err
-				}
-				result.OutputPath = outputPath
+	if !pkg.IsShared {
+		binaryData := cached.Bytes("binary")
+		if len(binaryData) > 0 {
+			outputDir := filepath.Join(b.rootDir, "bin")
+			if err := os.MkdirAll(outputDir, 0o755); err != nil {
+				return result, err
 			}
-		}
-
-		// Parse stored duration
-		if buildDur := cached.Meta("duration"); buildDur != "" {
-			if d, err := time.ParseDuration(buildDur); err == nil {
-				result.Duration = d
+			outputPath := filepath.Join(outputDir, pkg.Name)
+			if err := afero.WriteFile(b.fs, outputPath, binaryData, 0o755); err != nil {
+				return result, err
 			}
+			result.OutputPath = outputPath
 		}
-
-		result.FromCache = true
-		fmt.Printf("CACHED (%v)\n", duration)
-		return result, nil
 	}
 
-	// Cache miss - build from source
-	buildResult, err := b.executeBuild(pkg)
-	if err != nil {
-		fmt.Printf("FAILED\n")
-		return result, err
-	}
-
-	// Store in cache
-	writer := b.cache.Put(key).
-		Meta("package", pkg.Name).
-		Meta("duration", buildResult.Duration.String()).
-		Meta("build_time", buildResult.BuildTime.Format(time.RFC3339))
-
-	// Store binary if this is a service
-	if !pkg.IsShared && buildResult.OutputPath != "" {
-		binaryData, err := afero.ReadFile(b.fs, buildResult.OutputPath)
-		if err == nil {
-			writer = writer.Bytes("binary", binaryData)
+	// Parse stored duration
+	if buildDur := cached.Meta("duration"); buildDur != "" {
+		if d, err := time.ParseDuration(buildDur); err == nil {
+			result.Duration = d
 		}
 	}
-
-	writer.Commit()
-
-	fmt.Printf("BUILT (%v)\n", buildResult.Duration)
-	return buildResult, nil
+	return result, nil
 }
 
-// executeBuild performs the actual build
-func (b *SmartBuilder) executeBuild(pkg PackageInfo) (BuildResult, error) {
+// executeBuild performs the actual build and adds its outputs to out
+func (b *SmartBuilder) executeBuild(pkg PackageInfo, out *granular.WriteBuilder) error {
 	buildStart := time.Now()
-	result := BuildResult{
-		PackageName: pkg.Name,
-		BuildTime:   buildStart,
-		FromCache:   false,
-	}
 
 	// Determine output path
 	outputDir := filepath.Join(b.rootDir, "bin")
 	if err := os.MkdirAll(outputDir, 0o755); err != nil {
-		return result, err
+		return err
 	}
 
 	if pkg.IsShared {
@@ -221,12 +190,11 @@ func (b *SmartBuilder) executeBuild(pkg PackageInfo) (BuildResult, error) {
 
 		output, err := cmd.CombinedOutput()
 		if err != nil {
-			return result, fmt.Errorf("build error: %s", string(output))
+			return fmt.Errorf("build error: %s", string(output))
 		}
 	} else {
 		// For services, build binaries
 		outputPath := filepath.Join(outputDir, pkg.Name)
-		result.OutputPath = outputPath
 
 		cmd := exec.Command("go", "build", "-v", "-o", outputPath, "./"+pkg.Path)
 		cmd.Dir = b.rootDir
@@ -234,45 +202,21 @@ func (b *SmartBuilder) executeBuild(pkg PackageInfo) (BuildResult, error) {
 
 		output, err := cmd.CombinedOutput()
 		if err != nil {
-			return result, fmt.Errorf("build error: %s", string(output))
+			return fmt.Errorf("build error: %s", string(output))
 		}
-	}
-
-	result.Duration = time.Since(buildStart)
-	return result, nil
-}
 
-// calculateCacheKey generates a cache key based on source files and dependencies
-func (b *SmartBuilder) calculateCacheKey(pkg PackageInfo) (granular.Key, error) {
-	// Hash all source files in the package
-	pkgHash, err := b.hashPackageFiles(pkg.Path)
-	if err != nil {
-		return granular.Key{}, err
-	}
-
-	// Include dependency hashes
-	depHashes := make([]string, 0)
-	for _, dep := range b.dependencies[pkg.Name] {
-		depPath := b.getPackagePath(dep)
-		depHash, err := b.hashPackageFiles(depPath)
+		// Store the binary so it can be restored on a cache hit
+		binaryData, err := afero.ReadFile(b.fs, outputPath)
 		if err != nil {
-			return granular.Key{}, err
+			return err
 		}
-		depHashes = append(depHashes, depHash)
-	}
-
-	// Combine hashes
-	combinedHash := pkgHash
-	if len(depHashes) > 0 {
-		combinedHash += ":" + strings.Join(depHashes, ":")
+		out.Bytes("binary", binaryData)
 	}
 
-	// Create Granular key
-	return b.cache.Key().
-		String("package", pkg.Name).
-		String("hash", combinedHash).
-		String("go_version", "1.24").
-		Build(), nil
+	out.Meta("package", pkg.Name).
+		Meta("duration", time.Since(buildStart).String()).
+		Meta("build_time", buildStart.Format(time.RFC3339))
+	return nil
 }
 
 // hashPackageFiles computes a hash of all Go files in a package
@@ -343,17 +287,6 @@ func (b *SmartBuilder) getPackages() []PackageInfo {
 	}
 }
 
-// filterSharedPackages filters packages by shared status
-func filterSharedPackages(packages []PackageInfo, isShared bool) []PackageInfo {
-	result := make([]PackageInfo, 0)
-	for _, pkg := range packages {
-		if pkg.IsShared == isShared {
-			result = append(result, pkg)
-		}
-	}
-	return result
-}
-
 // estimateTimeSaved calculates time saved by caching
 func (b *SmartBuilder) estimateTimeSaved(results []BuildResult) time.Duration {
 	var saved time.Duration
@@ -0,0 +1,46 @@
+package granular
+
+import (
+	"io"
+
+	"github.com/gophersatwork/granular/internal/iobuf"
+)
+
+// Operation names reported in ProgressEvent.Op.
+const (
+	ProgressOpHash = "hash" // Hashing a key input file, or a cached output when storing or verifying an entry
+	ProgressOpCopy = "copy" // Copying a file into the cache (Commit) or out of it (Result.CopyFile)
+)
+
+// ProgressEvent reports progress on one file during hashing or copying.
+// Each file produces an event every few megabytes and a final event with
+// Done set, so callers can show per-file progress or sum Bytes across files.
+type ProgressEvent struct {
+	Op    string // ProgressOpHash or ProgressOpCopy
+	Path  string // File being hashed, source of a copy into the cache, or destination of a copy out of it
+	Bytes int64  // Bytes of Path processed so far
+	Done  bool   // Whether Path is finished
+}
+
+// reportProgress sends ev to the WithProgress callback, if any.
+func (c *Cache) reportProgress(ev ProgressEvent) {
+	if c.onProgress != nil {
+		c.onProgress(ev)
+	}
+}
+
+// trackProgress wraps r so that reading it reports progress on path. The
+// returned function reports the final event and must be called once r has
+// been read completely. Without a WithProgress callback, r is returned
+// unchanged.
+func (c *Cache) trackProgress(op, path string, r io.Reader) (io.Reader, func()) {
+	if c.onProgress == nil {
+		return r, func() {}
+	}
+	pr := &iobuf.ProgressReader{R: r, Report: func(n int64) {
+		c.onProgress(ProgressEvent{Op: op, Path: path, Bytes: n})
+	}}
+	return pr, func() {
+		c.onProgress(ProgressEvent{Op: op, Path: path, Bytes: pr.N(), Done: true})
+	}
+}
@@ -0,0 +1,244 @@
+package granular
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// setupClockCache creates a cache whose clock advances only when the returned
+// advance function is called.
+func setupClockCache(t *testing.T, options ...Option) (*Cache, func(time.Duration)) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	options = append([]Option{
+		WithFs(afero.NewMemMapFs()),
+		WithNowFunc(func() time.Time { return now }),
+	}, options...)
+	cache, err := Open(".cache", options...)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return cache, func(d time.Duration) { now = now.Add(d) }
+}
+
+// putSized stores a Bytes entry of size bytes under name and returns its key.
+func putSized(t *testing.T, cache *Cache, name string, size int) Key {
+	t.Helper()
+	key := cache.Key().String("name", name).Build()
+	if err := cache.Put(key).Bytes("data", make([]byte, size)).Commit(); err != nil {
+		t.Fatalf("Commit %s failed: %v", name, err)
+	}
+	return key
+}
+
+func TestPruneToSize(t *testing.T) {
+	cache, advance := setupClockCache(t)
+
+	var keys []Key
+	for i := range 4 {
+		keys = append(keys, putSized(t, cache, fmt.Sprint(i), 100))
+		advance(time.Minute)
+	}
+
+	// Touch the oldest entry so it becomes most recently used
+	if _, err := cache.Get(keys[0]); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	removed, freed, err := cache.PruneToSize(250)
+	if err != nil {
+		t.Fatalf("PruneToSize failed: %v", err)
+	}
+	if removed != 2 || freed != 200 {
+		t.Fatalf("Expected 2 entries / 200 bytes removed, got %d / %d", removed, freed)
+	}
+
+	// Least recently accessed entries (1 and 2) are evicted first
+	for i, want := range []bool{true, false, false, true} {
+		if got := cache.Has(keys[i]); got != want {
+			t.Errorf("Has(entry %d) = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestPruneToSize_AlreadyFits(t *testing.T) {
+	cache, _ := setupClockCache(t)
+	putSized(t, cache, "a", 100)
+
+	removed, freed, err := cache.PruneToSize(1000)
+	if err != nil {
+		t.Fatalf("PruneToSize failed: %v", err)
+	}
+	if removed != 0 || freed != 0 {
+		t.Errorf("Expected nothing removed, got %d / %d", removed, freed)
+	}
+}
+
+func TestPruneToSize_Zero(t *testing.T) {
+	cache, _ := setupClockCache(t)
+	putSized(t, cache, "a", 100)
+	putSized(t, cache, "b", 100)
+
+	removed, _, err := cache.PruneToSize(0)
+	if err != nil {
+		t.Fatalf("PruneToSize failed: %v", err)
+	}
+	stats, _ := cache.Stats()
+	if removed != 2 || stats.Entries != 0 {
+		t.Errorf("Expected cache to be emptied, removed %d, %d left", removed, stats.Entries)
+	}
+}
+
+func TestPrunePlan(t *testing.T) {
+	cache, advance := setupClockCache(t)
+
+	old := putSized(t, cache, "old", 10)
+	advance(2 * time.Hour)
+	putSized(t, cache, "new", 10)
+
+	planned, err := cache.PrunePlan(time.Hour)
+	if err != nil {
+		t.Fatalf("PrunePlan failed: %v", err)
+	}
+	if len(planned) != 1 || planned[0].KeyHash != old.Hash() {
+		t.Fatalf("Expected only the old entry to be planned, got %+v", planned)
+	}
+	if !cache.Has(old) {
+		t.Error("PrunePlan must not remove entries")
+	}
+
+	removed, err := cache.Prune(time.Hour)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if removed != len(planned) {
+		t.Errorf("Prune removed %d entries, plan listed %d", removed, len(planned))
+	}
+}
+
+func TestPruneUnusedPlan(t *testing.T) {
+	cache, advance := setupClockCache(t)
+
+	unused := putSized(t, cache, "unused", 10)
+	used := putSized(t, cache, "used", 10)
+	advance(2 * time.Hour)
+	if _, err := cache.Get(used); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	planned, err := cache.PruneUnusedPlan(time.Hour)
+	if err != nil {
+		t.Fatalf("PruneUnusedPlan failed: %v", err)
+	}
+	if len(planned) != 1 || planned[0].KeyHash != unused.Hash() {
+		t.Fatalf("Expected only the unused entry to be planned, got %+v", planned)
+	}
+	if !cache.Has(unused) {
+		t.Error("PruneUnusedPlan must not remove entries")
+	}
+}
+
+func TestPruneToSizePlan(t *testing.T) {
+	cache, advance := setupClockCache(t)
+
+	var keys []Key
+	for i := range 3 {
+		keys = append(keys, putSized(t, cache, fmt.Sprint(i), 100))
+		advance(time.Minute)
+	}
+
+	planned, err := cache.PruneToSizePlan(150)
+	if err != nil {
+		t.Fatalf("PruneToSizePlan failed: %v", err)
+	}
+	if len(planned) != 2 {
+		t.Fatalf("Expected 2 planned entries, got %d", len(planned))
+	}
+	// Eviction order: least recently accessed first
+	for i, entry := range planned {
+		if entry.KeyHash != keys[i].Hash() {
+			t.Errorf("planned[%d] = %s, want %s", i, entry.KeyHash, keys[i].Hash())
+		}
+	}
+	for i, key := range keys {
+		if !cache.Has(key) {
+			t.Errorf("PruneToSizePlan removed entry %d", i)
+		}
+	}
+
+	removed, _, err := cache.PruneToSize(150)
+	if err != nil {
+		t.Fatalf("PruneToSize failed: %v", err)
+	}
+	if removed != len(planned) {
+		t.Errorf("PruneToSize removed %d entries, plan listed %d", removed, len(planned))
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	var evicted []EvictReason
+	cache, _ := setupClockCache(t, WithMetrics(&MetricsHooks{
+		OnEvict: func(_ string, _ int64, reason EvictReason) { evicted = append(evicted, reason) },
+	}))
+
+	small := putSized(t, cache, "small", 10)
+	large := putSized(t, cache, "large", 1000)
+
+	key := cache.Key().String("name", "meta").Build()
+	if err := cache.Put(key).Bytes("data", []byte("x")).Meta("compiler", "go1.21").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	removed, err := cache.DeleteWhere(func(e Entry) bool { return e.Size > 500 })
+	if err != nil {
+		t.Fatalf("DeleteWhere failed: %v", err)
+	}
+	if removed != 1 || cache.Has(large) || !cache.Has(small) {
+		t.Fatalf("Expected only the large entry removed, removed=%d", removed)
+	}
+	if len(evicted) != 1 || evicted[0] != EvictReasonManual {
+		t.Errorf("Expected one manual eviction, got %v", evicted)
+	}
+
+	removed, err = cache.DeleteWhere(func(e Entry) bool { return e.Meta["compiler"] == "go1.21" })
+	if err != nil {
+		t.Fatalf("DeleteWhere failed: %v", err)
+	}
+	if removed != 1 || cache.Has(key) || !cache.Has(small) {
+		t.Fatalf("Expected only the metadata entry removed, removed=%d", removed)
+	}
+}
+
+func TestWithAccessTracking_Debounce(t *testing.T) {
+	cache, advance := setupClockCache(t, WithAccessTracking(time.Minute))
+	key := putSized(t, cache, "a", 10)
+
+	accessedAt := func() time.Time {
+		t.Helper()
+		info, err := cache.Describe(key.Hash())
+		if err != nil {
+			t.Fatalf("Describe failed: %v", err)
+		}
+		return info.AccessedAt
+	}
+	stored := accessedAt()
+
+	advance(30 * time.Second)
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := accessedAt(); !got.Equal(stored) {
+		t.Errorf("AccessedAt updated within debounce window: %v -> %v", stored, got)
+	}
+
+	advance(40 * time.Second)
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got, want := accessedAt(), stored.Add(70*time.Second); !got.Equal(want) {
+		t.Errorf("AccessedAt = %v, want %v", got, want)
+	}
+}
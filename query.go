@@ -0,0 +1,154 @@
+package granular
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Query selects cache entries by tag, metadata, size, and age. The zero
+// Query matches every entry; each field that is set narrows the match.
+type Query struct {
+	Tags map[string]string // Tags the entry must have, with these values
+	Meta map[string]string // Metadata the entry must have, with these values
+
+	MinSize int64 // Smallest Entry.Size to match; zero for no bound
+	MaxSize int64 // Largest Entry.Size to match; zero for no bound
+
+	CreatedBefore  time.Time // Match entries created before this time
+	CreatedAfter   time.Time // Match entries created after this time
+	AccessedBefore time.Time // Match entries last accessed before this time
+	AccessedAfter  time.Time // Match entries last accessed after this time
+
+	OrderBy QueryOrder // Order of the results
+	After   *Entry     // Cursor: only return entries ordered after this one
+	Offset  int        // Number of results to skip
+	Limit   int        // Maximum number of results; zero for no limit
+}
+
+// QueryOrder is the order of the entries returned by Cache.Query.
+type QueryOrder int
+
+// Orders for Query.OrderBy.
+const (
+	OrderByKeyHash  QueryOrder = iota // By key hash (the default)
+	OrderByCreated                    // Oldest first
+	OrderByAccessed                   // Least recently accessed first, like eviction
+	OrderBySize                       // Largest first
+)
+
+// compare orders entries for o. Ties are broken by key hash, so the order is
+// total and an entry can serve as a pagination cursor.
+func (o QueryOrder) compare(a, b Entry) int {
+	var c int
+	switch o {
+	case OrderByCreated:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	case OrderByAccessed:
+		c = a.AccessedAt.Compare(b.AccessedAt)
+	case OrderBySize:
+		c = cmp.Compare(b.Size, a.Size)
+	}
+	return cmp.Or(c, strings.Compare(a.KeyHash, b.KeyHash))
+}
+
+// Match reports whether entry satisfies q; ordering and pagination are
+// ignored. It can be passed to DeleteWhere to remove what a query selects.
+func (q Query) Match(entry Entry) bool {
+	for k, v := range q.Tags {
+		if got, ok := entry.Tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	for k, v := range q.Meta {
+		if got, ok := entry.Meta[k]; !ok || got != v {
+			return false
+		}
+	}
+	switch {
+	case q.MinSize > 0 && entry.Size < q.MinSize,
+		q.MaxSize > 0 && entry.Size > q.MaxSize,
+		!q.CreatedBefore.IsZero() && !entry.CreatedAt.Before(q.CreatedBefore),
+		!q.CreatedAfter.IsZero() && !entry.CreatedAt.After(q.CreatedAfter),
+		!q.AccessedBefore.IsZero() && !entry.AccessedAt.Before(q.AccessedBefore),
+		!q.AccessedAfter.IsZero() && !entry.AccessedAt.After(q.AccessedAfter):
+		return false
+	}
+	return true
+}
+
+// Query returns the entries matching q, in the order given by q.OrderBy.
+//
+// Results can be paged with Offset and Limit, or with a cursor: pass the
+// last entry of a page as After to get the next one. Unlike an offset, a
+// cursor does not skip or repeat entries when entries before it are added or
+// removed between pages.
+//
+// With WithIndex the query is answered from memory; otherwise it walks the
+// manifests like Entries. Caches whose janitors query often should enable
+// the index.
+//
+// Example:
+//
+//	// Entries of one pipeline older than 30 days and larger than 100MB
+//	entries, err := cache.Query(granular.Query{
+//		Meta:          map[string]string{"pipeline": "nightly"},
+//		CreatedBefore: time.Now().AddDate(0, 0, -30),
+//		MinSize:       100 << 20,
+//		OrderBy:       granular.OrderBySize,
+//	})
+func (c *Cache) Query(q Query) ([]Entry, error) {
+	entries, err := c.allEntries()
+	if err != nil {
+		return nil, err
+	}
+	entries = slices.DeleteFunc(entries, func(e Entry) bool { return !q.Match(e) })
+	slices.SortFunc(entries, q.OrderBy.compare)
+
+	if q.After != nil {
+		i, found := slices.BinarySearchFunc(entries, *q.After, q.OrderBy.compare)
+		if found {
+			i++
+		}
+		entries = entries[i:]
+	}
+	entries = entries[min(max(q.Offset, 0), len(entries)):]
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries, nil
+}
+
+// EntriesOption sorts or pages the entries returned by Cache.Entries.
+type EntriesOption func(*Query)
+
+// SortEntries returns entries in the given order instead of the order they
+// are found in.
+func SortEntries(order QueryOrder) EntriesOption {
+	return func(q *Query) {
+		q.OrderBy = order
+	}
+}
+
+// LimitEntries returns at most n entries.
+func LimitEntries(n int) EntriesOption {
+	return func(q *Query) {
+		q.Limit = n
+	}
+}
+
+// OffsetEntries skips the first n entries.
+func OffsetEntries(n int) EntriesOption {
+	return func(q *Query) {
+		q.Offset = n
+	}
+}
+
+// EntriesAfter returns the entries ordered after cursor, typically the last
+// entry of the previous page. See Query.After.
+func EntriesAfter(cursor Entry) EntriesOption {
+	return func(q *Query) {
+		q.After = &cursor
+	}
+}
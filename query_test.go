@@ -0,0 +1,137 @@
+package granular
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		name := "Walk"
+		var options []Option
+		if indexed {
+			name = "Index"
+			options = append(options, WithIndex())
+		}
+		t.Run(name, func(t *testing.T) {
+			cache, advance := setupClockCache(t, options...)
+			start := cache.now()
+
+			put := func(name, pipeline, team string, size int) string {
+				key := cache.Key().String("name", name).Build()
+				err := cache.Put(key).Bytes("data", make([]byte, size)).
+					Meta("pipeline", pipeline).Tag("team", team).Commit()
+				assertNoError(t, err, "Commit "+name)
+				advance(24 * time.Hour)
+				return key.Hash()
+			}
+			oldBig := put("old-big", "nightly", "infra", 300)
+			oldSmall := put("old-small", "nightly", "infra", 10)
+			oldOther := put("old-other", "release", "infra", 500)
+			advance(30 * 24 * time.Hour)
+			newBig := put("new-big", "nightly", "web", 200)
+
+			hashes := func(entries []Entry, err error) []string {
+				t.Helper()
+				assertNoError(t, err, "Query")
+				var keys []string
+				for _, e := range entries {
+					keys = append(keys, e.KeyHash)
+				}
+				return keys
+			}
+			sorted := func(keys ...string) []string { return slices.Sorted(slices.Values(keys)) }
+
+			tests := []struct {
+				name  string
+				query Query
+				want  []string
+			}{
+				{"All", Query{}, sorted(oldBig, oldSmall, oldOther, newBig)},
+				{"Meta", Query{Meta: map[string]string{"pipeline": "nightly"}}, sorted(oldBig, oldSmall, newBig)},
+				{"Tag", Query{Tags: map[string]string{"team": "web"}}, []string{newBig}},
+				{"Janitor", Query{
+					Meta:          map[string]string{"pipeline": "nightly"},
+					CreatedBefore: start.Add(10 * 24 * time.Hour),
+					MinSize:       100,
+				}, []string{oldBig}},
+				{"MaxSize", Query{MaxSize: 200}, sorted(oldSmall, newBig)},
+				{"CreatedAfter", Query{CreatedAfter: start}, sorted(oldSmall, oldOther, newBig)},
+				{"BySize", Query{OrderBy: OrderBySize}, []string{oldOther, oldBig, newBig, oldSmall}},
+				{"ByCreatedLimit", Query{OrderBy: OrderByCreated, Limit: 2}, []string{oldBig, oldSmall}},
+				{"NoMatch", Query{Meta: map[string]string{"pipeline": "missing"}}, nil},
+			}
+			for _, tt := range tests {
+				if got := hashes(cache.Query(tt.query)); !slices.Equal(got, tt.want) {
+					t.Errorf("%s: Query = %v, want %v", tt.name, got, tt.want)
+				}
+			}
+
+			// Query.Match composes with DeleteWhere
+			q := Query{Meta: map[string]string{"pipeline": "nightly"}, MinSize: 100}
+			n, err := cache.DeleteWhere(q.Match)
+			assertNoError(t, err, "DeleteWhere")
+			if n != 2 {
+				t.Errorf("DeleteWhere removed %d entries, want 2", n)
+			}
+			if got := hashes(cache.Query(Query{})); !slices.Equal(got, sorted(oldSmall, oldOther)) {
+				t.Errorf("after DeleteWhere: Query = %v", got)
+			}
+		})
+	}
+}
+
+// TestEntriesPagination tests sorting and paging Entries with offsets and
+// cursors.
+func TestEntriesPagination(t *testing.T) {
+	cache, advance := setupClockCache(t, WithIndex())
+	var bySize []string // Largest first
+	for i := range 7 {
+		bySize = append([]string{putSized(t, cache, fmt.Sprint(i), 10*(i+1)).Hash()}, bySize...)
+		advance(time.Minute)
+	}
+	hashes := func(entries []Entry) []string {
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.KeyHash)
+		}
+		return keys
+	}
+
+	all, err := cache.Entries()
+	assertNoError(t, err, "Entries")
+	if len(all) != 7 {
+		t.Fatalf("Entries() = %d entries, want 7", len(all))
+	}
+
+	page, err := cache.Entries(SortEntries(OrderBySize), OffsetEntries(2), LimitEntries(3))
+	assertNoError(t, err, "Entries with offset")
+	if got := hashes(page); !slices.Equal(got, bySize[2:5]) {
+		t.Errorf("offset page = %v, want %v", got, bySize[2:5])
+	}
+	if page, _ := cache.Entries(OffsetEntries(10)); len(page) != 0 {
+		t.Errorf("offset past the end = %d entries, want 0", len(page))
+	}
+
+	// Walk every page by cursor, deleting an entry already seen midway:
+	// nothing is skipped or repeated
+	var walked []string
+	opts := []EntriesOption{SortEntries(OrderBySize), LimitEntries(3)}
+	for {
+		page, err := cache.Entries(opts...)
+		assertNoError(t, err, "Entries page")
+		if len(page) == 0 {
+			break
+		}
+		walked = append(walked, hashes(page)...)
+		if len(walked) == 3 {
+			assertNoError(t, cache.Delete(cache.Key().String("name", "6").Build()), "Delete")
+		}
+		opts = []EntriesOption{SortEntries(OrderBySize), LimitEntries(3), EntriesAfter(page[len(page)-1])}
+	}
+	if !slices.Equal(walked, bySize) {
+		t.Errorf("cursor walk = %v, want %v", walked, bySize)
+	}
+}
@@ -0,0 +1,54 @@
+package granular
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// refresher runs the WithRefresher callback for entries past their soft
+// TTL. It is shared by the namespace views of a cache.
+type refresher struct {
+	fn       func(Key) error
+	inFlight sync.Map // key hash -> struct{}, entries being refreshed
+	wg       sync.WaitGroup
+}
+
+// refreshIfStale schedules a background refresh of the entry behind result
+// if it is past the soft TTL set with WriteBuilder.RefreshAfter. At most one
+// refresh per entry runs at a time; failures are reported through
+// MetricsHooks.OnError and the next hit schedules another attempt.
+func (c *Cache) refreshIfStale(key Key, result *Result) {
+	r := c.refresher
+	if r == nil || !result.Stale() {
+		return
+	}
+	if _, running := r.inFlight.LoadOrStore(result.keyHash, struct{}{}); running {
+		return
+	}
+	r.wg.Go(func() {
+		defer r.inFlight.Delete(result.keyHash)
+		if err := r.fn(key); err != nil {
+			c.recordError("refresh", fmt.Errorf("refresh %s: %w", result.keyHash, err))
+		}
+	})
+}
+
+// waitRefreshes waits for scheduled refreshes to finish.
+func (c *Cache) waitRefreshes() {
+	if c.refresher != nil {
+		c.refresher.wg.Wait()
+	}
+}
+
+// RefreshAt returns when the entry passes its soft TTL, as set with
+// WriteBuilder.RefreshAfter, or the zero time if it has none.
+func (r *Result) RefreshAt() time.Time {
+	return r.refreshAt
+}
+
+// Stale reports whether the entry is past its soft TTL. Stale entries are
+// still returned by Get; with WithRefresher, Get also schedules a refresh.
+func (r *Result) Stale() bool {
+	return !r.refreshAt.IsZero() && !r.cache.now().Before(r.refreshAt)
+}
@@ -0,0 +1,88 @@
+package granular
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshAfter(t *testing.T) {
+	var cache *Cache
+	var calls atomic.Int32
+	release := make(chan struct{})
+	cache, advance := setupClockCache(t, WithRefresher(func(key Key) error {
+		calls.Add(1)
+		<-release
+		return cache.Put(key).Bytes("deps", []byte("v2")).RefreshAfter(time.Hour).Commit()
+	}))
+
+	key := cache.Key().String("deps", "app").Build()
+	if err := cache.Put(key).Bytes("deps", []byte("v1")).RefreshAfter(time.Hour).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get before soft TTL")
+	if result.Stale() || calls.Load() != 0 {
+		t.Fatalf("fresh entry: Stale = %v, refreshes = %d", result.Stale(), calls.Load())
+	}
+
+	// Past the soft TTL the entry still hits, and concurrent hits schedule
+	// a single refresh
+	advance(2 * time.Hour)
+	for range 3 {
+		result, err = cache.Get(key)
+		assertCacheHit(t, result, err, "Get after soft TTL")
+		assertEqual(t, string(result.Bytes("deps")), "v1", "stale data")
+		if !result.Stale() {
+			t.Fatal("expected entry past its soft TTL to be stale")
+		}
+	}
+	close(release)
+	assertNoError(t, cache.Close(), "Close")
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("refresher called %d times, want 1", n)
+	}
+
+	result, err = cache.Get(key)
+	assertCacheHit(t, result, err, "Get after refresh")
+	assertEqual(t, string(result.Bytes("deps")), "v2", "refreshed data")
+	if result.Stale() {
+		t.Error("refreshed entry is stale")
+	}
+}
+
+func TestRefreshAfter_Errors(t *testing.T) {
+	var mu sync.Mutex
+	var ops []string
+	refreshErr := errors.New("registry unavailable")
+	cache, advance := setupClockCache(t,
+		WithRefresher(func(Key) error { return refreshErr }),
+		WithMetrics(&MetricsHooks{OnError: func(op string, err error) {
+			if errors.Is(err, refreshErr) {
+				mu.Lock()
+				ops = append(ops, op)
+				mu.Unlock()
+			}
+		}}))
+
+	key := cache.Key().String("deps", "app").Build()
+	if err := cache.Put(key).Bytes("deps", []byte("v1")).RefreshAfter(time.Minute).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	advance(time.Hour)
+
+	// Each hit after a failed refresh tries again
+	for range 2 {
+		result, err := cache.Get(key)
+		assertCacheHit(t, result, err, "Get")
+		cache.waitRefreshes()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ops) != 2 || ops[0] != "refresh" {
+		t.Errorf("reported refresh errors = %v, want two for op refresh", ops)
+	}
+}
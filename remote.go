@@ -0,0 +1,395 @@
+package granular
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gophersatwork/granular/internal/format"
+	"github.com/spf13/afero"
+)
+
+// ErrInvalidObjectName is returned when an object name is not the base name
+// of a cached file or data object (file.<name> or data.<name>.dat).
+var ErrInvalidObjectName = errors.New("invalid object name")
+
+// ErrUploadOffset is returned by ResumeObject when fewer bytes of the object
+// are staged than the transfer wants to resume from.
+var ErrUploadOffset = errors.New("resume offset past staged bytes")
+
+// Entries are transferred between caches one manifest and one object at a
+// time, so a remote cache server can expose them as content-addressed URLs
+// and stream objects without buffering whole entries:
+//
+//  1. the sender reads the manifest with ExportManifest and each object it
+//     names with OpenObject;
+//  2. the receiver stores each object with StageObject, then the manifest
+//     with ImportManifest, which verifies the staged objects and publishes
+//     the entry.
+//
+// A transfer interrupted while staging an object keeps the bytes received so
+// far; it continues from StagedSize with ResumeObject instead of starting
+// over, which matters for multi-gigabyte objects on flaky networks.
+//
+// Exported manifests name objects by base name and carry an output hash that
+// does not depend on where either cache lives.
+
+// ExportManifest returns the manifest of the entry with the given key hash in
+// its portable form. Object paths are replaced by their base names, which are
+// the names to pass to OpenObject.
+//
+// It returns ErrCacheMiss if the entry does not exist and ErrCacheCorrupted if
+// its objects no longer match the manifest, so corrupted entries are never
+// handed to another cache.
+func (c *Cache) ExportManifest(keyHash string) ([]byte, error) {
+	if err := validateKeyHash(keyHash); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	m, err := c.loadManifest(keyHash)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+	}
+	if !inNamespace(c.namespace, m.Namespace) {
+		return nil, ErrCacheMiss
+	}
+	if err := c.verifyOutputHash(m); err != nil {
+		return nil, fmt.Errorf("%w: entry %s", ErrCacheCorrupted, keyHash)
+	}
+
+	baseNames := func(paths map[string]string) map[string]string {
+		names := make(map[string]string, len(paths))
+		for name, path := range paths {
+			names[name] = filepath.Base(path)
+		}
+		return names
+	}
+	portable := *m
+	if portable.HashAlgo == "" {
+		portable.HashAlgo = DefaultHashAlgoName // legacy manifests were written with the default
+	}
+	portable.OutputFiles = baseNames(m.OutputFiles)
+	portable.OutputData = baseNames(m.OutputData)
+	portable.OutputHash, err = c.portableOutputHash(slices.Collect(maps.Values(m.OutputFiles)), m.OutputData, m.OutputMeta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash outputs of %s: %w", keyHash, err)
+	}
+	return format.Marshal(&portable)
+}
+
+// OpenObject opens an object of the entry with the given key hash for
+// reading. name is a base name from the manifest returned by ExportManifest.
+// Objects are returned as stored, so data is still compressed if the cache
+// uses compression. The caller must close the file.
+//
+// It returns ErrCacheMiss if the entry or object does not exist.
+func (c *Cache) OpenObject(keyHash, name string) (afero.File, error) {
+	if err := validateKeyHash(keyHash); err != nil {
+		return nil, err
+	}
+	if err := validateObjectName(name); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	objectDir, err := c.objectPath(keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	f, err := c.fs.Open(filepath.Join(objectDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		// Data may be a shared blob (see WithDedup) the manifest references
+		m, loadErr := c.loadManifest(keyHash)
+		if loadErr != nil {
+			return nil, ErrCacheMiss
+		}
+		for path := range maps.Values(m.OutputData) {
+			if isBlobPath(path) && filepath.Base(path) == name {
+				return c.fs.Open(path)
+			}
+		}
+		return nil, ErrCacheMiss
+	}
+	return f, err
+}
+
+// ObjectVersion returns a token identifying the content of an object of the
+// entry with the given key hash: while the token stays the same, so do the
+// bytes OpenObject returns. It is derived from the output hash recorded in
+// the manifest, or for a shared blob (see WithDedup) is its digest, so it
+// costs a manifest read rather than a read of the object. A cache server
+// uses it as the object's ETag.
+//
+// It returns ErrCacheMiss if the entry or object does not exist.
+func (c *Cache) ObjectVersion(keyHash, name string) (string, error) {
+	if err := validateKeyHash(keyHash); err != nil {
+		return "", err
+	}
+	if err := validateObjectName(name); err != nil {
+		return "", err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+
+	m, err := c.loadManifest(keyHash)
+	if err != nil {
+		return "", ErrCacheMiss
+	}
+	for _, path := range slices.Concat(slices.Collect(maps.Values(m.OutputFiles)), slices.Collect(maps.Values(m.OutputData))) {
+		if filepath.Base(path) != name {
+			continue
+		}
+		if isBlobPath(path) {
+			return strings.TrimSuffix(strings.TrimPrefix(name, "data."), ".dat"), nil
+		}
+		h := c.newHash()
+		fmt.Fprintf(h, "%d:%s%d:%s", len(m.OutputHash), m.OutputHash, len(name), name)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return "", ErrCacheMiss
+}
+
+// StageObject stores an object of an incoming entry until ImportManifest
+// publishes it. Staged objects are not visible to Get. Staging the same
+// object again replaces it.
+//
+// If reading r fails, the bytes received so far are kept, and the transfer
+// can continue from StagedSize with ResumeObject instead of starting over.
+func (c *Cache) StageObject(keyHash, name string, r io.Reader) error {
+	return c.stageObject(keyHash, name, 0, r)
+}
+
+// ResumeObject continues staging an object whose first offset bytes were
+// already staged by StageObject or ResumeObject: the staged object is cut
+// to offset bytes and r is appended to it. An offset of 0 is the same as
+// StageObject.
+//
+// It returns ErrUploadOffset if fewer than offset bytes are staged.
+func (c *Cache) ResumeObject(keyHash, name string, offset int64, r io.Reader) error {
+	return c.stageObject(keyHash, name, offset, r)
+}
+
+// StagedSize returns how many bytes of an object of an incoming entry have
+// been staged, complete or not: the offset to resume a transfer from. It is
+// 0 if nothing has been staged.
+func (c *Cache) StagedSize(keyHash, name string) (int64, error) {
+	if err := validateKeyHash(keyHash); err != nil {
+		return 0, err
+	}
+	if err := validateObjectName(name); err != nil {
+		return 0, err
+	}
+	c.uploadLocks.lockKey(keyHash)
+	defer c.uploadLocks.unlockKey(keyHash)
+
+	path := filepath.Join(c.uploadDir(keyHash), name)
+	for _, p := range []string{partialPath(path), path} {
+		info, err := c.fs.Stat(p)
+		if err == nil {
+			return info.Size(), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// stageObject writes r to the staged object name, starting at offset.
+// Objects are written to a partial file that is renamed into place once r
+// is read to the end, so ImportManifest never adopts half an object. The
+// upload lock keeps a resumed transfer from writing the partial file while
+// the interrupted one is still finishing.
+func (c *Cache) stageObject(keyHash, name string, offset int64, r io.Reader) error {
+	if err := validateKeyHash(keyHash); err != nil {
+		return err
+	}
+	if err := validateObjectName(name); err != nil {
+		return err
+	}
+
+	c.uploadLocks.lockKey(keyHash)
+	defer c.uploadLocks.unlockKey(keyHash)
+
+	stageDir := c.uploadDir(keyHash)
+	if err := c.mkdirAll(stageDir); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	path := filepath.Join(stageDir, name)
+	partial := partialPath(path)
+	var f afero.File
+	var err error
+	if offset > 0 {
+		// A complete object is reopened, so a resumed transfer that only
+		// resends its tail still ends with the whole object
+		if _, err := c.fs.Stat(partial); errors.Is(err, os.ErrNotExist) {
+			_ = c.fs.Rename(path, partial)
+		}
+		f, err = c.fs.OpenFile(partial, os.O_WRONLY, 0)
+		if err == nil {
+			err = resumeAt(f, offset)
+		}
+	} else {
+		f, err = c.createFile(partial)
+	}
+	if err != nil {
+		if f != nil {
+			_ = f.Close()
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: nothing staged, resuming at %d", ErrUploadOffset, offset)
+		}
+		return fmt.Errorf("failed to open object %s: %w", name, err)
+	}
+	maxSize := c.effectiveMaxDataSize() - offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, maxSize+1))
+	if err := errors.Join(copyErr, f.Close()); err != nil {
+		// Keep what arrived, so the transfer can resume from there
+		return fmt.Errorf("failed to write object %s: %w", name, err)
+	}
+	if n > maxSize {
+		_ = c.fs.Remove(partial)
+		return fmt.Errorf("failed to write object %s: object exceeds max allowed size %d", name, c.effectiveMaxDataSize())
+	}
+	if err := c.fs.Rename(partial, path); err != nil {
+		return fmt.Errorf("failed to rename object %s: %w", name, err)
+	}
+	return nil
+}
+
+// resumeAt cuts f to offset bytes and moves to its end. It fails with
+// ErrUploadOffset if f is shorter than offset.
+func resumeAt(f afero.File, offset int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < offset {
+		return fmt.Errorf("%w: %d bytes staged, resuming at %d", ErrUploadOffset, info.Size(), offset)
+	}
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// partialPath returns where the staged object at path is written until it
+// is complete.
+func partialPath(path string) string {
+	return path + ".partial"
+}
+
+// ImportManifest publishes an entry whose objects have been staged with
+// StageObject. data is a manifest as returned by ExportManifest. The staged
+// objects are checked against the manifest's output hash before anything
+// becomes visible, and an existing entry with the same key hash is replaced.
+//
+// It returns ErrCacheCorrupted if objects are missing or do not match, and
+// ErrHashAlgoMismatch or ErrCompressionMismatch if the entry was written by a
+// cache configured differently. The staged objects are discarded either way.
+func (c *Cache) ImportManifest(keyHash string, data []byte) error {
+	if err := validateKeyHash(keyHash); err != nil {
+		return err
+	}
+	stageDir := c.uploadDir(keyHash)
+	defer func() { _ = c.fs.RemoveAll(stageDir) }()
+
+	m, err := format.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+	}
+	if m.KeyHash != keyHash {
+		return fmt.Errorf("%w: manifest for %s uploaded as %s", ErrCacheCorrupted, m.KeyHash, keyHash)
+	}
+	if m.HashAlgo != c.hashAlgoName {
+		return fmt.Errorf("%w: entry uses %s, cache uses %s", ErrHashAlgoMismatch, m.HashAlgo, c.hashAlgoName)
+	}
+	if CompressionType(m.Compression) != c.compression {
+		return fmt.Errorf("%w: entry compression %q, cache compression %q", ErrCompressionMismatch, m.Compression, c.compression)
+	}
+
+	// Point the manifest at the staged objects and check them before adopting
+	staged := func(names map[string]string, validate func(string) error) (map[string]string, error) {
+		paths := make(map[string]string, len(names))
+		for name, object := range names {
+			if err := validate(name); err != nil {
+				return nil, fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+			}
+			if err := validateObjectName(object); err != nil {
+				return nil, err
+			}
+			paths[name] = filepath.Join(stageDir, object)
+		}
+		return paths, nil
+	}
+	if m.OutputFiles, err = staged(m.OutputFiles, validatePathName); err != nil {
+		return err
+	}
+	if m.OutputData, err = staged(m.OutputData, validateName); err != nil {
+		return err
+	}
+	sum, err := c.portableOutputHash(slices.Collect(maps.Values(m.OutputFiles)), m.OutputData, m.OutputMeta)
+	if err != nil || sum != m.OutputHash {
+		return fmt.Errorf("%w: staged objects of %s do not match the manifest", ErrCacheCorrupted, keyHash)
+	}
+
+	if _, err := c.adoptEntry(m, stageDir, ConflictOverwrite); err != nil {
+		return err
+	}
+	if c.maxSize > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.evictIfNeeded(0)
+	}
+	return nil
+}
+
+// uploadDir returns the staging directory for objects of an incoming entry.
+func (c *Cache) uploadDir(keyHash string) string {
+	return filepath.Join(c.root, "tmp", "upload-"+keyHash)
+}
+
+// validateKeyHash checks that keyHash is a hex digest long enough for
+// sharding. Key hashes from other processes are checked before they are used
+// in paths.
+func validateKeyHash(keyHash string) error {
+	if len(keyHash) < hashPrefixLen || strings.Trim(keyHash, "0123456789abcdef") != "" {
+		return fmt.Errorf("%w: %q", ErrInvalidKeyHash, keyHash)
+	}
+	return nil
+}
+
+// validateObjectName checks that name is the base name of a cached object.
+func validateObjectName(name string) error {
+	if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) ||
+		!(strings.HasPrefix(name, "file.") || strings.HasPrefix(name, "data.") && strings.HasSuffix(name, ".dat")) {
+		return fmt.Errorf("%w: %q", ErrInvalidObjectName, name)
+	}
+	return nil
+}
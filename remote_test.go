@@ -0,0 +1,169 @@
+package granular
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gophersatwork/granular/internal/format"
+	"github.com/spf13/afero"
+)
+
+// TestManifestTransfer tests moving an entry between caches with different
+// roots through the manifest/object transfer API.
+func TestManifestTransfer(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	src, err := Open("/src", WithFs(fs))
+	assertNoError(t, err, "Open src")
+	dst, err := Open("/elsewhere/dst", WithFs(fs))
+	assertNoError(t, err, "Open dst")
+
+	createTestFile(t, fs, "/work/app", []byte("binary"))
+	key := src.Key().String("target", "app").Build()
+	err = src.Put(key).File("app", "/work/app").Bytes("log", []byte("ok")).Commit()
+	assertNoError(t, err, "Commit")
+
+	data, err := src.ExportManifest(key.Hash())
+	assertNoError(t, err, "ExportManifest")
+	m, err := format.Unmarshal(data)
+	assertNoError(t, err, "parse manifest")
+	for _, object := range slices.Concat(slices.Collect(maps.Values(m.OutputFiles)), slices.Collect(maps.Values(m.OutputData))) {
+		f, err := src.OpenObject(key.Hash(), object)
+		assertNoError(t, err, "OpenObject "+object)
+		err = dst.StageObject(key.Hash(), object, f)
+		_ = f.Close()
+		assertNoError(t, err, "StageObject "+object)
+	}
+	assertNoError(t, dst.ImportManifest(key.Hash(), data), "ImportManifest")
+
+	result, err := dst.Get(dst.Key().String("target", "app").Build())
+	assertNoError(t, err, "Get")
+	log, err := result.BytesErr("log")
+	assertNoError(t, err, "BytesErr")
+	assertEqual(t, string(log), "ok", "log data")
+	if dir := filepath.Dir(result.File("app")); dir != mustObjectPath(t, dst, key.Hash()) {
+		t.Errorf("imported file lives in %s, want the destination object directory", dir)
+	}
+	if _, err := dst.fs.Stat(dst.uploadDir(key.Hash())); err == nil {
+		t.Error("Expected staging directory to be removed")
+	}
+
+	// Object names and key hashes are checked before they reach a path
+	if _, err := src.OpenObject(key.Hash(), "../manifest.json"); !errors.Is(err, ErrInvalidObjectName) {
+		t.Errorf("OpenObject with traversal = %v, want ErrInvalidObjectName", err)
+	}
+	if err := dst.StageObject("../../etc", "data.x.dat", io.MultiReader()); !errors.Is(err, ErrInvalidKeyHash) {
+		t.Errorf("StageObject with bad key hash = %v, want ErrInvalidKeyHash", err)
+	}
+	if _, err := src.ExportManifest("00000000deadbeef"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("ExportManifest of missing entry = %v, want ErrCacheMiss", err)
+	}
+
+	// Logical names in imported manifests are checked like names given to Put
+	m.OutputData["../../manifests/x"] = m.OutputData["log"]
+	tampered, err := format.Marshal(m)
+	assertNoError(t, err, "marshal manifest")
+	if err := dst.ImportManifest(key.Hash(), tampered); !errors.Is(err, ErrCacheCorrupted) {
+		t.Errorf("ImportManifest with traversal name = %v, want ErrCacheCorrupted", err)
+	}
+}
+
+func mustObjectPath(t *testing.T, c *Cache, keyHash string) string {
+	t.Helper()
+	dir, err := c.objectPath(keyHash)
+	assertNoError(t, err, "objectPath")
+	return dir
+}
+
+// TestResumeObject tests that an interrupted StageObject keeps what it read
+// and that ResumeObject continues from there.
+func TestResumeObject(t *testing.T) {
+	src := OpenTemp()
+	dst := OpenTemp()
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	key := src.Key().String("target", "model").Build()
+	assertNoError(t, src.Put(key).Bytes("weights", payload).Commit(), "Commit")
+	data, err := src.ExportManifest(key.Hash())
+	assertNoError(t, err, "ExportManifest")
+	object := "data.weights.dat"
+
+	// The connection drops after 4000 bytes
+	interrupted := io.MultiReader(bytes.NewReader(payload[:4000]), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if err := dst.StageObject(key.Hash(), object, interrupted); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("interrupted StageObject = %v, want io.ErrUnexpectedEOF", err)
+	}
+	staged, err := dst.StagedSize(key.Hash(), object)
+	assertNoError(t, err, "StagedSize")
+	if staged != 4000 {
+		t.Fatalf("StagedSize = %d, want 4000", staged)
+	}
+	if err := dst.ImportManifest(key.Hash(), data); !errors.Is(err, ErrCacheCorrupted) {
+		t.Fatalf("ImportManifest of a partial object = %v, want ErrCacheCorrupted", err)
+	}
+
+	// Resuming past the staged bytes fails; resuming earlier resends a tail
+	assertNoError(t, dst.StageObject(key.Hash(), object, bytes.NewReader(payload[:4000])), "StageObject")
+	if err := dst.ResumeObject(key.Hash(), object, 5000, bytes.NewReader(payload[5000:])); !errors.Is(err, ErrUploadOffset) {
+		t.Errorf("ResumeObject past staged bytes = %v, want ErrUploadOffset", err)
+	}
+	assertNoError(t, dst.ResumeObject(key.Hash(), object, 3000, bytes.NewReader(payload[3000:])), "ResumeObject")
+	staged, err = dst.StagedSize(key.Hash(), object)
+	assertNoError(t, err, "StagedSize")
+	if staged != int64(len(payload)) {
+		t.Errorf("StagedSize after resume = %d, want %d", staged, len(payload))
+	}
+	assertNoError(t, dst.ImportManifest(key.Hash(), data), "ImportManifest")
+
+	result, err := dst.Get(dst.Key().String("target", "model").Build())
+	assertNoError(t, err, "Get")
+	if got, err := result.BytesErr("weights"); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("resumed object = %d bytes, %v; want the payload", len(got), err)
+	}
+	if err := dst.ResumeObject(key.Hash(), object, 1, bytes.NewReader(nil)); !errors.Is(err, ErrUploadOffset) {
+		t.Errorf("ResumeObject with nothing staged = %v, want ErrUploadOffset", err)
+	}
+}
+
+func TestObjectVersion(t *testing.T) {
+	cache, err := Open("/cache", WithFs(afero.NewMemMapFs()), WithDedup())
+	assertNoError(t, err, "Open")
+	key := cache.Key().String("target", "app").Build()
+	commit := func(out string) {
+		t.Helper()
+		assertNoError(t, afero.WriteFile(cache.fs, "/src/app", []byte(out), 0o644), "writing source")
+		assertNoError(t, cache.Put(key).File("app", "/src/app").Bytes("schema", []byte("schema v1")).Commit(), "Commit")
+	}
+	versions := func() (file, blob string) {
+		t.Helper()
+		m, err := cache.loadManifest(key.Hash())
+		assertNoError(t, err, "loadManifest")
+		file, err = cache.ObjectVersion(key.Hash(), filepath.Base(m.OutputFiles["app"]))
+		assertNoError(t, err, "ObjectVersion of a file")
+		blob, err = cache.ObjectVersion(key.Hash(), filepath.Base(m.OutputData["schema"]))
+		assertNoError(t, err, "ObjectVersion of a blob")
+		return file, blob
+	}
+
+	commit("v1")
+	file1, blob1 := versions()
+	commit("v1")
+	file2, blob2 := versions()
+	assertEqual(t, file2, file1, "file version after an identical commit")
+	commit("v2")
+	file3, blob3 := versions()
+	if file3 == file1 {
+		t.Error("Expected a new file version after its content changed")
+	}
+	if blob1 != blob2 || blob2 != blob3 {
+		t.Errorf("blob versions %s, %s, %s; want the digest of the unchanged blob", blob1, blob2, blob3)
+	}
+
+	if _, err := cache.ObjectVersion(key.Hash(), "data.missing.dat"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("ObjectVersion of a missing object = %v, want ErrCacheMiss", err)
+	}
+}
@@ -0,0 +1,109 @@
+package granular
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// reportAgeLimits are the upper bounds of the age buckets in a UsageReport.
+// Entries older than the last limit fall into a final, open-ended bucket.
+var reportAgeLimits = []time.Duration{
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// neverAccessedSlack is how long after its commit an entry's access time
+// may be and still count as never accessed. Commit reads the clock
+// separately for CreatedAt and AccessedAt, so they rarely match exactly.
+const neverAccessedSlack = time.Second
+
+// AgeBucket counts the entries whose age falls in one range of a
+// UsageReport's histogram.
+type AgeBucket struct {
+	MaxAge  time.Duration // Exclusive upper bound of the range; 0 for the last, open-ended bucket
+	Entries int           // Number of entries in the range
+	Size    int64         // Total object size of those entries (Entry.Size)
+}
+
+// UsageReport summarizes how old, how large, and how used the entries of a
+// cache are. It is returned by Report.
+type UsageReport struct {
+	Entries int   // Number of entries
+	Size    int64 // Total object size of all entries (Entry.Size)
+
+	// Ages is a histogram of entries by age since creation: under an hour,
+	// a day, a week, 30 days, and older.
+	Ages []AgeBucket
+
+	// Largest lists the largest entries, largest first.
+	Largest []Entry
+
+	// NeverAccessed lists the entries that have not been read since they
+	// were committed, oldest first. Reads within a second of the commit, or
+	// with WithAccessTracking within the debounce interval, are not recorded
+	// and do not count.
+	NeverAccessed []Entry
+}
+
+// Report summarizes the entries of the cache (or of this namespace) for
+// sizing and eviction decisions: an age histogram, the largest entries,
+// and the entries that were never read. largest is the number of entries
+// listed in UsageReport.Largest; if it is not positive, none are listed.
+//
+// Report walks every manifest (or uses the index enabled by WithIndex) and
+// holds the read lock while doing so.
+//
+// Example:
+//
+//	report, err := cache.Report(10)
+//	if err != nil {
+//		return err
+//	}
+//	for _, b := range report.Ages {
+//		fmt.Printf("< %v: %d entries, %d bytes\n", b.MaxAge, b.Entries, b.Size)
+//	}
+//	fmt.Printf("%d entries never read\n", len(report.NeverAccessed))
+func (c *Cache) Report(largest int) (*UsageReport, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UsageReport{Entries: len(entries), Ages: make([]AgeBucket, len(reportAgeLimits)+1)}
+	for i, limit := range reportAgeLimits {
+		report.Ages[i].MaxAge = limit
+	}
+
+	now := c.now()
+	for _, entry := range entries {
+		report.Size += entry.Size
+
+		age := now.Sub(entry.CreatedAt)
+		i := 0
+		for i < len(reportAgeLimits) && age >= reportAgeLimits[i] {
+			i++
+		}
+		report.Ages[i].Entries++
+		report.Ages[i].Size += entry.Size
+
+		if entry.AccessedAt.Sub(entry.CreatedAt) < neverAccessedSlack {
+			report.NeverAccessed = append(report.NeverAccessed, entry)
+		}
+	}
+
+	slices.SortFunc(report.NeverAccessed, func(a, b Entry) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.KeyHash, b.KeyHash))
+	})
+
+	if largest > 0 {
+		slices.SortFunc(entries, func(a, b Entry) int {
+			return cmp.Or(cmp.Compare(b.Size, a.Size), cmp.Compare(a.KeyHash, b.KeyHash))
+		})
+		report.Largest = entries[:min(largest, len(entries))]
+	}
+
+	return report, nil
+}
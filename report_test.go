@@ -0,0 +1,67 @@
+package granular
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	cache, advance := setupClockCache(t)
+
+	old := putSized(t, cache, "old", 10)
+	advance(2 * time.Hour)
+	read := putSized(t, cache, "read", 30)
+	advance(10 * 24 * time.Hour)
+	fresh := putSized(t, cache, "fresh", 20)
+	advance(time.Minute)
+	if _, err := cache.Get(read); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	report, err := cache.Report(2)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if report.Entries != 3 || report.Size != 60 {
+		t.Errorf("Report = %d entries / %d bytes, want 3 / 60", report.Entries, report.Size)
+	}
+
+	wantAges := []AgeBucket{
+		{MaxAge: time.Hour, Entries: 1, Size: 20},
+		{MaxAge: 24 * time.Hour},
+		{MaxAge: 7 * 24 * time.Hour},
+		{MaxAge: 30 * 24 * time.Hour, Entries: 2, Size: 40},
+		{},
+	}
+	if len(report.Ages) != len(wantAges) {
+		t.Fatalf("Ages has %d buckets, want %d", len(report.Ages), len(wantAges))
+	}
+	for i, want := range wantAges {
+		if report.Ages[i] != want {
+			t.Errorf("Ages[%d] = %+v, want %+v", i, report.Ages[i], want)
+		}
+	}
+
+	hashes := func(entries []Entry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.KeyHash)
+		}
+		return out
+	}
+	if got, want := hashes(report.Largest), []string{read.Hash(), fresh.Hash()}; !slices.Equal(got, want) {
+		t.Errorf("Largest = %v, want %v", got, want)
+	}
+	if got, want := hashes(report.NeverAccessed), []string{old.Hash(), fresh.Hash()}; !slices.Equal(got, want) {
+		t.Errorf("NeverAccessed = %v, want %v", got, want)
+	}
+
+	none, err := cache.Report(0)
+	if err != nil {
+		t.Fatalf("Report(0) failed: %v", err)
+	}
+	if len(none.Largest) != 0 {
+		t.Errorf("Report(0) listed %d largest entries", len(none.Largest))
+	}
+}
@@ -2,12 +2,16 @@ package granular
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"iter"
 	"maps"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/spf13/afero"
@@ -24,13 +28,21 @@ type Result struct {
 	dataPaths   map[string]string // name -> path to .dat file (lazy loading)
 	dataCache   map[string][]byte // lazy-loaded cache for data bytes
 	metadata    map[string]string // metadata key-value pairs
+	tags        map[string]string // tag key-value pairs
+	description string            // set with WriteBuilder.Describe
+	inputs      []string          // descriptions of the key inputs, in key order
+	extras      map[string]string // key components added with String, Version, and Env
 	compression CompressionType   // compression used for stored data
 	createdAt   time.Time
 	accessedAt  time.Time
+	refreshAt   time.Time // soft TTL deadline; zero if none
+	generation  int       // 0 for the current entry, else its generation in the history (see WithHistory)
 }
 
 // File returns the path to a cached file by name.
 // Returns empty string if the file doesn't exist.
+// The path always lies inside the entry's object directory: manifests whose
+// names or paths escape it are treated as corrupted by Get.
 func (r *Result) File(name string) string {
 	return r.files[name]
 }
@@ -49,6 +61,9 @@ func (r *Result) HasFile(name string) bool {
 // CopyFile copies a cached file to the destination path, decompressing if needed.
 // Returns an error if the file doesn't exist or the copy fails.
 func (r *Result) CopyFile(name, dst string) error {
+	if r.cache.recordLatency {
+		defer r.cache.observeLatency(&r.cache.counters.restoreLatency, r.cache.now())
+	}
 	src := r.files[name]
 	if src == "" {
 		return fmt.Errorf("file %s not found in cache", name)
@@ -86,19 +101,21 @@ func (r *Result) CopyFile(name, dst string) error {
 	// Limit decompressed output to prevent decompression bombs (zstd/gzip bombs).
 	// Same maxDataSize used by readCompressedFile for Bytes().
 	// Allow exactly maxSize bytes; error if decompressed output exceeds it.
-	maxSize := r.cache.effectiveMaxDataSize()
-	limited := &limitedReader{r: reader, remaining: maxSize + 1}
+	limited := &limitedReader{r: reader, remaining: r.cache.effectiveMaxDataSize()}
 
-	bufPtr := bufferPool.Get().(*[]byte)
+	buffers := r.cache.buffers()
+	bufPtr := buffers.Get()
 	buffer := *bufPtr
-	defer bufferPool.Put(bufPtr)
+	defer buffers.Put(bufPtr)
 
-	_, copyErr := io.CopyBuffer(dstFile, limited, buffer)
+	tracked, done := r.cache.trackProgress(ProgressOpCopy, dst, limited)
+	_, copyErr := io.CopyBuffer(dstFile, tracked, buffer)
 	closeErr := dstFile.Close()
 	if err := errors.Join(copyErr, closeErr); err != nil {
 		_ = r.cache.fs.Remove(tmpPath)
 		return fmt.Errorf("failed to copy file: %w", err)
 	}
+	done()
 
 	if err := r.cache.fs.Rename(tmpPath, dst); err != nil {
 		_ = r.cache.fs.Remove(tmpPath)
@@ -111,7 +128,7 @@ func (r *Result) CopyFile(name, dst string) error {
 // Bytes returns byte data by name.
 // Returns nil if the data doesn't exist or if there's a read/decompression error.
 // Data is lazy-loaded from disk on first access and decompressed if needed.
-// Use BytesErr for explicit error handling.
+// Use BytesErr for explicit error handling, and Open to stream large data.
 func (r *Result) Bytes(name string) []byte {
 	data, _ := r.BytesErr(name)
 	return data
@@ -150,9 +167,55 @@ func (r *Result) BytesErr(name string) ([]byte, error) {
 	return data, nil
 }
 
+// Open returns a reader streaming the data stored under name, decompressed
+// if needed. Unlike Bytes, it never holds the whole blob in memory, so it is
+// the way to read large data such as test logs. The caller must close the
+// reader. The decompressed size is limited like Bytes (see WithMaxDataSize).
+//
+// Returns an error wrapping fs.ErrNotExist if the entry has no data under
+// name.
+func (r *Result) Open(name string) (io.ReadCloser, error) {
+	if data, ok := r.dataCache[name]; ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	path, ok := r.dataPaths[name]
+	if !ok {
+		return nil, fmt.Errorf("data %s not found in cache: %w", name, fs.ErrNotExist)
+	}
+
+	file, err := r.cache.fs.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cached data %s: %w", name, err)
+	}
+	reader, err := decompressReader(file, r.compression)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to create decompressor: %w", err)
+	}
+	return &dataReader{
+		Reader: &limitedReader{r: reader, remaining: r.cache.effectiveMaxDataSize()},
+		dec:    reader,
+		file:   file,
+	}, nil
+}
+
+// dataReader streams decompressed data and closes the decompressor and the
+// underlying file together.
+type dataReader struct {
+	io.Reader
+	dec  io.Closer
+	file io.Closer
+}
+
+func (d *dataReader) Close() error {
+	return errors.Join(d.dec.Close(), d.file.Close())
+}
+
 // limitedReader wraps a reader and returns an error when the limit is exceeded.
 // Unlike io.LimitReader (which returns EOF), this returns a descriptive error
-// to distinguish a normal complete read from a decompression bomb.
+// to distinguish a normal complete read from a decompression bomb. It never
+// returns more than remaining bytes: once they are read, it reads one more
+// byte from r to tell the end of the data from an overflow.
 type limitedReader struct {
 	r         io.Reader
 	remaining int64
@@ -160,6 +223,10 @@ type limitedReader struct {
 
 func (lr *limitedReader) Read(p []byte) (int, error) {
 	if lr.remaining <= 0 {
+		var probe [1]byte
+		if _, err := io.ReadAtLeast(lr.r, probe[:], 1); err != nil {
+			return 0, err
+		}
 		return 0, fmt.Errorf("decompressed output exceeds max size limit")
 	}
 	if int64(len(p)) > lr.remaining {
@@ -293,6 +360,127 @@ func (r *Result) HasMeta(key string) bool {
 	return ok
 }
 
+// meta returns the metadata value under key, or an error if it is not set.
+func (r *Result) meta(key string) (string, error) {
+	value, ok := r.metadata[key]
+	if !ok {
+		return "", fmt.Errorf("metadata %q is not set", key)
+	}
+	return value, nil
+}
+
+// MetaInt returns metadata set with WriteBuilder.MetaInt. It returns an
+// error if the key is not set or its value is not an integer.
+func (r *Result) MetaInt(key string) (int64, error) {
+	value, err := r.meta(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("metadata %q: %w", key, err)
+	}
+	return n, nil
+}
+
+// MetaBool returns metadata set with WriteBuilder.MetaBool. It returns an
+// error if the key is not set or its value is not a boolean.
+func (r *Result) MetaBool(key string) (bool, error) {
+	value, err := r.meta(key)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("metadata %q: %w", key, err)
+	}
+	return b, nil
+}
+
+// MetaTime returns metadata set with WriteBuilder.MetaTime. It returns an
+// error if the key is not set or its value is not an RFC 3339 time.
+func (r *Result) MetaTime(key string) (time.Time, error) {
+	value, err := r.meta(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("metadata %q: %w", key, err)
+	}
+	return t, nil
+}
+
+// MetaDuration returns metadata set with WriteBuilder.MetaDuration. It
+// returns an error if the key is not set or its value is not a duration.
+func (r *Result) MetaDuration(key string) (time.Duration, error) {
+	value, err := r.meta(key)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("metadata %q: %w", key, err)
+	}
+	return d, nil
+}
+
+// MetaJSON decodes metadata set with WriteBuilder.MetaJSON into out, as
+// json.Unmarshal does. It returns an error if the key is not set or its
+// value cannot be decoded into out.
+//
+// Example:
+//
+//	var tc Toolchain
+//	if err := result.MetaJSON("toolchain", &tc); err != nil {
+//		return err
+//	}
+func (r *Result) MetaJSON(key string, out any) error {
+	value, err := r.meta(key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(value), out); err != nil {
+		return fmt.Errorf("metadata %q: %w", key, err)
+	}
+	return nil
+}
+
+// Tag returns the value of a tag by key.
+// Returns empty string if the tag doesn't exist.
+func (r *Result) Tag(key string) string {
+	return r.tags[key]
+}
+
+// Description returns the description set with WriteBuilder.Describe, or
+// the empty string if there is none.
+func (r *Result) Description() string {
+	return r.description
+}
+
+// Inputs returns the descriptions of the inputs of the key the entry was
+// stored under, in key order (e.g. "file:go.sum", "glob:src/**/*.go"), as
+// recorded in its manifest. Together with Extras, they tell what an
+// artifact was built from without reading the manifest.
+//
+// Example:
+//
+//	log.Printf("artifact %s built from %v %v", result.KeyHash(), result.Inputs(), result.Extras())
+func (r *Result) Inputs() []string {
+	return slices.Clone(r.inputs)
+}
+
+// Extras returns the components added to the key the entry was stored under
+// with String, Version, and Env.
+func (r *Result) Extras() map[string]string {
+	return maps.Clone(r.extras)
+}
+
+// Tags returns all tags as a map.
+func (r *Result) Tags() map[string]string {
+	return maps.Clone(r.tags)
+}
+
 // Age returns how long ago this result was created.
 func (r *Result) Age() time.Duration {
 	return r.cache.now().Sub(r.createdAt)
@@ -308,8 +496,9 @@ func (r *Result) AccessedAt() time.Time {
 	return r.accessedAt
 }
 
-// Size returns the total size of all cached files and data in bytes.
-// Returns 0 if unable to determine size.
+// Size returns the size of the entry on disk in bytes: its cached files,
+// its data as stored (compressed), and its manifest. Files that cannot be
+// statted are not counted.
 func (r *Result) Size() int64 {
 	var total int64
 	for path := range maps.Values(r.files) {
@@ -324,6 +513,11 @@ func (r *Result) Size() int64 {
 			total += info.Size()
 		}
 	}
+	if mPath, err := r.manifestPath(); err == nil {
+		if info, err := r.cache.fs.Stat(mPath); err == nil {
+			total += info.Size()
+		}
+	}
 	return total
 }
 
@@ -338,7 +532,7 @@ func (r *Result) KeyHash() string {
 // This is a point-in-time check — the entry could be deleted immediately after
 // Valid returns true.
 func (r *Result) Valid() bool {
-	mPath, err := r.cache.manifestPath(r.keyHash)
+	mPath, err := r.manifestPath()
 	if err != nil {
 		return false
 	}
@@ -346,6 +540,19 @@ func (r *Result) Valid() bool {
 	return err == nil && exists
 }
 
+// manifestPath returns the path of the manifest describing r: the entry's,
+// or for a previous result, its generation's.
+func (r *Result) manifestPath() (string, error) {
+	if r.generation == 0 {
+		return r.cache.manifestPath(r.keyHash)
+	}
+	dir, err := r.cache.generationPath(r.keyHash, r.generation)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyManifest), nil
+}
+
 // DataNames returns an iterator over the names of all data entries in the result.
 // Use BytesErr to load the actual data for a given name.
 func (r *Result) DataNames() iter.Seq[string] {
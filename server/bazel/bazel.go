@@ -0,0 +1,162 @@
+// Package bazel serves a granular cache to Bazel and other clients of the
+// Bazel remote caching HTTP protocol.
+//
+// A Handler implements the action cache and content-addressable store
+// endpoints used by bazel --remote_cache=http://...:
+//
+//	GET, HEAD, PUT  /ac/{sha256}    action results
+//	GET, HEAD, PUT  /cas/{sha256}   blobs, keyed by the SHA-256 of their content
+//
+// Blobs are stored as ordinary granular entries tagged bazel=ac or bazel=cas,
+// so they are listed, pruned, and size-limited with the rest of the cache.
+// CAS uploads are rejected unless their content matches the digest in the
+// URL. Action results are stored as opaque bytes.
+//
+// Bazel instance names map to URL prefixes; strip them with http.StripPrefix.
+// The gRPC protocol is not supported.
+package bazel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gophersatwork/granular"
+)
+
+// maxBlobSize bounds the size of an uploaded blob. Blobs are streamed in
+// both directions and never held in memory.
+const maxBlobSize = 1 << 30
+
+// errDigestMismatch reports a CAS upload whose content does not match the
+// digest in its URL.
+var errDigestMismatch = errors.New("blob content does not match its digest")
+
+// emptyDigest is the SHA-256 of the empty blob, which Bazel expects every
+// CAS to contain.
+const emptyDigest = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Handler is an http.Handler speaking the Bazel remote caching HTTP protocol.
+type Handler struct {
+	cache *granular.Cache
+	mux   *http.ServeMux
+}
+
+// New returns a Handler storing blobs in cache.
+//
+// Example:
+//
+//	cache, _ := granular.Open("/var/cache/bazel")
+//	log.Fatal(http.ListenAndServe(":8080", bazel.New(cache)))
+func New(cache *granular.Cache) *Handler {
+	h := &Handler{cache: cache, mux: http.NewServeMux()}
+	for _, kind := range []string{"ac", "cas"} {
+		h.mux.HandleFunc("GET /"+kind+"/{digest}", func(w http.ResponseWriter, r *http.Request) {
+			h.get(w, r, kind, r.PathValue("digest"))
+		})
+		h.mux.HandleFunc("PUT /"+kind+"/{digest}", func(w http.ResponseWriter, r *http.Request) {
+			h.put(w, r, kind, r.PathValue("digest"))
+		})
+	}
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// key returns the cache key of a blob.
+func (h *Handler) key(kind, digest string) granular.Key {
+	return h.cache.Key().String("bazel", kind).String("digest", digest).Build()
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, kind, digest string) {
+	if !validDigest(digest) {
+		http.Error(w, fmt.Sprintf("invalid digest %q", digest), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if kind == "cas" && digest == emptyDigest {
+		w.Header().Set("Content-Length", "0")
+		return
+	}
+	result, err := h.cache.Get(h.key(kind, digest))
+	if errors.Is(err, granular.ErrCacheMiss) {
+		http.NotFound(w, r)
+		return
+	}
+	// Blobs are read through the result rather than served as stored
+	// objects, which are compressed if the cache compresses data
+	var blob io.ReadCloser
+	if err == nil {
+		blob, err = result.Open("blob")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = blob.Close() }()
+	if r.Method != http.MethodHead {
+		_, _ = io.Copy(w, blob)
+	}
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, kind, digest string) {
+	if !validDigest(digest) {
+		http.Error(w, fmt.Sprintf("invalid digest %q", digest), http.StatusBadRequest)
+		return
+	}
+	body := &blobReader{r: http.MaxBytesReader(w, r.Body, maxBlobSize)}
+	if kind == "cas" {
+		body.hash, body.digest = sha256.New(), digest
+	}
+	err := h.cache.Put(h.key(kind, digest)).BytesFrom("blob", body).Tag("bazel", kind).Commit()
+	switch _, tooLarge := errors.AsType[*http.MaxBytesError](body.err); {
+	case tooLarge:
+		http.Error(w, fmt.Sprintf("failed to read blob: %v", body.err), http.StatusRequestEntityTooLarge)
+	case errors.Is(body.err, errDigestMismatch):
+		http.Error(w, body.err.Error(), http.StatusBadRequest)
+	case body.err != nil:
+		http.Error(w, fmt.Sprintf("failed to read blob: %v", body.err), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// blobReader reads an uploaded blob and remembers the error that ended the
+// upload, if any. With a hash, it fails with errDigestMismatch at the end of
+// a blob whose content does not match digest, so the blob is never stored.
+type blobReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	digest string
+	err    error
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if b.hash != nil {
+		b.hash.Write(p[:n])
+		if err == io.EOF && hex.EncodeToString(b.hash.Sum(nil)) != b.digest {
+			err = errDigestMismatch
+		}
+	}
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// validDigest reports whether digest is a lowercase hex SHA-256 digest.
+func validDigest(digest string) bool {
+	return len(digest) == sha256.Size*2 && strings.Trim(digest, "0123456789abcdef") == ""
+}
@@ -0,0 +1,107 @@
+package bazel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+)
+
+func digestOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// do sends a request and returns the status code and body.
+func do(t *testing.T, ts *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestBazelProtocol(t *testing.T) {
+	cache := granular.OpenTemp()
+	ts := httptest.NewServer(New(cache))
+	defer ts.Close()
+
+	blob := "compiled output"
+	cas := "/cas/" + digestOf(blob)
+	if code, _ := do(t, ts, http.MethodGet, cas, ""); code != http.StatusNotFound {
+		t.Errorf("GET missing blob: status %d, want 404", code)
+	}
+	if code, _ := do(t, ts, http.MethodPut, cas, blob); code != http.StatusOK {
+		t.Fatalf("PUT blob: status %d", code)
+	}
+	if code, body := do(t, ts, http.MethodGet, cas, ""); code != http.StatusOK || body != blob {
+		t.Errorf("GET blob = %d %q, want %q", code, body, blob)
+	}
+	if code, body := do(t, ts, http.MethodHead, cas, ""); code != http.StatusOK || body != "" {
+		t.Errorf("HEAD blob = %d %q", code, body)
+	}
+
+	// Action results are opaque and keyed by the action digest
+	ac := "/ac/" + digestOf("action")
+	if code, _ := do(t, ts, http.MethodPut, ac, "action result"); code != http.StatusOK {
+		t.Fatalf("PUT action result: status %d", code)
+	}
+	if code, body := do(t, ts, http.MethodGet, ac, ""); code != http.StatusOK || body != "action result" {
+		t.Errorf("GET action result = %d %q", code, body)
+	}
+
+	if code, _ := do(t, ts, http.MethodGet, "/cas/"+digestOf(""), ""); code != http.StatusOK {
+		t.Errorf("GET empty blob: status %d, want 200", code)
+	}
+
+	entries, err := cache.EntriesByTag("bazel", "cas")
+	if err != nil || len(entries) != 1 {
+		t.Errorf("EntriesByTag(bazel, cas) = %d entries, %v; want 1", len(entries), err)
+	}
+}
+
+func TestBazelRejectsBadUploads(t *testing.T) {
+	ts := httptest.NewServer(New(granular.OpenTemp()))
+	defer ts.Close()
+
+	if code, _ := do(t, ts, http.MethodPut, "/cas/"+digestOf("expected"), "actual"); code != http.StatusBadRequest {
+		t.Errorf("PUT blob with wrong digest: status %d, want 400", code)
+	}
+	if code, _ := do(t, ts, http.MethodGet, "/cas/not-a-digest", ""); code != http.StatusBadRequest {
+		t.Errorf("GET invalid digest: status %d, want 400", code)
+	}
+	if code, _ := do(t, ts, http.MethodGet, "/cas/"+digestOf("expected"), ""); code != http.StatusNotFound {
+		t.Errorf("GET rejected blob: status %d, want 404", code)
+	}
+}
+
+func TestBazelCompressedCache(t *testing.T) {
+	cache, err := granular.Open(t.TempDir(), granular.WithCompression(granular.CompressionZstd))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	ts := httptest.NewServer(New(cache))
+	defer ts.Close()
+
+	// Blobs are stored compressed and served as uploaded
+	blob := strings.Repeat("compiled output ", 1<<12)
+	cas := "/cas/" + digestOf(blob)
+	if code, _ := do(t, ts, http.MethodPut, cas, blob); code != http.StatusOK {
+		t.Fatalf("PUT blob: status %d", code)
+	}
+	if code, body := do(t, ts, http.MethodGet, cas, ""); code != http.StatusOK || body != blob {
+		t.Errorf("GET blob = %d, %d bytes; want %d bytes", code, len(body), len(blob))
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/internal/format"
+)
+
+// Client copies entries between a local cache and a Server.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a Client for the server at baseURL (for example
+// "http://cache.internal:8080"). If httpClient is nil, http.DefaultClient is
+// used.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), http: httpClient}
+}
+
+// Pull downloads the entry with the given key hash into cache. It returns
+// granular.ErrCacheMiss if the server does not have the entry.
+//
+// Example:
+//
+//	key := cache.Key().Glob("*.go").Build()
+//	if err := client.Pull(ctx, cache, key.Hash()); err == nil {
+//		result, _ := cache.Get(key)
+//		...
+//	}
+func (cl *Client) Pull(ctx context.Context, cache *granular.Cache, keyHash string) error {
+	resp, err := cl.do(ctx, http.MethodGet, cl.manifestURL(keyHash), nil)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	_ = resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read manifest %s: %w", keyHash, err)
+	}
+
+	objects, err := objectNames(data)
+	if err != nil {
+		return fmt.Errorf("%w: manifest %s: %v", granular.ErrCacheCorrupted, keyHash, err)
+	}
+	for _, object := range objects {
+		if err := cl.resume(ctx, cache.StagedSize, keyHash, object, func(offset int64) error {
+			return cl.getObject(ctx, cache, keyHash, object, offset)
+		}); err != nil {
+			return err
+		}
+	}
+	return cache.ImportManifest(keyHash, data)
+}
+
+// Push uploads the entry with the given key hash from cache to the server.
+// It returns granular.ErrCacheMiss if cache does not have the entry.
+func (cl *Client) Push(ctx context.Context, cache *granular.Cache, keyHash string) error {
+	data, err := cache.ExportManifest(keyHash)
+	if err != nil {
+		return err
+	}
+	objects, err := objectNames(data)
+	if err != nil {
+		return err
+	}
+	uploaded := func(keyHash, object string) (int64, error) {
+		return cl.uploadOffset(ctx, keyHash, object)
+	}
+	for _, object := range objects {
+		if err := cl.resume(ctx, uploaded, keyHash, object, func(offset int64) error {
+			return cl.putObject(ctx, cache, keyHash, object, offset)
+		}); err != nil {
+			return err
+		}
+	}
+
+	resp, err := cl.do(ctx, http.MethodPut, cl.manifestURL(keyHash), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Has reports whether the server has the entry with the given key hash.
+func (cl *Client) Has(ctx context.Context, keyHash string) (bool, error) {
+	resp, err := cl.do(ctx, http.MethodHead, cl.manifestURL(keyHash), nil)
+	if errors.Is(err, granular.ErrCacheMiss) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, resp.Body.Close()
+}
+
+// maxStalledResumes is how many times in a row an interrupted object
+// transfer is resumed without getting further before the transfer fails.
+const maxStalledResumes = 3
+
+// resume calls transfer from offset 0, or from the bytes already
+// transferred as reported by transferred, until it succeeds. It resumes as
+// long as transfers are interrupted by the connection and keep making
+// progress, giving up after maxStalledResumes attempts without progress.
+func (cl *Client) resume(ctx context.Context, transferred func(keyHash, object string) (int64, error), keyHash, object string, transfer func(offset int64) error) error {
+	offset, err := transferred(keyHash, object)
+	if err != nil {
+		return err
+	}
+	for stalled := 0; ; {
+		err := transfer(offset)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if _, ok := errors.AsType[*interruptedError](err); !ok {
+			return err
+		}
+		next, offsetErr := transferred(keyHash, object)
+		if offsetErr != nil {
+			return err
+		}
+		if next > offset {
+			stalled = 0
+		} else if stalled++; stalled >= maxStalledResumes {
+			return err
+		}
+		offset = next
+	}
+}
+
+// getObject downloads an object into the staging area of cache, resuming
+// after the first offset bytes.
+func (cl *Client) getObject(ctx context.Context, cache *granular.Cache, keyHash, object string, offset int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cl.objectURL(keyHash, object), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		// Ask for the last staged byte again: a range starting at the end of
+		// a complete object would not be satisfiable
+		offset--
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := cl.send(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusPartialContent {
+		offset = 0 // the server sent the whole object
+	}
+	return cache.ResumeObject(keyHash, object, offset, connReader{resp.Body})
+}
+
+// putObject uploads an object from cache, resuming after the first offset
+// bytes.
+func (cl *Client) putObject(ctx context.Context, cache *granular.Cache, keyHash, object string, offset int64) error {
+	f, err := cache.OpenObject(keyHash, object)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, cl.objectURL(keyHash, object), f)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	}
+	resp, err := cl.send(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadOffset returns how many bytes of an object the server has received.
+// Servers that do not support resuming uploads report 0.
+func (cl *Client) uploadOffset(ctx context.Context, keyHash, object string) (int64, error) {
+	resp, err := cl.do(ctx, http.MethodHead, cl.uploadURL(keyHash, object), nil)
+	if errors.Is(err, granular.ErrCacheMiss) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	offset, err := strconv.ParseInt(resp.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid %s %q", uploadOffsetHeader, resp.Header.Get(uploadOffsetHeader))
+	}
+	return offset, nil
+}
+
+// do sends a request and returns the response if it succeeded. Error
+// statuses are turned into errors; 404 becomes granular.ErrCacheMiss.
+func (cl *Client) do(ctx context.Context, method, target string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	return cl.send(req)
+}
+
+// send is like do for a prepared request. Errors of the connection are
+// returned as *interruptedError.
+func (cl *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := cl.http.Do(req)
+	if err != nil {
+		return nil, &interruptedError{err}
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, granular.ErrCacheMiss
+	}
+	return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// interruptedError is an error of the connection to the server, as opposed
+// to an error reported by the server or the cache. Transfers interrupted by
+// one are resumed.
+type interruptedError struct{ err error }
+
+func (e *interruptedError) Error() string { return e.err.Error() }
+func (e *interruptedError) Unwrap() error { return e.err }
+
+// connReader reports errors reading a response body as *interruptedError.
+type connReader struct{ r io.Reader }
+
+func (c connReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &interruptedError{err}
+	}
+	return n, err
+}
+
+func (cl *Client) manifestURL(keyHash string) string {
+	return cl.baseURL + "/v1/manifests/" + url.PathEscape(keyHash)
+}
+
+func (cl *Client) objectURL(keyHash, object string) string {
+	return cl.baseURL + "/v1/objects/" + url.PathEscape(keyHash) + "/" + url.PathEscape(object)
+}
+
+func (cl *Client) uploadURL(keyHash, object string) string {
+	return cl.baseURL + "/v1/uploads/" + url.PathEscape(keyHash) + "/" + url.PathEscape(object)
+}
+
+// objectNames returns the names of the objects listed in a portable manifest.
+func objectNames(data []byte) ([]string, error) {
+	m, err := format.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	objects := slices.Concat(slices.Collect(maps.Values(m.OutputFiles)), slices.Collect(maps.Values(m.OutputData)))
+	slices.Sort(objects)
+	return slices.Compact(objects), nil // Names may share a blob
+}
@@ -0,0 +1,186 @@
+// Package server shares a granular cache over HTTP.
+//
+// A Server exposes the entries of a cache at URLs addressed by key hash:
+//
+//	GET, HEAD  /v1/manifests/{keyHash}          portable manifest (JSON)
+//	PUT        /v1/manifests/{keyHash}          publish an uploaded entry
+//	GET, HEAD  /v1/objects/{keyHash}/{object}   object bytes, as stored
+//	PUT        /v1/objects/{keyHash}/{object}   upload an object
+//	GET, HEAD  /v1/uploads/{keyHash}/{object}   bytes of an object uploaded so far
+//
+// Objects are streamed in both directions, and GET supports range requests
+// and conditional requests, so a CDN or caching proxy can front the server.
+// Objects carry an ETag that changes with their content (see
+// granular.Cache.ObjectVersion), and manifests carry one of their own. Both
+// must be revalidated on every request: a key hash addresses the inputs of
+// an entry, not its outputs, and entries can be replaced or removed. A proxy
+// revalidating an unchanged object gets a 304 without the bytes.
+// An entry is uploaded by putting each of its objects and then its manifest;
+// the server verifies the objects against the manifest before the entry
+// becomes visible. Client implements this protocol on top of a local cache.
+//
+// An interrupted object upload keeps the bytes that arrived. The uploads
+// endpoint reports their number in the Upload-Offset header, and a PUT with
+// an Upload-Offset header appends to them from that offset, so large
+// objects resume instead of being sent again from the start.
+//
+// The server performs no authentication. Put it behind a proxy that does, or
+// only listen on trusted networks.
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gophersatwork/granular"
+)
+
+// maxManifestSize bounds the size of an uploaded manifest.
+const maxManifestSize = 16 << 20
+
+// objectCacheControl and manifestCacheControl let caches in front of the
+// server keep objects and manifests, but make them revalidate both on every
+// request, since the URLs are not content-addressed.
+const (
+	objectCacheControl   = "no-cache"
+	manifestCacheControl = "no-cache"
+)
+
+// uploadOffsetHeader carries the number of bytes of an object already
+// uploaded.
+const uploadOffsetHeader = "Upload-Offset"
+
+// Server is an http.Handler serving a granular cache.
+type Server struct {
+	cache *granular.Cache
+	mux   *http.ServeMux
+}
+
+// New returns a Server for the given cache.
+//
+// Example:
+//
+//	cache, _ := granular.Open(".cache")
+//	log.Fatal(http.ListenAndServe(":8080", server.New(cache)))
+func New(cache *granular.Cache) *Server {
+	s := &Server{cache: cache, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /v1/manifests/{keyHash}", s.getManifest)
+	s.mux.HandleFunc("PUT /v1/manifests/{keyHash}", s.putManifest)
+	s.mux.HandleFunc("GET /v1/objects/{keyHash}/{object}", s.getObject)
+	s.mux.HandleFunc("PUT /v1/objects/{keyHash}/{object}", s.putObject)
+	s.mux.HandleFunc("GET /v1/uploads/{keyHash}/{object}", s.getUpload)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) getManifest(w http.ResponseWriter, r *http.Request) {
+	data, err := s.cache.ExportManifest(r.PathValue("keyHash"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.Quote(hex.EncodeToString(sum[:])))
+	w.Header().Set("Cache-Control", manifestCacheControl)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *Server) putManifest(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxManifestSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read manifest: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.cache.ImportManifest(r.PathValue("keyHash"), data); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
+	keyHash, object := r.PathValue("keyHash"), r.PathValue("object")
+	version, err := s.cache.ObjectVersion(keyHash, object)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, err := s.cache.OpenObject(keyHash, object)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// If the entry was replaced while the object was opened, the bytes may
+	// not match the version: serve them, but not for caching
+	if now, err := s.cache.ObjectVersion(keyHash, object); err == nil && now == version {
+		w.Header().Set("ETag", strconv.Quote(version))
+		w.Header().Set("Cache-Control", objectCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	// ServeContent answers If-None-Match, If-Range, and Range from the ETag
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request) {
+	var offset int64
+	if h := r.Header.Get(uploadOffsetHeader); h != "" {
+		var err error
+		if offset, err = strconv.ParseInt(h, 10, 64); err != nil || offset < 0 {
+			http.Error(w, fmt.Sprintf("invalid %s %q", uploadOffsetHeader, h), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := s.cache.ResumeObject(r.PathValue("keyHash"), r.PathValue("object"), offset, r.Body); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) getUpload(w http.ResponseWriter, r *http.Request) {
+	offset, err := s.cache.StagedSize(r.PathValue("keyHash"), r.PathValue("object"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError maps a cache error to an HTTP status.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, granular.ErrCacheMiss):
+		code = http.StatusNotFound
+	case errors.Is(err, granular.ErrInvalidKeyHash), errors.Is(err, granular.ErrInvalidObjectName):
+		code = http.StatusBadRequest
+	case errors.Is(err, granular.ErrHashAlgoMismatch), errors.Is(err, granular.ErrCompressionMismatch),
+		errors.Is(err, granular.ErrUploadOffset):
+		code = http.StatusConflict
+	case errors.Is(err, granular.ErrCacheCorrupted):
+		code = http.StatusUnprocessableEntity
+	}
+	http.Error(w, err.Error(), code)
+}
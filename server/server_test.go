@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gophersatwork/granular"
+)
+
+// setupServer starts a server for a fresh in-memory cache and returns the
+// cache and a client for it.
+func setupServer(t *testing.T) (*granular.Cache, *Client, *httptest.Server) {
+	t.Helper()
+	remote := granular.OpenTemp()
+	ts := httptest.NewServer(New(remote))
+	t.Cleanup(ts.Close)
+	return remote, NewClient(ts.URL, ts.Client()), ts
+}
+
+func TestPushPull(t *testing.T) {
+	remote, client, _ := setupServer(t)
+	ctx := context.Background()
+
+	local := granular.OpenTemp()
+	key := local.Key().String("target", "app").Build()
+	if err := local.Put(key).Bytes("out", []byte("built")).Meta("tool", "go").Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if has, err := client.Has(ctx, key.Hash()); err != nil || has {
+		t.Fatalf("Has before push = %v, %v", has, err)
+	}
+	if err := client.Push(ctx, local, key.Hash()); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if has, err := client.Has(ctx, key.Hash()); err != nil || !has {
+		t.Fatalf("Has after push = %v, %v", has, err)
+	}
+	if !remote.Has(remote.Key().String("target", "app").Build()) {
+		t.Fatal("Expected pushed entry in the server's cache")
+	}
+
+	other := granular.OpenTemp()
+	if err := client.Pull(ctx, other, key.Hash()); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	result, err := other.Get(other.Key().String("target", "app").Build())
+	if err != nil {
+		t.Fatalf("Get after pull failed: %v", err)
+	}
+	if data, err := result.BytesErr("out"); err != nil || string(data) != "built" || result.Meta("tool") != "go" {
+		t.Errorf("pulled entry = %q, %v, tool=%q", data, err, result.Meta("tool"))
+	}
+
+	if err := client.Pull(ctx, other, "00000000deadbeef"); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("Pull of missing entry = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestRejectsBadUploads(t *testing.T) {
+	remote, client, ts := setupServer(t)
+	ctx := context.Background()
+
+	local := granular.OpenTemp()
+	key := local.Key().String("target", "app").Build()
+	if err := local.Put(key).Bytes("out", []byte("built")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	manifest, err := local.ExportManifest(key.Hash())
+	if err != nil {
+		t.Fatalf("ExportManifest failed: %v", err)
+	}
+
+	put := func(path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPut, ts.URL+path, strings.NewReader(body))
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("PUT %s failed: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Objects that don't match the manifest are never published
+	if code := put("/v1/objects/"+key.Hash()+"/data.out.dat", "tampered"); code != http.StatusCreated {
+		t.Fatalf("object upload: status %d", code)
+	}
+	if code := put("/v1/manifests/"+key.Hash(), string(manifest)); code != http.StatusUnprocessableEntity {
+		t.Errorf("manifest with tampered object: status %d, want 422", code)
+	}
+	if has, _ := client.Has(ctx, key.Hash()); has {
+		t.Error("Expected tampered entry to be rejected")
+	}
+
+	for _, path := range []string{
+		"/v1/objects/..%2F..%2Fetc/data.out.dat",
+		"/v1/objects/" + key.Hash() + "/..%2Fmanifest.json",
+		"/v1/objects/" + key.Hash() + "/notes.txt",
+	} {
+		if code := put(path, "x"); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status %d, want 400", path, code)
+		}
+	}
+
+	stats, err := remote.Stats()
+	if err != nil || stats.Entries != 0 {
+		t.Errorf("server cache has %d entries (err %v), want 0", stats.Entries, err)
+	}
+}
+
+// flakyTransport cuts the body of the first request or response for an
+// object URL after limit bytes, like a network that drops the connection
+// mid-transfer, and counts the body bytes sent and received.
+type flakyTransport struct {
+	base  http.RoundTripper
+	limit int64
+
+	mu     sync.Mutex
+	cut    bool
+	copied int64
+}
+
+func (ft *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ft.mu.Lock()
+	cut := !ft.cut && strings.Contains(req.URL.Path, "/v1/objects/")
+	ft.cut = ft.cut || cut
+	ft.mu.Unlock()
+
+	if req.Body != nil {
+		req.Body = ft.body(req.Body, cut)
+	}
+	resp, err := ft.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet {
+		return resp, err
+	}
+	resp.Body = ft.body(resp.Body, cut)
+	return resp, nil
+}
+
+func (ft *flakyTransport) body(rc io.ReadCloser, cut bool) io.ReadCloser {
+	var r io.Reader = rc
+	if cut {
+		r = io.MultiReader(io.LimitReader(rc, ft.limit), iotest.ErrReader(io.ErrUnexpectedEOF))
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&countingReader{r, ft}, rc}
+}
+
+type countingReader struct {
+	r  io.Reader
+	ft *flakyTransport
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.ft.mu.Lock()
+	c.ft.copied += int64(n)
+	c.ft.mu.Unlock()
+	return n, err
+}
+
+func TestPushPull_Resume(t *testing.T) {
+	remote := granular.OpenTemp()
+	ts := httptest.NewServer(New(remote))
+	t.Cleanup(ts.Close)
+	ctx := context.Background()
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64<<10) // 1 MiB
+	local := granular.OpenTemp()
+	key := local.Key().String("target", "model").Build()
+	if err := local.Put(key).Bytes("weights", payload).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Each transfer is cut after half of the object and resumed from there,
+	// so about one object's worth of bytes crosses the wire
+	transfer := func(what string, fn func(*Client) error) {
+		t.Helper()
+		ft := &flakyTransport{base: http.DefaultTransport, limit: int64(len(payload) / 2)}
+		if err := fn(NewClient(ts.URL, &http.Client{Transport: ft})); err != nil {
+			t.Fatalf("%s failed: %v", what, err)
+		}
+		if !ft.cut {
+			t.Fatalf("%s was not interrupted", what)
+		}
+		if ft.copied >= int64(len(payload))*3/2 {
+			t.Errorf("%s copied %d bytes of a %d byte object; want it resumed, not restarted", what, ft.copied, len(payload))
+		}
+	}
+
+	transfer("Push", func(cl *Client) error { return cl.Push(ctx, local, key.Hash()) })
+	if !remote.Has(remote.Key().String("target", "model").Build()) {
+		t.Fatal("Expected pushed entry in the server's cache")
+	}
+
+	other := granular.OpenTemp()
+	transfer("Pull", func(cl *Client) error { return cl.Pull(ctx, other, key.Hash()) })
+	result, err := other.Get(other.Key().String("target", "model").Build())
+	if err != nil {
+		t.Fatalf("Get after pull failed: %v", err)
+	}
+	if got, err := result.BytesErr("weights"); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("pulled %d bytes, %v; want the payload", len(got), err)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	remote, _, ts := setupServer(t)
+	ctx := context.Background()
+	key := remote.Key().String("target", "app").Build()
+	if err := remote.Put(key).Bytes("out", []byte("0123456789")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	get := func(path string, header ...string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	object := "/v1/objects/" + key.Hash() + "/data.out.dat"
+	resp, body := get(object)
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || body != "0123456789" || etag == "" {
+		t.Fatalf("GET object: status %d, body %q, ETag %q", resp.StatusCode, body, etag)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("object Cache-Control = %q, want no-cache", cc)
+	}
+	if resp, _ := get(object, "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET object with matching If-None-Match: status %d, want 304", resp.StatusCode)
+	}
+	resp, body = get(object, "Range", "bytes=2-5")
+	if resp.StatusCode != http.StatusPartialContent || body != "2345" || resp.Header.Get("ETag") != etag {
+		t.Errorf("GET object range: status %d, body %q, ETag %q", resp.StatusCode, body, resp.Header.Get("ETag"))
+	}
+
+	manifest := "/v1/manifests/" + key.Hash()
+	resp, _ = get(manifest)
+	manifestTag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || manifestTag == "" || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("GET manifest: status %d, ETag %q, Cache-Control %q", resp.StatusCode, manifestTag, resp.Header.Get("Cache-Control"))
+	}
+	if resp, _ := get(manifest, "If-None-Match", manifestTag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET manifest with matching If-None-Match: status %d, want 304", resp.StatusCode)
+	}
+
+	// New content under the same key gets a new ETag, so stale copies do
+	// not match; a partial copy is not resumed from with If-Range
+	if err := remote.Put(key).Bytes("out", []byte("abcdefghij")).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	resp, body = get(object, "If-None-Match", etag)
+	if resp.StatusCode != http.StatusOK || body != "abcdefghij" || resp.Header.Get("ETag") == etag {
+		t.Errorf("GET replaced object: status %d, body %q, ETag %q (was %q)", resp.StatusCode, body, resp.Header.Get("ETag"), etag)
+	}
+	resp, body = get(object, "Range", "bytes=5-", "If-Range", etag)
+	if resp.StatusCode != http.StatusOK || body != "abcdefghij" {
+		t.Errorf("GET replaced object with stale If-Range: status %d, body %q, want the whole object", resp.StatusCode, body)
+	}
+	if resp, _ := get(manifest, "If-None-Match", manifestTag); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET replaced manifest with stale If-None-Match: status %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,75 @@
+package granular
+
+import (
+	"time"
+
+	"github.com/gophersatwork/granular/hashing"
+)
+
+// SlowThresholds configures soft time limits for cache operations.
+// Exceeding a threshold never aborts the operation; it only reports the
+// offending operation through MetricsHooks.OnSlow so slow inputs can be found.
+// A zero duration disables the check for that operation.
+type SlowThresholds struct {
+	// Hash is the limit for hashing a single key input, and for hashing a
+	// single file within a Glob or Dir input. Reports name the input
+	// description or the file path.
+	Hash time.Duration
+
+	// Get is the limit for a whole Get call, including key hashing.
+	// Reports name the key hash.
+	Get time.Duration
+
+	// Commit is the limit for a whole Commit call, including key hashing,
+	// eviction, and copying outputs. Reports name the key hash.
+	Commit time.Duration
+}
+
+// Operation names reported to MetricsHooks.OnSlow.
+const (
+	SlowOpHash   = "hash"
+	SlowOpGet    = "get"
+	SlowOpCommit = "commit"
+)
+
+// reportIfSlow reports op through the OnSlow hook if elapsed exceeds its threshold.
+func (c *Cache) reportIfSlow(op, subject string, elapsed time.Duration) {
+	var threshold time.Duration
+	switch op {
+	case SlowOpHash:
+		threshold = c.slow.Hash
+	case SlowOpGet:
+		threshold = c.slow.Get
+	case SlowOpCommit:
+		threshold = c.slow.Commit
+	}
+	if threshold > 0 && elapsed > threshold {
+		c.metrics.slow(op, subject, elapsed)
+	}
+}
+
+// hasher returns a hashing.Hasher configured for this cache.
+func (c *Cache) hasher() *hashing.Hasher {
+	hs := &hashing.Hasher{
+		Now:        c.nowFunc,
+		BufferSize: c.bufferSize,
+		FoldCase:   c.foldCase,
+		Base:       c.basePath,
+		MaxFiles:   c.maxInputFiles,
+		MaxBytes:   c.maxInputBytes,
+	}
+	if c.slow.Hash > 0 || c.onProgress != nil {
+		hs.OnFile = func(ev hashing.FileEvent) {
+			if c.slow.Hash > 0 {
+				c.reportIfSlow(SlowOpHash, ev.Path, ev.Elapsed)
+			}
+			c.reportProgress(ProgressEvent{Op: ProgressOpHash, Path: ev.Path, Bytes: ev.Bytes, Done: true})
+		}
+	}
+	if c.onProgress != nil {
+		hs.OnProgress = func(path string, bytes int64) {
+			c.onProgress(ProgressEvent{Op: ProgressOpHash, Path: path, Bytes: bytes})
+		}
+	}
+	return hs
+}
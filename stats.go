@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"iter"
+	"log/slog"
 	"maps"
 	"os"
 	"path/filepath"
@@ -16,19 +17,33 @@ import (
 
 // Stats represents cache statistics.
 type Stats struct {
-	Entries     int           // Total number of cache entries
-	TotalSize   int64         // Total size of all cached files in bytes
-	OldestEntry time.Duration // Age of the oldest entry
-	NewestEntry time.Duration // Age of the newest entry
+	Entries      int           // Total number of cache entries
+	TotalSize    int64         // Total size on disk in bytes: FileSize + DataSize + ManifestSize + HistorySize
+	FileSize     int64         // Size of cached files stored with WriteBuilder.File
+	DataSize     int64         // Size of data stored with WriteBuilder.Bytes, as stored (compressed)
+	ManifestSize int64         // Size of the entries' manifests
+	HistorySize  int64         // Size of the previous results kept by WithHistory
+	OldestEntry  time.Duration // Age of the oldest entry
+	NewestEntry  time.Duration // Age of the newest entry
+
+	SkippedManifests int // Manifests skipped because they could not be parsed
 }
 
 // Entry represents a single cache entry for iteration.
 type Entry struct {
-	KeyHash    string
-	CreatedAt  time.Time
-	AccessedAt time.Time
-	Size       int64
-	FileCount  int
+	KeyHash      string
+	CreatedAt    time.Time
+	AccessedAt   time.Time
+	Size         int64 // Size of the entry's objects and history (FileSize + DataSize + HistorySize), the size counted against WithMaxSize
+	FileCount    int
+	FileSize     int64             // Size of the entry's cached files
+	DataSize     int64             // Size of the entry's data objects, as stored (compressed)
+	ManifestSize int64             // Size of the entry's manifest
+	HistorySize  int64             // Size of the previous results kept for the entry by WithHistory
+	Tags         map[string]string // Tags set with WriteBuilder.Tag
+	Meta         map[string]string // Metadata set with WriteBuilder.Meta
+	Namespace    string            // Namespace the entry was stored in; empty for the root cache
+	Description  string            // Description set with WriteBuilder.Describe
 }
 
 // Stats returns statistics about the cache.
@@ -38,24 +53,43 @@ func (c *Cache) Stats() (Stats, error) {
 
 	stats := Stats{}
 	var oldest, newest time.Time
-
-	var walkErr error
-	for _, m := range c.manifests(&walkErr, nil) {
+	add := func(createdAt time.Time, files, data, manifest, history int64) {
 		stats.Entries++
 
 		// Track oldest and newest
-		if oldest.IsZero() || m.CreatedAt.Before(oldest) {
-			oldest = m.CreatedAt
+		if oldest.IsZero() || createdAt.Before(oldest) {
+			oldest = createdAt
 		}
-		if newest.IsZero() || m.CreatedAt.After(newest) {
-			newest = m.CreatedAt
+		if newest.IsZero() || createdAt.After(newest) {
+			newest = createdAt
 		}
 
-		// Calculate size from manifest file references to avoid O(N^2) directory walks.
-		stats.TotalSize += c.manifestEntrySize(m)
+		stats.FileSize += files
+		stats.DataSize += data
+		stats.ManifestSize += manifest
+		stats.HistorySize += history
+		stats.TotalSize += files + data + manifest + history
 	}
-	if walkErr != nil {
-		return Stats{}, walkErr
+
+	if c.index != nil {
+		for _, entry := range c.indexedEntries() {
+			add(entry.CreatedAt, entry.FileSize, entry.DataSize, entry.ManifestSize, entry.HistorySize)
+		}
+	} else {
+		var walkErr error
+		var skipped []string
+		for keyHash, m := range c.manifests(&walkErr, &skipped) {
+			if !inNamespace(c.namespace, m.Namespace) {
+				continue
+			}
+			// Calculate size from manifest file references to avoid O(N^2) directory walks.
+			files, data, manifest, history := c.entrySizes(keyHash, m)
+			add(m.CreatedAt, files, data, manifest, history)
+		}
+		if walkErr != nil {
+			return Stats{}, walkErr
+		}
+		stats.SkippedManifests = len(skipped)
 	}
 
 	now := c.now()
@@ -72,23 +106,38 @@ func (c *Cache) Stats() (Stats, error) {
 // Prune removes cache entries older than the given duration.
 // Returns the number of entries removed.
 func (c *Cache) Prune(olderThan time.Duration) (int, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	count := 0
 	cutoff := c.now().Add(-olderThan)
+	return c.deleteWhere(func(e Entry) bool { return e.CreatedAt.Before(cutoff) }, EvictReasonExpired)
+}
 
-	type entryToRemove struct {
-		keyHash string
-		size    int64
-	}
-	var toRemove []entryToRemove
+// PruneUnused removes cache entries not accessed since the given duration.
+// Returns the number of entries removed.
+func (c *Cache) PruneUnused(notAccessedSince time.Duration) (int, error) {
+	cutoff := c.now().Add(-notAccessedSince)
+	return c.deleteWhere(func(e Entry) bool { return e.AccessedAt.Before(cutoff) }, EvictReasonExpired)
+}
+
+// DeleteWhere removes all cache entries for which match returns true.
+// Returns the number of entries removed.
+//
+// match is called while the cache is locked; it must not call back into
+// the cache.
+func (c *Cache) DeleteWhere(match func(Entry) bool) (int, error) {
+	return c.deleteWhere(match, EvictReasonManual)
+}
+
+// deleteWhere removes all entries matching match, reporting each eviction
+// with reason.
+func (c *Cache) deleteWhere(match func(Entry) bool, reason EvictReason) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	var toRemove []Entry
 	var walkErr error
 	var corruptedKeys []string
-	for keyHash, m := range c.manifests(&walkErr, &corruptedKeys) {
-		if m.CreatedAt.Before(cutoff) {
-			toRemove = append(toRemove, entryToRemove{keyHash: keyHash, size: c.manifestEntrySize(m)})
+	for entry := range c.entriesUnlocked(&walkErr, &corruptedKeys) {
+		if match(entry) {
+			toRemove = append(toRemove, entry)
 		}
 	}
 	if walkErr != nil {
@@ -98,68 +147,153 @@ func (c *Cache) Prune(olderThan time.Duration) (int, error) {
 	c.cleanupCorrupted(corruptedKeys)
 
 	// Remove entries, acquiring per-key lock for each to prevent races with concurrent Get()
+	count := 0
+	var freed int64
 	for _, entry := range toRemove {
-		c.keyLocks.lockKey(entry.keyHash)
-		if err := c.removeByHash(entry.keyHash); err != nil {
-			c.keyLocks.unlockKey(entry.keyHash)
-			return count, fmt.Errorf("failed to remove entry %s: %w", entry.keyHash, err)
+		c.keyLocks.lockKey(entry.KeyHash)
+		if err := c.removeWithHistory(entry.KeyHash); err != nil {
+			c.keyLocks.unlockKey(entry.KeyHash)
+			return count, fmt.Errorf("failed to remove entry %s: %w", entry.KeyHash, err)
 		}
-		c.keyLocks.unlockKey(entry.keyHash)
-		c.metrics.evict(entry.keyHash, entry.size, EvictReasonExpired)
+		c.keyLocks.unlockKey(entry.KeyHash)
+		c.recordEvict(entry.KeyHash, entry.Size, reason)
+		freed += entry.Size
 		count++
 	}
 
+	c.log(slog.LevelInfo, "cache pruned", slog.String("reason", string(reason)), slog.Int("entries", count), bytesAttr(freed))
 	return count, nil
 }
 
-// PruneUnused removes cache entries not accessed since the given duration.
-// Returns the number of entries removed.
-func (c *Cache) PruneUnused(notAccessedSince time.Duration) (int, error) {
+// PruneToSize removes least-recently-accessed entries until the total cache
+// size is at most maxBytes. Unlike Prune, it guarantees a size bound, which
+// makes it suitable for scheduled cleanup jobs.
+// Returns the number of entries removed and the number of bytes freed.
+func (c *Cache) PruneToSize(maxBytes int64) (removed int, freed int64, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	count := 0
+	var walkErr error
+	var corruptedKeys []string
+	entries := slices.Collect(c.entriesUnlocked(&walkErr, &corruptedKeys))
+	if walkErr != nil {
+		return 0, 0, walkErr
+	}
+
+	c.cleanupCorrupted(corruptedKeys)
+
+	// Remove entries, acquiring per-key lock for each to prevent races with concurrent Get()
+	for _, entry := range lruOverSize(entries, maxBytes) {
+		c.keyLocks.lockKey(entry.KeyHash)
+		if err := c.removeWithHistory(entry.KeyHash); err != nil {
+			c.keyLocks.unlockKey(entry.KeyHash)
+			return removed, freed, fmt.Errorf("failed to remove entry %s: %w", entry.KeyHash, err)
+		}
+		c.keyLocks.unlockKey(entry.KeyHash)
+		c.recordEvict(entry.KeyHash, entry.Size, EvictReasonLRU)
+		freed += entry.Size
+		removed++
+	}
+
+	c.log(slog.LevelInfo, "cache pruned", slog.String("reason", string(EvictReasonLRU)), slog.Int("entries", removed), bytesAttr(freed))
+	return removed, freed, nil
+}
+
+// PrunePlan reports the entries Prune would remove for the same duration,
+// without deleting anything. Entries are returned in walk order.
+func (c *Cache) PrunePlan(olderThan time.Duration) ([]Entry, error) {
+	cutoff := c.now().Add(-olderThan)
+	return c.planWhere(func(e Entry) bool { return e.CreatedAt.Before(cutoff) })
+}
+
+// PruneUnusedPlan reports the entries PruneUnused would remove for the same
+// duration, without deleting anything. Entries are returned in walk order.
+func (c *Cache) PruneUnusedPlan(notAccessedSince time.Duration) ([]Entry, error) {
 	cutoff := c.now().Add(-notAccessedSince)
+	return c.planWhere(func(e Entry) bool { return e.AccessedAt.Before(cutoff) })
+}
 
-	type entryToRemove struct {
-		keyHash string
-		size    int64
+// PruneToSizePlan reports the entries PruneToSize would remove for the same
+// size bound, without deleting anything. Entries are returned in eviction
+// order, least recently accessed first.
+func (c *Cache) PruneToSizePlan(maxBytes int64) ([]Entry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var walkErr error
+	entries := slices.Collect(c.entriesUnlocked(&walkErr, nil))
+	if walkErr != nil {
+		return nil, walkErr
 	}
-	var toRemove []entryToRemove
+	return lruOverSize(entries, maxBytes), nil
+}
+
+// planWhere returns all entries matching match under the read lock.
+func (c *Cache) planWhere(match func(Entry) bool) ([]Entry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
+	var planned []Entry
 	var walkErr error
-	var corruptedKeys []string
-	for keyHash, m := range c.manifests(&walkErr, &corruptedKeys) {
-		if m.AccessedAt.Before(cutoff) {
-			toRemove = append(toRemove, entryToRemove{keyHash: keyHash, size: c.manifestEntrySize(m)})
+	for entry := range c.entriesUnlocked(&walkErr, nil) {
+		if match(entry) {
+			planned = append(planned, entry)
 		}
 	}
 	if walkErr != nil {
-		return 0, walkErr
+		return nil, walkErr
 	}
+	return planned, nil
+}
 
-	c.cleanupCorrupted(corruptedKeys)
+// lruOverSize sorts entries by LRU order and returns the prefix that must be
+// removed to bring their total size down to at most maxBytes.
+func lruOverSize(entries []Entry, maxBytes int64) []Entry {
+	var totalSize int64
+	for _, entry := range entries {
+		totalSize += entry.Size
+	}
 
-	// Remove entries, acquiring per-key lock for each to prevent races with concurrent Get()
-	for _, entry := range toRemove {
-		c.keyLocks.lockKey(entry.keyHash)
-		if err := c.removeByHash(entry.keyHash); err != nil {
-			c.keyLocks.unlockKey(entry.keyHash)
-			return count, fmt.Errorf("failed to remove entry %s: %w", entry.keyHash, err)
-		}
-		c.keyLocks.unlockKey(entry.keyHash)
-		c.metrics.evict(entry.keyHash, entry.size, EvictReasonExpired)
-		count++
+	sortLRU(entries)
+
+	n := 0
+	for n < len(entries) && totalSize > maxBytes {
+		totalSize -= entries[n].Size
+		n++
 	}
+	return entries[:n]
+}
 
-	return count, nil
+// Entries returns all cache entries as a slice. Without options, entries
+// are in no particular order; options sort and page them like Query.
+//
+// Example:
+//
+//	// Pages of 50 entries, largest first
+//	page, err := cache.Entries(granular.SortEntries(granular.OrderBySize), granular.LimitEntries(50))
+//	next, err := cache.Entries(granular.SortEntries(granular.OrderBySize), granular.LimitEntries(50),
+//		granular.EntriesAfter(page[len(page)-1]))
+func (c *Cache) Entries(opts ...EntriesOption) ([]Entry, error) {
+	if len(opts) > 0 {
+		var q Query
+		for _, opt := range opts {
+			opt(&q)
+		}
+		return c.Query(q)
+	}
+	return c.allEntries()
 }
 
-// Entries returns all cache entries as a slice.
-func (c *Cache) Entries() ([]Entry, error) {
+// allEntries returns all cache entries in c's namespace, from the index if
+// it is enabled.
+func (c *Cache) allEntries() ([]Entry, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	if c.index != nil {
+		return c.indexedEntries(), nil
+	}
+
 	var walkErr error
 	entries := slices.Collect(c.entriesUnlocked(&walkErr, nil))
 	if walkErr != nil {
@@ -168,24 +302,89 @@ func (c *Cache) Entries() ([]Entry, error) {
 	return entries, nil
 }
 
-// EntriesIter returns an iterator over all cache entries.
-// It holds a read lock during iteration, released when the iterator
-// completes or the caller breaks. Walk errors are silently skipped;
-// use Entries() for explicit error handling.
-func (c *Cache) EntriesIter() iter.Seq[Entry] {
-	return func(yield func(Entry) bool) {
+// EntriesIter returns an iterator that streams cache entries one manifest
+// shard at a time. Unlike Entries, it neither materializes every entry nor
+// holds the read lock for the whole walk: the lock is taken while a shard is
+// read and released before its entries are yielded, so writers are blocked
+// for at most one shard.
+//
+// Because the lock is released between shards, entries added or removed
+// during iteration may or may not be seen; no entry is yielded twice.
+// If a shard cannot be read, the error is yielded (with a zero Entry) and
+// iteration stops.
+//
+// Example:
+//
+//	for entry, err := range cache.EntriesIter() {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(entry.KeyHash, entry.Size)
+//	}
+func (c *Cache) EntriesIter() iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
 		c.mu.RLock()
-		defer c.mu.RUnlock()
+		shards, err := afero.ReadDir(c.fs, c.manifestDir())
+		c.mu.RUnlock()
+		if err != nil {
+			yield(Entry{}, err)
+			return
+		}
 
-		var walkErr error
-		for entry := range c.entriesUnlocked(&walkErr, nil) {
-			if !yield(entry) {
+		for _, shard := range shards {
+			if !shard.IsDir() {
+				continue
+			}
+			batch, err := c.shardEntries(shard.Name())
+			if err != nil {
+				yield(Entry{}, err)
 				return
 			}
+			for _, entry := range batch {
+				if !yield(entry, nil) {
+					return
+				}
+			}
 		}
 	}
 }
 
+// shardEntries returns the entries in c's namespace whose manifests live in
+// the given shard directory, holding the read lock only while reading it.
+// A shard removed concurrently (e.g. by Clear) yields no entries.
+func (c *Cache) shardEntries(shard string) ([]Entry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	files, err := afero.ReadDir(c.fs, filepath.Join(c.manifestDir(), shard))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		keyHash := strings.TrimSuffix(file.Name(), ".json")
+		m, err := c.loadManifest(keyHash)
+		if err != nil {
+			if c.strictWalks {
+				return nil, fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+			}
+			c.recordError("manifests", fmt.Errorf("corrupted manifest %s: %w", keyHash, err))
+			continue
+		}
+		if inNamespace(c.namespace, m.Namespace) {
+			entries = append(entries, c.newEntry(keyHash, m))
+		}
+	}
+	return entries, nil
+}
+
 // errStopWalk is a sentinel error used to break out of afero.Walk
 // when the iterator consumer stops early.
 var errStopWalk = errors.New("stop walk")
@@ -194,8 +393,17 @@ var errStopWalk = errors.New("stop walk")
 // Walk errors are captured in walkErr. Corrupted manifest keyHashes are
 // appended to corrupted (if non-nil) and skipped. Callers holding a write
 // lock should pass a non-nil slice and clean up corrupted entries after
-// iteration. Callers holding only a read lock should pass nil.
+// iteration. Callers holding only a read lock may collect them for reporting
+// but must not clean them up.
+//
+// With WithStrictWalks, the first corrupted manifest stops the walk and is
+// reported through walkErr (wrapping ErrCacheCorrupted) instead.
 func (c *Cache) manifests(walkErr *error, corrupted *[]string) iter.Seq2[string, *manifest] {
+	return c.walkManifests(c.strictWalks, walkErr, corrupted)
+}
+
+// walkManifests implements manifests with an explicit strict setting.
+func (c *Cache) walkManifests(strict bool, walkErr *error, corrupted *[]string) iter.Seq2[string, *manifest] {
 	return func(yield func(string, *manifest) bool) {
 		manifestDir := c.manifestDir()
 
@@ -220,7 +428,10 @@ func (c *Cache) manifests(walkErr *error, corrupted *[]string) iter.Seq2[string,
 			// Load manifest
 			m, err := c.loadManifest(keyHash)
 			if err != nil {
-				c.metrics.error("manifests", fmt.Errorf("corrupted manifest %s: %w", keyHash, err))
+				if strict {
+					return fmt.Errorf("%w: manifest %s: %v", ErrCacheCorrupted, keyHash, err)
+				}
+				c.recordError("manifests", fmt.Errorf("corrupted manifest %s: %w", keyHash, err))
 				if corrupted != nil {
 					*corrupted = append(*corrupted, keyHash)
 				}
@@ -249,21 +460,32 @@ func (c *Cache) cleanupCorrupted(keyHashes []string) {
 	}
 }
 
-// manifestEntrySize computes the size of a cache entry by statting the files
-// referenced in the manifest. This avoids a full directory walk per entry.
-func (c *Cache) manifestEntrySize(m *manifest) int64 {
-	var size int64
+// entrySizes computes the sizes of the files, data objects, manifest, and
+// history of a cache entry by statting the paths referenced in the manifest
+// and walking only the entry's history. This avoids a full directory walk
+// per entry.
+func (c *Cache) entrySizes(keyHash string, m *manifest) (files, data, manifest, history int64) {
 	for path := range maps.Values(m.OutputFiles) {
 		if info, err := c.fs.Stat(path); err == nil {
-			size += info.Size()
+			files += info.Size()
 		}
 	}
 	for path := range maps.Values(m.OutputData) {
 		if info, err := c.fs.Stat(path); err == nil {
-			size += info.Size()
+			data += info.Size()
 		}
 	}
-	return size
+	if mPath, err := c.manifestPath(keyHash); err == nil {
+		if info, err := c.fs.Stat(mPath); err == nil {
+			manifest = info.Size()
+		}
+	}
+	if dir, err := c.historyPath(keyHash); err == nil {
+		if exists, _ := afero.DirExists(c.fs, dir); exists {
+			history, _ = c.dirSize(dir)
+		}
+	}
+	return files, data, manifest, history
 }
 
 // dirSize calculates the total size of all files in a directory.
@@ -317,6 +539,7 @@ func (c *Cache) removeByHash(keyHash string) error {
 			return fmt.Errorf("failed to remove manifest: %w", err)
 		}
 	}
+	c.indexRemove(keyHash)
 
 	return nil
 }
@@ -324,67 +547,151 @@ func (c *Cache) removeByHash(keyHash string) error {
 // GC performs garbage collection on the cache, removing orphaned object directories
 // that have no corresponding manifest. This can happen if Put() succeeds writing
 // objects but fails writing the manifest (crash, disk full, etc.).
-// Returns the number of orphaned directories removed and total bytes reclaimed.
+// It also removes the staging directories of commits interrupted while copying
+// their outputs and of uploads abandoned before ImportManifest, and the
+// history (see WithHistory) of keys that have no entry anymore. Shared blobs
+// (see WithDedup) that no entry or kept history references are collected as
+// well.
+// Returns the number of orphans removed and total bytes reclaimed.
 func (c *Cache) GC() (int, int64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// Step 1: Collect all valid object directory hashes from manifests
+	// and the shared blobs they and the kept history reference
 	validHashes := make(map[string]bool)
+	blobs := make(map[string]bool)
 	var walkErr error
 	var corruptedKeys []string
-	for keyHash := range c.manifests(&walkErr, &corruptedKeys) {
+	for keyHash, m := range c.manifests(&walkErr, &corruptedKeys) {
 		validHashes[keyHash] = true
+		for path := range maps.Values(m.OutputData) {
+			if isBlobPath(path) {
+				blobs[path] = true
+			}
+		}
 	}
 	if walkErr != nil {
 		return 0, 0, fmt.Errorf("failed to walk manifests: %w", walkErr)
 	}
+	historyRemoved, historyBytes, err := c.removeOrphanHistory(validHashes)
+	if err != nil {
+		return historyRemoved, historyBytes, fmt.Errorf("failed to walk history: %w", err)
+	}
+	if err := c.historyBlobs(blobs); err != nil {
+		return 0, 0, fmt.Errorf("failed to walk history: %w", err)
+	}
 
 	c.cleanupCorrupted(corruptedKeys)
 
-	// Step 2: Walk the objects directory and find orphans
-	objectsDir := c.objectsDir()
-	var dirsRemoved int
-	var bytesReclaimed int64
-
-	// Objects are stored as: objects/{first2chars}/{fullhash}/files
-	// Walk the sharded directories
-	err := afero.Walk(c.fs, objectsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			// Skip errors (e.g., permission denied)
-			return nil
+	// Step 2: Walk the objects directory and remove orphans
+	dirsRemoved, bytesReclaimed := historyRemoved, historyBytes
+	for _, path := range c.orphanObjects(validHashes, &err) {
+		size, _ := c.dirSize(path)
+		if removeErr := c.fs.RemoveAll(path); removeErr == nil {
+			dirsRemoved++
+			bytesReclaimed += size
 		}
+	}
+	if err != nil {
+		return dirsRemoved, bytesReclaimed, fmt.Errorf("failed to walk objects directory: %w", err)
+	}
 
-		// We're looking for hash directories (the ones containing actual files)
-		// Path structure: objects/ab/abcd1234.../
-		if !info.IsDir() {
-			return nil
+	// Step 3: Remove the staging directories of interrupted commits and uploads
+	for _, path := range c.staleStaging() {
+		size, _ := c.dirSize(path)
+		if removeErr := c.fs.RemoveAll(path); removeErr == nil {
+			dirsRemoved++
+			bytesReclaimed += size
 		}
+	}
 
-		// Extract hash from path
-		hash := extractHashFromPath(path, objectsDir)
-		if hash == "" {
-			return nil // Not a hash directory (might be shard directory or root)
-		}
+	// Step 4: Remove shared blobs no entry references anymore
+	blobsRemoved, blobBytes, err := c.removeUnreferencedBlobs(blobs)
+	dirsRemoved += blobsRemoved
+	bytesReclaimed += blobBytes
+	if err != nil {
+		return dirsRemoved, bytesReclaimed, fmt.Errorf("failed to walk blobs directory: %w", err)
+	}
 
-		// Check if this hash has a corresponding manifest
-		if !validHashes[hash] {
-			// Orphan! Remove it
-			size, _ := c.dirSize(path)
-			if removeErr := c.fs.RemoveAll(path); removeErr == nil {
-				dirsRemoved++
-				bytesReclaimed += size
-			}
-			return filepath.SkipDir // Don't descend into removed directory
-		}
+	c.log(slog.LevelInfo, "cache garbage collected", slog.Int("orphans", dirsRemoved), bytesAttr(bytesReclaimed))
+	return dirsRemoved, bytesReclaimed, nil
+}
 
-		return filepath.SkipDir // Don't descend into valid directories either
-	})
+// stagingStaleAfter is how long a staging directory may go without writes
+// before GC treats it as abandoned.
+const stagingStaleAfter = time.Hour
+
+// staleStaging returns the staging directories of commits, rollbacks and
+// uploads in which nothing has been written for stagingStaleAfter. Commits
+// copy their outputs there before taking the cache lock and rollbacks move
+// the generation they restore there, so a crash can leave them behind;
+// uploads keep partial objects there so they can be resumed.
+func (c *Cache) staleStaging() []string {
+	tmpDir := filepath.Join(c.root, "tmp")
+	infos, err := afero.ReadDir(c.fs, tmpDir)
 	if err != nil {
-		return dirsRemoved, bytesReclaimed, fmt.Errorf("failed to walk objects directory: %w", err)
+		return nil
+	}
+	cutoff := c.now().Add(-stagingStaleAfter)
+	var stale []string
+	for _, info := range infos {
+		if !info.IsDir() || !hasStagingPrefix(info.Name()) {
+			continue
+		}
+		path := filepath.Join(tmpDir, info.Name())
+		latest := info.ModTime()
+		files, _ := afero.ReadDir(c.fs, path)
+		for _, f := range files {
+			if f.ModTime().After(latest) {
+				latest = f.ModTime()
+			}
+		}
+		if latest.Before(cutoff) {
+			stale = append(stale, path)
+		}
 	}
+	return stale
+}
 
-	return dirsRemoved, bytesReclaimed, nil
+// orphanObjects returns an iterator over object directories whose key hash
+// is not in valid, yielding the key hash and directory path. The consumer may
+// remove the yielded directory. Walk errors are captured in walkErr.
+func (c *Cache) orphanObjects(valid map[string]bool, walkErr *error) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		objectsDir := c.objectsDir()
+
+		// Objects are stored as: objects/{first2chars}/{fullhash}/files
+		// Walk the sharded directories
+		err := afero.Walk(c.fs, objectsDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// Skip errors (e.g., permission denied)
+				return nil
+			}
+
+			// We're looking for hash directories (the ones containing actual files)
+			// Path structure: objects/ab/abcd1234.../
+			if !info.IsDir() {
+				return nil
+			}
+
+			// Extract hash from path
+			hash := extractHashFromPath(path, objectsDir)
+			if hash == "" {
+				return nil // Not a hash directory (might be shard directory or root)
+			}
+
+			// Check if this hash has a corresponding manifest
+			if !valid[hash] && !yield(hash, path) {
+				return errStopWalk
+			}
+
+			return filepath.SkipDir // Don't descend into hash directories
+		})
+		if err != nil && !errors.Is(err, errStopWalk) {
+			*walkErr = err
+		}
+	}
 }
 
 // extractHashFromPath extracts the key hash from an object directory path.
@@ -402,3 +709,14 @@ func extractHashFromPath(path, objectsDir string) string {
 	// parts[0] is the shard (e.g., "ab"), parts[1] is the full hash
 	return parts[1]
 }
+
+// hasStagingPrefix reports whether name is a staging directory that GC may
+// collect once it is stale.
+func hasStagingPrefix(name string) bool {
+	for _, prefix := range []string{"commit-", "rollback-", "upload-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
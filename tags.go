@@ -0,0 +1,39 @@
+package granular
+
+// EntriesByTag returns all cache entries tagged with key=value.
+func (c *Cache) EntriesByTag(key, value string) ([]Entry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var entries []Entry
+	var walkErr error
+	for entry := range c.entriesUnlocked(&walkErr, nil) {
+		if v, ok := entry.Tags[key]; ok && v == value {
+			entries = append(entries, entry)
+		}
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return entries, nil
+}
+
+// DeleteByTag removes all cache entries tagged with key=value.
+// Returns the number of entries removed.
+func (c *Cache) DeleteByTag(key, value string) (int, error) {
+	return c.DeleteWhere(func(e Entry) bool {
+		v, ok := e.Tags[key]
+		return ok && v == value
+	})
+}
+
+// FindByMeta returns all cache entries whose metadata, set with
+// WriteBuilder.Meta, has key=value, sorted by key hash. With WithIndex it is
+// answered from memory.
+//
+// Example:
+//
+//	entries, err := cache.FindByMeta("compiler", "go1.24.1")
+func (c *Cache) FindByMeta(key, value string) ([]Entry, error) {
+	return c.Query(Query{Meta: map[string]string{key: value}})
+}
@@ -0,0 +1,118 @@
+package granular
+
+import (
+	"errors"
+	"testing"
+)
+
+// putTagged stores an entry for name with the given pipeline tag.
+func putTagged(t *testing.T, cache *Cache, name, pipeline string) Key {
+	t.Helper()
+	key := cache.Key().String("name", name).Build()
+	err := cache.Put(key).
+		Bytes("data", []byte(name)).
+		Tag("pipeline", pipeline).
+		Commit()
+	if err != nil {
+		t.Fatalf("Commit %s failed: %v", name, err)
+	}
+	return key
+}
+
+func TestTag_RoundTrip(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-tag-test")
+	key := putTagged(t, cache, "a", "docs")
+
+	result, err := cache.Get(key)
+	assertCacheHit(t, result, err, "Get tagged entry")
+	assertEqual(t, result.Tag("pipeline"), "docs", "Result.Tag")
+	if len(result.Tags()) != 1 {
+		t.Errorf("Expected 1 tag, got %v", result.Tags())
+	}
+}
+
+func TestEntriesByTag(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-entries-by-tag-test")
+	docsA := putTagged(t, cache, "a", "docs")
+	docsB := putTagged(t, cache, "b", "docs")
+	putTagged(t, cache, "c", "build")
+
+	entries, err := cache.EntriesByTag("pipeline", "docs")
+	assertNoError(t, err, "EntriesByTag")
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 docs entries, got %d", len(entries))
+	}
+	want := map[string]bool{docsA.Hash(): true, docsB.Hash(): true}
+	for _, e := range entries {
+		if !want[e.KeyHash] {
+			t.Errorf("Unexpected entry %s", e.KeyHash)
+		}
+		assertEqual(t, e.Tags["pipeline"], "docs", "Entry.Tags")
+	}
+
+	entries, err = cache.EntriesByTag("pipeline", "missing")
+	assertNoError(t, err, "EntriesByTag missing")
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries, got %d", len(entries))
+	}
+}
+
+func TestFindByMeta(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-find-by-meta-test")
+	put := func(name, compiler string) Key {
+		key := cache.Key().String("name", name).Build()
+		err := cache.Put(key).Bytes("data", []byte(name)).Meta("compiler", compiler).Commit()
+		assertNoError(t, err, "Commit "+name)
+		return key
+	}
+	a := put("a", "go1.24.1")
+	b := put("b", "go1.24.1")
+	put("c", "go1.23.0")
+
+	entries, err := cache.FindByMeta("compiler", "go1.24.1")
+	assertNoError(t, err, "FindByMeta")
+	want := []string{a.Hash(), b.Hash()}
+	if want[0] > want[1] {
+		want[0], want[1] = want[1], want[0]
+	}
+	if len(entries) != 2 || entries[0].KeyHash != want[0] || entries[1].KeyHash != want[1] {
+		t.Fatalf("FindByMeta = %v, want entries %v", entries, want)
+	}
+	assertEqual(t, entries[0].Meta["compiler"], "go1.24.1", "Entry.Meta")
+
+	entries, err = cache.FindByMeta("compiler", "missing")
+	assertNoError(t, err, "FindByMeta missing")
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries, got %d", len(entries))
+	}
+}
+
+func TestDeleteByTag(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-delete-by-tag-test")
+	docsA := putTagged(t, cache, "a", "docs")
+	docsB := putTagged(t, cache, "b", "docs")
+	build := putTagged(t, cache, "c", "build")
+
+	removed, err := cache.DeleteByTag("pipeline", "docs")
+	assertNoError(t, err, "DeleteByTag")
+	if removed != 2 {
+		t.Fatalf("Expected 2 entries removed, got %d", removed)
+	}
+	if cache.Has(docsA) || cache.Has(docsB) {
+		t.Error("Expected docs entries to be deleted")
+	}
+	if !cache.Has(build) {
+		t.Error("Expected build entry to survive")
+	}
+}
+
+func TestTag_InvalidUTF8(t *testing.T) {
+	cache, _, _ := setupTestCache(t, "granular-tag-utf8-test")
+	key := cache.Key().String("k", "v").Build()
+
+	err := cache.Put(key).Tag("pipeline", "\xff").Commit()
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+// Package testcache caches expensive test fixtures in a granular cache.
+//
+// Fixtures such as generated databases or compiled assets are often built
+// from a few inputs that rarely change, yet rebuilt by every test run. A
+// Fixture is built once per set of inputs and restored on later runs, from
+// TestMain:
+//
+//	var testDB = &testcache.Fixture{
+//		Name: "testdb",
+//		Inputs: func(kb *granular.KeyBuilder) {
+//			kb.Glob("testdata/schema/*.sql").Glob("testdata/seed/*.csv").Version("v1")
+//		},
+//		Build: func(dir string) error {
+//			return buildDB(filepath.Join(dir, "test.db"), "testdata")
+//		},
+//	}
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(testcache.Run(m, []*testcache.Fixture{testDB}))
+//	}
+//
+//	func TestQuery(t *testing.T) {
+//		db := openDB(t, testDB.Path("test.db"))
+//		...
+//	}
+//
+// Each run gets a fresh copy of every fixture, removed once the tests are
+// done, so tests may modify it freely. The code of Build is not part of the
+// key: bump a version in Inputs when it changes. Fixtures hold regular
+// files; permissions and empty directories are not kept.
+//
+// Fixtures are shared by all packages through a cache under the user cache
+// directory, or the directory in $GRANULAR_TESTCACHE; see DefaultRoot.
+// Concurrent test binaries building the same fixture wait for each other
+// rather than building it twice.
+package testcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gophersatwork/granular"
+)
+
+// RootEnv is the environment variable that overrides DefaultRoot.
+const RootEnv = "GRANULAR_TESTCACHE"
+
+// Fixture is a directory of test data, built by Build from the inputs added
+// by Inputs.
+type Fixture struct {
+	// Name identifies the fixture. It is part of the key, so fixtures
+	// sharing a cache do not see each other's contents.
+	Name string
+
+	// Inputs adds the inputs of the fixture to its key: the files Build
+	// reads, configuration, and a version to bump when Build changes. It
+	// may be nil.
+	Inputs func(kb *granular.KeyBuilder)
+
+	// Build writes the fixture into dir, which is empty. It is only called
+	// on a cache miss; if it returns an error, nothing is cached.
+	Build func(dir string) error
+
+	dir    string
+	cached bool
+}
+
+// Dir returns the directory holding the fixture's copy for this run. It is
+// empty until the fixture has been prepared by Run or Prepare.
+func (f *Fixture) Dir() string {
+	return f.dir
+}
+
+// Path returns the path of name within the fixture's directory.
+//
+// Example:
+//
+//	db, err := sql.Open("sqlite", testDB.Path("test.db"))
+func (f *Fixture) Path(name string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(name))
+}
+
+// Cached reports whether the fixture was restored from the cache rather
+// than built by this run.
+func (f *Fixture) Cached() bool {
+	return f.cached
+}
+
+// DefaultRoot returns the cache directory Run uses unless WithCache says
+// otherwise: $GRANULAR_TESTCACHE if set, or granular/testcache under the
+// user cache directory.
+func DefaultRoot() (string, error) {
+	if root := os.Getenv(RootEnv); root != "" {
+		return root, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("testcache: no cache directory, set %s: %w", RootEnv, err)
+	}
+	return filepath.Join(dir, "granular", "testcache"), nil
+}
+
+type options struct {
+	cache  *granular.Cache
+	stderr io.Writer
+}
+
+// Option configures Run.
+type Option func(*options)
+
+// WithCache stores fixtures in cache instead of the cache at DefaultRoot.
+//
+// Example:
+//
+//	os.Exit(testcache.Run(m, fixtures, testcache.WithCache(cache)))
+func WithCache(cache *granular.Cache) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
+// WithStderr sets where Run reports fixtures that fail to build. The
+// default is os.Stderr.
+//
+// Example:
+//
+//	code := testcache.Run(m, fixtures, testcache.WithStderr(&buf))
+func WithStderr(w io.Writer) Option {
+	return func(o *options) {
+		o.stderr = w
+	}
+}
+
+// M is the part of testing.M that Run uses.
+type M interface {
+	Run() int
+}
+
+// Run prepares the fixtures, runs the tests, removes the fixtures' copies,
+// and returns the exit code for os.Exit. If a fixture cannot be prepared,
+// the error is reported and no tests run.
+func Run(m M, fixtures []*Fixture, opts ...Option) int {
+	o := options{stderr: os.Stderr}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cache := o.cache
+	if cache == nil {
+		root, err := DefaultRoot()
+		if err == nil {
+			cache, err = granular.Open(root)
+		}
+		if err != nil {
+			fmt.Fprintf(o.stderr, "testcache: %v\n", err)
+			return 1
+		}
+		defer func() { _ = cache.Close() }()
+	}
+
+	defer func() {
+		for _, f := range fixtures {
+			_ = f.Remove()
+		}
+	}()
+	var wg sync.WaitGroup
+	errs := make([]error, len(fixtures))
+	for i, f := range fixtures {
+		wg.Go(func() {
+			if err := f.Prepare(context.Background(), cache); err != nil {
+				errs[i] = fmt.Errorf("fixture %s: %w", f.Name, err)
+			}
+		})
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintf(o.stderr, "testcache: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// Key returns the key the fixture is stored under.
+func (f *Fixture) Key(cache *granular.Cache) granular.Key {
+	kb := cache.Key().String("testcache.fixture", f.Name)
+	if f.Inputs != nil {
+		f.Inputs(kb)
+	}
+	return kb.Build()
+}
+
+// Prepare restores the fixture from cache into a new temporary directory,
+// building and caching it first on a miss. Run calls it for every fixture;
+// call it directly to manage fixtures without Run, and Remove when done.
+func (f *Fixture) Prepare(ctx context.Context, cache *granular.Cache) error {
+	if f.Name == "" {
+		return errors.New("testcache: fixture has no name")
+	}
+	if f.Build == nil {
+		return fmt.Errorf("testcache: fixture %s has no Build function", f.Name)
+	}
+	dir, err := os.MkdirTemp("", "testcache-"+f.Name+"-")
+	if err != nil {
+		return err
+	}
+	if err := f.prepare(ctx, cache, dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	f.dir = dir
+	return nil
+}
+
+func (f *Fixture) prepare(ctx context.Context, cache *granular.Cache, dir string) error {
+	key := f.Key(cache)
+	result, unlock, err := cache.GetOrLock(ctx, key)
+	if err == nil {
+		f.cached = true
+		return restore(result, dir)
+	}
+	if !errors.Is(err, granular.ErrCacheMiss) {
+		return err
+	}
+	defer unlock()
+
+	if err := f.Build(dir); err != nil {
+		return err
+	}
+	out := cache.Put(key).Meta("testcache.fixture", f.Name)
+	if entries, err := os.ReadDir(dir); err != nil {
+		return err
+	} else if len(entries) > 0 {
+		out.Glob(dir, filepath.Join(dir, "**"))
+	}
+	return out.Commit()
+}
+
+// Remove deletes the fixture's copy for this run.
+func (f *Fixture) Remove() error {
+	if f.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(f.dir)
+	f.dir = ""
+	return err
+}
+
+// restore copies every file of a cached fixture into dir.
+func restore(result *granular.Result, dir string) error {
+	for name := range result.FileNames() {
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("%w: fixture file %q escapes its directory", granular.ErrCacheCorrupted, name)
+		}
+		if err := result.CopyFile(name, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
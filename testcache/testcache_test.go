@@ -0,0 +1,124 @@
+package testcache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+)
+
+// fakeM records the fixtures' state when the tests run.
+type fakeM struct {
+	run  func()
+	code int
+}
+
+func (m *fakeM) Run() int {
+	if m.run != nil {
+		m.run()
+	}
+	return m.code
+}
+
+func TestRun(t *testing.T) {
+	cache, err := granular.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("schema.sql", []byte("create table t"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	builds := 0
+	newFixture := func() *Fixture {
+		return &Fixture{
+			Name:   "db",
+			Inputs: func(kb *granular.KeyBuilder) { kb.File("schema.sql") },
+			Build: func(dir string) error {
+				builds++
+				schema, err := os.ReadFile("schema.sql")
+				if err != nil {
+					return err
+				}
+				if err := os.Mkdir(filepath.Join(dir, "data"), 0o755); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(dir, "data", "test.db"), schema, 0o644)
+			},
+		}
+	}
+	run := func(f *Fixture) (contents string, dir string) {
+		t.Helper()
+		m := &fakeM{code: 3, run: func() {
+			data, err := os.ReadFile(f.Path("data/test.db"))
+			if err != nil {
+				t.Errorf("fixture not prepared: %v", err)
+			}
+			contents, dir = string(data), f.Dir()
+			// Tests may modify their copy without affecting the cache
+			_ = os.WriteFile(f.Path("data/test.db"), []byte("modified"), 0o644)
+		}}
+		if code := Run(m, []*Fixture{f}, WithCache(cache)); code != 3 {
+			t.Fatalf("Run = %d, want the tests' exit code 3", code)
+		}
+		return contents, dir
+	}
+
+	f := newFixture()
+	got, dir := run(f)
+	if got != "create table t" || builds != 1 || f.Cached() {
+		t.Errorf("first run read %q after %d builds (cached %v)", got, builds, f.Cached())
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) || f.Dir() != "" {
+		t.Errorf("fixture copy %s not removed after the tests: %v", dir, err)
+	}
+
+	f = newFixture()
+	if got, _ := run(f); got != "create table t" || builds != 1 || !f.Cached() {
+		t.Errorf("second run read %q after %d builds (cached %v), want a restored copy", got, builds, f.Cached())
+	}
+
+	// Changing an input builds the fixture again
+	if err := os.WriteFile("schema.sql", []byte("create table u"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := run(newFixture()); got != "create table u" || builds != 2 {
+		t.Errorf("run after an input change read %q after %d builds", got, builds)
+	}
+}
+
+func TestRun_BuildError(t *testing.T) {
+	cache, err := granular.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	failing := &Fixture{Name: "assets", Build: func(string) error { return errors.New("compiler crashed") }}
+	ok := &Fixture{Name: "empty", Build: func(string) error { return nil }}
+	var stderr bytes.Buffer
+	ran := false
+	m := &fakeM{run: func() { ran = true }}
+	if code := Run(m, []*Fixture{failing, ok}, WithCache(cache), WithStderr(&stderr)); code != 1 || ran {
+		t.Errorf("Run = %d (tests ran: %v), want 1 without running the tests", code, ran)
+	}
+	if !strings.Contains(stderr.String(), "fixture assets: compiler crashed") {
+		t.Errorf("stderr = %q, want the build error", stderr.String())
+	}
+	if ok.Dir() != "" {
+		t.Error("fixture copies not removed after a failed run")
+	}
+	if _, err := cache.Get(failing.Key(cache)); !errors.Is(err, granular.ErrCacheMiss) {
+		t.Errorf("failed fixture was cached: %v", err)
+	}
+
+	// An empty fixture is cached too
+	if err := ok.Prepare(t.Context(), cache); err != nil || !ok.Cached() {
+		t.Errorf("Prepare of an empty fixture = %v (cached %v), want a hit", err, ok.Cached())
+	}
+	_ = ok.Remove()
+}
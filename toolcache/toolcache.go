@@ -0,0 +1,292 @@
+// Package toolcache caches the results of external commands in a granular
+// cache.
+//
+// Linters, code generators, and test runners are expensive to run but
+// deterministic: given the same tool, arguments, and input files, they print
+// the same output, exit with the same code, and write the same files. Run
+// keys a command on exactly those, and on a hit replays its output and
+// restores its output files without running it:
+//
+//	res, err := toolcache.Run(ctx, cache, toolcache.Spec{
+//		Cmd:         "protoc",
+//		Args:        []string{"--go_out=gen", "api.proto"},
+//		Inputs:      []string{"api.proto"},
+//		OutputGlobs: []string{"gen/**/*.go"},
+//		Stdout:      os.Stdout,
+//		Stderr:      os.Stderr,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	os.Exit(res.ExitCode)
+//
+// Commands run on the operating system, so paths in Inputs and OutputGlobs
+// are read through the cache's filesystem when hashing but through the
+// operating system when collecting and restoring outputs; the cache should
+// use the default OS filesystem.
+package toolcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/gophersatwork/granular"
+	"github.com/gophersatwork/granular/hashing"
+	"github.com/spf13/afero"
+)
+
+// Spec describes a command to run.
+type Spec struct {
+	// Cmd is the tool to run: a path, relative to Dir, or a name looked up
+	// in PATH. The contents of the tool binary are part of the key, so
+	// upgrading the tool invalidates its results.
+	Cmd string
+
+	// Args are the command's arguments. They are part of the key.
+	Args []string
+
+	// Dir is the working directory of the command; empty means the current
+	// directory. Relative paths in Cmd, Inputs, and OutputGlobs are resolved
+	// against it. The paths of input files are part of the key, so results
+	// are shared between checkouts in different places only if the cache
+	// hashes paths relative to a base (see granular.WithBasePath).
+	Dir string
+
+	// Env holds extra environment variables, in "KEY=value" form, added to
+	// the environment of the current process. They are part of the key;
+	// the inherited environment is not.
+	Env []string
+
+	// Inputs are glob patterns (supporting **) of the files the command
+	// reads, relative to Dir unless absolute. Their contents are part of
+	// the key.
+	Inputs []string
+
+	// OutputGlobs are glob patterns of the files the command writes,
+	// relative to Dir unless absolute. Files matching them when the command
+	// exits are cached, and restored on a hit to the same paths relative to
+	// Dir.
+	OutputGlobs []string
+
+	// Stdout and Stderr, if set, receive the command's output as it runs,
+	// or the cached output on a hit.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Result is the outcome of a command, whether it ran or was cached.
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	Outputs  []string // Files matching OutputGlobs, resolved against Dir, sorted
+	Cached   bool     // Whether the result came from the cache
+	KeyHash  string
+}
+
+// Names under which results are stored.
+const (
+	stdoutName   = "stdout"
+	stderrName   = "stderr"
+	outputsName  = "outputs"
+	exitCodeMeta = "toolcache.exitCode"
+)
+
+// outputName is the cached file name of the i-th output file; output paths
+// are stored separately because names cannot contain path separators.
+func outputName(i int) string {
+	return "output." + strconv.Itoa(i)
+}
+
+// Run runs the command described by spec, or replays it from the cache if
+// the tool, arguments, extra environment, and input files are unchanged.
+//
+// Every completed run is cached, whatever its exit code: a lint failure is
+// as reproducible as a success. A non-zero exit code is reported in
+// Result.ExitCode, not as an error. Run returns an error if the command
+// cannot be started, the context is canceled (nothing is cached then), or
+// the cache cannot be read. If the command ran but its result could not be
+// stored, Run returns both the result and the error.
+//
+// Concurrent runs of the same command, in this or other processes sharing
+// the cache directory, run it once: the others wait for the first to finish
+// (see granular.Cache.GetOrLock) and then replay its cached result.
+func Run(ctx context.Context, cache *granular.Cache, spec Spec) (*Result, error) {
+	cmd := spec.Cmd
+	if filepath.Base(cmd) != cmd {
+		cmd = resolve(spec.Dir, cmd)
+	}
+	bin, err := exec.LookPath(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if bin, err = filepath.Abs(bin); err != nil {
+		return nil, err
+	}
+	args, err := json.Marshal(spec.Args)
+	if err != nil {
+		return nil, err
+	}
+	env, err := json.Marshal(spec.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	kb := cache.Key().
+		File(bin).
+		String("toolcache.cmd", filepath.Base(bin)).
+		String("toolcache.args", string(args)).
+		String("toolcache.env", string(env))
+	for _, pattern := range spec.Inputs {
+		kb.Glob(resolve(spec.Dir, pattern))
+	}
+	key := kb.Build()
+	keyHash, err := key.HashErr()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, unlock, err := cache.GetOrLock(ctx, key)
+	if err == nil {
+		return replay(cached, spec, keyHash)
+	}
+	if !errors.Is(err, granular.ErrCacheMiss) {
+		return nil, err
+	}
+	defer unlock()
+
+	res, err := execute(ctx, bin, spec)
+	if err != nil {
+		return nil, err
+	}
+	res.KeyHash = keyHash
+
+	// Store output paths relative to Dir, so they are restored into the
+	// directory of the command that replays them
+	stored := make([]string, len(res.Outputs))
+	for i, path := range res.Outputs {
+		stored[i] = path
+		if rel, err := filepath.Rel(spec.Dir, path); spec.Dir != "" && err == nil && filepath.IsLocal(rel) {
+			stored[i] = rel
+		}
+	}
+	outputs, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+	out := cache.Put(key).
+		Bytes(stdoutName, res.Stdout).
+		Bytes(stderrName, res.Stderr).
+		Bytes(outputsName, outputs).
+		Meta(exitCodeMeta, strconv.Itoa(res.ExitCode))
+	for i, path := range res.Outputs {
+		out.File(outputName(i), path)
+	}
+	if err := out.Commit(); err != nil {
+		return res, fmt.Errorf("failed to cache result: %w", err)
+	}
+	return res, nil
+}
+
+// execute runs the command and collects its outputs.
+func execute(ctx context.Context, bin string, spec Spec) (*Result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, spec.Args...)
+	cmd.Dir = spec.Dir
+	cmd.Env = append(os.Environ(), spec.Env...)
+	cmd.Stdout = teeTo(&stdout, spec.Stdout)
+	cmd.Stderr = teeTo(&stderr, spec.Stderr)
+
+	res := &Result{}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		exitErr, ok := errors.AsType[*exec.ExitError](err)
+		if !ok {
+			return nil, err
+		}
+		res.ExitCode = exitErr.ExitCode()
+	}
+	res.Stdout, res.Stderr = stdout.Bytes(), stderr.Bytes()
+
+	fs := afero.NewOsFs()
+	for _, pattern := range spec.OutputGlobs {
+		matches, err := hashing.ExpandGlob(fs, resolve(spec.Dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("output glob %s: %w", pattern, err)
+		}
+		res.Outputs = append(res.Outputs, matches...)
+	}
+	slices.Sort(res.Outputs)
+	res.Outputs = slices.Compact(res.Outputs)
+	return res, nil
+}
+
+// replay restores a cached result: it writes the cached output to the
+// spec's writers and copies the output files back into place.
+func replay(cached *granular.Result, spec Spec, keyHash string) (*Result, error) {
+	res := &Result{Cached: true, KeyHash: keyHash}
+	var err error
+	if res.Stdout, err = cached.BytesErr(stdoutName); err != nil {
+		return nil, err
+	}
+	if res.Stderr, err = cached.BytesErr(stderrName); err != nil {
+		return nil, err
+	}
+	if res.ExitCode, err = strconv.Atoi(cached.Meta(exitCodeMeta)); err != nil {
+		return nil, fmt.Errorf("entry %s has no exit code: %w", keyHash, err)
+	}
+	outputs, err := cached.BytesErr(outputsName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(outputs, &res.Outputs); err != nil {
+		return nil, fmt.Errorf("entry %s has invalid outputs: %w", keyHash, err)
+	}
+
+	for i, path := range res.Outputs {
+		res.Outputs[i] = resolve(spec.Dir, path)
+		if err := cached.CopyFile(outputName(i), res.Outputs[i]); err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(res.Outputs)
+	if spec.Stdout != nil {
+		if _, err := spec.Stdout.Write(res.Stdout); err != nil {
+			return nil, err
+		}
+	}
+	if spec.Stderr != nil {
+		if _, err := spec.Stderr.Write(res.Stderr); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// resolve returns path resolved against dir, unless dir is empty or path
+// is absolute.
+func resolve(dir, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// teeTo returns a writer to buf and w, or to buf alone if w is nil.
+func teeTo(buf *bytes.Buffer, w io.Writer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, w)
+}
@@ -0,0 +1,199 @@
+package toolcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophersatwork/granular"
+)
+
+// The test binary doubles as the tool: with TOOLCACHE_HELPER set, it
+// uppercases its first argument into its second, logs the run to
+// TOOLCACHE_LOG, and exits with the code in TOOLCACHE_EXIT.
+func TestMain(m *testing.M) {
+	if os.Getenv("TOOLCACHE_HELPER") == "" {
+		os.Exit(m.Run())
+	}
+	f, err := os.OpenFile(os.Getenv("TOOLCACHE_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		fmt.Fprintln(f, "run")
+		f.Close()
+	}
+	in, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := os.WriteFile(os.Args[2], []byte(strings.ToUpper(string(in))), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Printf("converted %s\n", filepath.Base(os.Args[1]))
+	fmt.Fprintln(os.Stderr, "warning: nothing to do")
+	code := 0
+	fmt.Sscan(os.Getenv("TOOLCACHE_EXIT"), &code)
+	os.Exit(code)
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := granular.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	input, output, log := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out", "in.TXT"), filepath.Join(dir, "runs.log")
+	writeFile(t, input, "hello")
+	if err := os.Mkdir(filepath.Dir(output), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := func(exit string) Spec {
+		return Spec{
+			Cmd:         os.Args[0],
+			Args:        []string{input, output},
+			Env:         []string{"TOOLCACHE_HELPER=1", "TOOLCACHE_LOG=" + log, "TOOLCACHE_EXIT=" + exit},
+			Inputs:      []string{filepath.Join(dir, "*.txt")},
+			OutputGlobs: []string{filepath.Join(dir, "out", "*")},
+		}
+	}
+	run := func(s Spec, wantCached bool) *Result {
+		t.Helper()
+		var stdout strings.Builder
+		s.Stdout = &stdout
+		res, err := Run(t.Context(), cache, s)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if res.Cached != wantCached {
+			t.Errorf("Cached = %v, want %v", res.Cached, wantCached)
+		}
+		if got := stdout.String(); got != "converted in.txt\n" || string(res.Stdout) != got {
+			t.Errorf("stdout = %q, Result.Stdout = %q", got, res.Stdout)
+		}
+		if string(res.Stderr) != "warning: nothing to do\n" {
+			t.Errorf("stderr = %q", res.Stderr)
+		}
+		if len(res.Outputs) != 1 || res.Outputs[0] != output {
+			t.Errorf("Outputs = %v, want [%s]", res.Outputs, output)
+		}
+		if got, _ := os.ReadFile(output); string(got) != strings.ToUpper(readFile(t, input)) {
+			t.Errorf("output file = %q", got)
+		}
+		return res
+	}
+
+	first := run(spec("0"), false)
+
+	// A hit replays the output and restores the output files
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	if second := run(spec("0"), true); second.KeyHash != first.KeyHash {
+		t.Errorf("KeyHash changed between runs")
+	}
+
+	// Changing an input or the environment reruns the command, and failures
+	// are cached like successes
+	writeFile(t, input, "changed")
+	run(spec("0"), false)
+	if res := run(spec("3"), false); res.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want 3", res.ExitCode)
+	}
+	if res := run(spec("3"), true); res.ExitCode != 3 {
+		t.Errorf("cached ExitCode = %d, want 3", res.ExitCode)
+	}
+
+	if runs := strings.Count(readFile(t, log), "run"); runs != 3 {
+		t.Errorf("tool ran %d times, want 3", runs)
+	}
+}
+
+func TestRun_Dir(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := granular.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	work := filepath.Join(dir, "work")
+	if err := os.MkdirAll(filepath.Join(work, "out"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(work, "in.txt"), "hello")
+	output := filepath.Join(work, "out", "in.TXT")
+
+	// Relative paths are resolved against Dir, not the current directory
+	spec := Spec{
+		Cmd:         os.Args[0],
+		Args:        []string{"in.txt", filepath.Join("out", "in.TXT")},
+		Dir:         work,
+		Env:         []string{"TOOLCACHE_HELPER=1"},
+		Inputs:      []string{"*.txt"},
+		OutputGlobs: []string{"out/*"},
+	}
+	run := func(wantCached bool) {
+		t.Helper()
+		res, err := Run(t.Context(), cache, spec)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if res.Cached != wantCached {
+			t.Errorf("Cached = %v, want %v", res.Cached, wantCached)
+		}
+		if len(res.Outputs) != 1 || res.Outputs[0] != output {
+			t.Errorf("Outputs = %v, want [%s]", res.Outputs, output)
+		}
+		if got, want := readFile(t, output), strings.ToUpper(readFile(t, filepath.Join(work, "in.txt"))); got != want {
+			t.Errorf("output file = %q, want %q", got, want)
+		}
+	}
+	run(false)
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	run(true)
+	writeFile(t, filepath.Join(work, "in.txt"), "changed")
+	run(false)
+}
+
+func TestRun_Errors(t *testing.T) {
+	cache, err := granular.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := Run(t.Context(), cache, Spec{Cmd: "granular-no-such-tool"}); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Run of a missing tool = %v, want ErrNotFound", err)
+	}
+
+	// A canceled run is not cached
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	spec := Spec{Cmd: os.Args[0], Args: []string{"a", "b"}, Env: []string{"TOOLCACHE_HELPER=1"}}
+	if _, err := Run(ctx, cache, spec); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run with canceled context = %v, want context.Canceled", err)
+	}
+	if stats, _ := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("canceled run stored %d entries", stats.Entries)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
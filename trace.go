@@ -0,0 +1,145 @@
+package granular
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/gophersatwork/granular/hashing"
+)
+
+// KeyTrace records how a key hash was computed: every input in the order it
+// was hashed, the files each one read, and the digest of the key so far
+// after each input. Comparing the traces of two machines that compute
+// different hashes for the same key finds the first input, and the file
+// within it, where they diverge.
+type KeyTrace struct {
+	Hash      string            // Final key hash
+	HashAlgo  string            // Hash algorithm of the cache
+	Namespace string            // Namespace of the cache view, hashed before the inputs
+	Salted    bool              // Whether a cache salt was hashed before the inputs
+	Inputs    []InputTrace      // Inputs in hashing order
+	Extras    map[string]string // Components added with String, Version, and Env, hashed last
+}
+
+// InputTrace records the hashing of one key input.
+type InputTrace struct {
+	Desc   string      // Input descriptor, as hashed (e.g. "glob:**/*.go")
+	Files  []FileTrace // Files read, in hashing order
+	Digest string      // Digest of the key after this input
+}
+
+// FileTrace records one file read by a key input.
+type FileTrace struct {
+	Path   string // Path of the file as read
+	Bytes  int64  // Number of content bytes hashed
+	Digest string // Digest of the file's content alone, with the cache's hash algorithm; empty for a repeat
+	Repeat bool   // Whether an earlier input already hashed the file, so it was not read again
+}
+
+// Trace computes the hash of the key like HashErr, recording each step.
+// Every file is read twice, to digest its content on its own, so Trace is
+// meant for debugging rather than routine use. The digest of the key after
+// an input depends on everything hashed before it: the first input whose
+// digest differs between two traces is where they diverge, and its file
+// digests tell which file differs. If the file digests all match, the
+// difference lies in the paths, the namespace, the salt, or the hash
+// algorithm.
+//
+// Example:
+//
+//	trace, err := key.Trace()
+//	if err != nil {
+//		return err
+//	}
+//	trace.WriteTo(os.Stderr)
+func (k Key) Trace() (*KeyTrace, error) {
+	if k.cache == nil {
+		return nil, fmt.Errorf("key was not built by a cache")
+	}
+	trace := &KeyTrace{
+		HashAlgo:  k.cache.hashAlgoName,
+		Namespace: k.cache.namespace,
+		Salted:    k.cache.salt != "",
+		Extras:    maps.Clone(k.extras),
+	}
+	keyHash, err := k.hashInputs(trace)
+	if err != nil {
+		return nil, err
+	}
+	trace.Hash = keyHash
+	return trace, nil
+}
+
+// WriteTo writes the trace in a line-oriented text form suited to diff:
+//
+//	key 3f2a... (xxhash64)
+//	input glob:src/**/*.go
+//	  file src/main.go 1204 bytes 9c1e...
+//	  digest 51d0...
+//	extra version=1.2.3
+func (t *KeyTrace) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	fmt.Fprintf(cw, "key %s (%s)\n", t.Hash, t.HashAlgo)
+	if t.Namespace != "" {
+		fmt.Fprintf(cw, "namespace %s\n", t.Namespace)
+	}
+	if t.Salted {
+		fmt.Fprintln(cw, "salted")
+	}
+	for _, in := range t.Inputs {
+		fmt.Fprintf(cw, "input %s\n", in.Desc)
+		for _, f := range in.Files {
+			if f.Repeat {
+				fmt.Fprintf(cw, "  file %s repeat\n", f.Path)
+				continue
+			}
+			fmt.Fprintf(cw, "  file %s %d bytes %s\n", f.Path, f.Bytes, f.Digest)
+		}
+		fmt.Fprintf(cw, "  digest %s\n", in.Digest)
+	}
+	for _, name := range slices.Sorted(maps.Keys(t.Extras)) {
+		fmt.Fprintf(cw, "extra %s=%s\n", name, t.Extras[name])
+	}
+	return cw.n, cw.err
+}
+
+// traceFiles makes hs record every file it hashes in the last input of
+// trace, along with a digest of the file's content on its own.
+func (c *Cache) traceFiles(hs *hashing.Hasher, trace *KeyTrace) {
+	onFile := hs.OnFile
+	hs.OnFile = func(ev hashing.FileEvent) {
+		if onFile != nil {
+			onFile(ev)
+		}
+		f := FileTrace{Path: ev.Path, Bytes: ev.Bytes, Repeat: ev.Repeat}
+		if !f.Repeat {
+			h := c.newHash()
+			if err := hashing.File(h, c.fs, ev.Path); err != nil {
+				f.Digest = "error: " + err.Error()
+			} else {
+				f.Digest = hashing.Sum(h)
+			}
+		}
+		in := &trace.Inputs[len(trace.Inputs)-1]
+		in.Files = append(in.Files, f)
+	}
+}
+
+// countingWriter counts the bytes written to w and keeps the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,88 @@
+package granular
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestKeyTrace(t *testing.T) {
+	// Two machines whose checkouts differ in one file of a glob
+	traceOn := func(content string) *KeyTrace {
+		t.Helper()
+		fs := afero.NewMemMapFs()
+		for path, data := range map[string]string{"/src/a.go": "package a", "/src/b.go": content, "/go.mod": "module m"} {
+			if err := afero.WriteFile(fs, path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cache, err := Open("/cache", WithFs(fs))
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		t.Cleanup(func() { _ = cache.Close() })
+		key := cache.Key().File("/go.mod").Glob("/src/*.go").Version("1").Build()
+		trace, err := key.Trace()
+		if err != nil {
+			t.Fatalf("Trace failed: %v", err)
+		}
+		if trace.Hash != key.Hash() {
+			t.Errorf("Trace hash = %s, want the key hash %s", trace.Hash, key.Hash())
+		}
+		return trace
+	}
+	local, remote := traceOn("package b"), traceOn("package b // changed")
+
+	if len(local.Inputs) != 2 || local.Inputs[0].Desc != "file:/go.mod" || len(local.Inputs[1].Files) != 2 {
+		t.Fatalf("unexpected trace: %+v", local)
+	}
+	if f := local.Inputs[1].Files[1]; f.Path != "/src/b.go" || f.Bytes != int64(len("package b")) || f.Digest == "" {
+		t.Errorf("file trace = %+v", f)
+	}
+	if local.Inputs[0].Digest != remote.Inputs[0].Digest {
+		t.Error("digests differ before the divergent input")
+	}
+	if local.Inputs[1].Digest == remote.Inputs[1].Digest {
+		t.Error("digests match after the divergent input")
+	}
+	if local.Inputs[1].Files[0].Digest != remote.Inputs[1].Files[0].Digest || local.Inputs[1].Files[1].Digest == remote.Inputs[1].Files[1].Digest {
+		t.Error("file digests do not single out the divergent file")
+	}
+
+	var buf strings.Builder
+	n, err := local.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo = %d, %v; wrote %d bytes", n, err, buf.Len())
+	}
+	for _, want := range []string{
+		"key " + local.Hash + " (" + DefaultHashAlgoName + ")\n",
+		"input glob:/src/*.go\n  file /src/a.go 9 bytes " + local.Inputs[1].Files[0].Digest + "\n",
+		"  digest " + local.Inputs[1].Digest + "\n",
+		"extra version=1\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("trace missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestKeyTrace_Repeat(t *testing.T) {
+	_, memFs, tempDir := setupTestCache(t, "granular-trace-repeat-test")
+	cache, err := Open(tempDir, WithFs(memFs), WithSkipRepeatedFiles())
+	assertNoError(t, err, "Open")
+	path := tempDir + "/main.go"
+	createTestFile(t, memFs, path, []byte("package main"))
+
+	trace, err := cache.Key().File(path).Glob(tempDir + "/*.go").Build().Trace()
+	assertNoError(t, err, "Trace")
+	files := trace.Inputs[1].Files
+	if len(files) != 1 || !files[0].Repeat || files[0].Digest != "" {
+		t.Errorf("second input files = %+v, want a repeat of main.go", files)
+	}
+	var buf strings.Builder
+	_, _ = trace.WriteTo(&buf)
+	if !strings.Contains(buf.String(), "  file "+path+" repeat\n") {
+		t.Errorf("trace does not mark the repeat:\n%s", buf.String())
+	}
+}
@@ -84,6 +84,10 @@ func (c *Cache) Export(w io.Writer) error {
 		if relPath == "." {
 			return nil
 		}
+		// The index describes this cache only; the importer rebuilds its own
+		if relPath == indexFileName {
+			return nil
+		}
 
 		// Create tar header
 		header, err := tar.FileInfoHeader(info, "")
@@ -150,10 +154,13 @@ func (c *Cache) Import(r io.Reader) error {
 		if err != nil {
 			return err
 		}
+		if targetPath == c.indexPath() {
+			continue // never trust an index from another cache
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := c.fs.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
+			if err := c.mkdirAll(targetPath); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
 			}
 		case tar.TypeReg:
@@ -164,14 +171,14 @@ func (c *Cache) Import(r io.Reader) error {
 			}
 
 			// Ensure parent directory exists
-			if err := c.fs.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
+			if err := c.mkdirAll(filepath.Dir(targetPath)); err != nil {
 				return fmt.Errorf("failed to create parent directory: %w", err)
 			}
 
 			// Use atomic write (tmp + rename) to avoid partial files on crash.
 			// Limit copy to declared header size to prevent oversized streams.
 			tmpPath := targetPath + ".tmp." + randomSuffix()
-			file, err := c.fs.Create(tmpPath)
+			file, err := c.createFile(tmpPath)
 			if err != nil {
 				return fmt.Errorf("failed to create file %s: %w", targetPath, err)
 			}
@@ -210,5 +217,8 @@ func (c *Cache) Import(r io.Reader) error {
 
 	c.cleanupCorrupted(corruptedKeys)
 
+	if walkErr == nil && c.index != nil {
+		return c.rebuildIndex()
+	}
 	return walkErr
 }
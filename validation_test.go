@@ -3,9 +3,12 @@ package granular
 import (
 	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/gophersatwork/granular/internal/format"
 	"github.com/spf13/afero"
 )
 
@@ -278,6 +281,31 @@ func TestKeyBuilder_GlobValidation(t *testing.T) {
 			t.Error("Hash should not be empty even for zero matches")
 		}
 	})
+
+	t.Run("GlobRequire with enough matches", func(t *testing.T) {
+		kb := cache.Key().GlobRequire("src/*.go", 2)
+		if errs := kb.Errors(); errs != nil {
+			t.Fatalf("Unexpected errors: %v", errs)
+		}
+		if kb.Hash() != cache.Key().Glob("src/*.go").Hash() {
+			t.Error("GlobRequire should hash like Glob")
+		}
+	})
+
+	t.Run("GlobRequire with too few matches", func(t *testing.T) {
+		_, err := cache.Key().GlobRequire("sr/*.go", 1).Build().computeHash()
+		ve, ok := errors.AsType[*ValidationError](err)
+		if !ok {
+			t.Fatalf("Expected *ValidationError, got %v", err)
+		}
+		if len(ve.Errors) != 1 || !strings.Contains(ve.Errors[0].Error(), "matched 0 files") {
+			t.Errorf("Unexpected errors: %v", ve.Errors)
+		}
+
+		if errs := cache.Key().GlobRequire("src/*.go", 3).Errors(); len(errs) != 1 {
+			t.Errorf("Expected 1 error for 2 of 3 required matches, got %v", errs)
+		}
+	})
 }
 
 // TestKeyBuilder_DirValidation tests Dir() validation errors
@@ -502,6 +530,29 @@ func TestKeyBuilder_HashErrorPropagation(t *testing.T) {
 			t.Error("Hash() should return non-empty string for valid input")
 		}
 	})
+
+	t.Run("HashErr() and Errors() report the validation error", func(t *testing.T) {
+		kb := cache.Key().File("missing.txt")
+		if errs := kb.Errors(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "missing.txt") {
+			t.Errorf("Errors() = %v, want the missing file", errs)
+		}
+		_, err := kb.HashErr()
+		if _, ok := errors.AsType[*ValidationError](err); !ok {
+			t.Errorf("KeyBuilder.HashErr() error = %v, want ValidationError", err)
+		}
+		if _, err := kb.Build().HashErr(); err == nil {
+			t.Error("Key.HashErr() should return the validation error")
+		}
+
+		valid := cache.Key().File("valid.txt")
+		if errs := valid.Errors(); errs != nil {
+			t.Errorf("Errors() = %v for valid input, want nil", errs)
+		}
+		hash, err := valid.HashErr()
+		if err != nil || hash != valid.Hash() {
+			t.Errorf("HashErr() = %q, %v; want %q", hash, err, valid.Hash())
+		}
+	})
 }
 
 // TestKeyBuilder_ValidateMultipleExcludePatterns tests multiple exclude patterns in Dir()
@@ -658,6 +709,33 @@ func TestMaxDataSize(t *testing.T) {
 		t.Fatalf("expected 'exceeds max size' error, got: %v", err)
 	}
 
+	// Streaming hands out no byte past the limit
+	rc, err := result.Open("big")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	streamed, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err == nil || len(streamed) != 50 {
+		t.Fatalf("streamed %d bytes with error %v, want the 50 allowed and an error", len(streamed), err)
+	}
+	key3 := cache.Key().String("test", "exact").Build()
+	if err := cache.Put(key3).Bytes("exact", bigData[:50]).Commit(); err != nil {
+		t.Fatalf("Put data at the limit failed: %v", err)
+	}
+	result3, err := cache.Get(key3)
+	if err != nil {
+		t.Fatalf("Get data at the limit failed: %v", err)
+	}
+	if rc, err = result3.Open("exact"); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	streamed, err = io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil || len(streamed) != 50 {
+		t.Fatalf("streamed %d bytes with error %v, want all 50", len(streamed), err)
+	}
+
 	// Data within the limit should work
 	smallData := []byte("small")
 	key2 := cache.Key().String("test", "small").Build()
@@ -680,6 +758,43 @@ func TestMaxDataSize(t *testing.T) {
 	}
 }
 
+// TestMaxInlineSize tests that Commit rejects entries whose manifest values
+// exceed the limit, while large data outside the manifest is unaffected.
+func TestMaxInlineSize(t *testing.T) {
+	cache, err := Open(".cache", WithFs(afero.NewMemMapFs()), WithMaxInlineSize(100))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer cache.Close()
+	key := cache.Key().String("test", "inline").Build()
+
+	// Data is stored beside the manifest, so its size does not count
+	err = cache.Put(key).Bytes("big", make([]byte, 1000)).Meta("small", "value").Commit()
+	assertNoError(t, err, "Commit with large data")
+
+	large := strings.Repeat("x", 100)
+	for name, wb := range map[string]*WriteBuilder{
+		"metadata":    cache.Put(key).Meta("report", large),
+		"tags":        cache.Put(key).Tag("team", large),
+		"description": cache.Put(key).Describe(large),
+		"key":         cache.Put(cache.Key().String("config", large).Build()),
+	} {
+		err := wb.Commit()
+		if !errors.Is(err, ErrManifestTooLarge) || !strings.Contains(err.Error(), "Bytes") {
+			t.Errorf("%s over the limit: got %v, want ErrManifestTooLarge with guidance", name, err)
+		}
+	}
+
+	// A negative limit removes it
+	unlimited, err := Open(".cache", WithFs(afero.NewMemMapFs()), WithMaxInlineSize(-1))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer unlimited.Close()
+	err = unlimited.Put(unlimited.Key().String("test", "inline").Build()).Meta("report", strings.Repeat("x", 2*DefaultMaxInlineSize)).Commit()
+	assertNoError(t, err, "Commit without a limit")
+}
+
 // TestEmptyKey tests that a key with no inputs produces a validation error.
 func TestEmptyKey(t *testing.T) {
 	fs := afero.NewMemMapFs()
@@ -733,6 +848,7 @@ func TestWriteBuilder_NameInjection(t *testing.T) {
 
 	badNames := []string{
 		"../escape",
+		"../../manifests/x",
 		"foo/bar",
 		"foo\\bar",
 		"..\\escape",
@@ -804,6 +920,54 @@ func TestWriteBuilder_NameInjection(t *testing.T) {
 	})
 }
 
+// TestGet_TamperedManifestPaths tests that Get rejects manifests whose names
+// or object paths escape the entry's object directory, even when the output
+// hash is not verified.
+func TestGet_TamperedManifestPaths(t *testing.T) {
+	tamper := map[string]func(m *format.Manifest){
+		"data name": func(m *format.Manifest) {
+			m.OutputData["../../manifests/x"] = m.OutputData["config"]
+		},
+		"file name": func(m *format.Manifest) {
+			m.OutputFiles["../escape"] = m.OutputFiles["output"]
+		},
+		"file path": func(m *format.Manifest) {
+			m.OutputFiles["output"] = "/etc/passwd"
+		},
+		"data path": func(m *format.Manifest) {
+			m.OutputData["config"] = filepath.Join(filepath.Dir(m.OutputData["config"]), "..", "..", "..", "manifests", "x.dat")
+		},
+	}
+	for name, fn := range tamper {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			cache, err := Open("/cache", WithFs(fs), WithVerifyOnGet(false))
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			afero.WriteFile(fs, "/src/test.txt", []byte("hello"), 0o644)
+			key := cache.Key().String("test", "value").Build()
+			if err := cache.Put(key).File("output", "/src/test.txt").Bytes("config", []byte("data")).Commit(); err != nil {
+				t.Fatalf("Commit failed: %v", err)
+			}
+
+			mPath, _ := cache.manifestPath(key.Hash())
+			data, _ := afero.ReadFile(fs, mPath)
+			m, err := format.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			fn(m)
+			data, _ = format.Marshal(m)
+			afero.WriteFile(fs, mPath, data, 0o644)
+
+			if _, err := cache.Get(key); !errors.Is(err, ErrCacheCorrupted) {
+				t.Fatalf("Get = %v, want ErrCacheCorrupted", err)
+			}
+		})
+	}
+}
+
 // TestWriteBuilder_RejectsInvalidUTF8 verifies that Put rejects strings
 // containing invalid UTF-8 byte sequences. Manifests are persisted as JSON
 // which silently substitutes U+FFFD for invalid bytes; rejecting at the
@@ -0,0 +1,86 @@
+package granular
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// valueName is the data name under which PutValue and Func store values.
+const valueName = "value"
+
+// Codec encodes and decodes the values stored by PutValue and Func.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values with encoding/json. It is the default codec.
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec encodes values with encoding/gob, which preserves more Go types
+// than JSON (such as maps with struct keys) and is more compact.
+var GobCodec Codec = gobCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// PutValue stores v under key, encoded with the cache's codec (see
+// WithCodec).
+//
+// Example:
+//
+//	err := granular.PutValue(cache, key, report)
+func PutValue[T any](c *Cache, key Key, v T) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %w", err)
+	}
+	return c.Put(key).Bytes(valueName, data).Commit()
+}
+
+// GetValue returns the value stored under key by PutValue. It returns
+// ErrCacheMiss if there is none, and the errors of Get otherwise.
+//
+// Example:
+//
+//	report, err := granular.GetValue[Report](cache, key)
+//	if errors.Is(err, granular.ErrCacheMiss) {
+//		report = analyze()
+//		granular.PutValue(cache, key, report)
+//	}
+func GetValue[T any](c *Cache, key Key) (T, error) {
+	var v T
+	result, err := c.Get(key)
+	if err != nil {
+		return v, err
+	}
+	if !result.HasData(valueName) {
+		return v, fmt.Errorf("entry %s was not stored by PutValue", key.Hash())
+	}
+	data, err := result.BytesErr(valueName)
+	if err != nil {
+		return v, err
+	}
+	if err := c.codec.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("failed to decode value: %w", err)
+	}
+	return v, nil
+}
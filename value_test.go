@@ -0,0 +1,62 @@
+package granular
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+type point struct{ X, Y int }
+
+type report struct {
+	Name   string
+	Counts map[point]int // not representable in JSON
+}
+
+func TestValue_RoundTrip(t *testing.T) {
+	cache := OpenTemp()
+	key := cache.Key().String("report", "daily").Build()
+
+	if _, err := GetValue[report](cache, key); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("GetValue before PutValue = %v, want ErrCacheMiss", err)
+	}
+	want := report{Name: "daily"}
+	assertNoError(t, PutValue(cache, key, want), "PutValue")
+
+	got, err := GetValue[report](cache, key)
+	assertNoError(t, err, "GetValue")
+	assertEqual(t, got.Name, want.Name, "Name")
+
+	// A value stored as JSON does not decode into an incompatible type
+	if _, err := GetValue[[]int](cache, key); err == nil {
+		t.Error("GetValue into an incompatible type succeeded")
+	}
+
+	// Entries not written by PutValue are reported as such
+	other := cache.Key().String("report", "raw").Build()
+	assertNoError(t, cache.Put(other).Bytes("data", []byte("x")).Commit(), "Commit")
+	if _, err := GetValue[report](cache, other); err == nil {
+		t.Error("GetValue of an entry without a value succeeded")
+	}
+}
+
+func TestValue_Codecs(t *testing.T) {
+	v := report{Name: "grid", Counts: map[point]int{{1, 2}: 3}}
+
+	jsonCache := OpenTemp()
+	key := jsonCache.Key().String("report", "grid").Build()
+	if err := PutValue(jsonCache, key, v); err == nil {
+		t.Error("PutValue with JSONCodec accepted a map with struct keys")
+	}
+
+	gobCache, err := Open("", WithFs(afero.NewMemMapFs()), WithCodec(GobCodec))
+	assertNoError(t, err, "Open")
+	key = gobCache.Key().String("report", "grid").Build()
+	assertNoError(t, PutValue(gobCache, key, v), "PutValue with GobCodec")
+	got, err := GetValue[report](gobCache, key)
+	assertNoError(t, err, "GetValue with GobCodec")
+	if got.Counts[point{1, 2}] != 3 {
+		t.Errorf("GetValue = %+v, want %+v", got, v)
+	}
+}
@@ -0,0 +1,120 @@
+package granular
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/afero"
+)
+
+// VerifyReport describes the problems found by Verify or Repair.
+// Key hashes are listed in walk order.
+type VerifyReport struct {
+	Checked int // Number of manifests examined
+
+	Unreadable []string // Manifests that could not be read or parsed
+	Dangling   []string // Manifests whose object directory is missing
+	Damaged    []string // Entries with missing output files or outputs that no longer match their hash
+	Orphans    []string // Object directories without a manifest
+
+	Repaired bool // True if the problems above were removed (Repair)
+}
+
+// OK reports whether no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Unreadable) == 0 && len(r.Dangling) == 0 && len(r.Damaged) == 0 && len(r.Orphans) == 0
+}
+
+// Verify audits the whole cache without modifying it. It checks that every
+// manifest parses, that every referenced object file exists and matches the
+// recorded output hash, and looks for object directories without a manifest.
+//
+// Verify reads every cached byte, so it is as slow as reading the whole cache.
+// It holds the read lock for its duration. The returned error reports
+// failures to walk the cache, not problems with entries; those are listed in
+// the report.
+//
+// Example:
+//
+//	report, err := cache.Verify()
+//	if err != nil {
+//		return err
+//	}
+//	if !report.OK() {
+//		log.Printf("cache damaged: %d unreadable, %d damaged, %d orphans",
+//			len(report.Unreadable), len(report.Damaged), len(report.Orphans))
+//	}
+func (c *Cache) Verify() (*VerifyReport, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.verify(false)
+}
+
+// Repair runs the same checks as Verify and removes every problem it finds:
+// unreadable, dangling, and damaged entries are deleted and orphaned object
+// directories are removed. It holds the write lock for its duration.
+func (c *Cache) Repair() (*VerifyReport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.verify(true)
+}
+
+// verify implements Verify and Repair. Caller must hold c.mu, exclusively
+// if repair is true.
+func (c *Cache) verify(repair bool) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	valid := make(map[string]bool)
+
+	// Unreadable manifests are collected by the walk itself, independent of
+	// WithStrictWalks: reporting them is the point of verification.
+	var walkErr error
+	for keyHash, m := range c.walkManifests(false, &walkErr, &report.Unreadable) {
+		report.Checked++
+		valid[keyHash] = true
+
+		objectDir, err := c.objectPath(keyHash)
+		if err != nil {
+			report.Damaged = append(report.Damaged, keyHash)
+			continue
+		}
+		exists, err := afero.DirExists(c.fs, objectDir)
+		if err == nil && !exists && len(m.OutputFiles)+len(m.OutputData) > 0 {
+			report.Dangling = append(report.Dangling, keyHash)
+			continue
+		}
+		if err := c.verifyOutputHash(m); err != nil {
+			report.Damaged = append(report.Damaged, keyHash)
+		}
+	}
+	if walkErr != nil {
+		return nil, fmt.Errorf("failed to walk manifests: %w", walkErr)
+	}
+	report.Checked += len(report.Unreadable)
+
+	// Objects of unreadable entries are reported with their manifest, not as orphans
+	for _, keyHash := range report.Unreadable {
+		valid[keyHash] = true
+	}
+
+	for keyHash := range c.orphanObjects(valid, &walkErr) {
+		report.Orphans = append(report.Orphans, keyHash)
+	}
+	if walkErr != nil {
+		return nil, fmt.Errorf("failed to walk objects directory: %w", walkErr)
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, keyHash := range slices.Concat(report.Unreadable, report.Dangling, report.Damaged, report.Orphans) {
+		c.keyLocks.lockKey(keyHash)
+		err := c.removeByHash(keyHash)
+		c.keyLocks.unlockKey(keyHash)
+		if err != nil {
+			return report, fmt.Errorf("failed to remove entry %s: %w", keyHash, err)
+		}
+	}
+	report.Repaired = true
+	return report, nil
+}
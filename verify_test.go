@@ -0,0 +1,83 @@
+package granular
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// damageEntry overwrites every object file of the entry with garbage.
+func damageEntry(t *testing.T, cache *Cache, fs afero.Fs, key Key) {
+	t.Helper()
+	dir, err := cache.objectPath(key.Hash())
+	assertNoError(t, err, "objectPath")
+	infos, err := afero.ReadDir(fs, dir)
+	assertNoError(t, err, "ReadDir")
+	for _, info := range infos {
+		assertNoError(t, afero.WriteFile(fs, filepath.Join(dir, info.Name()), []byte("garbage"), 0o644), "WriteFile")
+	}
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open(".cache", WithFs(fs))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	good := putSized(t, cache, "good", 10)
+	damaged := putSized(t, cache, "damaged", 10)
+	dangling := putSized(t, cache, "dangling", 10)
+
+	damageEntry(t, cache, fs, damaged)
+	danglingDir, err := cache.objectPath(dangling.Hash())
+	assertNoError(t, err, "objectPath")
+	assertNoError(t, fs.RemoveAll(danglingDir), "RemoveAll")
+
+	unreadable := "ab" + good.Hash()[2:]
+	writeCorruptedManifest(t, fs, ".cache", unreadable)
+
+	orphan := "deadbeef12345678"
+	createTestFile(t, fs, filepath.Join(".cache", "objects", "de", orphan, "file.out"), []byte("orphan"))
+
+	report, err := cache.Verify()
+	assertNoError(t, err, "Verify")
+	if report.OK() || report.Repaired {
+		t.Fatalf("Expected unrepaired problems, got %+v", report)
+	}
+	if report.Checked != 4 {
+		t.Errorf("Checked = %d, want 4", report.Checked)
+	}
+	for name, got := range map[string][]string{
+		damaged.Hash():  report.Damaged,
+		dangling.Hash(): report.Dangling,
+		unreadable:      report.Unreadable,
+		orphan:          report.Orphans,
+	} {
+		if !slices.Equal(got, []string{name}) {
+			t.Errorf("Expected [%s], got %v (report %+v)", name, got, report)
+		}
+	}
+
+	// Verify must not change anything
+	if again, _ := cache.Verify(); len(again.Orphans) != 1 || len(again.Damaged) != 1 {
+		t.Fatalf("Verify modified the cache: %+v", again)
+	}
+
+	report, err = cache.Repair()
+	assertNoError(t, err, "Repair")
+	if !report.Repaired {
+		t.Error("Expected Repaired to be set")
+	}
+
+	report, err = cache.Verify()
+	assertNoError(t, err, "Verify after Repair")
+	if !report.OK() || report.Checked != 1 {
+		t.Errorf("Expected a clean cache with 1 entry after Repair, got %+v", report)
+	}
+	if !cache.Has(good) {
+		t.Error("Repair removed a healthy entry")
+	}
+}
@@ -0,0 +1,45 @@
+package granular
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophersatwork/granular/backend"
+)
+
+// Warm copies entries from the cache stored in source into c before they
+// are needed, so that a build starts with them on local disk instead of
+// fetching them one miss at a time on its critical path. source holds a
+// cache written with WithBackend, such as the shared cache that main-branch
+// builds fill.
+//
+// Only entries accepted by filter are copied; a nil filter copies them all.
+// Entries c already has are skipped without being fetched, so warming again
+// only fetches what is new. If c is a namespace view, only entries of its
+// namespace are considered. Like Merge, Warm verifies entries before copying
+// them, keeps their timestamps, metadata, and tags, and requires both caches
+// to use the same hash algorithm and compression. Nothing is written to
+// source. It returns the number of entries copied, and stops with ctx's
+// error if ctx is canceled.
+//
+// Example:
+//
+//	// Fetch last night's main-branch artifacts before the build starts
+//	shared := gcs.New("build-cache", gcs.WithHTTPClient(client))
+//	since := time.Now().Add(-24 * time.Hour)
+//	n, err := cache.Warm(ctx, shared, func(e granular.Entry) bool {
+//		return e.Tags["branch"] == "main" && e.CreatedAt.After(since)
+//	})
+func (c *Cache) Warm(ctx context.Context, source backend.Backend, filter func(Entry) bool) (int, error) {
+	src, err := OpenExisting("", WithBackend(source), func(s *Cache) {
+		s.hashFunc, s.hashAlgoName = c.hashFunc, c.hashAlgoName
+		s.compression = c.compression
+		s.maxDataSize = c.maxDataSize
+		s.readOnly = true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to open source cache: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+	return c.merge(ctx, src.Namespace(c.namespace), ConflictSkip, filter)
+}
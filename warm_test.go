@@ -0,0 +1,80 @@
+package granular
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gophersatwork/granular/backend"
+	"github.com/spf13/afero"
+)
+
+func TestWarm(t *testing.T) {
+	shared := backend.NewMemory()
+	src, err := Open("", WithBackend(shared))
+	assertNoError(t, err, "Open source")
+	for _, branch := range []string{"main", "feature"} {
+		key := src.Key().String("branch", branch).Build()
+		err := src.Put(key).Bytes("out", []byte("built on "+branch)).Tag("branch", branch).Commit()
+		assertNoError(t, err, "Commit "+branch)
+	}
+
+	local := OpenTemp()
+	onMain := func(e Entry) bool { return e.Tags["branch"] == "main" }
+	warmed, err := local.Warm(t.Context(), shared, onMain)
+	assertNoError(t, err, "Warm")
+	if warmed != 1 {
+		t.Fatalf("Warm copied %d entries, want 1", warmed)
+	}
+	result, err := local.Get(local.Key().String("branch", "main").Build())
+	assertCacheHit(t, result, err, "Get warmed entry")
+	assertEqual(t, string(result.Bytes("out")), "built on main", "warmed data")
+	if result.Tag("branch") != "main" {
+		t.Errorf("warmed entry tag = %q, want main", result.Tag("branch"))
+	}
+	if local.Has(local.Key().String("branch", "feature").Build()) {
+		t.Error("Expected entry rejected by the filter not to be warmed")
+	}
+
+	// Warming again only copies what is missing
+	warmed, err = local.Warm(t.Context(), shared, nil)
+	assertNoError(t, err, "Warm all")
+	if warmed != 1 {
+		t.Errorf("second Warm copied %d entries, want 1", warmed)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := OpenTemp().Warm(ctx, shared, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Warm with canceled context = %v, want context.Canceled", err)
+	}
+	if _, err := OpenTemp().Warm(t.Context(), backend.NewMemory(), nil); !errors.Is(err, ErrNotCache) {
+		t.Errorf("Warm from an empty backend = %v, want ErrNotCache", err)
+	}
+	sha, err := Open("", WithFs(afero.NewMemMapFs()), WithSHA256())
+	assertNoError(t, err, "Open SHA-256 cache")
+	if _, err := sha.Warm(t.Context(), shared, nil); !errors.Is(err, ErrHashAlgoMismatch) {
+		t.Errorf("Warm with another hash algorithm = %v, want ErrHashAlgoMismatch", err)
+	}
+}
+
+func TestWarm_ReadOnly(t *testing.T) {
+	shared := backend.NewMemory()
+	src, err := Open("", WithBackend(shared))
+	assertNoError(t, err, "Open source")
+	key := src.Key().String("branch", "main").Build()
+	assertNoError(t, src.Put(key).Bytes("out", []byte("built")).Commit(), "Commit")
+
+	// A cache from before metadata was recorded gets none from Warm
+	assertNoError(t, shared.Delete(t.Context(), metaFileName), "removing metadata")
+	local := OpenTemp()
+	warmed, err := local.Warm(t.Context(), shared, nil)
+	assertNoError(t, err, "Warm")
+	if warmed != 1 {
+		t.Fatalf("Warm copied %d entries, want 1", warmed)
+	}
+	if _, err := shared.Stat(t.Context(), metaFileName); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of source metadata after Warm = %v, want ErrNotExist", err)
+	}
+}
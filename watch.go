@@ -0,0 +1,390 @@
+package granular
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gophersatwork/granular/hashing"
+	"github.com/spf13/afero"
+)
+
+// defaultWatchInterval is how often Watch polls when WithWatchInterval is not
+// set.
+const defaultWatchInterval = 500 * time.Millisecond
+
+// Invalidation reports that files backing a watched key changed. Keys hash
+// their inputs once, so build the key again to get its new hash.
+type Invalidation struct {
+	Key   Key      // The watched key
+	Paths []string // Files created, modified, or removed since the last notification, sorted
+}
+
+// fileStamp is the metadata Watch compares to detect changes.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+// Watch sends an Invalidation to ch whenever a file backing one of key's
+// File, Glob, or Dir inputs is created, modified, or removed, so long-running
+// tools can react to changes instead of re-hashing their inputs on every
+// request. Call stop to end the watch; it waits for the watcher goroutine to
+// exit and is safe to call more than once.
+//
+// On the OS filesystem, Watch subscribes to change notifications from the
+// operating system (inotify, kqueue, ReadDirectoryChangesW) for the
+// directory of each File input, the directory of each Glob (and every
+// directory below its "**"), and every directory of each Dir input, so
+// changes are reported as soon as they happen and idle watches cost
+// nothing. Directories created later are watched as they appear.
+//
+// Other afero filesystems, such as in-memory ones, have no notifications:
+// Watch polls them instead, comparing file sizes, modification times, and
+// modes at the interval set by WithWatchInterval (500ms by default). Watch
+// also falls back to polling if the operating system refuses a new
+// watcher, for example when the inotify instance limit is reached. A write
+// that leaves size, modification time, and mode all unchanged goes
+// unnoticed when polling. File contents are never read, and globs and
+// directories are re-expanded on every check, so new matches are reported
+// too.
+//
+// Notifications are sent with a blocking send: changes that happen while the
+// receiver is busy are coalesced into the next Invalidation. Watch returns a
+// ValidationError if the key is invalid, and an error if it has no file
+// inputs to watch. Errors while watching are reported through
+// MetricsHooks.OnError with the operation "watch" and do not end the watch.
+//
+// Example:
+//
+//	key := cache.Key().Glob("templates/*.html").Build()
+//	changes := make(chan granular.Invalidation)
+//	stop, err := cache.Watch(key, changes)
+//	if err != nil {
+//		return err
+//	}
+//	defer stop()
+//	for range changes {
+//		reloadTemplates()
+//	}
+func (c *Cache) Watch(key Key, ch chan<- Invalidation) (stop func(), err error) {
+	if len(key.errors) > 0 {
+		return nil, newValidationError(key.errors)
+	}
+	if !slices.ContainsFunc(key.inputs, isFileInput) {
+		return nil, errors.New("key has no File, Glob, or Dir inputs to watch")
+	}
+
+	// Subscribe before taking the first snapshot, so that no change falls
+	// between the two.
+	var notify *dirWatcher
+	if _, ok := c.fs.(*afero.OsFs); ok {
+		notify, err = c.newDirWatcher(key)
+		if err != nil {
+			c.log(slog.LevelWarn, "watch falls back to polling", slog.String("error", err.Error()))
+		}
+	}
+
+	prev, err := c.watchSnapshot(key)
+	if err != nil {
+		if notify != nil {
+			_ = notify.w.Close()
+		}
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	if notify != nil {
+		wg.Go(func() {
+			defer notify.w.Close()
+			c.watchNotify(key, ch, prev, notify, done)
+		})
+	} else {
+		wg.Go(func() { c.watchPoll(key, ch, prev, done) })
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}, nil
+}
+
+// watchPoll sends an Invalidation to ch whenever a snapshot taken at the
+// watch interval differs from the last one sent, until done is closed.
+func (c *Cache) watchPoll(key Key, ch chan<- Invalidation, prev map[string]fileStamp, done <-chan struct{}) {
+	ticker := time.NewTicker(cmp.Or(c.watchInterval, defaultWatchInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		cur, err := c.watchSnapshot(key)
+		if err != nil {
+			c.recordError("watch", err)
+			continue
+		}
+		changed := changedPaths(prev, cur)
+		if len(changed) == 0 {
+			continue
+		}
+		select {
+		case ch <- Invalidation{Key: key, Paths: changed}:
+			prev = cur
+		case <-done:
+			return
+		}
+	}
+}
+
+// dirWatcher is an fsnotify watcher with the set of directories it watches.
+type dirWatcher struct {
+	w    *fsnotify.Watcher
+	dirs map[string]bool
+}
+
+// newDirWatcher returns a dirWatcher subscribed to the directories backing
+// the key's file inputs.
+func (c *Cache) newDirWatcher(key Key) (*dirWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dw := &dirWatcher{w: w, dirs: make(map[string]bool)}
+	if err := c.watchDirs(key, dw); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	return dw, nil
+}
+
+// note records the file named by ev as notified. The watch of a removed or
+// renamed directory ends with it, so it is forgotten to be added again if
+// the directory comes back.
+func (dw *dirWatcher) note(ev fsnotify.Event, notified map[string]bool) {
+	name := filepath.Clean(ev.Name)
+	notified[name] = true
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		delete(dw.dirs, name)
+	}
+}
+
+// watchNotify sends an Invalidation to ch whenever the operating system
+// reports a change to a file backing the key, until done is closed. Events
+// arriving together are reported in one Invalidation. A notified file counts
+// as changed even if its size, modification time, and mode are not.
+func (c *Cache) watchNotify(key Key, ch chan<- Invalidation, prev map[string]fileStamp, dw *dirWatcher, done <-chan struct{}) {
+	notified := make(map[string]bool)
+	for {
+		select {
+		case <-done:
+			return
+		case err, ok := <-dw.w.Errors:
+			if !ok {
+				return
+			}
+			c.recordError("watch", err)
+			continue
+		case ev, ok := <-dw.w.Events:
+			if !ok {
+				return
+			}
+			dw.note(ev, notified)
+		}
+	drain:
+		for {
+			select {
+			case ev, ok := <-dw.w.Events:
+				if !ok {
+					return
+				}
+				dw.note(ev, notified)
+			default:
+				break drain
+			}
+		}
+
+		// New directories may back the key now
+		if err := c.watchDirs(key, dw); err != nil {
+			c.recordError("watch", err)
+		}
+		cur, err := c.watchSnapshot(key)
+		if err != nil {
+			c.recordError("watch", err)
+			continue
+		}
+		changed := changedPaths(prev, cur)
+		for path := range cur {
+			if notified[filepath.Clean(path)] {
+				changed = append(changed, path)
+			}
+		}
+		clear(notified)
+		if len(changed) == 0 {
+			continue
+		}
+		slices.Sort(changed)
+		changed = slices.Compact(changed)
+		select {
+		case ch <- Invalidation{Key: key, Paths: changed}:
+			prev = cur
+		case <-done:
+			return
+		}
+	}
+}
+
+// watchDirs subscribes dw to every directory whose entries can back the
+// key's file inputs, and unsubscribes it from directories that no longer
+// do. A missing directory is replaced by its closest existing parent, so
+// that its creation is noticed.
+func (c *Cache) watchDirs(key Key, dw *dirWatcher) error {
+	want := make(map[string]bool)
+	addTree := func(root string) error {
+		root = c.existingDir(root)
+		return afero.Walk(c.fs, root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil // removed during the walk
+				}
+				return err
+			}
+			if info.IsDir() {
+				want[filepath.Clean(path)] = true
+			}
+			return nil
+		})
+	}
+	for _, in := range key.inputs {
+		switch in := in.(type) {
+		case fileInput:
+			want[c.existingDir(filepath.Dir(in.path))] = true
+		case globInput:
+			if before, _, ok := strings.Cut(in.pattern, "**"); ok {
+				if err := addTree(filepath.Dir(before)); err != nil {
+					return err
+				}
+			} else {
+				want[c.existingDir(filepath.Dir(in.pattern))] = true
+			}
+		case dirInput:
+			if err := addTree(in.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for dir := range dw.dirs {
+		if !want[dir] {
+			_ = dw.w.Remove(dir) // fails if the directory is gone, which also ends its watch
+			delete(dw.dirs, dir)
+		}
+	}
+	for dir := range want {
+		if dw.dirs[dir] {
+			continue
+		}
+		if err := dw.w.Add(dir); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // removed since; its parent's watch reports it
+			}
+			return err
+		}
+		dw.dirs[dir] = true
+	}
+	return nil
+}
+
+// existingDir returns dir, or its closest parent that exists if it does not.
+func (c *Cache) existingDir(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if ok, _ := afero.DirExists(c.fs, dir); ok {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// isFileInput reports whether an input is backed by files.
+func isFileInput(in input) bool {
+	switch in.(type) {
+	case fileInput, globInput, dirInput:
+		return true
+	}
+	return false
+}
+
+// watchSnapshot stats every file currently backing the key's inputs. Missing
+// files and directories are left out rather than reported as errors, so that
+// removals show up as changes.
+func (c *Cache) watchSnapshot(key Key) (map[string]fileStamp, error) {
+	var paths []string
+	for _, in := range key.inputs {
+		switch in := in.(type) {
+		case fileInput:
+			paths = append(paths, in.path)
+		case globInput:
+			matches, err := expandGlob(in.pattern, c.fs)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+		case dirInput:
+			files, err := (&hashing.Hasher{}).DirFilesWith(c.fs, in.path, in.opts)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, files...)
+		}
+	}
+
+	snapshot := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		info, err := c.fs.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshot[path] = fileStamp{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+	}
+	return snapshot, nil
+}
+
+// changedPaths returns the sorted paths that differ between two snapshots.
+func changedPaths(prev, cur map[string]fileStamp) []string {
+	var changed []string
+	for path, stamp := range cur {
+		if old, ok := prev[path]; !ok || old.size != stamp.size || !old.modTime.Equal(stamp.modTime) || old.mode != stamp.mode {
+			changed = append(changed, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := cur[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
@@ -0,0 +1,133 @@
+package granular
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestWatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cache, err := Open("/cache", WithFs(fs), WithWatchInterval(5*time.Millisecond))
+	assertNoError(t, err, "Open")
+	createTestFile(t, fs, "/w/config.yaml", []byte("a: 1"))
+	createTestFile(t, fs, "/w/src/main.go", []byte("package main"))
+	createTestFile(t, fs, "/w/assets/logo.svg", []byte("<svg/>"))
+
+	key := cache.Key().File("/w/config.yaml").Glob("/w/src/*.go").Dir("/w/assets").Version("v1").Build()
+	changes := make(chan Invalidation)
+	stop, err := cache.Watch(key, changes)
+	assertNoError(t, err, "Watch")
+	defer stop()
+
+	expect := func(what string, want ...string) {
+		t.Helper()
+		select {
+		case inv := <-changes:
+			if !slices.Equal(inv.Paths, want) {
+				t.Errorf("%s: paths = %v, want %v", what, inv.Paths, want)
+			}
+			if inv.Key.Hash() == "" {
+				t.Errorf("%s: invalidation has no key", what)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no invalidation", what)
+		}
+	}
+
+	createTestFile(t, fs, "/w/config.yaml", []byte("a: 22"))
+	expect("modified file", "/w/config.yaml")
+
+	createTestFile(t, fs, "/w/src/util.go", []byte("package main"))
+	createTestFile(t, fs, "/w/src/notes.txt", []byte("not matched"))
+	expect("new glob match", "/w/src/util.go")
+
+	assertNoError(t, fs.Remove("/w/assets/logo.svg"), "Remove")
+	expect("removed file", "/w/assets/logo.svg")
+
+	// Nothing changed, nothing is sent
+	select {
+	case inv := <-changes:
+		t.Errorf("unexpected invalidation %v", inv.Paths)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	stop()
+	stop()
+	createTestFile(t, fs, "/w/config.yaml", []byte("a: 333"))
+	select {
+	case inv := <-changes:
+		t.Errorf("invalidation after stop: %v", inv.Paths)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatch_Errors(t *testing.T) {
+	cache := OpenTemp()
+	changes := make(chan Invalidation)
+
+	if _, err := cache.Watch(cache.Key().String("env", "prod").Build(), changes); err == nil {
+		t.Error("Watch of a key without file inputs succeeded")
+	}
+	_, err := cache.Watch(cache.Key().File("/does/not/exist").Build(), changes)
+	if _, ok := errors.AsType[*ValidationError](err); !ok {
+		t.Errorf("Watch of an invalid key = %v, want ValidationError", err)
+	}
+}
+
+func TestWatch_OsFs(t *testing.T) {
+	dir := t.TempDir()
+	// An hour-long interval: only notifications can report changes in time
+	cache, err := Open(filepath.Join(dir, "cache"), WithWatchInterval(time.Hour))
+	assertNoError(t, err, "Open")
+	fs := afero.NewOsFs()
+	config := filepath.Join(dir, "config.yaml")
+	createTestFile(t, fs, config, []byte("a: 1"))
+	createTestFile(t, fs, filepath.Join(dir, "assets", "logo.svg"), []byte("<svg/>"))
+
+	key := cache.Key().File(config).Dir(filepath.Join(dir, "assets")).Glob(filepath.Join(dir, "src", "**", "*.go")).Build()
+	changes := make(chan Invalidation)
+	stop, err := cache.Watch(key, changes)
+	assertNoError(t, err, "Watch")
+	defer stop()
+
+	// Several events may report one change, so wait for the path.
+	waitFor := func(what, path string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case inv := <-changes:
+				if slices.Contains(inv.Paths, path) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("%s: no invalidation for %s", what, path)
+			}
+		}
+	}
+
+	info, err := fs.Stat(config)
+	assertNoError(t, err, "Stat")
+	createTestFile(t, fs, config, []byte("a: 2"))
+	assertNoError(t, fs.Chtimes(config, info.ModTime(), info.ModTime()), "Chtimes")
+	waitFor("same size and time", config)
+
+	nested := filepath.Join(dir, "assets", "icons", "new.svg")
+	createTestFile(t, fs, nested, []byte("<svg/>"))
+	waitFor("new directory", nested)
+	createTestFile(t, fs, nested, []byte("<svg></svg>"))
+	waitFor("write in the new directory", nested)
+
+	// The glob's root does not exist yet
+	main := filepath.Join(dir, "src", "cmd", "main.go")
+	createTestFile(t, fs, main, []byte("package main"))
+	waitFor("glob match in new directories", main)
+
+	assertNoError(t, fs.Remove(config), "Remove")
+	waitFor("removed file", config)
+}
@@ -2,15 +2,23 @@ package granular
 
 import (
 	"bytes"
+	"cmp"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"maps"
+	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
+	"github.com/gophersatwork/granular/hashing"
+	"github.com/gophersatwork/granular/internal/format"
 	"github.com/spf13/afero"
 )
 
@@ -41,6 +49,39 @@ func validateName(name string) error {
 	return nil
 }
 
+// validatePathName checks a logical name made of "/"-separated components,
+// as given to outputs captured by WriteBuilder.Glob. Each component must be
+// a valid name.
+func validatePathName(name string) error {
+	for part := range strings.SplitSeq(name, "/") {
+		if err := validateName(part); err != nil {
+			return fmt.Errorf("invalid name %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// objectFileName returns the base name of the object storing the output
+// file name. "/" in names captured by Glob is escaped, along with "%" so
+// escaped names cannot collide with literal ones.
+func objectFileName(name, ext string) string {
+	return "file." + objectNameEscaper.Replace(name) + ext
+}
+
+var objectNameEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// checkNameCase checks that name does not differ only by case from a name
+// already in names. Used with WithCaseInsensitivePaths, where such names
+// would map to the same object file.
+func checkNameCase[V any](names map[string]V, name string) error {
+	for other := range names {
+		if other != name && strings.EqualFold(other, name) {
+			return fmt.Errorf("invalid name %q: differs only by case from %q", name, other)
+		}
+	}
+	return nil
+}
+
 // WriteBuilder provides a fluent API for storing cache results.
 // Users should not construct this directly, use Cache.Put() instead.
 type WriteBuilder struct {
@@ -48,11 +89,18 @@ type WriteBuilder struct {
 	key              Key
 	files            map[string]string // name -> source path
 	data             map[string][]byte // name -> bytes
+	spilled          map[string]string // name -> temporary file holding data added with BytesFrom
+	spillDir         string            // Directory of the spilled files; empty until the first spill
+	stopSpill        func()            // Stops refreshing spillDir and cancels its cleanup; nil until the first spill
 	metadata         map[string]string // metadata key-value pairs
+	tags             map[string]string // tag key-value pairs for grouping entries
 	errors           []error           // Accumulated validation errors (from key + write operations)
 	accumulateErrors bool              // If true, accumulate all errors; if false, fail-fast
 	attempted        bool              // True once Commit() starts; prevents retry after failure
 	committed        bool              // True after Commit() succeeds; prevents reuse
+	refreshAfter     time.Duration     // Soft TTL set with RefreshAfter; 0 if none
+	description      string            // Set with Describe
+	conflict         ConflictPolicy    // Set with OnConflict; ConflictOverwrite by default
 }
 
 // File adds a file to be stored in the cache.
@@ -61,6 +109,11 @@ type WriteBuilder struct {
 // Validates the file and accumulates any errors.
 // Errors are only surfaced when Commit() is called.
 func (wb *WriteBuilder) File(name, srcPath string) *WriteBuilder {
+	return wb.addFile(name, srcPath, validateName)
+}
+
+// addFile adds a file like File, checking its name with validate.
+func (wb *WriteBuilder) addFile(name, srcPath string, validate func(string) error) *WriteBuilder {
 	// If fail-fast and already have errors, skip validation
 	if !wb.accumulateErrors && len(wb.errors) > 0 {
 		if wb.files == nil {
@@ -71,12 +124,20 @@ func (wb *WriteBuilder) File(name, srcPath string) *WriteBuilder {
 	}
 
 	// Validate name is safe for filesystem paths
-	if err := validateName(name); err != nil {
+	if err := validate(name); err != nil {
 		wb.errors = append(wb.errors, err)
 		if !wb.accumulateErrors {
 			return wb
 		}
 	}
+	if wb.cache.foldCase {
+		if err := checkNameCase(wb.files, name); err != nil {
+			wb.errors = append(wb.errors, err)
+			if !wb.accumulateErrors {
+				return wb
+			}
+		}
+	}
 
 	// Validate source file exists
 	exists, err := afero.Exists(wb.cache.fs, srcPath)
@@ -101,23 +162,219 @@ func (wb *WriteBuilder) File(name, srcPath string) *WriteBuilder {
 	return wb
 }
 
+// Files adds several files to be stored in the cache, mapping logical
+// names to source paths as File does. Every file is validated, even without
+// WithAccumulateErrors, so Commit reports each missing or invalid file at
+// once instead of only the first.
+//
+// Example:
+//
+//	err := cache.Put(key).Files(map[string]string{
+//		"api":    "gen/api.pb.go",
+//		"client": "gen/client.pb.go",
+//	}).Commit()
+func (wb *WriteBuilder) Files(files map[string]string) *WriteBuilder {
+	accumulate := wb.accumulateErrors
+	if len(wb.errors) == 0 {
+		wb.accumulateErrors = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		wb.File(name, files[name])
+	}
+	wb.accumulateErrors = accumulate
+	return wb
+}
+
+// FileList adds the files in dir with the given names, each stored under
+// its own name, and validates them like Files.
+//
+// Example:
+//
+//	err := cache.Put(key).FileList([]string{"api.pb.go", "client.pb.go"}, "gen").Commit()
+func (wb *WriteBuilder) FileList(names []string, dir string) *WriteBuilder {
+	files := make(map[string]string, len(names))
+	for _, name := range names {
+		files[name] = filepath.Join(dir, name)
+	}
+	return wb.Files(files)
+}
+
+// Glob adds every file matching pattern, which may use ** to match any
+// number of directories. Each file is named by its path relative to the
+// directory prefix, with "/" separators, and can be retrieved with
+// Result.File under that name. A pattern matching no files, or a file
+// outside prefix, is an error.
+//
+// Example:
+//
+//	// Stores dist/index.html as "index.html", dist/js/app.js as "js/app.js"
+//	err := cache.Put(key).Glob("dist", "dist/**").Commit()
+func (wb *WriteBuilder) Glob(prefix, pattern string) *WriteBuilder {
+	if !wb.accumulateErrors && len(wb.errors) > 0 {
+		return wb
+	}
+	matches, err := wb.cache.hasher().ExpandGlob(wb.cache.fs, pattern)
+	if err == nil && len(matches) == 0 {
+		err = fmt.Errorf("glob %q matched no files", pattern)
+	}
+	if err != nil {
+		wb.errors = append(wb.errors, fmt.Errorf("failed to expand glob %q: %w", pattern, err))
+		return wb
+	}
+	accumulate := wb.accumulateErrors
+	if len(wb.errors) == 0 {
+		wb.accumulateErrors = true
+	}
+	for _, path := range matches {
+		rel, err := filepath.Rel(prefix, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			wb.errors = append(wb.errors, fmt.Errorf("glob %q: %s is outside %s", pattern, path, prefix))
+			continue
+		}
+		wb.addFile(filepath.ToSlash(rel), path, validatePathName)
+	}
+	wb.accumulateErrors = accumulate
+	return wb
+}
+
+// spillThreshold is the size above which Bytes writes data to a temporary
+// file under the cache root instead of keeping a copy in memory until Commit.
+const spillThreshold = 8 << 20
+
 // Bytes adds byte data to be stored in the cache.
 // name is the logical name for this data (used to retrieve it later).
+// The data is copied, so the caller may reuse the slice: small data is
+// copied in memory, data larger than a few megabytes is written to a
+// temporary file under the cache root right away. Use BytesNoCopy to avoid
+// the copy, or BytesFrom to stream data that is not already in memory.
 func (wb *WriteBuilder) Bytes(name string, data []byte) *WriteBuilder {
+	if len(data) > spillThreshold {
+		return wb.BytesFrom(name, bytes.NewReader(data))
+	}
+	// Store a copy to prevent mutations
+	return wb.addData(name, bytes.Clone(data))
+}
+
+// BytesNoCopy adds byte data like Bytes, but keeps a reference to data
+// instead of copying it. The caller must not modify data until Commit
+// returns.
+//
+// Example:
+//
+//	blob := render() // 500MB, not touched again
+//	err := cache.Put(key).BytesNoCopy("frames", blob).Commit()
+func (wb *WriteBuilder) BytesNoCopy(name string, data []byte) *WriteBuilder {
+	return wb.addData(name, data)
+}
+
+// BytesFrom adds the data read from r, stored under name like Bytes. r is
+// read to the end right away and its content written to a temporary file
+// under the cache root, so it is never held in memory. Read errors are
+// surfaced by Commit.
+//
+// Example:
+//
+//	resp, err := http.Get(artifactURL)
+//	...
+//	defer resp.Body.Close()
+//	err = cache.Put(key).BytesFrom("artifact", resp.Body).Commit()
+func (wb *WriteBuilder) BytesFrom(name string, r io.Reader) *WriteBuilder {
+	if !wb.validDataName(name) {
+		return wb
+	}
+	path, err := wb.spill(r)
+	if err != nil {
+		wb.errors = append(wb.errors, fmt.Errorf("failed to read data %s: %w", name, err))
+		return wb
+	}
+	delete(wb.data, name)
+	if old, ok := wb.spilled[name]; ok {
+		_ = wb.cache.fs.Remove(old)
+	}
+	if wb.spilled == nil {
+		wb.spilled = make(map[string]string)
+	}
+	wb.spilled[name] = path
+	return wb
+}
+
+// addData adds data under name, replacing any data already added.
+func (wb *WriteBuilder) addData(name string, data []byte) *WriteBuilder {
+	if !wb.validDataName(name) {
+		return wb
+	}
+	if old, ok := wb.spilled[name]; ok {
+		_ = wb.cache.fs.Remove(old)
+		delete(wb.spilled, name)
+	}
+	if wb.data == nil {
+		wb.data = make(map[string][]byte)
+	}
+	wb.data[name] = data
+	return wb
+}
+
+// validDataName records an error if name cannot name byte data, and
+// reports whether the data should still be added.
+func (wb *WriteBuilder) validDataName(name string) bool {
 	// Validate name is safe for filesystem paths
 	if err := validateName(name); err != nil {
 		wb.errors = append(wb.errors, err)
 		if !wb.accumulateErrors {
-			return wb
+			return false
 		}
 	}
+	if wb.cache.foldCase {
+		err := checkNameCase(wb.data, name)
+		if err == nil {
+			err = checkNameCase(wb.spilled, name)
+		}
+		if err != nil {
+			wb.errors = append(wb.errors, err)
+			if !wb.accumulateErrors {
+				return false
+			}
+		}
+	}
+	return true
+}
 
-	if wb.data == nil {
-		wb.data = make(map[string][]byte)
+// spill copies r to a new file in the builder's spill directory and returns
+// its path. The directory is named like a commit staging directory, and
+// refreshed while the builder is alive, so GC leaves it alone however long
+// the builder stays open but removes it after a crash. A builder dropped
+// without Commit removes it once garbage collected.
+func (wb *WriteBuilder) spill(r io.Reader) (string, error) {
+	if wb.spillDir == "" {
+		c := wb.cache
+		dir := filepath.Join(c.root, "tmp", "commit-"+randomSuffix())
+		if err := c.mkdirAll(dir); err != nil {
+			return "", err
+		}
+		stop := c.keepAlive(dir, lockHeartbeat)
+		cleanup := runtime.AddCleanup(wb, func(dir string) {
+			stop()
+			_ = c.fs.RemoveAll(dir)
+		}, dir)
+		wb.spillDir = dir
+		wb.stopSpill = func() {
+			cleanup.Stop()
+			stop()
+		}
 	}
-	// Store a copy to prevent mutations
-	wb.data[name] = bytes.Clone(data)
-	return wb
+	path := filepath.Join(wb.spillDir, "spill."+randomSuffix())
+	f, err := wb.cache.createFile(path)
+	if err != nil {
+		return "", err
+	}
+	bufPtr := wb.cache.buffers().Get()
+	defer wb.cache.buffers().Put(bufPtr)
+	_, copyErr := io.CopyBuffer(f, r, *bufPtr)
+	if err := errors.Join(copyErr, f.Close()); err != nil {
+		_ = wb.cache.fs.Remove(path)
+		return "", err
+	}
+	return path, nil
 }
 
 // Meta adds metadata to the cache entry.
@@ -143,32 +400,184 @@ func (wb *WriteBuilder) Meta(key, value string) *WriteBuilder {
 	return wb
 }
 
+// MetaInt adds an integer as metadata. Read it back with Result.MetaInt.
+//
+// Example:
+//
+//	cache.Put(key).MetaInt("tests", 1342)
+func (wb *WriteBuilder) MetaInt(key string, value int64) *WriteBuilder {
+	return wb.Meta(key, strconv.FormatInt(value, 10))
+}
+
+// MetaBool adds a boolean as metadata. Read it back with Result.MetaBool.
+func (wb *WriteBuilder) MetaBool(key string, value bool) *WriteBuilder {
+	return wb.Meta(key, strconv.FormatBool(value))
+}
+
+// MetaTime adds a time as metadata, in RFC 3339 format with nanoseconds.
+// Read it back with Result.MetaTime.
+func (wb *WriteBuilder) MetaTime(key string, value time.Time) *WriteBuilder {
+	return wb.Meta(key, value.Format(time.RFC3339Nano))
+}
+
+// MetaDuration adds a duration as metadata, formatted like "1m30s". Read
+// it back with Result.MetaDuration.
+//
+// Example:
+//
+//	cache.Put(key).MetaDuration("build_time", time.Since(start))
+func (wb *WriteBuilder) MetaDuration(key string, value time.Duration) *WriteBuilder {
+	return wb.Meta(key, value.String())
+}
+
+// MetaJSON adds value, encoded as JSON, as metadata. Keep it small: all of
+// an entry's metadata is loaded with its manifest.
+//
+// Example:
+//
+//	cache.Put(key).MetaJSON("toolchain", Toolchain{Go: "1.24.1", CGO: false})
+func (wb *WriteBuilder) MetaJSON(key string, value any) *WriteBuilder {
+	data, err := json.Marshal(value)
+	if err != nil {
+		wb.errors = append(wb.errors, fmt.Errorf("failed to encode metadata %q: %w", key, err))
+		return wb
+	}
+	return wb.Meta(key, string(data))
+}
+
+// Tag labels the cache entry with a key-value pair.
+// Tags group entries (for example by pipeline or branch) so they can later be
+// listed with Cache.EntriesByTag or removed with Cache.DeleteByTag.
+// Both key and value must be valid UTF-8; invalid input is rejected at Commit.
+func (wb *WriteBuilder) Tag(key, value string) *WriteBuilder {
+	if err := validateUTF8("tag key", key); err != nil {
+		wb.errors = append(wb.errors, err)
+		if !wb.accumulateErrors {
+			return wb
+		}
+	}
+	if err := validateUTF8("tag value", value); err != nil {
+		wb.errors = append(wb.errors, err)
+		if !wb.accumulateErrors {
+			return wb
+		}
+	}
+	if wb.tags == nil {
+		wb.tags = make(map[string]string)
+	}
+	wb.tags[key] = value
+	return wb
+}
+
+// Describe sets a human-readable description of the entry, such as the
+// command that produced it. It is shown by "granular ls" and returned by
+// Entry.Description and Result.Description; it is not part of the key.
+//
+// Example:
+//
+//	cache.Put(key).File("api", "gen/api.pb.go").Describe("protoc gen for schema.proto")
+func (wb *WriteBuilder) Describe(text string) *WriteBuilder {
+	if err := validateUTF8("description", text); err != nil {
+		wb.errors = append(wb.errors, err)
+		return wb
+	}
+	wb.description = text
+	return wb
+}
+
+// RefreshAfter sets a soft TTL: once d has passed since the commit, Get
+// still returns the entry but reports it as Stale and, if the cache was
+// opened with WithRefresher, schedules a refresh in the background. Unlike
+// pruning, nothing is removed. A non-positive d means no soft TTL.
+func (wb *WriteBuilder) RefreshAfter(d time.Duration) *WriteBuilder {
+	wb.refreshAfter = d
+	return wb
+}
+
+// OnConflict sets what Commit does when the cache already has a usable entry
+// for the key:
+//
+//   - ConflictOverwrite, the default, replaces it.
+//   - ConflictSkip keeps it and stores nothing, like CommitIfAbsent.
+//   - ConflictError keeps it and returns an error wrapping ErrEntryExists,
+//     which surfaces builds that run twice for one key, or nondeterministic
+//     ones that should have been cache hits.
+//
+// Example:
+//
+//	err := cache.Put(key).File("app", "./app").OnConflict(granular.ConflictError).Commit()
+//	if errors.Is(err, granular.ErrEntryExists) {
+//		log.Printf("%s was built again", key)
+//	}
+func (wb *WriteBuilder) OnConflict(policy ConflictPolicy) *WriteBuilder {
+	wb.conflict = policy
+	return wb
+}
+
 // Commit finalizes and stores the cache entry.
 // Returns a ValidationError if there are accumulated errors from key building or write operations.
-// Returns an error if the storage operation fails.
+// Returns an error if the storage operation fails, or wrapping ErrEntryExists
+// if the entry exists under ConflictError (see OnConflict).
 func (wb *WriteBuilder) Commit() error {
+	_, err := wb.commit(wb.conflict)
+	return err
+}
+
+// CommitIfAbsent stores the entry unless the cache already has a usable
+// entry for the key, in which case it writes nothing and returns false.
+//
+// The check and the write happen under the key's lock, so concurrent
+// CommitIfAbsent calls on one Cache store the entry exactly once. Separate
+// processes sharing a cache directory skip the write whenever the entry was
+// published before they start writing; two processes that start at the same
+// moment may both write, which is harmless for deterministic outputs.
+//
+// Example:
+//
+//	stored, err := cache.Put(key).File("app", "./app").CommitIfAbsent()
+func (wb *WriteBuilder) CommitIfAbsent() (stored bool, err error) {
+	return wb.commit(ConflictSkip)
+}
+
+// commit implements Commit and CommitIfAbsent, resolving conflicts with an
+// existing entry according to policy.
+func (wb *WriteBuilder) commit(policy ConflictPolicy) (bool, error) {
 	if wb.committed || wb.attempted {
-		return fmt.Errorf("WriteBuilder already used: Commit can only be called once")
+		return false, fmt.Errorf("WriteBuilder already used: Commit can only be called once")
 	}
 	wb.attempted = true
+	defer wb.removeSpilled()
 
 	startTime := wb.cache.now()
+	defer wb.cache.observeLatency(&wb.cache.counters.commitLatency, startTime)
 
 	// Check for accumulated validation errors first (no lock needed)
 	if len(wb.errors) > 0 {
-		return newValidationError(wb.errors)
+		return false, newValidationError(wb.errors)
+	}
+	if err := wb.checkInlineSize(); err != nil {
+		return false, err
 	}
 
 	// Compute key hash BEFORE locking (pure computation, no lock needed)
 	keyHash, err := wb.key.computeHash()
 	if err != nil {
-		return fmt.Errorf("failed to compute key hash: %w", err)
+		return false, fmt.Errorf("failed to compute key hash: %w", err)
+	}
+
+	if wb.cache.slow.Commit > 0 {
+		defer func() { wb.cache.reportIfSlow(SlowOpCommit, keyHash, wb.cache.now().Sub(startTime)) }()
 	}
 
 	// Estimate required space for this entry (before acquiring locks)
 	requiredSpace, err := wb.estimateSize()
 	if err != nil {
-		return fmt.Errorf("failed to estimate entry size: %w", err)
+		return false, fmt.Errorf("failed to estimate entry size: %w", err)
+	}
+
+	// Skip the work, including eviction, if the entry is already there
+	if policy != ConflictOverwrite && wb.cache.hasUsableEntry(keyHash) {
+		return false, wb.conflictWith(policy, keyHash)
 	}
 
 	// Reserve pending size so concurrent Commits see each other's reservations
@@ -180,122 +589,367 @@ func (wb *WriteBuilder) Commit() error {
 		wb.cache.mu.Lock()
 		if err := wb.cache.evictIfNeeded(requiredSpace); err != nil {
 			wb.cache.mu.Unlock()
-			wb.cache.metrics.error("put", err)
-			return fmt.Errorf("failed to evict entries: %w", err)
+			wb.cache.recordError("put", err)
+			return false, fmt.Errorf("failed to evict entries: %w", err)
 		}
 		wb.cache.mu.Unlock()
 	}
 
-	// Hold global read lock during the write phase to prevent Clear() from
-	// removing directories while files are being written. Multiple Put()
-	// calls can proceed concurrently since they all hold RLock.
-	wb.cache.mu.RLock()
-	defer wb.cache.mu.RUnlock()
-
-	// Use per-key lock for concurrent writes to different keys
-	wb.cache.keyLocks.lockKey(keyHash)
-	defer wb.cache.keyLocks.unlockKey(keyHash)
-
-	// Create object directory
+	// Copy the outputs into a private staging directory without holding any
+	// lock: copying gigabytes of artifacts must not stall Clear, GC, or
+	// readers of this key. Only moving them into place is done under lock.
 	objectDir, err := wb.cache.objectPath(keyHash)
 	if err != nil {
-		return err
+		return false, err
 	}
-	if err := wb.cache.fs.MkdirAll(objectDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create object directory: %w", err)
+	stageDir := filepath.Join(wb.cache.root, "tmp", "commit-"+keyHash+"-"+randomSuffix())
+	if err := wb.cache.mkdirAll(stageDir); err != nil {
+		return false, fmt.Errorf("failed to create staging directory: %w", err)
 	}
+	defer func() { _ = wb.cache.fs.RemoveAll(stageDir) }()
 
-	// Clean up objectDir on any error after this point.
-	committed := false
-	defer func() {
-		if !committed {
-			_ = wb.cache.fs.RemoveAll(objectDir)
-		}
-	}()
-
-	// Copy all files to cache.
+	// Copy all files to staging, except those already cached unchanged
+	// under this key, which are kept in place.
 	// Uses "file.<name>.<ext>" as the destination to avoid basename collisions
 	// when different source paths share the same filename.
-	cachedFiles := make(map[string]string)
+	previous, _ := wb.cache.loadManifest(keyHash)
+	stagedFiles := make(map[string]string, len(wb.files))
+	kept := make(map[string]os.FileInfo)
 	for name, srcPath := range wb.files {
 		ext := filepath.Ext(srcPath)
-		dstPath := filepath.Join(objectDir, "file."+name+ext)
+		objectName := objectFileName(name, ext)
+		if info, ok := wb.cache.unchangedObject(previous, name, srcPath, filepath.Join(objectDir, objectName)); ok {
+			kept[name] = info
+			continue
+		}
+		dstPath := filepath.Join(stageDir, objectName)
 
 		if err := wb.copyFile(srcPath, dstPath); err != nil {
-			return fmt.Errorf("failed to copy file %s: %w", name, err)
+			return false, fmt.Errorf("failed to copy file %s: %w", name, err)
 		}
 
-		cachedFiles[name] = dstPath
+		stagedFiles[name] = dstPath
 	}
 
-	// Write byte data to cache as files atomically and track paths for manifest.
+	// Write byte data to staging as files atomically.
 	// Uses "data.<name>.dat" as the destination to namespace separately from files.
-	cachedDataPaths := make(map[string]string, len(wb.data))
+	// With WithDedup, each is also given the path of its shared blob.
+	stagedData := make(map[string]string, len(wb.data)+len(wb.spilled))
+	blobs := make(map[string]string)
 	for name, data := range wb.data {
-		dstPath := filepath.Join(objectDir, "data."+name+".dat")
-		if err := wb.writeDataFile(dstPath, data); err != nil {
-			return fmt.Errorf("failed to write data %s: %w", name, err)
+		dstPath := filepath.Join(stageDir, "data."+name+".dat")
+		if err := wb.writeDataFile(dstPath, bytes.NewReader(data)); err != nil {
+			return false, fmt.Errorf("failed to write data %s: %w", name, err)
+		}
+		stagedData[name] = dstPath
+		if wb.cache.dedup {
+			digest, err := wb.cache.dataDigest(data)
+			if err != nil {
+				return false, fmt.Errorf("failed to hash data %s: %w", name, err)
+			}
+			blobs[name] = wb.cache.blobPath(digest)
+		}
+	}
+	for name, path := range wb.spilled {
+		dstPath := filepath.Join(stageDir, "data."+name+".dat")
+		if err := wb.writeSpilledFile(dstPath, path); err != nil {
+			return false, fmt.Errorf("failed to write data %s: %w", name, err)
+		}
+		stagedData[name] = dstPath
+		if wb.cache.dedup {
+			digest, err := wb.cache.fileDigest(path)
+			if err != nil {
+				return false, fmt.Errorf("failed to hash data %s: %w", name, err)
+			}
+			blobs[name] = wb.cache.blobPath(digest)
 		}
-		// Store the path to the .dat file in the manifest (not the raw bytes)
-		cachedDataPaths[name] = dstPath
 	}
 
-	// Build input descriptions for manifest
-	inputDescs := make([]string, len(wb.key.inputs))
-	for i, ki := range wb.key.inputs {
-		inputDescs[i] = ki.String()
+	// Compute the output hash from the staged and kept files and .dat files
+	// (all possibly compressed), under the paths they will have once moved.
+	// Hashing what is on disk ensures the hash matches what verification
+	// will compute.
+	inObjectDir := func(path string) string { return filepath.Join(objectDir, filepath.Base(path)) }
+	outputs := slices.Collect(maps.Values(stagedFiles))
+	for name := range kept {
+		outputs = append(outputs, previous.OutputFiles[name])
+	}
+	outputHash, err := wb.cache.outputHash(outputs, stagedData, wb.metadata, inObjectDir)
+	if err != nil {
+		return false, fmt.Errorf("failed to compute output hash: %w", err)
 	}
 
-	// Create output file list for hash computation (use cached paths for consistency with verification)
-	cachedFilePaths := slices.Collect(maps.Values(cachedFiles))
+	// Hold global read lock while moving objects into place to prevent
+	// Clear() from removing directories under us. Multiple Put() calls can
+	// proceed concurrently since they all hold RLock.
+	wb.cache.mu.RLock()
+	defer wb.cache.mu.RUnlock()
 
-	// Read back the compressed data from .dat files for hash computation
-	// This ensures the hash matches what verification will compute
-	cachedDataForHash := make(map[string][]byte, len(cachedDataPaths))
-	for name, dataPath := range cachedDataPaths {
-		data, err := afero.ReadFile(wb.cache.fs, dataPath)
+	// Use per-key lock for concurrent writes to different keys
+	wb.cache.keyLocks.lockKey(keyHash)
+	defer wb.cache.keyLocks.unlockKey(keyHash)
+
+	// Check again now that writers of this key are excluded
+	if policy != ConflictOverwrite && wb.cache.usableEntry(keyHash) {
+		return false, wb.conflictWith(policy, keyHash)
+	}
+
+	// Record the in-flight commit so Open can clean it up after a crash
+	if wb.cache.durable {
+		endJournal, err := wb.cache.beginJournal(keyHash)
 		if err != nil {
-			return fmt.Errorf("failed to read back cached data %s: %w", name, err)
+			return false, err
 		}
-		cachedDataForHash[name] = data
+		defer endJournal()
+	}
+
+	// Keep the entry being replaced, with WithHistory
+	wb.cache.archiveEntry(keyHash, outputHash)
+
+	if err := wb.cache.mkdirAll(objectDir); err != nil {
+		return false, fmt.Errorf("failed to create object directory: %w", err)
 	}
 
-	// Compute output hash from cached files and data (both possibly compressed)
-	outputHash, err := wb.cache.computeOutputHash(cachedFilePaths, cachedDataForHash, wb.metadata)
+	// Clean up objectDir on any error after this point.
+	committed := false
+	defer func() {
+		if !committed {
+			_ = wb.cache.fs.RemoveAll(objectDir)
+		}
+	}()
+
+	moveIn := func(staged map[string]string) (map[string]string, error) {
+		paths := make(map[string]string, len(staged))
+		for name, path := range staged {
+			if err := wb.cache.fs.Rename(path, inObjectDir(path)); err != nil {
+				return nil, fmt.Errorf("failed to move object %s: %w", name, err)
+			}
+			paths[name] = inObjectDir(path)
+		}
+		return paths, nil
+	}
+	cachedFiles, err := moveIn(stagedFiles)
+	if err != nil {
+		return false, err
+	}
+	ownData := maps.Clone(stagedData)
+	for name := range blobs {
+		delete(ownData, name)
+	}
+	cachedDataPaths, err := moveIn(ownData)
 	if err != nil {
-		return fmt.Errorf("failed to compute output hash: %w", err)
+		return false, err
+	}
+	for name, blob := range blobs {
+		if err := wb.cache.storeBlob(stagedData[name], blob); err != nil {
+			return false, fmt.Errorf("failed to store data %s: %w", name, err)
+		}
+		cachedDataPaths[name] = blob
+	}
+	for name, info := range kept {
+		// Another commit of this key may have replaced the object since it
+		// was compared; copy it then, which is what the comparison saved.
+		path := previous.OutputFiles[name]
+		if now, err := wb.cache.fs.Stat(path); err != nil || !now.ModTime().Equal(info.ModTime()) || now.Size() != info.Size() {
+			if err := wb.copyFile(wb.files[name], path); err != nil {
+				return false, fmt.Errorf("failed to copy file %s: %w", name, err)
+			}
+		}
+		cachedFiles[name] = path
+	}
+
+	// Build input descriptions for manifest
+	inputDescs, _ := wb.key.Describe()
+
+	// Make the objects durable before the manifest makes them visible
+	if wb.cache.durable {
+		syncDir(wb.cache.fs, objectDir)
+		syncDir(wb.cache.fs, filepath.Dir(objectDir))
 	}
 
 	// Create and save manifest
 	manifest := &manifest{
-		Version:     1,                     // Current manifest format version
+		Version:     format.Version,        // Current manifest format version
 		HashAlgo:    wb.cache.hashAlgoName, // Hash algorithm for compatibility checking
 		KeyHash:     keyHash,
+		Namespace:   wb.key.cache.namespace,
 		InputDescs:  inputDescs,
 		ExtraData:   wb.key.extras,
 		OutputFiles: cachedFiles,
 		OutputData:  cachedDataPaths, // Store paths to .dat files
 		OutputMeta:  wb.metadata,
 		OutputHash:  outputHash,
-		Compression: wb.cache.compression,
+		Compression: string(wb.cache.compression),
+		Tags:        wb.tags,
+		Description: wb.description,
 		CreatedAt:   wb.cache.now(),
 		AccessedAt:  wb.cache.now(),
 	}
 
+	if wb.refreshAfter > 0 {
+		manifest.RefreshAt = manifest.CreatedAt.Add(wb.refreshAfter)
+	}
+
 	if err := wb.cache.saveManifest(manifest); err != nil {
-		return fmt.Errorf("failed to save manifest: %w", err)
+		return false, fmt.Errorf("failed to save manifest: %w", err)
 	}
 
 	committed = true
 	wb.committed = true
+	wb.release()
+
+	// Report successful put with duration (use nowFunc for deterministic time in tests)
+	wb.cache.recordPut(keyHash, requiredSpace, wb.cache.now().Sub(startTime))
+
+	return true, nil
+}
+
+// checkInlineSize returns an error wrapping ErrManifestTooLarge if the
+// values stored inline in the manifest exceed the cache's limit.
+func (wb *WriteBuilder) checkInlineSize() error {
+	limit := cmp.Or(wb.cache.maxInlineSize, DefaultMaxInlineSize)
+	if limit < 0 {
+		return nil
+	}
+	var meta, key int64
+	for k, v := range wb.metadata {
+		meta += int64(len(k) + len(v))
+	}
+	for k, v := range wb.tags {
+		meta += int64(len(k) + len(v))
+	}
+	meta += int64(len(wb.description))
+	inputs, extras := wb.key.Describe()
+	for _, desc := range inputs {
+		key += int64(len(desc))
+	}
+	for k, v := range extras {
+		key += int64(len(k) + len(v))
+	}
+	if meta+key <= limit {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes of metadata, tags, and description and %d bytes of key components, over the limit of %d: "+
+		"store large values with Bytes, BytesFrom, or File, and add large key components with KeyBuilder.Bytes",
+		ErrManifestTooLarge, meta, key, limit)
+}
+
+// conflictWith resolves a conflict with the existing entry for keyHash under
+// policy, ConflictSkip or ConflictError, without storing anything.
+func (wb *WriteBuilder) conflictWith(policy ConflictPolicy, keyHash string) error {
+	wb.skip()
+	if policy == ConflictError {
+		return fmt.Errorf("%w: %s", ErrEntryExists, keyHash)
+	}
+	return nil
+}
+
+// skip marks the builder used without storing anything.
+func (wb *WriteBuilder) skip() {
+	wb.committed = true
+	wb.release()
+}
+
+// unchangedObject reports whether the output file name, to be copied from
+// srcPath to the object at dstPath, is already stored there by the entry
+// described by previous with the same content. If so, it returns the
+// object's file info, so the caller can tell whether it changes afterwards.
+func (c *Cache) unchangedObject(previous *manifest, name, srcPath, dstPath string) (os.FileInfo, bool) {
+	if previous == nil || previous.OutputFiles[name] != dstPath || CompressionType(previous.Compression) != c.compression {
+		return nil, false
+	}
+	info, err := c.fs.Stat(dstPath)
+	if err != nil {
+		return nil, false
+	}
+	if c.compression == CompressionNone {
+		if src, err := c.fs.Stat(srcPath); err != nil || src.Size() != info.Size() {
+			return nil, false
+		}
+	}
+	srcSum, err := c.contentHash(srcPath, CompressionNone)
+	if err != nil {
+		return nil, false
+	}
+	dstSum, err := c.contentHash(dstPath, c.compression)
+	if err != nil || dstSum != srcSum {
+		return nil, false
+	}
+	return info, true
+}
+
+// contentHash returns the hash of the content of the file at path,
+// decompressed with ct.
+func (c *Cache) contentHash(path string, ct CompressionType) (string, error) {
+	f, err := c.fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	r, err := decompressReader(f, ct)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	h := c.newHash()
+	if err := c.hasher().Reader(h, r); err != nil {
+		return "", err
+	}
+	return hashing.Sum(h), nil
+}
+
+// writeSpilledFile writes data spilled to the file at src to dst, like
+// writeDataFile.
+func (wb *WriteBuilder) writeSpilledFile(dst, src string) error {
+	f, err := wb.cache.fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return wb.writeDataFile(dst, f)
+}
+
+// removeSpilled removes the temporary files of data added with BytesFrom.
+func (wb *WriteBuilder) removeSpilled() {
+	if wb.spillDir != "" {
+		wb.stopSpill()
+		_ = wb.cache.fs.RemoveAll(wb.spillDir)
+		wb.spillDir = ""
+		wb.stopSpill = nil
+		wb.spilled = nil
+	}
+}
+
+// release drops the builder's references to the entry's contents.
+func (wb *WriteBuilder) release() {
 	wb.files = nil
 	wb.data = nil
 	wb.metadata = nil
+	wb.tags = nil
+}
 
-	// Report successful put with duration (use nowFunc for deterministic time in tests)
-	wb.cache.metrics.put(keyHash, requiredSpace, wb.cache.now().Sub(startTime))
+// hasUsableEntry reports whether the cache has an entry for keyHash that Get
+// would return.
+func (c *Cache) hasUsableEntry(keyHash string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.keyLocks.lockKey(keyHash)
+	defer c.keyLocks.unlockKey(keyHash)
+	return c.usableEntry(keyHash)
+}
 
-	return nil
+// usableEntry is hasUsableEntry for callers holding the key's lock. Entries
+// with another hash algorithm or compression, or that fail verification,
+// do not count: Get treats them as misses or errors.
+func (c *Cache) usableEntry(keyHash string) bool {
+	m, err := c.loadManifest(keyHash)
+	if err != nil {
+		return false
+	}
+	if cmp.Or(m.HashAlgo, DefaultHashAlgoName) != c.hashAlgoName || CompressionType(m.Compression) != c.compression {
+		return false
+	}
+	return !c.verifyOnGet || c.verifyOutputHash(m) == nil
 }
 
 // copyFile copies a file from src to dst atomically, applying compression if configured.
@@ -309,14 +963,15 @@ func (wb *WriteBuilder) copyFile(src, dst string) error {
 
 	// Write to temp file first for atomic operation
 	tmpPath := dst + ".tmp." + randomSuffix()
-	dstFile, err := wb.cache.fs.Create(tmpPath)
+	dstFile, err := wb.cache.createFile(tmpPath)
 	if err != nil {
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 
-	bufPtr := bufferPool.Get().(*[]byte)
+	buffers := wb.cache.buffers()
+	bufPtr := buffers.Get()
 	buffer := *bufPtr
-	defer bufferPool.Put(bufPtr)
+	defer buffers.Put(bufPtr)
 
 	// Wrap with compression if configured
 	compWriter, err := compressWriter(dstFile, wb.cache.compression)
@@ -326,10 +981,15 @@ func (wb *WriteBuilder) copyFile(src, dst string) error {
 		return fmt.Errorf("failed to create compressor: %w", err)
 	}
 
-	_, copyErr := io.CopyBuffer(compWriter, srcFile, buffer)
+	r, done := wb.cache.trackProgress(ProgressOpCopy, src, srcFile)
+	_, copyErr := io.CopyBuffer(compWriter, r, buffer)
+	if copyErr == nil {
+		done()
+	}
 	compCloseErr := compWriter.Close()
+	syncErr := wb.syncFile(dstFile)
 	fileCloseErr := dstFile.Close()
-	if err := errors.Join(copyErr, compCloseErr, fileCloseErr); err != nil {
+	if err := errors.Join(copyErr, compCloseErr, syncErr, fileCloseErr); err != nil {
 		_ = wb.cache.fs.Remove(tmpPath)
 		return fmt.Errorf("failed to copy: %w", err)
 	}
@@ -345,9 +1005,9 @@ func (wb *WriteBuilder) copyFile(src, dst string) error {
 }
 
 // writeDataFile writes byte data to a file atomically, applying compression if configured.
-func (wb *WriteBuilder) writeDataFile(dst string, data []byte) error {
+func (wb *WriteBuilder) writeDataFile(dst string, data io.Reader) error {
 	tmpPath := dst + ".tmp." + randomSuffix()
-	dstFile, err := wb.cache.fs.Create(tmpPath)
+	dstFile, err := wb.cache.createFile(tmpPath)
 	if err != nil {
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
@@ -360,10 +1020,13 @@ func (wb *WriteBuilder) writeDataFile(dst string, data []byte) error {
 		return fmt.Errorf("failed to create compressor: %w", err)
 	}
 
-	_, writeErr := compWriter.Write(data)
+	bufPtr := wb.cache.buffers().Get()
+	defer wb.cache.buffers().Put(bufPtr)
+	_, writeErr := io.CopyBuffer(compWriter, data, *bufPtr)
 	compCloseErr := compWriter.Close()
+	syncErr := wb.syncFile(dstFile)
 	fileCloseErr := dstFile.Close()
-	if err := errors.Join(writeErr, compCloseErr, fileCloseErr); err != nil {
+	if err := errors.Join(writeErr, compCloseErr, syncErr, fileCloseErr); err != nil {
 		_ = wb.cache.fs.Remove(tmpPath)
 		return fmt.Errorf("failed to write data: %w", err)
 	}
@@ -377,6 +1040,14 @@ func (wb *WriteBuilder) writeDataFile(dst string, data []byte) error {
 	return nil
 }
 
+// syncFile flushes f to stable storage when durable writes are enabled.
+func (wb *WriteBuilder) syncFile(f afero.File) error {
+	if !wb.cache.durable {
+		return nil
+	}
+	return f.Sync()
+}
+
 // estimateSize calculates the approximate size of the data to be written.
 // This includes all files and byte data that will be stored in the objects directory.
 //
@@ -400,6 +1071,13 @@ func (wb *WriteBuilder) estimateSize() (int64, error) {
 	for data := range maps.Values(wb.data) {
 		totalSize += int64(len(data))
 	}
+	for path := range maps.Values(wb.spilled) {
+		info, err := wb.cache.fs.Stat(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat data %s: %w", path, err)
+		}
+		totalSize += info.Size()
+	}
 
 	return totalSize, nil
 }